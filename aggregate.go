@@ -0,0 +1,185 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams"
+)
+
+const (
+	formatAggregate = "aggregate"
+
+	// lateRecordsAmend emits a correction of the window of a late record.
+	lateRecordsAmend = "amend"
+	// lateRecordsNext counts a late record into the oldest window not yet emitted.
+	lateRecordsNext = "next"
+)
+
+// aggregateWindow is a line of --format aggregate, the number of the mods of each table committed in the window.
+type aggregateWindow struct {
+	Start time.Time `json:"window_start"`
+	End   time.Time `json:"window_end"`
+	// Correction is true for the counts of the late records of a window already emitted, to be added to it.
+	Correction bool                                    `json:"correction,omitempty"`
+	Tables     map[string]*changestreams.ModTypeCounts `json:"tables"`
+}
+
+// aggregator counts the mods per table and mod type in the windows of the commit timestamps, and writes each window
+// once the low watermark of the reader passes its end, so that the counts are complete.
+type aggregator struct {
+	out         io.Writer
+	window      time.Duration
+	lateRecords string
+	// lowWatermark returns the low watermark of the reader. A record committed before it never arrives later.
+	lowWatermark func() time.Time
+	mu           sync.Mutex
+	windows      map[time.Time]*aggregateWindow
+	// closed is the end of the last window emitted. The windows before it are closed.
+	closed time.Time
+}
+
+func newAggregator(out io.Writer, window time.Duration, lateRecords string, lowWatermark func() time.Time) (*aggregator, error) {
+	if window <= 0 {
+		return nil, fmt.Errorf("invalid window: %s", window)
+	}
+	if lateRecords != lateRecordsAmend && lateRecords != lateRecordsNext {
+		return nil, fmt.Errorf("invalid late records: %s", lateRecords)
+	}
+	return &aggregator{
+		out:          out,
+		window:       window,
+		lateRecords:  lateRecords,
+		lowWatermark: lowWatermark,
+		windows:      make(map[time.Time]*aggregateWindow),
+	}, nil
+}
+
+// Read counts the mods of the result, and then writes the windows that the low watermark has passed.
+func (a *aggregator) Read(result *changestreams.ReadResult) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var corrections map[time.Time]*aggregateWindow
+	for record := range result.DataChangeRecords() {
+		start := record.CommitTimestamp.Truncate(a.window)
+		if !start.Before(a.closed) {
+			a.openWindow(start).add(record)
+			continue
+		}
+		var w *aggregateWindow
+		switch a.lateRecords {
+		case lateRecordsAmend:
+			if corrections == nil {
+				corrections = make(map[time.Time]*aggregateWindow)
+			}
+			w = corrections[start]
+			if w == nil {
+				w = &aggregateWindow{Start: start, End: start.Add(a.window), Correction: true, Tables: make(map[string]*changestreams.ModTypeCounts)}
+				corrections[start] = w
+			}
+		case lateRecordsNext:
+			w = a.openWindow(a.closed)
+		}
+		w.add(record)
+	}
+	if err := a.write(corrections); err != nil {
+		return err
+	}
+	return a.emit(a.lowWatermark())
+}
+
+// Flush writes all the windows, e.g. when a bounded read has finished.
+func (a *aggregator) Flush() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var end time.Time
+	for start := range a.windows {
+		if e := start.Add(a.window); e.After(end) {
+			end = e
+		}
+	}
+	return a.emit(end)
+}
+
+// openWindow returns the window starting at start, which is created if it doesn't exist yet.
+func (a *aggregator) openWindow(start time.Time) *aggregateWindow {
+	w := a.windows[start]
+	if w == nil {
+		w = &aggregateWindow{Start: start, End: start.Add(a.window), Tables: make(map[string]*changestreams.ModTypeCounts)}
+		a.windows[start] = w
+	}
+	return w
+}
+
+// emit writes the windows that end at or before watermark, and closes them.
+func (a *aggregator) emit(watermark time.Time) error {
+	closed := watermark.Truncate(a.window)
+	if !closed.After(a.closed) {
+		return nil
+	}
+	ready := make(map[time.Time]*aggregateWindow)
+	for start, w := range a.windows {
+		if w.End.After(closed) {
+			continue
+		}
+		ready[start] = w
+		delete(a.windows, start)
+	}
+	a.closed = closed
+	return a.write(ready)
+}
+
+// write writes the windows in the order of their starts.
+func (a *aggregator) write(windows map[time.Time]*aggregateWindow) error {
+	starts := make([]time.Time, 0, len(windows))
+	for start := range windows {
+		starts = append(starts, start)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+	encoder := json.NewEncoder(a.out)
+	for _, start := range starts {
+		if err := encoder.Encode(windows[start]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *aggregateWindow) add(record *changestreams.DataChangeRecord) {
+	counts := w.Tables[record.TableName]
+	if counts == nil {
+		counts = &changestreams.ModTypeCounts{}
+		w.Tables[record.TableName] = counts
+	}
+	mods := int64(len(record.Mods))
+	switch record.ModType {
+	case "INSERT":
+		counts.Inserts += mods
+	case "UPDATE":
+		counts.Updates += mods
+	case "DELETE":
+		counts.Deletes += mods
+	}
+}
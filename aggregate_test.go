@@ -0,0 +1,111 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAggregator(t *testing.T) {
+	start := mustParseTime(t, "2024-06-01T08:00:00Z")
+	// newResult returns the result of a record with the mods committed at the seconds from start.
+	newResult := func(second int, table, modType string, mods int) *changestreams.ReadResult {
+		record := &changestreams.DataChangeRecord{
+			CommitTimestamp: start.Add(time.Duration(second) * time.Second),
+			TableName:       table,
+			ModType:         modType,
+		}
+		for i := 0; i < mods; i++ {
+			record.Mods = append(record.Mods, &changestreams.Mod{})
+		}
+		return &changestreams.ReadResult{ChangeRecords: []*changestreams.ChangeRecord{{DataChangeRecords: []*changestreams.DataChangeRecord{record}}}}
+	}
+
+	for _, test := range []struct {
+		desc        string
+		lateRecords string
+		want        []string
+	}{
+		{
+			desc:        "amend",
+			lateRecords: lateRecordsAmend,
+			want: []string{
+				`{"window_start":"2024-06-01T08:00:00Z","window_end":"2024-06-01T08:01:00Z","tables":{"Albums":{"inserts":0,"updates":0,"deletes":1},"Singers":{"inserts":2,"updates":1,"deletes":0}}}`,
+				`{"window_start":"2024-06-01T08:00:00Z","window_end":"2024-06-01T08:01:00Z","correction":true,"tables":{"Singers":{"inserts":1,"updates":0,"deletes":0}}}`,
+				`{"window_start":"2024-06-01T08:01:00Z","window_end":"2024-06-01T08:02:00Z","tables":{"Singers":{"inserts":0,"updates":3,"deletes":0}}}`,
+			},
+		},
+		{
+			desc:        "next",
+			lateRecords: lateRecordsNext,
+			want: []string{
+				`{"window_start":"2024-06-01T08:00:00Z","window_end":"2024-06-01T08:01:00Z","tables":{"Albums":{"inserts":0,"updates":0,"deletes":1},"Singers":{"inserts":2,"updates":1,"deletes":0}}}`,
+				`{"window_start":"2024-06-01T08:01:00Z","window_end":"2024-06-01T08:02:00Z","tables":{"Singers":{"inserts":1,"updates":3,"deletes":0}}}`,
+			},
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			var out bytes.Buffer
+			var watermark time.Time
+			a, err := newAggregator(&out, time.Minute, test.lateRecords, func() time.Time { return watermark })
+			if err != nil {
+				t.Fatalf("newAggregator error: %v", err)
+			}
+			for _, step := range []struct {
+				result    *changestreams.ReadResult
+				watermark int
+			}{
+				{result: newResult(10, "Singers", "INSERT", 2)},
+				{result: newResult(59, "Singers", "UPDATE", 1)},
+				{result: newResult(30, "Albums", "DELETE", 1), watermark: 59},
+				// The watermark at the end of the window closes it.
+				{result: newResult(70, "Singers", "UPDATE", 3), watermark: 60},
+				// A record of the closed window arrives late.
+				{result: newResult(20, "Singers", "INSERT", 1), watermark: 61},
+			} {
+				watermark = start.Add(time.Duration(step.watermark) * time.Second)
+				if err := a.Read(step.result); err != nil {
+					t.Fatalf("Read error: %v", err)
+				}
+			}
+			if got := strings.Count(out.String(), "\n"); got != len(test.want)-1 {
+				t.Errorf("lines before Flush = %d, want %d", got, len(test.want)-1)
+			}
+			if err := a.Flush(); err != nil {
+				t.Fatalf("Flush error: %v", err)
+			}
+			if diff := cmp.Diff(strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n"), test.want); diff != "" {
+				t.Errorf("output diff = %v", diff)
+			}
+		})
+	}
+}
+
+func TestNewAggregatorInvalid(t *testing.T) {
+	if _, err := newAggregator(nil, 0, lateRecordsAmend, nil); err == nil {
+		t.Error("newAggregator with zero window succeeded, want error")
+	}
+	if _, err := newAggregator(nil, time.Minute, "drop", nil); err == nil {
+		t.Error("newAggregator with invalid late records succeeded, want error")
+	}
+}
@@ -0,0 +1,231 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	defaultBatchMaxRecords = 100
+	defaultBatchMaxDelay   = time.Second
+)
+
+// BatchConfig is the configuration for ReadBatch.
+type BatchConfig struct {
+	// MaxRecords is the number of the data change records of a full batch, which is delivered immediately.
+	// If MaxRecords is zero, 100 is used.
+	MaxRecords int
+	// MaxDelay is the longest time a record waits for its batch to fill. If MaxDelay is zero, 1s is used.
+	MaxDelay time.Duration
+	// Less sorts the records of each batch before the batch is delivered, e.g. by the keys to deduplicate them.
+	// The sort is stable, so the records that are equal by Less keep the order they arrived in. If Less is nil,
+	// the records are sorted by the commit timestamp and then the record sequence.
+	//
+	// The sort takes O(n log n) calls of Less for a batch of n records, and the records buffered beyond
+	// MaxRecords, e.g. by the partitions read in parallel, are sorted together before they are split, so a
+	// large MaxRecords or an expensive Less delays every batch.
+	Less func(a, b *DataChangeRecord) bool
+}
+
+// batcher buffers the data change records, and delivers them in sorted batches.
+type batcher struct {
+	// ctx is the context of the batches delivered by the timer and the checkpoints.
+	ctx        context.Context
+	maxRecords int
+	maxDelay   time.Duration
+	less       func(a, b *DataChangeRecord) bool
+	// deliver is called with each batch and the partition of its first record.
+	deliver func(ctx context.Context, partitionToken string, records []*DataChangeRecord) error
+	mu      sync.Mutex
+	records []batchedRecord
+	// deliverMu serializes the deliveries, so that the batches are delivered one at a time in order.
+	deliverMu sync.Mutex
+}
+
+// batchedRecord is a buffered data change record and the partition it was read from.
+type batchedRecord struct {
+	partitionToken string
+	record         *DataChangeRecord
+}
+
+func newBatcher(ctx context.Context, config BatchConfig, deliver func(ctx context.Context, partitionToken string, records []*DataChangeRecord) error) *batcher {
+	b := &batcher{
+		ctx:        ctx,
+		maxRecords: config.MaxRecords,
+		maxDelay:   config.MaxDelay,
+		less:       config.Less,
+		deliver:    deliver,
+	}
+	if b.maxRecords == 0 {
+		b.maxRecords = defaultBatchMaxRecords
+	}
+	if b.maxDelay == 0 {
+		b.maxDelay = defaultBatchMaxDelay
+	}
+	if b.less == nil {
+		b.less = isBefore
+	}
+	return b
+}
+
+// add buffers the data change records of the result, and delivers the batches if they are full.
+func (b *batcher) add(ctx context.Context, result *ReadResult) error {
+	b.mu.Lock()
+	for record := range result.DataChangeRecords() {
+		b.records = append(b.records, batchedRecord{partitionToken: result.PartitionToken, record: record})
+	}
+	full := len(b.records) >= b.maxRecords
+	b.mu.Unlock()
+
+	if !full {
+		return nil
+	}
+	return b.flush(ctx)
+}
+
+// flush delivers all the buffered records in batches of up to maxRecords.
+func (b *batcher) flush(ctx context.Context) error {
+	b.deliverMu.Lock()
+	defer b.deliverMu.Unlock()
+
+	b.mu.Lock()
+	records := b.records
+	b.records = nil
+	b.mu.Unlock()
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return b.less(records[i].record, records[j].record)
+	})
+	for len(records) > 0 {
+		n := min(len(records), b.maxRecords)
+		batch := make([]*DataChangeRecord, n)
+		for i := range batch {
+			batch[i] = records[i].record
+		}
+		if err := b.deliver(ctx, records[0].partitionToken, batch); err != nil {
+			return err
+		}
+		records = records[n:]
+	}
+	return nil
+}
+
+// buffered returns the number of the buffered records.
+func (b *batcher) buffered() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.records)
+}
+
+// run flushes the buffered records every maxDelay until stop is closed.
+func (b *batcher) run(stop <-chan struct{}) error {
+	ticker := time.NewTicker(b.maxDelay)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+			if err := b.flush(b.ctx); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// ReadBatch reads the change stream like ReadWithContext, and calls f with the data change records in batches of
+// up to BatchConfig.MaxRecords, sorted by BatchConfig.Less, e.g. for a bulk writer. A batch is delivered when it's
+// full, after BatchConfig.MaxDelay, before each checkpoint and at the end of the read. The heartbeat and child
+// partitions records are not delivered.
+//
+// The batches are delivered one at a time. The buffered records are flushed before each checkpoint is saved, so a
+// checkpoint never includes the records that haven't been delivered, and they are read again after a failure.
+//
+// Each call of f is made like the calls of the read function of ReadWithContext: a panic is recovered unless
+// Config.DisablePanicRecovery is true, the call is retried by Config.CallbackTimeout, its duration is recorded in
+// Stats.CallbackDurations and logged by Config.SlowCallbackThreshold, and with Config.PauseOnError a failed batch
+// pauses the partition of its first record until ResumePartition is called for it. The paused batch is
+// PartitionPause.Result, and the other batches wait for it.
+func (r *Reader) ReadBatch(ctx context.Context, config BatchConfig, f func(ctx context.Context, records []*DataChangeRecord) error) error {
+	if config.MaxRecords < 0 || config.MaxDelay < 0 {
+		return errors.New("BatchConfig.MaxRecords and BatchConfig.MaxDelay must not be negative")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	b := newBatcher(ctx, config, func(ctx context.Context, partitionToken string, records []*DataChangeRecord) error {
+		return r.deliverBatch(ctx, partitionToken, records, f)
+	})
+	r.mu.Lock()
+	if r.group != nil || r.batcher != nil {
+		r.mu.Unlock()
+		return errors.New("reader has already been read")
+	}
+	r.batcher = b
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		r.batcher = nil
+		r.mu.Unlock()
+	}()
+
+	var flusher errgroup.Group
+	stopFlusher := make(chan struct{})
+	flusher.Go(func() error {
+		err := b.run(stopFlusher)
+		if err != nil {
+			cancel()
+		}
+		return err
+	})
+	err := r.ReadWithContext(ctx, b.add)
+	close(stopFlusher)
+	// The read is cancelled by the failure of the flusher.
+	if flushErr := flusher.Wait(); flushErr != nil {
+		return flushErr
+	}
+	if err != nil {
+		return err
+	}
+	return b.flush(ctx)
+}
+
+// deliverBatch calls f with the batch read from the partition of its first record through the same wrappers as
+// the read function of ReadWithContext.
+func (r *Reader) deliverBatch(ctx context.Context, partitionToken string, records []*DataChangeRecord, f func(ctx context.Context, records []*DataChangeRecord) error) error {
+	read := func(ctx context.Context, result *ReadResult) error {
+		return f(ctx, records)
+	}
+	if !r.disablePanicRecovery {
+		read = func(ctx context.Context, result *ReadResult) error {
+			return callRecoveringPanics(func() error { return f(ctx, records) })
+		}
+	}
+	partitionID := r.partitionIDs.get(partitionToken)
+	logger := r.logger.With("partition", partitionID, "partition_token", partitionToken)
+	result := &ReadResult{PartitionToken: partitionToken, ChangeRecords: []*ChangeRecord{{DataChangeRecords: records}}}
+	err := r.callReadFunction(ctx, logger, read, result)
+	return r.retryPaused(ctx, logger, partitionToken, partitionID, err, len(records), read, result)
+}
@@ -0,0 +1,194 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBatcher(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2023, 2, 24, 17, 17, 0, 0, time.UTC)
+	newResult := func(records ...*DataChangeRecord) *ReadResult {
+		return &ReadResult{ChangeRecords: []*ChangeRecord{{DataChangeRecords: records}}}
+	}
+	a := &DataChangeRecord{TableName: "Singers", CommitTimestamp: start.Add(2 * time.Second), RecordSequence: "00000000"}
+	b := &DataChangeRecord{TableName: "Albums", CommitTimestamp: start.Add(time.Second), RecordSequence: "00000001"}
+	c := &DataChangeRecord{TableName: "Singers", CommitTimestamp: start.Add(time.Second), RecordSequence: "00000000"}
+
+	for _, tt := range []struct {
+		name string
+		less func(a, b *DataChangeRecord) bool
+		want [][]*DataChangeRecord
+	}{
+		{name: "default", want: [][]*DataChangeRecord{{c, b}, {a}}},
+		// The records of the same table keep the order they arrived in.
+		{name: "table", less: func(a, b *DataChangeRecord) bool { return a.TableName < b.TableName }, want: [][]*DataChangeRecord{{b, a}, {c}}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var batches [][]*DataChangeRecord
+			batcher := newBatcher(ctx, BatchConfig{MaxRecords: 2, Less: tt.less}, func(ctx context.Context, partitionToken string, records []*DataChangeRecord) error {
+				batches = append(batches, records)
+				return nil
+			})
+			if err := batcher.add(ctx, newResult(a)); err != nil {
+				t.Fatalf("add error: %v", err)
+			}
+			if len(batches) != 0 || batcher.buffered() != 1 {
+				t.Errorf("batches = %d with %d buffered, want none delivered before the batch is full", len(batches), batcher.buffered())
+			}
+			// The records beyond MaxRecords are sorted together, and then split.
+			if err := batcher.add(ctx, newResult(b, c)); err != nil {
+				t.Fatalf("add error: %v", err)
+			}
+			if diff := cmp.Diff(batches, tt.want); diff != "" {
+				t.Errorf("batches diff = %v", diff)
+			}
+		})
+	}
+}
+
+func TestReadBatch(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2023, 2, 24, 17, 17, 0, 0, time.UTC)
+	server, opts := newFakeSpannerServer(t)
+	server.childPartitions = map[string][]*ChildPartitionsRecord{
+		"": {{StartTimestamp: start, RecordSequence: "00000001", ChildPartitions: []*ChildPartition{{Token: "a"}, {Token: "b"}}}},
+	}
+	server.dataChangeRecords = map[string][]*DataChangeRecord{
+		"a": {
+			{CommitTimestamp: start.Add(time.Second), RecordSequence: "00000000", TableName: "Singers"},
+			{CommitTimestamp: start.Add(3 * time.Second), RecordSequence: "00000000", TableName: "Singers"},
+		},
+		"b": {
+			{CommitTimestamp: start.Add(2 * time.Second), RecordSequence: "00000000", TableName: "Albums"},
+		},
+	}
+	r, err := NewReaderWithConfig(ctx, "project", "instance", "database", "stream", Config{
+		StartTimestamp:       start,
+		EndTimestamp:         start.Add(time.Minute),
+		SpannerClientOptions: opts,
+	})
+	if err != nil {
+		t.Fatalf("NewReaderWithConfig error: %v", err)
+	}
+	defer r.Close()
+
+	if err := r.ReadBatch(ctx, BatchConfig{MaxRecords: -1}, nil); err == nil {
+		t.Error("ReadBatch with negative MaxRecords succeeded, want error")
+	}
+
+	// The batch is delivered at the end of the read, sorted by the commit timestamps across the partitions.
+	var batches [][]time.Time
+	if err := r.ReadBatch(ctx, BatchConfig{MaxRecords: 10, MaxDelay: time.Hour}, func(ctx context.Context, records []*DataChangeRecord) error {
+		var timestamps []time.Time
+		for _, record := range records {
+			timestamps = append(timestamps, record.CommitTimestamp)
+		}
+		batches = append(batches, timestamps)
+		return nil
+	}); err != nil {
+		t.Fatalf("ReadBatch error: %v", err)
+	}
+	want := [][]time.Time{{start.Add(time.Second), start.Add(2 * time.Second), start.Add(3 * time.Second)}}
+	if diff := cmp.Diff(batches, want); diff != "" {
+		t.Errorf("batches diff = %v", diff)
+	}
+	if got := r.Stats().BufferedRecords; got != 0 {
+		t.Errorf("BufferedRecords = %d, want 0", got)
+	}
+	if err := r.ReadBatch(ctx, BatchConfig{}, func(ctx context.Context, records []*DataChangeRecord) error { return nil }); err == nil {
+		t.Error("ReadBatch of the reader already read succeeded, want error")
+	}
+}
+
+func TestReadBatchWrappers(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2023, 2, 24, 17, 17, 0, 0, time.UTC)
+	newReader := func(t *testing.T, config Config) *Reader {
+		t.Helper()
+		server, opts := newFakeSpannerServer(t)
+		server.childPartitions = map[string][]*ChildPartitionsRecord{
+			"": {{StartTimestamp: start, RecordSequence: "00000001", ChildPartitions: []*ChildPartition{{Token: "a"}, {Token: "b"}}}},
+		}
+		server.dataChangeRecords = map[string][]*DataChangeRecord{
+			"a": {{CommitTimestamp: start.Add(time.Second), RecordSequence: "00000000", TableName: "Singers"}},
+			"b": {{CommitTimestamp: start.Add(2 * time.Second), RecordSequence: "00000000", TableName: "Albums"}},
+		}
+		config.StartTimestamp = start
+		config.EndTimestamp = start.Add(time.Minute)
+		config.SpannerClientOptions = opts
+		r, err := NewReaderWithConfig(ctx, "project", "instance", "database", "stream", config)
+		if err != nil {
+			t.Fatalf("NewReaderWithConfig error: %v", err)
+		}
+		t.Cleanup(r.Close)
+		return r
+	}
+
+	t.Run("panic is recovered", func(t *testing.T) {
+		r := newReader(t, Config{})
+		err := r.ReadBatch(ctx, BatchConfig{MaxDelay: time.Hour}, func(ctx context.Context, records []*DataChangeRecord) error {
+			panic("poison batch")
+		})
+		var panicErr *PanicError
+		if !errors.As(err, &panicErr) || panicErr.Value != "poison batch" {
+			t.Errorf("ReadBatch error = %v, want *PanicError", err)
+		}
+		if got := r.Stats().CallbackDurations.Count; got != 1 {
+			t.Errorf("CallbackDurations.Count = %d, want 1 for the batch", got)
+		}
+	})
+
+	t.Run("failed batch pauses the partition of the first record", func(t *testing.T) {
+		errPoison := errors.New("poison batch")
+		pauses := make(chan *PartitionPause, 1)
+		r := newReader(t, Config{PauseOnError: true, OnPartitionPaused: func(pause *PartitionPause) { pauses <- pause }})
+		operator := make(chan error, 1)
+		go func() {
+			pause := <-pauses
+			if pause.PartitionToken != "a" || pause.Err != errPoison || len(dataChangeRecords(pause.Result)) != 2 {
+				operator <- fmt.Errorf("unexpected pause %+v", pause)
+				return
+			}
+			operator <- r.ResumePartition("a", false)
+		}()
+
+		calls := 0
+		if err := r.ReadBatch(ctx, BatchConfig{MaxDelay: time.Hour}, func(ctx context.Context, records []*DataChangeRecord) error {
+			calls++
+			if calls == 1 {
+				return errPoison
+			}
+			return nil
+		}); err != nil {
+			t.Fatalf("ReadBatch error: %v", err)
+		}
+		if err := <-operator; err != nil {
+			t.Fatalf("operator error: %v", err)
+		}
+		if calls != 2 {
+			t.Errorf("calls = %d, want the batch retried once after the resume", calls)
+		}
+	})
+}
@@ -0,0 +1,69 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+)
+
+// BytesFormat decides how the BYTES columns appear in Mod.NewValues and Mod.OldValues.
+type BytesFormat int
+
+const (
+	// BytesFormatBase64 leaves the values as returned from Cloud Spanner, i.e. base64-encoded strings.
+	BytesFormatBase64 BytesFormat = iota
+	// BytesFormatHex replaces the values with hex-encoded strings.
+	BytesFormatHex
+	// BytesFormatOmit replaces the values with a marker object {"_omitted": true, "bytes": N}, where N is
+	// the length of the value, for the consumers that can't handle binary payloads.
+	BytesFormatOmit
+)
+
+// ParseBytesFormat parses the name of the format, i.e. "base64", "hex" or "omit".
+func ParseBytesFormat(name string) (BytesFormat, error) {
+	switch name {
+	case "base64":
+		return BytesFormatBase64, nil
+	case "hex":
+		return BytesFormatHex, nil
+	case "omit":
+		return BytesFormatOmit, nil
+	default:
+		return 0, fmt.Errorf("invalid bytes format: %q", name)
+	}
+}
+
+// formatBytesValue formats the BYTES value, which is a base64-encoded string.
+func formatBytesValue(v interface{}, format BytesFormat) (interface{}, error) {
+	if format == BytesFormatBase64 {
+		return v, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("unexpected value of BYTES: %T", v)
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if format == BytesFormatOmit {
+		return map[string]interface{}{"_omitted": true, "bytes": len(b)}, nil
+	}
+	return hex.EncodeToString(b), nil
+}
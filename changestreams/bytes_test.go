@@ -0,0 +1,100 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"fmt"
+	"testing"
+
+	"cloud.google.com/go/spanner"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFormatBytes(t *testing.T) {
+	jsonValue := func(v interface{}) spanner.NullJSON {
+		return spanner.NullJSON{Value: v, Valid: true}
+	}
+	newRecord := func() *DataChangeRecord {
+		return &DataChangeRecord{
+			ModType: "UPDATE",
+			ColumnTypes: []*ColumnType{
+				{Name: "id", Type: jsonValue(map[string]interface{}{"code": "BYTES"}), IsPrimaryKey: true},
+				{Name: "data", Type: jsonValue(map[string]interface{}{"code": "BYTES"})},
+				{Name: "chunks", Type: jsonValue(map[string]interface{}{"code": "ARRAY", "array_element_type": map[string]interface{}{"code": "BYTES"}})},
+				{Name: "name", Type: jsonValue(map[string]interface{}{"code": "STRING"})},
+			},
+			Mods: []*Mod{
+				{
+					Keys: jsonValue(map[string]interface{}{"id": "AQI="}),
+					// "3q2+7w==" is 0xdeadbeef.
+					NewValues: jsonValue(map[string]interface{}{"id": "AQI=", "data": "3q2+7w==", "chunks": []interface{}{"AQI=", nil}, "name": "AQI="}),
+					OldValues: jsonValue(map[string]interface{}{"data": nil}),
+				},
+			},
+		}
+	}
+
+	for _, test := range []struct {
+		format        BytesFormat
+		wantNewValues map[string]interface{}
+	}{
+		{
+			format:        BytesFormatBase64,
+			wantNewValues: map[string]interface{}{"id": "AQI=", "data": "3q2+7w==", "chunks": []interface{}{"AQI=", nil}, "name": "AQI="},
+		},
+		{
+			format:        BytesFormatHex,
+			wantNewValues: map[string]interface{}{"id": "AQI=", "data": "deadbeef", "chunks": []interface{}{"0102", nil}, "name": "AQI="},
+		},
+		{
+			format: BytesFormatOmit,
+			wantNewValues: map[string]interface{}{
+				"id":     "AQI=",
+				"data":   map[string]interface{}{"_omitted": true, "bytes": 4},
+				"chunks": []interface{}{map[string]interface{}{"_omitted": true, "bytes": 2}, nil},
+				"name":   "AQI=",
+			},
+		},
+	} {
+		t.Run(fmt.Sprint(test.format), func(t *testing.T) {
+			record := newRecord()
+			if err := formatValues(&ChangeRecord{DataChangeRecords: []*DataChangeRecord{record}}, valueFormat{bytes: test.format}); err != nil {
+				t.Fatalf("formatValues error: %v", err)
+			}
+			if diff := cmp.Diff(record.Mods[0].NewValues.Value, test.wantNewValues); diff != "" {
+				t.Errorf("new values diff = %v", diff)
+			}
+			if diff := cmp.Diff(record.Mods[0].OldValues.Value, map[string]interface{}{"data": nil}); diff != "" {
+				t.Errorf("old values diff = %v", diff)
+			}
+			if diff := cmp.Diff(record.Mods[0].Keys.Value, map[string]interface{}{"id": "AQI="}); diff != "" {
+				t.Errorf("keys diff = %v", diff)
+			}
+		})
+	}
+}
+
+func TestParseBytesFormat(t *testing.T) {
+	for name, want := range map[string]BytesFormat{"base64": BytesFormatBase64, "hex": BytesFormatHex, "omit": BytesFormatOmit} {
+		if got, err := ParseBytesFormat(name); err != nil || got != want {
+			t.Errorf("ParseBytesFormat(%q) = %v, %v, want %v", name, got, err, want)
+		}
+	}
+	if _, err := ParseBytesFormat("raw"); err == nil {
+		t.Error("ParseBytesFormat must fail for an unknown format")
+	}
+}
@@ -0,0 +1,161 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+const defaultCallbackMaxAttempts = 3
+
+// ErrCallbackTimeout is the error of the read function that overran Config.CallbackTimeout in all the attempts.
+var ErrCallbackTimeout = errors.New("read function timed out")
+
+// callbackDurationBounds are the upper bounds of the buckets of Stats.CallbackDurations.
+var callbackDurationBounds = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// DurationHistogram is the distribution of durations.
+type DurationHistogram struct {
+	// Buckets are the numbers of the durations in each bucket, which are not cumulative.
+	Buckets []DurationBucket `json:"buckets"`
+	Count   int64            `json:"count"`
+	Sum     time.Duration    `json:"sum"`
+}
+
+// DurationBucket is a bucket of DurationHistogram with the durations up to UpperBound, inclusive, and above
+// the previous bucket. UpperBound is zero for the last bucket, which has no upper bound.
+type DurationBucket struct {
+	UpperBound time.Duration `json:"upper_bound"`
+	Count      int64         `json:"count"`
+}
+
+// merge adds the counts of other, which must have the same buckets unless either is empty.
+func (h *DurationHistogram) merge(other DurationHistogram) {
+	if len(h.Buckets) == 0 {
+		h.Buckets = append([]DurationBucket(nil), other.Buckets...)
+	} else {
+		for i := range other.Buckets {
+			h.Buckets[i].Count += other.Buckets[i].Count
+		}
+	}
+	h.Count += other.Count
+	h.Sum += other.Sum
+}
+
+// durationHistogram records the durations with atomics, so that recording every call of the read function is cheap.
+type durationHistogram struct {
+	bounds []time.Duration
+	// counts has a bucket above the last bound.
+	counts []atomic.Int64
+	sum    atomic.Int64
+}
+
+func newDurationHistogram(bounds []time.Duration) *durationHistogram {
+	return &durationHistogram{bounds: bounds, counts: make([]atomic.Int64, len(bounds)+1)}
+}
+
+func (h *durationHistogram) observe(d time.Duration) {
+	i := 0
+	for i < len(h.bounds) && d > h.bounds[i] {
+		i++
+	}
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+}
+
+func (h *durationHistogram) snapshot() DurationHistogram {
+	histogram := DurationHistogram{Buckets: make([]DurationBucket, len(h.counts)), Sum: time.Duration(h.sum.Load())}
+	for i := range h.counts {
+		if i < len(h.bounds) {
+			histogram.Buckets[i].UpperBound = h.bounds[i]
+		}
+		histogram.Buckets[i].Count = h.counts[i].Load()
+		histogram.Count += histogram.Buckets[i].Count
+	}
+	return histogram
+}
+
+// warnSlowCallback logs the call of the read function that took longer than Config.SlowCallbackThreshold,
+// with the metadata of the first data change record of the result, if any.
+func (r *Reader) warnSlowCallback(logger *slog.Logger, result *ReadResult, elapsed time.Duration) {
+	attrs := []any{"event", "slow_callback", "elapsed", elapsed, "threshold", r.slowCallbackThreshold,
+		"data_change_records", len(dataChangeRecords(result))}
+	for record := range result.DataChangeRecords() {
+		attrs = append(attrs, "table_name", record.TableName, "mod_type", record.ModType,
+			"commit_timestamp", record.CommitTimestamp, "server_transaction_id", record.ServerTransactionID,
+			"record_sequence", record.RecordSequence)
+		break
+	}
+	logger.Warn("read function is slow", attrs...)
+}
+
+// callWithTimeout calls the read function with the result. With Config.CallbackTimeout, each call is passed
+// the context done after the timeout, and the call that overruns it and fails is retried up to
+// Config.CallbackMaxAttempts attempts. A call that returns nil has delivered the result, so it's never retried
+// even if it returned after the timeout. The retries are passed the same result, which is not copied.
+func (r *Reader) callWithTimeout(ctx context.Context, logger *slog.Logger, f func(ctx context.Context, result *ReadResult) error, result *ReadResult) error {
+	if r.callbackTimeout <= 0 {
+		return f(ctx, result)
+	}
+	for attempt := 1; ; attempt++ {
+		callCtx, cancel := context.WithTimeout(ctx, r.callbackTimeout)
+		err := f(callCtx, result)
+		overran := err != nil && callCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil
+		cancel()
+		if !overran {
+			return err
+		}
+		if attempt >= r.callbackMaxAttempts {
+			logger.Error("read function timed out", "event", "callback_timed_out", "attempts", attempt, "timeout", r.callbackTimeout)
+			return fmt.Errorf("%w in %s after %d attempts", ErrCallbackTimeout, r.callbackTimeout, attempt)
+		}
+		backoff := retryBackoff(attempt)
+		logger.Warn("read function timed out, retrying", "event", "callback_retried", "attempt", attempt,
+			"timeout", r.callbackTimeout, "backoff", backoff, "error", err)
+		if !sleep(ctx, backoff) {
+			return ctx.Err()
+		}
+	}
+}
+
+// callReadFunction calls the read function with the result by callWithTimeout, records the duration of the call
+// in Stats.CallbackDurations, and logs the call if it's slower than Config.SlowCallbackThreshold.
+func (r *Reader) callReadFunction(ctx context.Context, logger *slog.Logger, f func(ctx context.Context, result *ReadResult) error, result *ReadResult) error {
+	start := time.Now()
+	err := r.callWithTimeout(ctx, logger, f, result)
+	elapsed := time.Since(start)
+	r.callbackDurations.observe(elapsed)
+	if r.slowCallbackThreshold > 0 && elapsed > r.slowCallbackThreshold {
+		r.warnSlowCallback(logger, result, elapsed)
+	}
+	return err
+}
@@ -0,0 +1,182 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDurationHistogram(t *testing.T) {
+	h := newDurationHistogram([]time.Duration{time.Millisecond, time.Second})
+	for _, d := range []time.Duration{0, time.Millisecond, 2 * time.Millisecond, time.Minute} {
+		h.observe(d)
+	}
+	got := h.snapshot()
+	want := DurationHistogram{
+		Buckets: []DurationBucket{
+			{UpperBound: time.Millisecond, Count: 2},
+			{UpperBound: time.Second, Count: 1},
+			{Count: 1},
+		},
+		Count: 4,
+		Sum:   time.Minute + 3*time.Millisecond,
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("snapshot diff = %v", diff)
+	}
+
+	var merged DurationHistogram
+	merged.merge(got)
+	merged.merge(got)
+	if merged.Count != 8 || merged.Buckets[0].Count != 4 || merged.Sum != 2*want.Sum {
+		t.Errorf("merged = %+v", merged)
+	}
+}
+
+func TestSlowCallbackThreshold(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2023, 2, 24, 17, 17, 0, 0, time.UTC)
+	server, opts := newFakeSpannerServer(t)
+	server.childPartitions = map[string][]*ChildPartitionsRecord{
+		"": {{StartTimestamp: start, RecordSequence: "00000001", ChildPartitions: []*ChildPartition{{Token: "a"}}}},
+	}
+	var logs bytes.Buffer
+	r, err := NewReaderWithConfig(ctx, "project", "instance", "database", "stream", Config{
+		StartTimestamp:        start,
+		EndTimestamp:          start.Add(time.Minute),
+		SpannerClientOptions:  opts,
+		SlowCallbackThreshold: 10 * time.Millisecond,
+		Logger:                slog.New(slog.NewTextHandler(&logs, nil)),
+	})
+	if err != nil {
+		t.Fatalf("NewReaderWithConfig error: %v", err)
+	}
+	defer r.Close()
+
+	// Only the initial query returns a row.
+	if err := r.Read(ctx, func(result *ReadResult) error {
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	}); err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	if got := strings.Count(logs.String(), "event=slow_callback"); got != 1 {
+		t.Errorf("slow callbacks logged = %d, want 1:\n%s", got, logs.String())
+	}
+	if got := r.Stats().CallbackDurations; got.Count != 1 || got.Sum < 20*time.Millisecond {
+		t.Errorf("CallbackDurations = %+v, want a call of 20ms or longer", got)
+	}
+}
+
+func TestCallbackTimeout(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2023, 2, 24, 17, 17, 0, 0, time.UTC)
+	newReader := func(t *testing.T, maxAttempts int, logs *bytes.Buffer) *Reader {
+		server, opts := newFakeSpannerServer(t)
+		server.childPartitions = map[string][]*ChildPartitionsRecord{
+			"": {{StartTimestamp: start, RecordSequence: "00000001", ChildPartitions: []*ChildPartition{{Token: "a"}}}},
+		}
+		r, err := NewReaderWithConfig(ctx, "project", "instance", "database", "stream", Config{
+			StartTimestamp:       start,
+			EndTimestamp:         start.Add(time.Minute),
+			SpannerClientOptions: opts,
+			CallbackTimeout:      20 * time.Millisecond,
+			CallbackMaxAttempts:  maxAttempts,
+			Logger:               slog.New(slog.NewTextHandler(logs, nil)),
+		})
+		if err != nil {
+			t.Fatalf("NewReaderWithConfig error: %v", err)
+		}
+		t.Cleanup(r.Close)
+		return r
+	}
+
+	t.Run("retried after the overrun", func(t *testing.T) {
+		var logs bytes.Buffer
+		r := newReader(t, 2, &logs)
+		var calls int
+		// Only the initial query returns a row, whose first call waits for the context.
+		if err := r.ReadWithContext(ctx, func(ctx context.Context, result *ReadResult) error {
+			calls++
+			if calls == 1 {
+				<-ctx.Done()
+				return ctx.Err()
+			}
+			return nil
+		}); err != nil {
+			t.Fatalf("ReadWithContext error: %v", err)
+		}
+		if calls != 2 {
+			t.Errorf("calls = %d, want 2", calls)
+		}
+		if !strings.Contains(logs.String(), "event=callback_retried") {
+			t.Errorf("retry not logged:\n%s", logs.String())
+		}
+	})
+
+	t.Run("fails after the attempts", func(t *testing.T) {
+		var logs bytes.Buffer
+		r := newReader(t, 1, &logs)
+		err := r.ReadWithContext(ctx, func(ctx context.Context, result *ReadResult) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		if !errors.Is(err, ErrCallbackTimeout) {
+			t.Errorf("Read error = %v, want ErrCallbackTimeout", err)
+		}
+	})
+
+	t.Run("slow success is not retried", func(t *testing.T) {
+		var logs bytes.Buffer
+		r := newReader(t, 3, &logs)
+		var calls int
+		// The call that ignores the context and returns nil late has delivered the result.
+		if err := r.Read(ctx, func(result *ReadResult) error {
+			calls++
+			time.Sleep(40 * time.Millisecond)
+			return nil
+		}); err != nil {
+			t.Fatalf("Read error: %v", err)
+		}
+		if calls != 1 {
+			t.Errorf("calls = %d, want 1", calls)
+		}
+		if strings.Contains(logs.String(), "event=callback_retried") {
+			t.Errorf("slow success retried:\n%s", logs.String())
+		}
+	})
+}
+
+func TestCallbackMaxAttemptsWithAtMostOnce(t *testing.T) {
+	if _, err := NewReaderWithConfig(context.Background(), "project", "instance", "database", "stream", Config{
+		DeliveryMode:        DeliveryAtMostOnce,
+		CheckpointStore:     &fakeCheckpointStore{},
+		CallbackTimeout:     time.Second,
+		CallbackMaxAttempts: 2,
+		LazyConnect:         true,
+	}); err == nil {
+		t.Error("NewReaderWithConfig must fail with CallbackMaxAttempts more than 1 and DeliveryAtMostOnce")
+	}
+}
@@ -0,0 +1,105 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// PendingPartition is a partition to be read from StartTimestamp once all of its parents have finished.
+type PendingPartition struct {
+	Token                 string    `json:"token"`
+	StartTimestamp        time.Time `json:"start_timestamp"`
+	ParentPartitionTokens []string  `json:"parent_partition_tokens"`
+}
+
+// PartitionFinish is passed to Config.BeforeChildPartitions when the query of a partition has finished.
+type PartitionFinish struct {
+	// PartitionToken is the token of the finished partition. It's empty for the initial query.
+	PartitionToken string
+	// Watermark is the timestamp up to which all the records of the partition have been delivered.
+	Watermark time.Time
+	// Children are all the child partitions returned by the partition, including the ones that wait for
+	// another parent to finish.
+	Children []*PendingPartition
+}
+
+// Watermark returns the timestamp up to which the records of the partition have been read once the result is
+// delivered, i.e. the latest timestamp of the records in the result, or the zero time if there is none. A sink
+// can commit it with the writes of the result to resume the partition from it. See the exactly-once delivery in
+// the package documentation.
+func (r *ReadResult) Watermark() time.Time {
+	return latestTimestamp(r)
+}
+
+// Checkpoint is the state of the partitions to resume the read from. See Config.ResumeFrom.
+type Checkpoint struct {
+	// PendingPartitions are the partitions that haven't finished, including the ones being read.
+	PendingPartitions []*PendingPartition `json:"pending_partitions"`
+	// FinishedPartitionTokens are the tokens of the finished partitions. Only the parents of
+	// the pending partitions are needed; the others can be pruned.
+	FinishedPartitionTokens []string `json:"finished_partition_tokens"`
+	// PartitionIDs are the short IDs of the partitions keyed by the tokens, from Reader.PartitionIDs,
+	// so that the partitions keep their IDs in the logs and the stats after the resume.
+	PartitionIDs map[string]string `json:"partition_ids,omitempty"`
+}
+
+func (c *Checkpoint) validate() error {
+	seen := make(map[string]bool, len(c.PendingPartitions))
+	for _, p := range c.PendingPartitions {
+		if p.Token == "" {
+			return errors.New("pending partition without token in the checkpoint")
+		}
+		if seen[p.Token] {
+			return fmt.Errorf("duplicate pending partition in the checkpoint: %q", p.Token)
+		}
+		seen[p.Token] = true
+	}
+	for _, token := range c.FinishedPartitionTokens {
+		if seen[token] {
+			return fmt.Errorf("partition %q is both pending and finished in the checkpoint", token)
+		}
+	}
+	tokens := make(map[string]string, len(c.PartitionIDs))
+	for token, id := range c.PartitionIDs {
+		if id == "" {
+			return fmt.Errorf("partition %q has an empty ID in the checkpoint", token)
+		}
+		if other, ok := tokens[id]; ok {
+			return fmt.Errorf("partitions %q and %q have the same ID %s in the checkpoint", other, token, id)
+		}
+		tokens[id] = token
+	}
+	return nil
+}
+
+// pendingChildren returns the child partitions of the records as the pending partitions.
+func pendingChildren(records []*ChildPartitionsRecord) []*PendingPartition {
+	var children []*PendingPartition
+	for _, record := range records {
+		for _, child := range record.ChildPartitions {
+			children = append(children, &PendingPartition{
+				Token:                 child.Token,
+				StartTimestamp:        record.StartTimestamp,
+				ParentPartitionTokens: child.ParentPartitionTokens,
+			})
+		}
+	}
+	return children
+}
@@ -0,0 +1,217 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2023, 2, 24, 17, 17, 0, 0, time.UTC)
+	split := start.Add(time.Minute)
+	// The root partitions a and b are merged into c.
+	childPartitions := map[string][]*ChildPartitionsRecord{
+		"":  {{StartTimestamp: start, ChildPartitions: []*ChildPartition{{Token: "a"}, {Token: "b"}}}},
+		"a": {{StartTimestamp: split, ChildPartitions: []*ChildPartition{{Token: "c", ParentPartitionTokens: []string{"a", "b"}}}}},
+		"b": {{StartTimestamp: split, ChildPartitions: []*ChildPartition{{Token: "c", ParentPartitionTokens: []string{"a", "b"}}}}},
+	}
+	newReader := func(t *testing.T, config Config) (*Reader, *fakeSpannerServer) {
+		server, opts := newFakeSpannerServer(t)
+		server.childPartitions = childPartitions
+		config.StartTimestamp = start
+		config.EndTimestamp = split.Add(time.Minute)
+		config.SpannerClientOptions = opts
+		r, err := NewReaderWithConfig(ctx, "project", "instance", "database", "stream", config)
+		if err != nil {
+			t.Fatalf("NewReaderWithConfig error: %v", err)
+		}
+		t.Cleanup(r.Close)
+		return r, server
+	}
+	readTokens := func(server *fakeSpannerServer) []string {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		return append([]string(nil), server.readTokens...)
+	}
+	read := func(result *ReadResult) error { return nil }
+
+	t.Run("children are read after BeforeChildPartitions", func(t *testing.T) {
+		var mu sync.Mutex
+		finishes := make(map[string]*PartitionFinish)
+		var server *fakeSpannerServer
+		r, server := newReader(t, Config{
+			BeforeChildPartitions: func(ctx context.Context, finish *PartitionFinish) error {
+				for _, token := range readTokens(server) {
+					for _, child := range finish.Children {
+						if token == child.Token {
+							t.Errorf("child %q of %q is read before BeforeChildPartitions", child.Token, finish.PartitionToken)
+						}
+					}
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				finishes[finish.PartitionToken] = finish
+				return nil
+			},
+		})
+		if err := r.Read(ctx, read); err != nil {
+			t.Fatalf("Read error: %v", err)
+		}
+
+		tokens := readTokens(server)
+		sort.Strings(tokens)
+		if diff := cmp.Diff(tokens, []string{"", "a", "b", "c"}); diff != "" {
+			t.Errorf("read partitions diff = %v", diff)
+		}
+		want := map[string]*PartitionFinish{
+			"":  {PartitionToken: "", Watermark: start, Children: []*PendingPartition{{Token: "a", StartTimestamp: start, ParentPartitionTokens: []string{}}, {Token: "b", StartTimestamp: start, ParentPartitionTokens: []string{}}}},
+			"a": {PartitionToken: "a", Watermark: split, Children: []*PendingPartition{{Token: "c", StartTimestamp: split, ParentPartitionTokens: []string{"a", "b"}}}},
+			"b": {PartitionToken: "b", Watermark: split, Children: []*PendingPartition{{Token: "c", StartTimestamp: split, ParentPartitionTokens: []string{"a", "b"}}}},
+			"c": {PartitionToken: "c", Watermark: split},
+		}
+		if diff := cmp.Diff(finishes, want); diff != "" {
+			t.Errorf("finishes diff = %v", diff)
+		}
+	})
+
+	t.Run("error of BeforeChildPartitions fails the read", func(t *testing.T) {
+		errCheckpoint := errors.New("checkpoint error")
+		r, server := newReader(t, Config{
+			BeforeChildPartitions: func(ctx context.Context, finish *PartitionFinish) error {
+				if finish.PartitionToken == "a" {
+					return errCheckpoint
+				}
+				return nil
+			},
+		})
+		if err := r.Read(ctx, read); !errors.Is(err, errCheckpoint) {
+			t.Errorf("Read error = %v, want %v", err, errCheckpoint)
+		}
+		for _, token := range readTokens(server) {
+			if token == "c" {
+				t.Error("child of the partition failed to checkpoint must not be read")
+			}
+		}
+	})
+
+	t.Run("resume from the pending partitions", func(t *testing.T) {
+		// a has finished, and c waits for b.
+		r, server := newReader(t, Config{
+			ResumeFrom: &Checkpoint{
+				PendingPartitions: []*PendingPartition{
+					{Token: "c", StartTimestamp: split, ParentPartitionTokens: []string{"a", "b"}},
+					{Token: "b", StartTimestamp: start},
+				},
+				FinishedPartitionTokens: []string{"a"},
+			},
+		})
+		if err := r.Read(ctx, read); err != nil {
+			t.Fatalf("Read error: %v", err)
+		}
+		if diff := cmp.Diff(readTokens(server), []string{"b", "c"}); diff != "" {
+			t.Errorf("read partitions diff = %v", diff)
+		}
+	})
+}
+
+func TestCheckpointValidate(t *testing.T) {
+	for _, test := range []struct {
+		desc       string
+		checkpoint *Checkpoint
+		wantErr    bool
+	}{
+		{
+			desc:       "valid",
+			checkpoint: &Checkpoint{PendingPartitions: []*PendingPartition{{Token: "a"}, {Token: "b"}}, FinishedPartitionTokens: []string{"c"}},
+		},
+		{
+			desc:       "empty",
+			checkpoint: &Checkpoint{},
+		},
+		{
+			desc:       "without token",
+			checkpoint: &Checkpoint{PendingPartitions: []*PendingPartition{{Token: ""}}},
+			wantErr:    true,
+		},
+		{
+			desc:       "duplicate pending partition",
+			checkpoint: &Checkpoint{PendingPartitions: []*PendingPartition{{Token: "a"}, {Token: "a"}}},
+			wantErr:    true,
+		},
+		{
+			desc:       "pending and finished",
+			checkpoint: &Checkpoint{PendingPartitions: []*PendingPartition{{Token: "a"}}, FinishedPartitionTokens: []string{"a"}},
+			wantErr:    true,
+		},
+		{
+			desc:       "partition IDs",
+			checkpoint: &Checkpoint{PendingPartitions: []*PendingPartition{{Token: "a"}}, PartitionIDs: map[string]string{"a": "P-0001", "b": "P-0002"}},
+		},
+		{
+			desc:       "duplicate partition ID",
+			checkpoint: &Checkpoint{PartitionIDs: map[string]string{"a": "P-0001", "b": "P-0001"}},
+			wantErr:    true,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			if err := test.checkpoint.validate(); (err != nil) != test.wantErr {
+				t.Errorf("validate error = %v, wantErr %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestReadResultWatermark(t *testing.T) {
+	start := time.Date(2023, 2, 24, 17, 17, 0, 0, time.UTC)
+	for _, test := range []struct {
+		name   string
+		result *ReadResult
+		want   time.Time
+	}{
+		{name: "empty", result: &ReadResult{}, want: time.Time{}},
+		{
+			name: "data change records",
+			result: &ReadResult{ChangeRecords: []*ChangeRecord{{DataChangeRecords: []*DataChangeRecord{
+				{CommitTimestamp: start.Add(2 * time.Second)},
+				{CommitTimestamp: start.Add(time.Second)},
+			}}}},
+			want: start.Add(2 * time.Second),
+		},
+		{
+			name: "heartbeat and child partitions records",
+			result: &ReadResult{ChangeRecords: []*ChangeRecord{
+				{HeartbeatRecords: []*HeartbeatRecord{{Timestamp: start.Add(3 * time.Second)}}},
+				{ChildPartitionsRecords: []*ChildPartitionsRecord{{StartTimestamp: start.Add(4 * time.Second)}}},
+			}},
+			want: start.Add(4 * time.Second),
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.result.Watermark(); !got.Equal(test.want) {
+				t.Errorf("Watermark = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,260 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+const defaultCheckpointInterval = 10 * time.Second
+
+// DeliveryMode is the guarantee of the delivery of the data change records across a resume from the checkpoints
+// saved to Config.CheckpointStore.
+type DeliveryMode int
+
+const (
+	// DeliveryAtLeastOnce advances the watermarks in the checkpoints only after the read function has returned
+	// for the records, so the records being delivered at a crash are delivered again after the resume.
+	DeliveryAtLeastOnce DeliveryMode = iota
+	// DeliveryAtMostOnce saves a checkpoint past the data change records of each row before the read function
+	// is called for them, so no record is delivered again after the resume, but the records being delivered at
+	// a crash, and the records of the partition at the same commit timestamp that haven't been delivered yet,
+	// are never delivered. The read fails if the checkpoint fails. Note that a checkpoint for every row is
+	// much slower than the checkpoints of DeliveryAtLeastOnce.
+	DeliveryAtMostOnce
+)
+
+// CheckpointStore saves the checkpoints of the read. See Config.CheckpointStore.
+type CheckpointStore interface {
+	// SaveCheckpoint saves the checkpoint, which has the watermarks of all the partitions at once, so that
+	// the store can write them in a single transaction. The checkpoints are saved one at a time, and a later
+	// checkpoint always replaces the earlier ones.
+	SaveCheckpoint(ctx context.Context, checkpoint *Checkpoint) error
+}
+
+// checkpointer tracks the partitions to save in the checkpoints, and triggers the checkpoints.
+// A nil checkpointer tracks nothing.
+type checkpointer struct {
+	store         CheckpointStore
+	interval      time.Duration
+	everyNRecords int64
+	// If transactionBoundaries is true, the watermarks are advanced only when no transaction is open.
+	transactionBoundaries bool
+	// trigger has a value when a checkpoint should be saved before the next interval.
+	trigger chan struct{}
+	// saveMu serializes the checkpoints.
+	saveMu sync.Mutex
+
+	mu sync.Mutex
+	// ready is false until the initial query has returned all the root partitions.
+	ready bool
+	// pending are the partitions that haven't finished keyed by the tokens, whose StartTimestamp is advanced
+	// to the watermark.
+	pending map[string]*PendingPartition
+	// finished are the finished partitions that are the parents of any pending partition. The others are pruned
+	// as soon as they finish, or their last pending child does.
+	finished map[string]bool
+	// openTransactions are the server transaction IDs of the transactions of which the last record in
+	// the partition hasn't been delivered, keyed by the partition token.
+	openTransactions map[string]map[string]bool
+	// records is the number of the data change records delivered since the last checkpoint.
+	records int64
+}
+
+func newCheckpointer(store CheckpointStore, interval time.Duration, everyNRecords int64, transactionBoundaries bool, resumeFrom *Checkpoint) *checkpointer {
+	if store == nil {
+		return nil
+	}
+	if interval == 0 && everyNRecords == 0 {
+		interval = defaultCheckpointInterval
+	}
+	c := &checkpointer{
+		store:                 store,
+		interval:              interval,
+		everyNRecords:         everyNRecords,
+		transactionBoundaries: transactionBoundaries,
+		trigger:               make(chan struct{}, 1),
+		pending:               make(map[string]*PendingPartition),
+		finished:              make(map[string]bool),
+		openTransactions:      make(map[string]map[string]bool),
+	}
+	if resumeFrom != nil {
+		c.ready = true
+		for _, token := range resumeFrom.FinishedPartitionTokens {
+			c.finished[token] = true
+		}
+	}
+	return c
+}
+
+// add tracks the partitions to be read. The partitions already tracked or finished are ignored.
+func (c *checkpointer) add(partitions []*PendingPartition) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, p := range partitions {
+		if _, ok := c.pending[p.Token]; ok || c.finished[p.Token] {
+			continue
+		}
+		c.pending[p.Token] = &PendingPartition{
+			Token:                 p.Token,
+			StartTimestamp:        p.StartTimestamp,
+			ParentPartitionTokens: p.ParentPartitionTokens,
+		}
+	}
+}
+
+// remove stops tracking the partition that is never read by the reader.
+func (c *checkpointer) remove(partitionToken string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pending, partitionToken)
+	delete(c.openTransactions, partitionToken)
+	c.pruneLocked()
+}
+
+// advance advances the watermark of the partition after the data change records have been delivered.
+// With transactionBoundaries, the watermark is held while any transaction of the partition is open.
+func (c *checkpointer) advance(partitionToken string, watermark time.Time, records []*DataChangeRecord) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	open := c.openTransactions[partitionToken]
+	if c.transactionBoundaries {
+		for _, record := range records {
+			switch {
+			case !record.IsLastRecordInTransactionInPartition:
+				if open == nil {
+					open = make(map[string]bool)
+					c.openTransactions[partitionToken] = open
+				}
+				open[record.ServerTransactionID] = true
+			case open != nil:
+				delete(open, record.ServerTransactionID)
+			}
+		}
+	}
+	if p, ok := c.pending[partitionToken]; ok && len(open) == 0 && watermark.After(p.StartTimestamp) {
+		p.StartTimestamp = watermark
+	}
+	c.records += int64(len(records))
+	if c.everyNRecords > 0 && c.records >= c.everyNRecords {
+		c.triggerLocked()
+	}
+}
+
+// finish marks the partition finished, and tracks its children, which must be tracked before the partition
+// finishes so that no checkpoint misses them.
+func (c *checkpointer) finish(partitionToken string, children []*PendingPartition) {
+	if c == nil {
+		return
+	}
+	c.add(children)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if partitionToken == "" {
+		c.ready = true
+	} else {
+		delete(c.pending, partitionToken)
+		delete(c.openTransactions, partitionToken)
+		c.finished[partitionToken] = true
+		c.pruneLocked()
+	}
+	c.triggerLocked()
+}
+
+// pruneLocked removes the finished partitions that are not the parents of any pending partition. c.mu must be held.
+func (c *checkpointer) pruneLocked() {
+	parents := make(map[string]bool)
+	for _, p := range c.pending {
+		for _, parent := range p.ParentPartitionTokens {
+			parents[parent] = true
+		}
+	}
+	for token := range c.finished {
+		if !parents[token] {
+			delete(c.finished, token)
+		}
+	}
+}
+
+func (c *checkpointer) triggerLocked() {
+	if !c.ready {
+		return
+	}
+	select {
+	case c.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// snapshot returns the checkpoint of the partitions, or false until the initial query has finished.
+func (c *checkpointer) snapshot() (*Checkpoint, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.ready {
+		return nil, false
+	}
+	c.records = 0
+
+	checkpoint := &Checkpoint{PendingPartitions: []*PendingPartition{}, FinishedPartitionTokens: []string{}}
+	for _, p := range c.pending {
+		checkpoint.PendingPartitions = append(checkpoint.PendingPartitions, &PendingPartition{
+			Token:                 p.Token,
+			StartTimestamp:        p.StartTimestamp,
+			ParentPartitionTokens: p.ParentPartitionTokens,
+		})
+	}
+	for token := range c.finished {
+		checkpoint.FinishedPartitionTokens = append(checkpoint.FinishedPartitionTokens, token)
+	}
+	sort.Slice(checkpoint.PendingPartitions, func(i, j int) bool {
+		return checkpoint.PendingPartitions[i].Token < checkpoint.PendingPartitions[j].Token
+	})
+	sort.Strings(checkpoint.FinishedPartitionTokens)
+	return checkpoint, true
+}
+
+// run calls save at every interval and at every trigger until stop is closed.
+func (c *checkpointer) run(stop <-chan struct{}, save func()) {
+	var tick <-chan time.Time
+	if c.interval > 0 {
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-stop:
+			return
+		case <-tick:
+			save()
+		case <-c.trigger:
+			save()
+		}
+	}
+}
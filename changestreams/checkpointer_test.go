@@ -0,0 +1,283 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type fakeCheckpointStore struct {
+	mu          sync.Mutex
+	checkpoints []*Checkpoint
+	saved       chan *Checkpoint
+}
+
+func (s *fakeCheckpointStore) SaveCheckpoint(ctx context.Context, checkpoint *Checkpoint) error {
+	s.mu.Lock()
+	s.checkpoints = append(s.checkpoints, checkpoint)
+	s.mu.Unlock()
+	if s.saved != nil {
+		select {
+		case s.saved <- checkpoint:
+		default:
+		}
+	}
+	return nil
+}
+
+func TestCheckpointer(t *testing.T) {
+	start := time.Date(2023, 2, 24, 17, 17, 0, 0, time.UTC)
+
+	c := newCheckpointer(&fakeCheckpointStore{}, 0, 2, false, nil)
+	if c.interval != 0 {
+		t.Errorf("interval = %s, want 0", c.interval)
+	}
+	c.add([]*PendingPartition{{Token: "a", StartTimestamp: start}, {Token: "b", StartTimestamp: start}})
+	if _, ok := c.snapshot(); ok {
+		t.Error("snapshot must wait for the initial query")
+	}
+	c.finish("", nil)
+	<-c.trigger
+
+	c.advance("a", start.Add(time.Second), []*DataChangeRecord{{}})
+	select {
+	case <-c.trigger:
+		t.Error("checkpoint triggered before CheckpointEveryNRecords")
+	default:
+	}
+	c.advance("a", start.Add(2*time.Second), []*DataChangeRecord{{}})
+	<-c.trigger
+
+	c.finish("b", []*PendingPartition{
+		{Token: "c", StartTimestamp: start.Add(time.Minute), ParentPartitionTokens: []string{"a", "b"}},
+	})
+	// A finished partition is never tracked again.
+	c.add([]*PendingPartition{{Token: "b", StartTimestamp: start}})
+	got, _ := c.snapshot()
+	want := &Checkpoint{
+		PendingPartitions: []*PendingPartition{
+			{Token: "a", StartTimestamp: start.Add(2 * time.Second)},
+			{Token: "c", StartTimestamp: start.Add(time.Minute), ParentPartitionTokens: []string{"a", "b"}},
+		},
+		FinishedPartitionTokens: []string{"b"},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("snapshot diff = %v", diff)
+	}
+
+	c.finish("a", nil)
+	if diff := cmp.Diff(c.finished, map[string]bool{"a": true, "b": true}); diff != "" {
+		t.Errorf("finished partitions with the pending child diff = %v", diff)
+	}
+	c.finish("c", nil)
+	got, _ = c.snapshot()
+	if diff := cmp.Diff(got, &Checkpoint{PendingPartitions: []*PendingPartition{}, FinishedPartitionTokens: []string{}}); diff != "" {
+		t.Errorf("snapshot after all the partitions finished diff = %v", diff)
+	}
+	// The finished partitions are pruned once no pending partition is their child.
+	if len(c.finished) != 0 {
+		t.Errorf("finished partitions = %v, want none", c.finished)
+	}
+	c.finish("d", []*PendingPartition{{Token: "e", StartTimestamp: start.Add(time.Minute), ParentPartitionTokens: []string{"d"}}})
+	c.remove("e")
+	if len(c.finished) != 0 {
+		t.Errorf("finished partitions after the child is removed = %v, want none", c.finished)
+	}
+
+	if c := newCheckpointer(nil, 0, 0, false, nil); c != nil {
+		t.Error("checkpointer without store must be nil")
+	}
+	if c := newCheckpointer(&fakeCheckpointStore{}, 0, 0, false, nil); c.interval != defaultCheckpointInterval {
+		t.Errorf("interval = %s, want %s", c.interval, defaultCheckpointInterval)
+	}
+}
+
+func TestCheckpointAtTransactionBoundaries(t *testing.T) {
+	start := time.Date(2023, 2, 24, 17, 17, 0, 0, time.UTC)
+	c := newCheckpointer(&fakeCheckpointStore{}, 0, 0, true, nil)
+	c.add([]*PendingPartition{{Token: "a", StartTimestamp: start}})
+	c.finish("", nil)
+
+	watermark := func() time.Time {
+		t.Helper()
+		checkpoint, _ := c.snapshot()
+		return checkpoint.PendingPartitions[0].StartTimestamp
+	}
+	record := func(txnID string, commitTimestamp time.Time, last bool) *DataChangeRecord {
+		return &DataChangeRecord{ServerTransactionID: txnID, CommitTimestamp: commitTimestamp, IsLastRecordInTransactionInPartition: last}
+	}
+	t1, t2 := start.Add(time.Second), start.Add(2*time.Second)
+
+	c.advance("a", t1, []*DataChangeRecord{record("x", t1, false)})
+	c.advance("a", t1, []*DataChangeRecord{record("y", t1, true)})
+	if got := watermark(); !got.Equal(start) {
+		t.Errorf("watermark while x is open = %v, want %v", got, start)
+	}
+	// A heartbeat doesn't advance the watermark while x is open.
+	c.advance("a", t2, nil)
+	if got := watermark(); !got.Equal(start) {
+		t.Errorf("watermark after the heartbeat while x is open = %v, want %v", got, start)
+	}
+	c.advance("a", t1, []*DataChangeRecord{record("x", t1, true)})
+	if got := watermark(); !got.Equal(t1) {
+		t.Errorf("watermark after x = %v, want %v", got, t1)
+	}
+	c.advance("a", t2, nil)
+	if got := watermark(); !got.Equal(t2) {
+		t.Errorf("watermark after the heartbeat = %v, want %v", got, t2)
+	}
+}
+
+func TestCheckpointStore(t *testing.T) {
+	start := time.Date(2023, 2, 24, 17, 17, 0, 0, time.UTC)
+	server, opts := newFakeSpannerServer(t)
+	server.childPartitions = map[string][]*ChildPartitionsRecord{
+		"": {{StartTimestamp: start, RecordSequence: "00000001", ChildPartitions: []*ChildPartition{{Token: "a"}, {Token: "b"}}}},
+	}
+	// The partition a never finishes until the read is cancelled.
+	server.hangingQueries = map[string]int{"a": 1}
+	store := &fakeCheckpointStore{saved: make(chan *Checkpoint, 1)}
+	r, err := NewReaderWithConfig(context.Background(), "project", "instance", "database", "stream", Config{
+		StartTimestamp:       start,
+		EndTimestamp:         start.Add(time.Minute),
+		SpannerClientOptions: opts,
+		CheckpointStore:      store,
+		CheckpointInterval:   time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("NewReaderWithConfig error: %v", err)
+	}
+	defer r.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		// The checkpoints are saved when the partitions finish.
+		for checkpoint := range store.saved {
+			if len(checkpoint.PendingPartitions) == 1 {
+				cancel()
+				return
+			}
+		}
+	}()
+	if err := r.Read(ctx, func(result *ReadResult) error { return nil }); err == nil {
+		t.Fatal("Read must fail after the cancellation")
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	got := store.checkpoints[len(store.checkpoints)-1]
+	want := &Checkpoint{
+		PendingPartitions:       []*PendingPartition{{Token: "a", StartTimestamp: start, ParentPartitionTokens: []string{}}},
+		FinishedPartitionTokens: []string{},
+		PartitionIDs:            map[string]string{"a": "P-0001", "b": "P-0002"},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("final checkpoint diff = %v", diff)
+	}
+}
+
+func TestDeliveryMode(t *testing.T) {
+	start := time.Date(2023, 2, 24, 17, 17, 0, 0, time.UTC)
+	records := []*DataChangeRecord{
+		{CommitTimestamp: start.Add(time.Second), RecordSequence: "00000000", ServerTransactionID: "x", IsLastRecordInTransactionInPartition: true},
+		{CommitTimestamp: start.Add(2 * time.Second), RecordSequence: "00000001", ServerTransactionID: "y"},
+		{CommitTimestamp: start.Add(2 * time.Second), RecordSequence: "00000002", ServerTransactionID: "y", IsLastRecordInTransactionInPartition: true},
+		{CommitTimestamp: start.Add(3 * time.Second), RecordSequence: "00000003", ServerTransactionID: "z", IsLastRecordInTransactionInPartition: true},
+	}
+	errCrash := errors.New("crash")
+
+	for _, mode := range []DeliveryMode{DeliveryAtLeastOnce, DeliveryAtMostOnce} {
+		// The pipeline crashes while each record is being delivered, and is resumed from the last checkpoint.
+		for crashAt := range records {
+			server, opts := newFakeSpannerServer(t)
+			server.childPartitions = map[string][]*ChildPartitionsRecord{
+				"": {{StartTimestamp: start, RecordSequence: "00000001", ChildPartitions: []*ChildPartition{{Token: "a"}}}},
+			}
+			server.dataChangeRecords = map[string][]*DataChangeRecord{"a": records}
+
+			// calls and acks are the numbers of the calls of the read function, and of the calls that returned nil,
+			// keyed by the record sequence.
+			calls := make(map[string]int)
+			acks := make(map[string]int)
+			// read returns the last checkpoint saved by the read.
+			read := func(crash bool, resumeFrom *Checkpoint) (*Checkpoint, error) {
+				store := &fakeCheckpointStore{}
+				config := Config{
+					StartTimestamp:          start,
+					EndTimestamp:            start.Add(time.Minute),
+					SpannerClientOptions:    opts,
+					CheckpointStore:         store,
+					CheckpointEveryNRecords: 1,
+					DeliveryMode:            mode,
+					ResumeFrom:              resumeFrom,
+				}
+				r, err := NewReaderWithConfig(context.Background(), "project", "instance", "database", "stream", config)
+				if err != nil {
+					t.Fatalf("NewReaderWithConfig error: %v", err)
+				}
+				defer r.Close()
+				err = r.Read(context.Background(), func(result *ReadResult) error {
+					for record := range result.DataChangeRecords() {
+						calls[record.RecordSequence]++
+						if crash && record.RecordSequence == records[crashAt].RecordSequence {
+							return errCrash
+						}
+						acks[record.RecordSequence]++
+					}
+					return nil
+				})
+				store.mu.Lock()
+				defer store.mu.Unlock()
+				if n := len(store.checkpoints); n > 0 {
+					return store.checkpoints[n-1], err
+				}
+				return nil, err
+			}
+			// Without any checkpoint, the read is restarted from StartTimestamp.
+			checkpoint, err := read(true, nil)
+			if !errors.Is(err, errCrash) {
+				t.Fatalf("Read error = %v, want %v", err, errCrash)
+			}
+			if _, err := read(false, checkpoint); err != nil {
+				t.Fatalf("Read after the resume error: %v", err)
+			}
+
+			for _, record := range records {
+				seq := record.RecordSequence
+				switch mode {
+				case DeliveryAtLeastOnce:
+					if acks[seq] < 1 {
+						t.Errorf("record %s has never been delivered with DeliveryAtLeastOnce after the crash at %d", seq, crashAt)
+					}
+				case DeliveryAtMostOnce:
+					if calls[seq] > 1 {
+						t.Errorf("record %s has been delivered %d times with DeliveryAtMostOnce after the crash at %d", seq, calls[seq], crashAt)
+					}
+				}
+			}
+			if mode == DeliveryAtMostOnce && calls[records[crashAt].RecordSequence] != 1 {
+				t.Errorf("record being delivered at the crash must not be delivered again")
+			}
+		}
+	}
+}
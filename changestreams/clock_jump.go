@@ -0,0 +1,123 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	minClockCheckInterval = time.Second
+	maxClockCheckInterval = 15 * time.Second
+	// retiredClientCloseDelay is the time the previous client is kept after the client is replaced at a clock
+	// jump, so that the queries being cancelled on it finish with the cancellation rather than the closed client.
+	retiredClientCloseDelay = time.Minute
+)
+
+var errClockJumped = errors.New("clock jumped")
+
+// clockJumpDetector detects that the world moved under the reader, e.g. the machine slept or the process was
+// frozen, by comparing the wall clock with the monotonic clock, which doesn't advance while the machine sleeps,
+// at every interval. A nil clockJumpDetector never detects a jump.
+type clockJumpDetector struct {
+	threshold time.Duration
+	interval  time.Duration
+	// onJump is called at the jump before the queries are cancelled.
+	onJump func(jump time.Duration)
+	// jumped is closed at the next jump.
+	jumped chan struct{}
+	mu     sync.Mutex
+}
+
+func newClockJumpDetector(threshold time.Duration) *clockJumpDetector {
+	if threshold <= 0 {
+		return nil
+	}
+	interval := min(max(threshold/4, minClockCheckInterval), maxClockCheckInterval)
+	return &clockJumpDetector{threshold: threshold, interval: interval, jumped: make(chan struct{})}
+}
+
+// run checks the clocks every interval until stop is closed.
+func (d *clockJumpDetector) run(stop <-chan struct{}) {
+	if d == nil {
+		return
+	}
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	prev := time.Now()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			// Round(0) strips the monotonic clock reading, so the difference is in the wall clock.
+			if jump, ok := d.check(now.Sub(prev), now.Round(0).Sub(prev.Round(0))); ok {
+				d.fire(jump)
+			}
+			prev = now
+		}
+	}
+}
+
+// check returns the jump if either the wall clock advanced more than the monotonic clock, e.g. the machine
+// slept, or the monotonic clock advanced more than the interval, e.g. the process was frozen, by the threshold.
+func (d *clockJumpDetector) check(monotonic, wall time.Duration) (time.Duration, bool) {
+	jump := max(wall-monotonic, monotonic-d.interval)
+	return jump, jump >= d.threshold
+}
+
+// fire calls onJump, and then cancels the queries being watched.
+func (d *clockJumpDetector) fire(jump time.Duration) {
+	if d.onJump != nil {
+		d.onJump(jump)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	close(d.jumped)
+	d.jumped = make(chan struct{})
+}
+
+// watch returns the context of the query cancelled at the next jump, and the function to stop watching,
+// which reports whether the context has been cancelled by the jump.
+func (d *clockJumpDetector) watch(ctx context.Context) (context.Context, func() bool) {
+	if d == nil {
+		return ctx, func() bool { return false }
+	}
+	d.mu.Lock()
+	jumped := d.jumped
+	d.mu.Unlock()
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-jumped:
+			cancel(errClockJumped)
+		case <-done:
+		}
+	}()
+	return ctx, func() bool {
+		close(done)
+		cancelled := errors.Is(context.Cause(ctx), errClockJumped)
+		cancel(nil)
+		return cancelled
+	}
+}
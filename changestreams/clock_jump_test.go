@@ -0,0 +1,130 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestClockJumpDetector(t *testing.T) {
+	d := newClockJumpDetector(time.Minute)
+	if d.interval != 15*time.Second {
+		t.Errorf("interval = %s, want 15s", d.interval)
+	}
+	for _, test := range []struct {
+		desc            string
+		monotonic, wall time.Duration
+		want            bool
+	}{
+		{desc: "no jump", monotonic: d.interval, wall: d.interval},
+		{desc: "small drift", monotonic: d.interval, wall: d.interval + time.Second},
+		{desc: "machine slept", monotonic: d.interval, wall: d.interval + time.Hour, want: true},
+		{desc: "wall clock set back", monotonic: d.interval, wall: d.interval - time.Hour},
+		{desc: "process frozen", monotonic: d.interval + time.Hour, wall: d.interval + time.Hour, want: true},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			if _, got := d.check(test.monotonic, test.wall); got != test.want {
+				t.Errorf("check(%s, %s) = %v, want %v", test.monotonic, test.wall, got, test.want)
+			}
+		})
+	}
+
+	t.Run("jump cancels the watched contexts", func(t *testing.T) {
+		var jumps []time.Duration
+		d := newClockJumpDetector(time.Minute)
+		d.onJump = func(jump time.Duration) { jumps = append(jumps, jump) }
+
+		ctx, stop := d.watch(context.Background())
+		d.fire(time.Hour)
+		<-ctx.Done()
+		if !stop() {
+			t.Error("stop() = false, want true")
+		}
+
+		// The next watch waits for the next jump.
+		ctx, stop = d.watch(context.Background())
+		if ctx.Err() != nil {
+			t.Errorf("context is cancelled before the next jump: %v", ctx.Err())
+		}
+		if stop() {
+			t.Error("stop() = true, want false")
+		}
+		if diff := cmp.Diff(jumps, []time.Duration{time.Hour}); diff != "" {
+			t.Errorf("jumps diff = %v", diff)
+		}
+	})
+
+	t.Run("zero threshold", func(t *testing.T) {
+		d := newClockJumpDetector(0)
+		parent := context.Background()
+		ctx, stop := d.watch(parent)
+		if ctx != parent || stop() {
+			t.Error("detector with zero threshold must be a no-op")
+		}
+	})
+}
+
+func TestClockJump(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2023, 2, 24, 17, 17, 0, 0, time.UTC)
+	server, opts := newFakeSpannerServer(t)
+	server.childPartitions = map[string][]*ChildPartitionsRecord{
+		"": {{StartTimestamp: start, RecordSequence: "00000001", ChildPartitions: []*ChildPartition{{Token: "a"}}}},
+	}
+	server.hangingQueries = map[string]int{"a": 1}
+	r, err := NewReaderWithConfig(ctx, "project", "instance", "database", "stream", Config{
+		StartTimestamp:       start,
+		EndTimestamp:         start.Add(time.Minute),
+		SpannerClientOptions: opts,
+		ClockJumpThreshold:   time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("NewReaderWithConfig error: %v", err)
+	}
+	defer r.Close()
+	client := r.spannerClient()
+
+	// The clock jumps while the partition a is hanging.
+	go func() {
+		for {
+			server.mu.Lock()
+			hanging := server.hangingQueries["a"] == 0
+			server.mu.Unlock()
+			if hanging {
+				r.clockJumps.fire(time.Hour)
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	if err := r.Read(ctx, func(result *ReadResult) error { return nil }); err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	if diff := cmp.Diff(server.readTokens, []string{"", "a", "a"}); diff != "" {
+		t.Errorf("read partitions diff = %v", diff)
+	}
+	if r.spannerClient() == client {
+		t.Error("client must be replaced after the clock jump")
+	}
+	if retries := r.Stats().Retries; retries != 1 {
+		t.Errorf("Retries = %d, want 1", retries)
+	}
+}
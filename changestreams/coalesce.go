@@ -0,0 +1,157 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+type pendingRecord struct {
+	partitionToken string
+	record         *DataChangeRecord
+}
+
+// coalescer buffers the data change records and delivers only the latest record per key in each window.
+type coalescer struct {
+	window  time.Duration
+	key     func(record *DataChangeRecord) string
+	deliver func(result *ReadResult) error
+	pending map[string]*pendingRecord
+	mu      sync.Mutex
+	// deliverMu serializes the flushes, so that the records of a key are delivered in order even when the window,
+	// a barrier and a checkpoint flush at the same time.
+	deliverMu sync.Mutex
+}
+
+func newCoalescer(window time.Duration, key func(record *DataChangeRecord) string, deliver func(result *ReadResult) error) *coalescer {
+	if key == nil {
+		key = defaultCoalesceKey
+	}
+	return &coalescer{
+		window:  window,
+		key:     key,
+		deliver: deliver,
+		pending: make(map[string]*pendingRecord),
+	}
+}
+
+// defaultCoalesceKey returns the table name and the primary keys of the mods of the record.
+func defaultCoalesceKey(record *DataChangeRecord) string {
+	keys := make([]interface{}, 0, len(record.Mods))
+	for _, mod := range record.Mods {
+		keys = append(keys, mod.Keys)
+	}
+	b, err := json.Marshal(keys)
+	if err != nil {
+		// Record is not coalesced with any other record.
+		return record.ServerTransactionID + "/" + record.RecordSequence
+	}
+	return record.TableName + "/" + string(b)
+}
+
+// add buffers the data change records of the result, and delivers the rest of the records immediately.
+func (c *coalescer) add(result *ReadResult) error {
+	c.mu.Lock()
+	hasOtherRecords := false
+	for _, changeRecord := range result.ChangeRecords {
+		for _, record := range changeRecord.DataChangeRecords {
+			key := c.key(record)
+			// A later record, including a DELETE, always replaces the earlier one.
+			if p, ok := c.pending[key]; !ok || !isBefore(record, p.record) {
+				c.pending[key] = &pendingRecord{partitionToken: result.PartitionToken, record: record}
+			}
+		}
+		changeRecord.DataChangeRecords = []*DataChangeRecord{}
+		if len(changeRecord.HeartbeatRecords) > 0 || len(changeRecord.ChildPartitionsRecords) > 0 {
+			hasOtherRecords = true
+		}
+	}
+	c.mu.Unlock()
+
+	if !hasOtherRecords {
+		return nil
+	}
+	return c.deliver(result)
+}
+
+// flush delivers the buffered records in commit timestamp order.
+func (c *coalescer) flush() error {
+	c.deliverMu.Lock()
+	defer c.deliverMu.Unlock()
+
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = make(map[string]*pendingRecord)
+	c.mu.Unlock()
+
+	records := make([]*pendingRecord, 0, len(pending))
+	for _, p := range pending {
+		records = append(records, p)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return isBefore(records[i].record, records[j].record)
+	})
+	for _, p := range records {
+		if err := c.deliver(&ReadResult{
+			PartitionToken: p.partitionToken,
+			ChangeRecords: []*ChangeRecord{
+				{
+					DataChangeRecords:      []*DataChangeRecord{p.record},
+					HeartbeatRecords:       []*HeartbeatRecord{},
+					ChildPartitionsRecords: []*ChildPartitionsRecord{},
+				},
+			},
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buffered returns the number of the buffered records.
+func (c *coalescer) buffered() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pending)
+}
+
+// run flushes the buffered records at the end of every window until stop is closed.
+func (c *coalescer) run(stop <-chan struct{}) error {
+	ticker := time.NewTicker(c.window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+			if err := c.flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func isBefore(a, b *DataChangeRecord) bool {
+	if !a.CommitTimestamp.Equal(b.CommitTimestamp) {
+		return a.CommitTimestamp.Before(b.CommitTimestamp)
+	}
+	return a.RecordSequence < b.RecordSequence
+}
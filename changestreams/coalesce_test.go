@@ -0,0 +1,164 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestCoalescer(t *testing.T) {
+	start := mustParseTime("2023-02-24T17:00:00Z")
+	newRecord := func(seconds int, modType, id string) *DataChangeRecord {
+		return &DataChangeRecord{
+			CommitTimestamp: start.Add(time.Duration(seconds) * time.Second),
+			RecordSequence:  "00000000",
+			TableName:       "players",
+			ModType:         modType,
+			Mods: []*Mod{
+				{Keys: spanner.NullJSON{Value: map[string]interface{}{"id": id}, Valid: true}},
+			},
+		}
+	}
+	resultOf := func(token string, records ...*DataChangeRecord) *ReadResult {
+		return &ReadResult{
+			PartitionToken: token,
+			ChangeRecords:  []*ChangeRecord{{DataChangeRecords: records}},
+		}
+	}
+
+	var delivered []*ReadResult
+	c := newCoalescer(time.Minute, nil, func(result *ReadResult) error {
+		delivered = append(delivered, result)
+		return nil
+	})
+
+	insert1 := newRecord(0, "INSERT", "1")
+	update1 := newRecord(1, "UPDATE", "1")
+	delete1 := newRecord(2, "DELETE", "1")
+	update2 := newRecord(3, "UPDATE", "2")
+	heartbeat := &ChangeRecord{HeartbeatRecords: []*HeartbeatRecord{{Timestamp: start}}}
+
+	for _, result := range []*ReadResult{
+		resultOf("a", insert1),
+		resultOf("a", delete1, update2),
+		// Arrives late from another partition, must not replace the DELETE.
+		resultOf("b", update1),
+		{PartitionToken: "a", ChangeRecords: []*ChangeRecord{heartbeat}},
+	} {
+		if err := c.add(result); err != nil {
+			t.Fatalf("add error: %v", err)
+		}
+	}
+
+	// Only the heartbeat is delivered before the flush.
+	if len(delivered) != 1 || delivered[0].ChangeRecords[0] != heartbeat {
+		t.Fatalf("delivered before flush = %v, want only the heartbeat", delivered)
+	}
+
+	delivered = nil
+	if err := c.flush(); err != nil {
+		t.Fatalf("flush error: %v", err)
+	}
+	var got []*DataChangeRecord
+	for _, result := range delivered {
+		for r := range result.DataChangeRecords() {
+			got = append(got, r)
+		}
+	}
+	if diff := cmp.Diff(got, []*DataChangeRecord{delete1, update2}); diff != "" {
+		t.Errorf("flushed records diff = %v", diff)
+	}
+}
+
+func TestCoalescerBarrierDuringWindowFlush(t *testing.T) {
+	ctx := context.Background()
+	start := mustParseTime("2023-02-24T17:00:00Z")
+	newRecord := func(seconds int, modType string) *DataChangeRecord {
+		return &DataChangeRecord{
+			CommitTimestamp: start.Add(time.Duration(seconds) * time.Second),
+			RecordSequence:  "00000000",
+			TableName:       "players",
+			ModType:         modType,
+			Mods: []*Mod{
+				{Keys: spanner.NullJSON{Value: map[string]interface{}{"id": "1"}, Valid: true}},
+			},
+		}
+	}
+	resultOf := func(record *DataChangeRecord) *ReadResult {
+		return &ReadResult{PartitionToken: "a", ChangeRecords: []*ChangeRecord{{DataChangeRecords: []*DataChangeRecord{record}}}}
+	}
+	update := newRecord(0, "UPDATE")
+	del := newRecord(1, "DELETE")
+
+	// The window flush blocks in the delivery of the UPDATE, while the DELETE of the same key arrives and is
+	// flushed by the barrier.
+	var mu sync.Mutex
+	var delivered []*DataChangeRecord
+	entered, release := make(chan struct{}), make(chan struct{})
+	c := newCoalescer(time.Millisecond, nil, func(result *ReadResult) error {
+		for record := range result.DataChangeRecords() {
+			if record == update {
+				close(entered)
+				<-release
+			}
+			mu.Lock()
+			delivered = append(delivered, record)
+			mu.Unlock()
+		}
+		return nil
+	})
+	r := &Reader{group: &errgroup.Group{}, coalescer: c, stats: newStatsRecorder()}
+	if err := c.add(resultOf(update)); err != nil {
+		t.Fatalf("add error: %v", err)
+	}
+
+	stop := make(chan struct{})
+	var flusher errgroup.Group
+	flusher.Go(func() error { return c.run(stop) })
+	<-entered
+	close(stop)
+	if err := c.add(resultOf(del)); err != nil {
+		t.Fatalf("add error: %v", err)
+	}
+	barrier := make(chan error, 1)
+	go func() {
+		_, err := r.Barrier(ctx)
+		barrier <- err
+	}()
+	select {
+	case err := <-barrier:
+		t.Fatalf("Barrier returned while the window flush is delivering, error: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if err := <-barrier; err != nil {
+		t.Fatalf("Barrier error: %v", err)
+	}
+	if err := flusher.Wait(); err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+	if diff := cmp.Diff(delivered, []*DataChangeRecord{update, del}); diff != "" {
+		t.Errorf("delivered records diff = %v", diff)
+	}
+}
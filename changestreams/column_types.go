@@ -0,0 +1,89 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// columnTypeCache caches the decoded types of the non-key columns of each table, so that the types are not
+// decoded from ColumnType.Type for every record. A cached entry is used only while the columns of the table,
+// including their types, are the same as when it was cached, e.g. until the schema changes.
+type columnTypeCache struct {
+	tables sync.Map
+}
+
+type cachedColumnTypes struct {
+	signature string
+	types     map[string]*Type
+}
+
+// lookup returns the decoded types of the non-key columns of the record keyed by the column names.
+func (c *columnTypeCache) lookup(record *DataChangeRecord) (map[string]*Type, error) {
+	signature := columnTypesSignature(record.ColumnTypes)
+	if cached, ok := c.tables.Load(record.TableName); ok && cached.(*cachedColumnTypes).signature == signature {
+		return cached.(*cachedColumnTypes).types, nil
+	}
+	types := make(map[string]*Type, len(record.ColumnTypes))
+	for _, column := range record.ColumnTypes {
+		if column.IsPrimaryKey {
+			continue
+		}
+		t, err := column.DecodeType()
+		if err != nil {
+			return nil, err
+		}
+		types[column.Name] = t
+	}
+	c.tables.Store(record.TableName, &cachedColumnTypes{signature: signature, types: types})
+	return types, nil
+}
+
+// columnTypesSignature returns the string that identifies the columns and their types, which is much cheaper
+// than decoding the types.
+func columnTypesSignature(columns []*ColumnType) string {
+	var b strings.Builder
+	for _, column := range columns {
+		b.WriteString(strconv.Quote(column.Name))
+		if column.IsPrimaryKey {
+			b.WriteString("!")
+		}
+		writeTypeSignature(&b, column.Type.Value)
+		b.WriteString(";")
+	}
+	return b.String()
+}
+
+func writeTypeSignature(b *strings.Builder, v interface{}) {
+	t, ok := v.(map[string]interface{})
+	if !ok {
+		b.WriteString("?")
+		return
+	}
+	code, _ := t["code"].(string)
+	b.WriteString(code)
+	if annotation, ok := t["type_annotation"].(string); ok {
+		b.WriteString("(" + annotation + ")")
+	}
+	if element, ok := t["array_element_type"]; ok {
+		b.WriteString("<")
+		writeTypeSignature(b, element)
+		b.WriteString(">")
+	}
+}
@@ -0,0 +1,189 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// valueFormat is how the values of the columns of each type appear in Mod.NewValues and Mod.OldValues.
+// The zero value leaves the values as returned from Cloud Spanner.
+type valueFormat struct {
+	bytes           BytesFormat
+	numeric         NumericFormat
+	nonFiniteFloats NonFiniteFloatPolicy
+	parseJSON       bool
+	// The TIMESTAMP columns are re-rendered in timestampLocation with timestampLayout if either is set.
+	timestampLocation *time.Location
+	timestampLayout   string
+	// types caches the types of the columns. If it's nil, the types are decoded for every record.
+	types *columnTypeCache
+	// jsonParseFailures counts the JSON values left as strings because they failed to parse with parseJSON.
+	jsonParseFailures *atomic.Int64
+}
+
+// isZero reports whether the format leaves all the values as they are.
+func (f valueFormat) isZero() bool {
+	return f.bytes == BytesFormatBase64 && f.numeric == NumericFormatString && f.nonFiniteFloats == NonFiniteFloatString && !f.parseJSON &&
+		f.timestampLocation == nil && f.timestampLayout == ""
+}
+
+// converts reports whether the values of the type are converted by the format.
+func (f valueFormat) converts(t *Type) bool {
+	switch t.Code {
+	case "ARRAY":
+		return t.ArrayElementType != nil && f.converts(t.ArrayElementType)
+	case "BYTES":
+		return f.bytes != BytesFormatBase64
+	case "NUMERIC":
+		return f.numeric != NumericFormatString
+	case "FLOAT32", "FLOAT64":
+		return f.nonFiniteFloats != NonFiniteFloatString
+	case "JSON":
+		return f.parseJSON
+	case "TIMESTAMP":
+		return f.timestampLocation != nil || f.timestampLayout != ""
+	}
+	return false
+}
+
+// formatValues applies the format to the values of the mods of the data change records according to
+// their ColumnTypes, so that the values are the same in every output format and sink. The primary key columns
+// are left as they are, since they identify the rows.
+func formatValues(changeRecord *ChangeRecord, format valueFormat) error {
+	if format.isZero() {
+		return nil
+	}
+	for _, record := range changeRecord.DataChangeRecords {
+		columnTypes, err := format.columnTypes(record)
+		if err != nil {
+			return err
+		}
+		types := make(map[string]*Type)
+		for name, t := range columnTypes {
+			if format.converts(t) {
+				types[name] = t
+			}
+		}
+		if len(types) == 0 {
+			continue
+		}
+		for _, mod := range record.Mods {
+			for _, v := range []interface{}{mod.NewValues.Value, mod.OldValues.Value} {
+				values, ok := v.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				for name, t := range types {
+					v, ok := values[name]
+					if !ok {
+						continue
+					}
+					formatted, err := format.formatValue(t, v)
+					if err != nil {
+						return fmt.Errorf("column %s of table %s: %w", name, record.TableName, err)
+					}
+					values[name] = formatted
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// columnTypes returns the types of the non-key columns of the record keyed by the column names.
+func (f valueFormat) columnTypes(record *DataChangeRecord) (map[string]*Type, error) {
+	if f.types != nil {
+		return f.types.lookup(record)
+	}
+	types := make(map[string]*Type, len(record.ColumnTypes))
+	for _, c := range record.ColumnTypes {
+		if c.IsPrimaryKey {
+			continue
+		}
+		t, err := c.DecodeType()
+		if err != nil {
+			return nil, err
+		}
+		types[c.Name] = t
+	}
+	return types, nil
+}
+
+func (f valueFormat) formatValue(t *Type, v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	switch t.Code {
+	case "ARRAY":
+		elements, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("unexpected value of ARRAY: %T", v)
+		}
+		formatted := make([]interface{}, len(elements))
+		for i, e := range elements {
+			fe, err := f.formatValue(t.ArrayElementType, e)
+			if err != nil {
+				return nil, err
+			}
+			formatted[i] = fe
+		}
+		return formatted, nil
+	case "BYTES":
+		return formatBytesValue(v, f.bytes)
+	case "NUMERIC":
+		return formatNumericValue(v, f.numeric)
+	case "FLOAT32", "FLOAT64":
+		return formatFloatValue(v, f.nonFiniteFloats)
+	case "JSON":
+		return f.parseJSONValue(v), nil
+	case "TIMESTAMP":
+		return formatTimestampValue(v, f.timestampLocation, f.timestampLayout)
+	}
+	return v, nil
+}
+
+// parseJSONValue parses the JSON value, which is a string of the JSON document, into the structured value.
+// The numbers are parsed as json.Number to keep their precision. If the value fails to parse, it's left
+// as the string and counted in jsonParseFailures.
+func (f valueFormat) parseJSONValue(v interface{}) interface{} {
+	s, ok := v.(string)
+	if !ok {
+		return v
+	}
+	d := json.NewDecoder(bytes.NewReader([]byte(s)))
+	d.UseNumber()
+	var parsed interface{}
+	if err := d.Decode(&parsed); err != nil || !atEOF(d) {
+		if f.jsonParseFailures != nil {
+			f.jsonParseFailures.Add(1)
+		}
+		return v
+	}
+	return parsed
+}
+
+// atEOF reports whether the decoder has nothing but whitespace left.
+func atEOF(d *json.Decoder) bool {
+	_, err := d.Token()
+	return err == io.EOF
+}
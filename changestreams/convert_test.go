@@ -0,0 +1,186 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseJSONColumns(t *testing.T) {
+	jsonValue := func(v interface{}) spanner.NullJSON {
+		return spanner.NullJSON{Value: v, Valid: true}
+	}
+	record := &DataChangeRecord{
+		TableName: "Products",
+		ModType:   "UPDATE",
+		ColumnTypes: []*ColumnType{
+			{Name: "id", Type: jsonValue(map[string]interface{}{"code": "STRING"}), IsPrimaryKey: true},
+			{Name: "attrs", Type: jsonValue(map[string]interface{}{"code": "JSON"})},
+			{Name: "docs", Type: jsonValue(map[string]interface{}{"code": "ARRAY", "array_element_type": map[string]interface{}{"code": "JSON"}})},
+			{Name: "broken", Type: jsonValue(map[string]interface{}{"code": "JSON", "type_annotation": "PG_JSONB"})},
+			{Name: "name", Type: jsonValue(map[string]interface{}{"code": "STRING"})},
+		},
+		Mods: []*Mod{{
+			Keys: jsonValue(map[string]interface{}{"id": "1"}),
+			NewValues: jsonValue(map[string]interface{}{
+				"id":     "1",
+				"attrs":  `{"price": 12.345678901234567890, "big": 12345678901234567890, "tags": ["a"]}`,
+				"docs":   []interface{}{`[1, 2]`, nil, `"text"`},
+				"broken": `{"a": 1}}`,
+				"name":   `{"a": 1}`,
+			}),
+			OldValues: jsonValue(map[string]interface{}{"attrs": "null"}),
+		}},
+	}
+	failures := &atomic.Int64{}
+	format := valueFormat{parseJSON: true, jsonParseFailures: failures}
+	if err := formatValues(&ChangeRecord{DataChangeRecords: []*DataChangeRecord{record}}, format); err != nil {
+		t.Fatalf("formatValues error: %v", err)
+	}
+
+	b, err := json.Marshal(record.Mods[0].NewValues)
+	if err != nil {
+		t.Fatalf("failed to marshal the new values: %v", err)
+	}
+	// The numbers keep all their digits, and the string columns and the broken values are left as they are.
+	want := `{"attrs":{"big":12345678901234567890,"price":12.345678901234567890,"tags":["a"]},"broken":"{\"a\": 1}}","docs":[[1,2],null,"text"],"id":"1","name":"{\"a\": 1}"}`
+	if diff := cmp.Diff(string(b), want); diff != "" {
+		t.Errorf("new values diff = %v", diff)
+	}
+	if diff := cmp.Diff(record.Mods[0].OldValues.Value, map[string]interface{}{"attrs": nil}); diff != "" {
+		t.Errorf("old values diff = %v", diff)
+	}
+	if got := failures.Load(); got != 1 {
+		t.Errorf("failures = %d, want 1", got)
+	}
+}
+
+func TestFormatTimestampColumns(t *testing.T) {
+	jsonValue := func(v interface{}) spanner.NullJSON {
+		return spanner.NullJSON{Value: v, Valid: true}
+	}
+	newRecord := func() *DataChangeRecord {
+		return &DataChangeRecord{
+			TableName: "Users",
+			ModType:   "UPDATE",
+			ColumnTypes: []*ColumnType{
+				{Name: "id", Type: jsonValue(map[string]interface{}{"code": "STRING"}), IsPrimaryKey: true},
+				{Name: "updated_at", Type: jsonValue(map[string]interface{}{"code": "TIMESTAMP"})},
+				{Name: "history", Type: jsonValue(map[string]interface{}{"code": "ARRAY", "array_element_type": map[string]interface{}{"code": "TIMESTAMP"}})},
+				{Name: "birthday", Type: jsonValue(map[string]interface{}{"code": "DATE"})},
+			},
+			Mods: []*Mod{{
+				Keys: jsonValue(map[string]interface{}{"id": "1"}),
+				NewValues: jsonValue(map[string]interface{}{
+					"updated_at": "2023-02-25T01:17:00.678847Z",
+					"history":    []interface{}{"2023-02-24T15:00:00Z", nil},
+					"birthday":   "1990-01-01",
+				}),
+				OldValues: jsonValue(map[string]interface{}{"updated_at": nil}),
+			}},
+		}
+	}
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatalf("failed to load the location: %v", err)
+	}
+
+	for _, test := range []struct {
+		desc   string
+		format valueFormat
+		want   map[string]interface{}
+	}{
+		{
+			desc:   "location",
+			format: valueFormat{timestampLocation: tokyo},
+			want: map[string]interface{}{
+				"updated_at": "2023-02-25T10:17:00.678847+09:00",
+				"history":    []interface{}{"2023-02-25T00:00:00+09:00", nil},
+				"birthday":   "1990-01-01",
+			},
+		},
+		{
+			desc:   "layout",
+			format: valueFormat{timestampLayout: "2006-01-02 15:04:05"},
+			want: map[string]interface{}{
+				"updated_at": "2023-02-25 01:17:00",
+				"history":    []interface{}{"2023-02-24 15:00:00", nil},
+				"birthday":   "1990-01-01",
+			},
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			record := newRecord()
+			if err := formatValues(&ChangeRecord{DataChangeRecords: []*DataChangeRecord{record}}, test.format); err != nil {
+				t.Fatalf("formatValues error: %v", err)
+			}
+			if diff := cmp.Diff(record.Mods[0].NewValues.Value, test.want); diff != "" {
+				t.Errorf("new values diff = %v", diff)
+			}
+			if diff := cmp.Diff(record.Mods[0].OldValues.Value, map[string]interface{}{"updated_at": nil}); diff != "" {
+				t.Errorf("old values diff = %v", diff)
+			}
+		})
+	}
+}
+
+func TestColumnTypeCache(t *testing.T) {
+	jsonValue := func(v interface{}) spanner.NullJSON {
+		return spanner.NullJSON{Value: v, Valid: true}
+	}
+	newRecord := func(code string) *DataChangeRecord {
+		return &DataChangeRecord{
+			TableName: "Users",
+			ColumnTypes: []*ColumnType{
+				{Name: "id", Type: jsonValue(map[string]interface{}{"code": "STRING"}), IsPrimaryKey: true},
+				{Name: "value", Type: jsonValue(map[string]interface{}{"code": code})},
+			},
+		}
+	}
+	cache := &columnTypeCache{}
+
+	first, err := cache.lookup(newRecord("TIMESTAMP"))
+	if err != nil {
+		t.Fatalf("lookup error: %v", err)
+	}
+	if diff := cmp.Diff(first, map[string]*Type{"value": {Code: "TIMESTAMP"}}); diff != "" {
+		t.Errorf("types diff = %v", diff)
+	}
+	second, err := cache.lookup(newRecord("TIMESTAMP"))
+	if err != nil {
+		t.Fatalf("lookup error: %v", err)
+	}
+	// The same columns reuse the cached types.
+	if second["value"] != first["value"] {
+		t.Error("types must be cached")
+	}
+
+	// The type of the column changed, e.g. by ALTER TABLE.
+	changed, err := cache.lookup(newRecord("STRING"))
+	if err != nil {
+		t.Fatalf("lookup error: %v", err)
+	}
+	if diff := cmp.Diff(changed, map[string]*Type{"value": {Code: "STRING"}}); diff != "" {
+		t.Errorf("types diff = %v", diff)
+	}
+}
@@ -0,0 +1,218 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"cloud.google.com/go/spanner"
+	sppb "cloud.google.com/go/spanner/apiv1/spannerpb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// DecodeError is the error of the part of the row of the change stream query that failed to decode.
+type DecodeError struct {
+	PartitionToken string
+	// Path is the part of the row that failed, e.g. ChangeRecord[0].data_change_record[1], or ChangeRecord for
+	// the whole row.
+	Path string
+	Err  error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("failed to decode %s: %v", e.Path, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// salvageRow decodes the records of the row one by one after the whole row failed to decode, and returns
+// the change records that decoded. The records that failed are reported to report.
+func (r *Reader) salvageRow(row *spanner.Row, partitionToken string, report func(err *DecodeError)) []*ChangeRecord {
+	d := rowSalvager{partitionToken: partitionToken, report: report}
+	if r.dialect == dialectPostgreSQL {
+		return d.postgres(row)
+	}
+	return d.googleSQL(row)
+}
+
+type rowSalvager struct {
+	partitionToken string
+	report         func(err *DecodeError)
+}
+
+func (d *rowSalvager) fail(path string, err error) {
+	d.report(&DecodeError{PartitionToken: d.partitionToken, Path: path, Err: err})
+}
+
+func newChangeRecord() *ChangeRecord {
+	return &ChangeRecord{
+		DataChangeRecords:      []*DataChangeRecord{},
+		HeartbeatRecords:       []*HeartbeatRecord{},
+		ChildPartitionsRecords: []*ChildPartitionsRecord{},
+	}
+}
+
+// googleSQL salvages the row of ARRAY<STRUCT<data_change_record ARRAY<STRUCT<...>>, ...>>.
+func (d *rowSalvager) googleSQL(row *spanner.Row) []*ChangeRecord {
+	var col spanner.GenericColumnValue
+	if err := row.Column(0, &col); err != nil {
+		d.fail("ChangeRecord", err)
+		return nil
+	}
+	structType := col.Type.GetArrayElementType().GetStructType()
+	list := col.Value.GetListValue()
+	if structType == nil || list == nil {
+		d.fail("ChangeRecord", fmt.Errorf("unexpected ChangeRecord: %v", col.Type))
+		return nil
+	}
+
+	var changeRecords []*ChangeRecord
+	for i, v := range list.Values {
+		path := fmt.Sprintf("ChangeRecord[%d]", i)
+		fields := v.GetListValue()
+		if fields == nil || len(fields.Values) != len(structType.Fields) {
+			d.fail(path, fmt.Errorf("unexpected value: %v", v))
+			continue
+		}
+		changeRecord := newChangeRecord()
+		for j, field := range structType.Fields {
+			path := path + "." + field.Name
+			switch field.Name {
+			case "data_change_record":
+				d.records(path, field.Type, fields.Values[j], func(row *spanner.Row) error {
+					record := &DataChangeRecord{}
+					if err := row.ToStructLenient(record); err != nil {
+						return err
+					}
+					changeRecord.DataChangeRecords = append(changeRecord.DataChangeRecords, record)
+					return nil
+				})
+			case "heartbeat_record":
+				d.records(path, field.Type, fields.Values[j], func(row *spanner.Row) error {
+					record := &HeartbeatRecord{}
+					if err := row.ToStructLenient(record); err != nil {
+						return err
+					}
+					changeRecord.HeartbeatRecords = append(changeRecord.HeartbeatRecords, record)
+					return nil
+				})
+			case "child_partitions_record":
+				d.records(path, field.Type, fields.Values[j], func(row *spanner.Row) error {
+					record := &ChildPartitionsRecord{}
+					if err := row.ToStructLenient(record); err != nil {
+						return err
+					}
+					changeRecord.ChildPartitionsRecords = append(changeRecord.ChildPartitionsRecords, record)
+					return nil
+				})
+			}
+			// Unknown records are ignored like ToStructLenient does.
+		}
+		changeRecords = append(changeRecords, changeRecord)
+	}
+	return changeRecords
+}
+
+// records decodes each record of the ARRAY<STRUCT> value as a row of the fields of the struct.
+func (d *rowSalvager) records(path string, t *sppb.Type, v *structpb.Value, decode func(row *spanner.Row) error) {
+	if _, ok := v.GetKind().(*structpb.Value_NullValue); ok {
+		return
+	}
+	structType := t.GetArrayElementType().GetStructType()
+	list := v.GetListValue()
+	if structType == nil || list == nil {
+		d.fail(path, fmt.Errorf("unexpected value of %v: %v", t, v))
+		return
+	}
+	for i, record := range list.Values {
+		path := fmt.Sprintf("%s[%d]", path, i)
+		fields := record.GetListValue()
+		if fields == nil || len(fields.Values) != len(structType.Fields) {
+			d.fail(path, fmt.Errorf("unexpected value: %v", record))
+			continue
+		}
+		names := make([]string, len(structType.Fields))
+		values := make([]interface{}, len(structType.Fields))
+		for j, field := range structType.Fields {
+			names[j] = field.Name
+			values[j] = spanner.GenericColumnValue{Type: field.Type, Value: fields.Values[j]}
+		}
+		row, err := spanner.NewRow(names, values)
+		if err == nil {
+			err = decode(row)
+		}
+		if err != nil {
+			d.fail(path, err)
+		}
+	}
+}
+
+// postgres salvages the row of a JSONB object with data_change_record, heartbeat_record or
+// child_partitions_record.
+func (d *rowSalvager) postgres(row *spanner.Row) []*ChangeRecord {
+	var col spanner.NullJSON
+	if err := row.Column(0, &col); err != nil {
+		d.fail("ChangeRecord", err)
+		return nil
+	}
+	b, err := col.MarshalJSON()
+	if err != nil {
+		d.fail("ChangeRecord", err)
+		return nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		d.fail("ChangeRecord", err)
+		return nil
+	}
+
+	changeRecord := newChangeRecord()
+	for _, name := range []string{"data_change_record", "heartbeat_record", "child_partitions_record"} {
+		field, ok := fields[name]
+		if !ok || string(field) == "null" {
+			continue
+		}
+		path := "ChangeRecord." + name
+		switch name {
+		case "data_change_record":
+			record := &DataChangeRecord{}
+			if err := json.Unmarshal(field, record); err != nil {
+				d.fail(path, err)
+				continue
+			}
+			changeRecord.DataChangeRecords = append(changeRecord.DataChangeRecords, record)
+		case "heartbeat_record":
+			record := &HeartbeatRecord{}
+			if err := json.Unmarshal(field, record); err != nil {
+				d.fail(path, err)
+				continue
+			}
+			changeRecord.HeartbeatRecords = append(changeRecord.HeartbeatRecords, record)
+		case "child_partitions_record":
+			record := &ChildPartitionsRecord{}
+			if err := json.Unmarshal(field, record); err != nil {
+				d.fail(path, err)
+				continue
+			}
+			changeRecord.ChildPartitionsRecords = append(changeRecord.ChildPartitionsRecords, record)
+		}
+	}
+	return []*ChangeRecord{changeRecord}
+}
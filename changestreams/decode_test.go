@@ -0,0 +1,112 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"testing"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	sppb "cloud.google.com/go/spanner/apiv1/spannerpb"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestSalvageRow(t *testing.T) {
+	timestamp := &sppb.Type{Code: sppb.TypeCode_TIMESTAMP}
+	str := &sppb.Type{Code: sppb.TypeCode_STRING}
+	googleSQLRow, err := spanner.NewRow([]string{"ChangeRecord"}, []interface{}{spanner.GenericColumnValue{
+		Type: arrayType(structType(
+			field("data_change_record", arrayType(structType(
+				field("commit_timestamp", timestamp),
+				field("record_sequence", str),
+			))),
+			field("heartbeat_record", arrayType(structType(field("timestamp", timestamp)))),
+			field("unknown_record", arrayType(structType(field("unknown", str)))),
+		)),
+		Value: listValue(listValue(
+			listValue(
+				listValue(structpb.NewStringValue("malformed"), structpb.NewStringValue("00000000")),
+				listValue(structpb.NewStringValue("2023-02-24T17:17:00Z"), structpb.NewStringValue("00000001")),
+			),
+			listValue(listValue(structpb.NewStringValue("2023-02-24T17:17:01Z"))),
+			structpb.NewNullValue(),
+		)),
+	}})
+	if err != nil {
+		t.Fatalf("NewRow error: %v", err)
+	}
+	postgresRow, err := spanner.NewRow([]string{"read_json_stream"}, []interface{}{spanner.NullJSON{Valid: true, Value: map[string]interface{}{
+		"data_change_record": map[string]interface{}{"commit_timestamp": "malformed"},
+		"heartbeat_record":   map[string]interface{}{"timestamp": "2023-02-24T17:17:01Z"},
+	}}})
+	if err != nil {
+		t.Fatalf("NewRow error: %v", err)
+	}
+
+	for _, test := range []struct {
+		desc      string
+		dialect   dialect
+		row       *spanner.Row
+		want      []*ChangeRecord
+		wantPaths []string
+	}{
+		{
+			desc:    "GoogleSQL",
+			dialect: dialectGoogleSQL,
+			row:     googleSQLRow,
+			want: []*ChangeRecord{{
+				DataChangeRecords:      []*DataChangeRecord{{CommitTimestamp: time.Date(2023, 2, 24, 17, 17, 0, 0, time.UTC), RecordSequence: "00000001"}},
+				HeartbeatRecords:       []*HeartbeatRecord{{Timestamp: time.Date(2023, 2, 24, 17, 17, 1, 0, time.UTC)}},
+				ChildPartitionsRecords: []*ChildPartitionsRecord{},
+			}},
+			wantPaths: []string{"ChangeRecord[0].data_change_record[0]"},
+		},
+		{
+			desc:    "PostgreSQL",
+			dialect: dialectPostgreSQL,
+			row:     postgresRow,
+			want: []*ChangeRecord{{
+				DataChangeRecords:      []*DataChangeRecord{},
+				HeartbeatRecords:       []*HeartbeatRecord{{Timestamp: time.Date(2023, 2, 24, 17, 17, 1, 0, time.UTC)}},
+				ChildPartitionsRecords: []*ChildPartitionsRecord{},
+			}},
+			wantPaths: []string{"ChangeRecord.data_change_record"},
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			r := &Reader{dialect: test.dialect}
+			if err := r.decodeRow(test.row, &ReadResult{}); err == nil {
+				t.Fatal("decodeRow must fail")
+			}
+
+			var paths []string
+			got := r.salvageRow(test.row, "token", func(err *DecodeError) {
+				if err.PartitionToken != "token" {
+					t.Errorf("PartitionToken = %q, want token", err.PartitionToken)
+				}
+				paths = append(paths, err.Path)
+			})
+			if diff := cmp.Diff(got, test.want); diff != "" {
+				t.Errorf("salvageRow diff = %v", diff)
+			}
+			if diff := cmp.Diff(paths, test.wantPaths); diff != "" {
+				t.Errorf("failed paths diff = %v", diff)
+			}
+		})
+	}
+}
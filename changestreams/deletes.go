@@ -0,0 +1,50 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/spanner"
+)
+
+// ReadDeletes reads the change stream like Read, and calls f only for the mods of the DELETE records, with the table
+// name, the keys and the commit timestamp of each deleted row, e.g. for an audit trail of the erasures. The other
+// records are dropped, and the old values of the deleted rows are never passed to f.
+//
+// The calls are retried and redelivered in the same way as Read, so f may be called again with the same row.
+func (r *Reader) ReadDeletes(ctx context.Context, f func(table string, keys spanner.NullJSON, ts time.Time) error) error {
+	return r.ReadWithContext(ctx, func(ctx context.Context, result *ReadResult) error {
+		return forEachDelete(result, f)
+	})
+}
+
+// forEachDelete calls f for each mod of the DELETE records in the result in order, and stops at the first error.
+func forEachDelete(result *ReadResult, f func(table string, keys spanner.NullJSON, ts time.Time) error) error {
+	for record := range result.DataChangeRecords() {
+		if record.ModType != modTypeDelete {
+			continue
+		}
+		for _, mod := range record.Mods {
+			if err := f(record.TableName, mod.Keys, record.CommitTimestamp); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestForEachDelete(t *testing.T) {
+	keys := func(id string) spanner.NullJSON {
+		return spanner.NullJSON{Value: map[string]interface{}{"SingerId": id}, Valid: true}
+	}
+	ts := mustParseTime("2023-02-24T17:16:43Z")
+	result := &ReadResult{
+		ChangeRecords: []*ChangeRecord{
+			{DataChangeRecords: []*DataChangeRecord{
+				{TableName: "Singers", ModType: "INSERT", CommitTimestamp: ts, Mods: []*Mod{{Keys: keys("1")}}},
+				{TableName: "Singers", ModType: "DELETE", CommitTimestamp: ts, Mods: []*Mod{
+					{Keys: keys("2"), OldValues: spanner.NullJSON{Value: map[string]interface{}{"Name": "b"}, Valid: true}},
+					{Keys: keys("3")},
+				}},
+			}},
+			{DataChangeRecords: []*DataChangeRecord{
+				{TableName: "Albums", ModType: "UPDATE", CommitTimestamp: ts, Mods: []*Mod{{Keys: keys("4")}}},
+				{TableName: "Albums", ModType: "DELETE", CommitTimestamp: ts.Add(time.Second), Mods: []*Mod{{Keys: keys("5")}}},
+			}},
+		},
+	}
+
+	type deletion struct {
+		Table string
+		Keys  string
+		TS    time.Time
+	}
+	var got []deletion
+	if err := forEachDelete(result, func(table string, keys spanner.NullJSON, ts time.Time) error {
+		got = append(got, deletion{Table: table, Keys: keys.String(), TS: ts})
+		return nil
+	}); err != nil {
+		t.Fatalf("forEachDelete error: %v", err)
+	}
+	want := []deletion{
+		{Table: "Singers", Keys: `{"SingerId":"2"}`, TS: ts},
+		{Table: "Singers", Keys: `{"SingerId":"3"}`, TS: ts},
+		{Table: "Albums", Keys: `{"SingerId":"5"}`, TS: ts.Add(time.Second)},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("deletes diff = %v", diff)
+	}
+
+	// The first error stops the iteration.
+	errStop := errors.New("stop")
+	calls := 0
+	if err := forEachDelete(result, func(table string, keys spanner.NullJSON, ts time.Time) error {
+		calls++
+		return errStop
+	}); !errors.Is(err, errStop) || calls != 1 {
+		t.Errorf("forEachDelete = %v after %d calls, want the error after a call", err, calls)
+	}
+}
@@ -19,10 +19,19 @@ package changestreams
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"cloud.google.com/go/spanner"
 )
 
+const (
+	defaultHeartbeatInterval = 10 * time.Second
+	// minHeartbeatInterval and maxHeartbeatInterval are the range of the heartbeat parameter accepted by
+	// the change stream queries of both dialects.
+	minHeartbeatInterval = time.Second
+	maxHeartbeatInterval = 5 * time.Minute
+)
+
 type dialect int
 
 const (
@@ -60,3 +69,33 @@ func detectDialect(ctx context.Context, client *spanner.Client) (dialect, error)
 		return dialectUnknown, fmt.Errorf("invalid dialect: %q", value)
 	}
 }
+
+// heartbeatParam returns the value of the heartbeat parameter of the change stream query of the dialect for
+// the interval. The unit is explicit per dialect, so that a dialect changing it can't silently get a wrong value.
+func (d dialect) heartbeatParam(interval time.Duration) (int64, error) {
+	if err := validateHeartbeatInterval(interval); err != nil {
+		return 0, err
+	}
+	switch d {
+	case dialectGoogleSQL:
+		// heartbeat_milliseconds of READ_<stream>, bound as @heartbeat_millis_second, is INT64 in milliseconds.
+		return interval.Milliseconds(), nil
+	case dialectPostgreSQL:
+		// The 4th argument of read_json_<stream> is bigint in milliseconds.
+		return interval.Milliseconds(), nil
+	default:
+		return 0, fmt.Errorf("unexpected dialect: %s", d)
+	}
+}
+
+// validateHeartbeatInterval validates the interval, which must be a whole number of milliseconds, since
+// the heartbeat parameters of both dialects are in milliseconds, in the range the queries accept.
+func validateHeartbeatInterval(interval time.Duration) error {
+	if interval < minHeartbeatInterval || interval > maxHeartbeatInterval {
+		return fmt.Errorf("HeartbeatInterval must be between %s and %s, but got %s", minHeartbeatInterval, maxHeartbeatInterval, interval)
+	}
+	if interval%time.Millisecond != 0 {
+		return fmt.Errorf("HeartbeatInterval must be a whole number of milliseconds, but got %s", interval)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHeartbeatParam(t *testing.T) {
+	for _, test := range []struct {
+		dialect  dialect
+		interval time.Duration
+		want     int64
+		wantErr  bool
+	}{
+		{dialect: dialectGoogleSQL, interval: 10 * time.Second, want: 10000},
+		{dialect: dialectPostgreSQL, interval: 10 * time.Second, want: 10000},
+		{dialect: dialectGoogleSQL, interval: time.Second, want: 1000},
+		{dialect: dialectPostgreSQL, interval: 5 * time.Minute, want: 300000},
+		{dialect: dialectGoogleSQL, interval: 1500 * time.Millisecond, want: 1500},
+		{dialect: dialectGoogleSQL, interval: 999 * time.Millisecond, wantErr: true},
+		{dialect: dialectPostgreSQL, interval: 5*time.Minute + time.Millisecond, wantErr: true},
+		{dialect: dialectGoogleSQL, interval: time.Second + time.Microsecond, wantErr: true},
+		{dialect: dialectUnknown, interval: 10 * time.Second, wantErr: true},
+	} {
+		got, err := test.dialect.heartbeatParam(test.interval)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("heartbeatParam(%s) of %v = %d, want error", test.interval, test.dialect, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("heartbeatParam(%s) of %v error: %v", test.interval, test.dialect, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("heartbeatParam(%s) of %v = %d, want %d", test.interval, test.dialect, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"cloud.google.com/go/spanner"
+)
+
+// ChangedColumns returns the columns whose values differ between OldValues and NewValues of the mod, keyed by
+// the column name. The value is the pair of the JSON of the old and the new value, in this order, and a side is nil
+// if the column isn't in the values of that side, e.g. the old values of an INSERT, or of a column not captured
+// by the NEW_VALUES and NEW_ROW value capture types. The values are compared in JSON, whose object keys are sorted
+// by encoding/json. A column that is null on a side has the JSON null, which differs from the column not being there.
+//
+// The primary key columns are in Keys, not in the values, so they are never in the result.
+func (m *Mod) ChangedColumns() (map[string][2]json.RawMessage, error) {
+	oldValues, err := modValues(m.OldValues, "old values")
+	if err != nil {
+		return nil, err
+	}
+	newValues, err := modValues(m.NewValues, "new values")
+	if err != nil {
+		return nil, err
+	}
+
+	changed := make(map[string][2]json.RawMessage)
+	for column, oldValue := range oldValues {
+		newValue, ok := newValues[column]
+		if ok && bytes.Equal(oldValue, newValue) {
+			continue
+		}
+		changed[column] = [2]json.RawMessage{oldValue, newValue}
+	}
+	for column, newValue := range newValues {
+		if _, ok := oldValues[column]; !ok {
+			changed[column] = [2]json.RawMessage{nil, newValue}
+		}
+	}
+	return changed, nil
+}
+
+// modValues returns the JSON of each column of the values of the mod, or nil if the values are null.
+func modValues(values spanner.NullJSON, name string) (map[string]json.RawMessage, error) {
+	if !values.Valid {
+		return nil, nil
+	}
+	// encoding/json sorts the object keys, so the JSON of each value is canonical.
+	b, err := json.Marshal(values.Value)
+	if err != nil {
+		return nil, err
+	}
+	var columns map[string]json.RawMessage
+	if err := json.Unmarshal(b, &columns); err != nil {
+		return nil, fmt.Errorf("%s are not an object: %w", name, err)
+	}
+	return columns, nil
+}
@@ -0,0 +1,97 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"encoding/json"
+	"testing"
+
+	"cloud.google.com/go/spanner"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestChangedColumns(t *testing.T) {
+	jsonValue := func(v interface{}) spanner.NullJSON {
+		return spanner.NullJSON{Value: v, Valid: true}
+	}
+	raw := func(s string) json.RawMessage { return json.RawMessage(s) }
+	for _, test := range []struct {
+		desc    string
+		mod     *Mod
+		want    map[string][2]json.RawMessage
+		wantErr bool
+	}{
+		{
+			desc: "update",
+			mod: &Mod{
+				OldValues: jsonValue(map[string]interface{}{"name": "a", "age": "1", "tags": map[string]interface{}{"b": 1.0, "a": 2.0}, "note": nil}),
+				NewValues: jsonValue(map[string]interface{}{"name": "b", "age": "1", "tags": map[string]interface{}{"a": 2.0, "b": 1.0}, "note": "x"}),
+			},
+			want: map[string][2]json.RawMessage{
+				"name": {raw(`"a"`), raw(`"b"`)},
+				"note": {raw(`null`), raw(`"x"`)},
+			},
+		},
+		{
+			desc: "columns in one side only",
+			mod: &Mod{
+				OldValues: jsonValue(map[string]interface{}{"old": "a", "same": "x"}),
+				NewValues: jsonValue(map[string]interface{}{"new": "b", "same": "x"}),
+			},
+			want: map[string][2]json.RawMessage{
+				"old": {raw(`"a"`), nil},
+				"new": {nil, raw(`"b"`)},
+			},
+		},
+		{
+			desc: "insert",
+			mod: &Mod{
+				OldValues: jsonValue(map[string]interface{}{}),
+				NewValues: jsonValue(map[string]interface{}{"name": "a"}),
+			},
+			want: map[string][2]json.RawMessage{"name": {nil, raw(`"a"`)}},
+		},
+		{
+			desc: "delete with null new values",
+			mod:  &Mod{OldValues: jsonValue(map[string]interface{}{"name": "a"})},
+			want: map[string][2]json.RawMessage{"name": {raw(`"a"`), nil}},
+		},
+		{
+			desc: "no-op update",
+			mod: &Mod{
+				OldValues: jsonValue(map[string]interface{}{"name": "a"}),
+				NewValues: jsonValue(map[string]interface{}{"name": "a"}),
+			},
+			want: map[string][2]json.RawMessage{},
+		},
+		{
+			desc:    "not an object",
+			mod:     &Mod{OldValues: jsonValue([]interface{}{"a"})},
+			wantErr: true,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			got, err := test.mod.ChangedColumns()
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ChangedColumns error = %v, wantErr %v", err, test.wantErr)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("ChangedColumns diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
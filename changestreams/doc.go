@@ -38,15 +38,36 @@ Package changestreams provides the functionality for reading the Cloud Spanner c
 		defer reader.Close()
 
 		if err := reader.Read(ctx, func(result *changestreams.ReadResult) error {
-			for _, cr := range result.ChangeRecords {
-				for _, dcr := range cr.DataChangeRecords {
-					fmt.Printf("[%s] %s %s\n", dcr.CommitTimestamp, dcr.ModType, dcr.TableName)
-				}
+			for dcr := range result.DataChangeRecords() {
+				fmt.Printf("[%s] %s %s\n", dcr.CommitTimestamp, dcr.ModType, dcr.TableName)
 			}
 			return nil
 		}); err != nil {
 			log.Fatalf("failed to read: %v", err)
 		}
 	}
+
+# Exactly-once delivery
+
+A transactional sink, e.g. another Cloud Spanner database, can apply each change exactly once by committing the
+writes of a result together with the watermark of its partition, instead of relying on Config.CheckpointStore.
+The contract is:
+
+  - The read function is called with the results of a partition one at a time, in order, and the reader never
+    advances the partition past a result until the read function has returned nil for it. The read function
+    commits the writes of the result and ReadResult.Watermark of ReadResult.PartitionToken in one transaction,
+    and returns only after the transaction has committed. An error fails the read, or pauses the partition
+    with Config.PauseOnError, and the result is never skipped unless ResumePartition is called with skip.
+  - Config.BeforeChildPartitions is called when a partition has finished, before any of its children is read.
+    It commits that the partition has finished and that its children are pending, with their start timestamps,
+    in one transaction.
+  - After a restart, the pending partitions and the finished tokens of the sink are passed as Config.ResumeFrom,
+    with the start timestamp of each pending partition being its committed watermark, or the start timestamp
+    recorded by BeforeChildPartitions if it has none. The records at the start timestamp may be read again, so the
+    sink skips the records it has already committed, e.g. by IdempotencyKey.
+
+Config.DeliveryMode must be DeliveryAtLeastOnce, which is the default. Config.CoalesceWindow and ReadBatch buffer
+the records across the results and the partitions, so they must not be used, and Config.CheckpointStore is not
+needed since the sink keeps the checkpoint.
 */
 package changestreams
@@ -0,0 +1,339 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package enrich attaches the whole row to the mods of the data change records, e.g. for the sinks that need
+// the full row while the change stream only captures the modified columns with NEW_VALUES value capture type.
+package enrich
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	spannerclient "cloud.google.com/go/spanner"
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams"
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams/sink/spanner"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	defaultConcurrency            = 8
+	defaultCacheSize              = 1024
+	defaultVersionRetentionPeriod = time.Hour
+)
+
+// ExpiredTimestampPolicy decides what happens with Config.ExactTimestamp when the commit timestamp of a record
+// is older than the version retention period of the source database, so the row can't be read at the timestamp.
+type ExpiredTimestampPolicy int
+
+const (
+	// ExpiredTimestampFail fails the enrichment, and the read function returns the error.
+	ExpiredTimestampFail ExpiredTimestampPolicy = iota
+	// ExpiredTimestampReadCurrent reads the current row instead, and sets Mod.RowStale.
+	ExpiredTimestampReadCurrent
+	// ExpiredTimestampSkip attaches no row to the mods.
+	ExpiredTimestampSkip
+)
+
+// Config is the configuration for the enricher.
+type Config struct {
+	// Concurrency is the maximum number of the concurrent reads of the rows, shared by all the partitions.
+	// If Concurrency is zero, 8 is used.
+	Concurrency int
+	// CacheSize is the number of the rows kept in the LRU cache to avoid reading the hot rows again.
+	// If CacheSize is zero, 1024 is used. If it's negative, the rows are not cached.
+	CacheSize int
+	// If Staleness is set, the rows are read with the exact staleness instead of strong reads, which is cheaper
+	// but may return a row older than the data change record if Staleness is longer than the lag of the read.
+	Staleness time.Duration
+	// If ExactTimestamp is true, the rows are read at the commit timestamp of each record in a read-only
+	// transaction, so the row is exactly the state produced by the transaction of the record. The rows of the
+	// records of a result committed at the same timestamp to the same table are read in one read.
+	// Staleness is ignored, and the cache only helps when the same record is delivered again.
+	ExactTimestamp bool
+	// VersionRetentionPeriod is the version_retention_period of the source database, beyond which the rows
+	// can't be read at the commit timestamps with ExactTimestamp. If VersionRetentionPeriod is zero, 1 hour,
+	// the default of Cloud Spanner, is used.
+	VersionRetentionPeriod time.Duration
+	// ExpiredTimestampPolicy decides what happens with ExactTimestamp when a commit timestamp is older than
+	// VersionRetentionPeriod, or Cloud Spanner refuses to read at the timestamp. By default, the enrichment fails.
+	ExpiredTimestampPolicy ExpiredTimestampPolicy
+}
+
+// Enricher reads the row of each INSERT and UPDATE mod from the source database and attaches it to Mod.Row,
+// encoded like Mod.NewValues. Only the columns in the ColumnTypes of the record, i.e. the columns tracked by
+// the change stream, are read.
+//
+// The row is read when the record is delivered, not at the commit timestamp of the record, so it may reflect
+// the transactions committed later than the record; the rows of the same record may even be read at different
+// timestamps. A cached row is only reused for the records committed at or before the timestamp it was read at.
+// The row is not attached if it has been deleted by the time it's read, nor to the DELETE mods.
+// See Config.ExactTimestamp to read the rows at the commit timestamps instead.
+type Enricher struct {
+	ctx                    context.Context
+	client                 *spannerclient.Client
+	staleness              time.Duration
+	exactTimestamp         bool
+	versionRetentionPeriod time.Duration
+	expiredTimestampPolicy ExpiredTimestampPolicy
+	sem                    chan struct{}
+	cache                  *rowCache
+	// read reads the columns of the row of the table, and returns the row in JSON, or nil if it doesn't exist,
+	// with the timestamp it was read at.
+	read func(ctx context.Context, table string, key spannerclient.Key, columns []string) (json.RawMessage, time.Time, error)
+	// readAt reads the columns of the rows of the table at the timestamp, and returns the rows in JSON
+	// keyed by their primary key columns in JSON.
+	readAt func(ctx context.Context, table string, keys []spannerclient.Key, keyColumns, columns []string, timestamp time.Time) (map[string]json.RawMessage, error)
+}
+
+// New creates a new enricher that reads the rows with client of the source database.
+// ctx is used for all the reads of the enricher.
+func New(ctx context.Context, client *spannerclient.Client, config Config) (*Enricher, error) {
+	if client == nil {
+		return nil, errors.New("client of the source database must be specified")
+	}
+	if config.Concurrency < 0 {
+		return nil, fmt.Errorf("invalid Concurrency: %d", config.Concurrency)
+	}
+	if config.Staleness < 0 {
+		return nil, fmt.Errorf("invalid Staleness: %s", config.Staleness)
+	}
+	if config.VersionRetentionPeriod < 0 {
+		return nil, fmt.Errorf("invalid VersionRetentionPeriod: %s", config.VersionRetentionPeriod)
+	}
+	versionRetentionPeriod := config.VersionRetentionPeriod
+	if versionRetentionPeriod == 0 {
+		versionRetentionPeriod = defaultVersionRetentionPeriod
+	}
+	concurrency := config.Concurrency
+	if concurrency == 0 {
+		concurrency = defaultConcurrency
+	}
+	cacheSize := config.CacheSize
+	if cacheSize == 0 {
+		cacheSize = defaultCacheSize
+	}
+	e := &Enricher{
+		ctx:                    ctx,
+		client:                 client,
+		staleness:              config.Staleness,
+		exactTimestamp:         config.ExactTimestamp,
+		versionRetentionPeriod: versionRetentionPeriod,
+		expiredTimestampPolicy: config.ExpiredTimestampPolicy,
+		sem:                    make(chan struct{}, concurrency),
+		cache:                  newRowCache(cacheSize),
+	}
+	e.read = e.readRow
+	e.readAt = e.readRowsAt
+	return e, nil
+}
+
+// Wrap returns the read function that enriches the result before calling f, e.g. with the Read method of a sink.
+func (e *Enricher) Wrap(f func(result *changestreams.ReadResult) error) func(result *changestreams.ReadResult) error {
+	return func(result *changestreams.ReadResult) error {
+		if err := e.Enrich(result); err != nil {
+			return err
+		}
+		return f(result)
+	}
+}
+
+// Enrich attaches the rows to the INSERT and UPDATE mods of the data change records in the result.
+func (e *Enricher) Enrich(result *changestreams.ReadResult) error {
+	g, ctx := errgroup.WithContext(e.ctx)
+	var batches []*batch
+	for record := range result.DataChangeRecords() {
+		if record.ModType == "DELETE" || len(record.Mods) == 0 {
+			continue
+		}
+		if e.exactTimestamp {
+			batches = addToBatch(batches, record)
+			continue
+		}
+		columns := columnNames(record)
+		for _, mod := range record.Mods {
+			g.Go(func() error {
+				return e.enrichMod(ctx, record, mod, columns)
+			})
+		}
+	}
+	for _, b := range batches {
+		g.Go(func() error {
+			return e.enrichBatch(ctx, b)
+		})
+	}
+	return g.Wait()
+}
+
+func columnNames(record *changestreams.DataChangeRecord) []string {
+	columns := make([]string, 0, len(record.ColumnTypes))
+	for _, c := range record.ColumnTypes {
+		columns = append(columns, c.Name)
+	}
+	return columns
+}
+
+// cacheKey returns the key of the row of the mod in the cache.
+func cacheKey(record *changestreams.DataChangeRecord, mod *changestreams.Mod) (string, error) {
+	keys, err := mod.PrimaryKeyString()
+	if err != nil {
+		return "", err
+	}
+	return record.TableName + "/" + keys, nil
+}
+
+func (e *Enricher) enrichMod(ctx context.Context, record *changestreams.DataChangeRecord, mod *changestreams.Mod, columns []string) error {
+	cacheKey, err := cacheKey(record, mod)
+	if err != nil {
+		return err
+	}
+	if row, ok := e.cache.get(cacheKey, record.CommitTimestamp); ok {
+		mod.Row = row
+		return nil
+	}
+
+	key, err := spanner.PrimaryKey(record, mod)
+	if err != nil {
+		return err
+	}
+	select {
+	case e.sem <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	row, readTimestamp, err := e.read(ctx, record.TableName, key, columns)
+	<-e.sem
+	if err != nil {
+		return fmt.Errorf("failed to read the row of table %s: %w", record.TableName, err)
+	}
+	if row == nil {
+		return nil
+	}
+	e.cache.add(cacheKey, row, readTimestamp)
+	mod.Row = row
+	return nil
+}
+
+func (e *Enricher) readRow(ctx context.Context, table string, key spannerclient.Key, columns []string) (json.RawMessage, time.Time, error) {
+	tx := e.client.Single()
+	if e.staleness > 0 {
+		tx = tx.WithTimestampBound(spannerclient.ExactStaleness(e.staleness))
+	}
+	defer tx.Close()
+
+	row, err := tx.ReadRow(ctx, table, key, columns)
+	if spannerclient.ErrCode(err) == codes.NotFound {
+		return nil, time.Time{}, nil
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	readTimestamp, err := tx.Timestamp()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	values, err := rowValues(row)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	b, err := json.Marshal(values)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return b, readTimestamp, nil
+}
+
+// rowValues returns the values of the columns of the row keyed by the column names.
+func rowValues(row *spannerclient.Row) (map[string]interface{}, error) {
+	values := make(map[string]interface{}, row.Size())
+	for i, name := range row.ColumnNames() {
+		var v spannerclient.GenericColumnValue
+		if err := row.Column(i, &v); err != nil {
+			return nil, err
+		}
+		// The values are encoded in the same way as in the mods, e.g. INT64 as a string.
+		values[name] = v.Value.AsInterface()
+	}
+	return values, nil
+}
+
+type cachedRow struct {
+	key           string
+	row           json.RawMessage
+	readTimestamp time.Time
+}
+
+// rowCache is the LRU cache of the rows.
+type rowCache struct {
+	size  int
+	order *list.List
+	rows  map[string]*list.Element
+	mu    sync.Mutex
+}
+
+func newRowCache(size int) *rowCache {
+	return &rowCache{
+		size:  size,
+		order: list.New(),
+		rows:  make(map[string]*list.Element),
+	}
+}
+
+// get returns the row if it was read at or after the commit timestamp, i.e. it reflects the change.
+// The row is shared by all the mods of the row, so it must not be modified.
+func (c *rowCache) get(key string, commitTimestamp time.Time) (json.RawMessage, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.rows[key]
+	if !ok {
+		return nil, false
+	}
+	cached := elem.Value.(*cachedRow)
+	if commitTimestamp.After(cached.readTimestamp) {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return cached.row, true
+}
+
+func (c *rowCache) add(key string, row json.RawMessage, readTimestamp time.Time) {
+	if c.size < 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.rows[key]; ok {
+		cached := elem.Value.(*cachedRow)
+		if readTimestamp.After(cached.readTimestamp) {
+			cached.row, cached.readTimestamp = row, readTimestamp
+		}
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.rows[key] = c.order.PushFront(&cachedRow{key: key, row: row, readTimestamp: readTimestamp})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.rows, oldest.Value.(*cachedRow).key)
+	}
+}
@@ -0,0 +1,219 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package enrich
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	spannerclient "cloud.google.com/go/spanner"
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestEnricher(t *testing.T) {
+	jsonValue := func(v interface{}) spannerclient.NullJSON {
+		return spannerclient.NullJSON{Value: v, Valid: true}
+	}
+	commitTimestamp := time.Date(2023, 2, 24, 17, 0, 0, 0, time.UTC)
+	newResult := func(modType string, commitTimestamp time.Time, ids ...string) *changestreams.ReadResult {
+		record := &changestreams.DataChangeRecord{
+			CommitTimestamp: commitTimestamp,
+			TableName:       "Singers",
+			ModType:         modType,
+			ColumnTypes: []*changestreams.ColumnType{
+				{Name: "SingerId", Type: jsonValue(map[string]interface{}{"code": "INT64"}), IsPrimaryKey: true, OrdinalPosition: 1},
+				{Name: "Name", Type: jsonValue(map[string]interface{}{"code": "STRING"}), OrdinalPosition: 2},
+			},
+		}
+		for _, id := range ids {
+			record.Mods = append(record.Mods, &changestreams.Mod{
+				Keys:      jsonValue(map[string]interface{}{"SingerId": id}),
+				NewValues: jsonValue(map[string]interface{}{"Name": "new"}),
+			})
+		}
+		return &changestreams.ReadResult{ChangeRecords: []*changestreams.ChangeRecord{{DataChangeRecords: []*changestreams.DataChangeRecord{record}}}}
+	}
+	rows := func(result *changestreams.ReadResult) []string {
+		var rows []string
+		for event := range result.ModEvents() {
+			rows = append(rows, string(event.Mod.Row))
+		}
+		return rows
+	}
+
+	newEnricher := func(t *testing.T, config Config) (*Enricher, func() []string) {
+		e, err := New(context.Background(), &spannerclient.Client{}, config)
+		if err != nil {
+			t.Fatalf("New error: %v", err)
+		}
+		var mu sync.Mutex
+		var reads []string
+		e.read = func(ctx context.Context, table string, key spannerclient.Key, columns []string) (json.RawMessage, time.Time, error) {
+			id := fmt.Sprint(key[0].(spannerclient.NullInt64).Int64)
+			mu.Lock()
+			reads = append(reads, id)
+			mu.Unlock()
+			if id == "404" {
+				return nil, time.Time{}, nil
+			}
+			return json.RawMessage(fmt.Sprintf(`{"Name":"current","SingerId":%q}`, id)), commitTimestamp, nil
+		}
+		e.readAt = func(ctx context.Context, table string, keys []spannerclient.Key, keyColumns, columns []string, timestamp time.Time) (map[string]json.RawMessage, error) {
+			rows := make(map[string]json.RawMessage)
+			var ids []string
+			for _, key := range keys {
+				id := fmt.Sprint(key[0].(spannerclient.NullInt64).Int64)
+				ids = append(ids, id)
+				if id == "404" {
+					continue
+				}
+				rows[fmt.Sprintf(`{"SingerId":%q}`, id)] = json.RawMessage(fmt.Sprintf(`{"Name":"at","SingerId":%q}`, id))
+			}
+			mu.Lock()
+			reads = append(reads, fmt.Sprint(ids))
+			mu.Unlock()
+			return rows, nil
+		}
+		return e, func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+			return reads
+		}
+	}
+
+	t.Run("rows are attached", func(t *testing.T) {
+		e, reads := newEnricher(t, Config{})
+		result := newResult("UPDATE", commitTimestamp, "1", "404")
+		if err := e.Enrich(result); err != nil {
+			t.Fatalf("Enrich error: %v", err)
+		}
+		want := []string{`{"Name":"current","SingerId":"1"}`, ""}
+		if diff := cmp.Diff(rows(result), want); diff != "" {
+			t.Errorf("rows diff = %v", diff)
+		}
+
+		// The cached row reflects the record committed at the same timestamp.
+		result = newResult("INSERT", commitTimestamp, "1")
+		if err := e.Enrich(result); err != nil {
+			t.Fatalf("Enrich error: %v", err)
+		}
+		if diff := cmp.Diff(rows(result), want[:1]); diff != "" {
+			t.Errorf("cached rows diff = %v", diff)
+		}
+		if got := len(reads()); got != 2 {
+			t.Errorf("reads = %d, want 2", got)
+		}
+
+		// The cached row is older than the later record, so it's read again.
+		if err := e.Enrich(newResult("UPDATE", commitTimestamp.Add(time.Second), "1")); err != nil {
+			t.Fatalf("Enrich error: %v", err)
+		}
+		if got := len(reads()); got != 3 {
+			t.Errorf("reads = %d, want 3", got)
+		}
+	})
+
+	t.Run("rows of deletes are not read", func(t *testing.T) {
+		e, reads := newEnricher(t, Config{})
+		result := newResult("DELETE", commitTimestamp, "1")
+		if err := e.Enrich(result); err != nil {
+			t.Fatalf("Enrich error: %v", err)
+		}
+		if got := reads(); len(got) != 0 || rows(result)[0] != "" {
+			t.Errorf("reads = %v with rows %v, want none", got, rows(result))
+		}
+	})
+
+	t.Run("least recently used row is evicted", func(t *testing.T) {
+		e, reads := newEnricher(t, Config{CacheSize: 2, Concurrency: 1})
+		for _, id := range []string{"1", "2", "1", "3", "2"} {
+			if err := e.Enrich(newResult("UPDATE", commitTimestamp, id)); err != nil {
+				t.Fatalf("Enrich error: %v", err)
+			}
+		}
+		if diff := cmp.Diff(reads(), []string{"1", "2", "3", "2"}); diff != "" {
+			t.Errorf("reads diff = %v", diff)
+		}
+	})
+
+	t.Run("rows are read at the commit timestamp in one read", func(t *testing.T) {
+		e, reads := newEnricher(t, Config{ExactTimestamp: true})
+		now := time.Now()
+		result := newResult("UPDATE", now, "1", "404")
+		other := newResult("UPDATE", now, "2")
+		result.ChangeRecords = append(result.ChangeRecords, other.ChangeRecords...)
+		if err := e.Enrich(result); err != nil {
+			t.Fatalf("Enrich error: %v", err)
+		}
+		want := []string{`{"Name":"at","SingerId":"1"}`, "", `{"Name":"at","SingerId":"2"}`}
+		if diff := cmp.Diff(rows(result), want); diff != "" {
+			t.Errorf("rows diff = %v", diff)
+		}
+		if diff := cmp.Diff(reads(), []string{"[1 404 2]"}); diff != "" {
+			t.Errorf("reads diff = %v", diff)
+		}
+
+		// The row at the later commit timestamp is not cached.
+		if err := e.Enrich(newResult("UPDATE", now.Add(time.Millisecond), "1")); err != nil {
+			t.Fatalf("Enrich error: %v", err)
+		}
+		if got := len(reads()); got != 2 {
+			t.Errorf("reads = %d, want 2", got)
+		}
+	})
+
+	t.Run("expired commit timestamp", func(t *testing.T) {
+		for _, test := range []struct {
+			desc      string
+			policy    ExpiredTimestampPolicy
+			wantRows  []string
+			wantStale bool
+			wantErr   bool
+		}{
+			{desc: "fails", policy: ExpiredTimestampFail, wantRows: []string{""}, wantErr: true},
+			{desc: "reads the current row", policy: ExpiredTimestampReadCurrent, wantRows: []string{`{"Name":"current","SingerId":"1"}`}, wantStale: true},
+			{desc: "is skipped", policy: ExpiredTimestampSkip, wantRows: []string{""}},
+		} {
+			t.Run(test.desc, func(t *testing.T) {
+				e, reads := newEnricher(t, Config{ExactTimestamp: true, ExpiredTimestampPolicy: test.policy})
+				result := newResult("UPDATE", time.Now().Add(-2*time.Hour), "1")
+				err := e.Enrich(result)
+				if test.wantErr != errors.Is(err, ErrTimestampExpired) {
+					t.Fatalf("Enrich error = %v, want ErrTimestampExpired: %v", err, test.wantErr)
+				}
+				if diff := cmp.Diff(rows(result), test.wantRows); diff != "" {
+					t.Errorf("rows diff = %v", diff)
+				}
+				mod := result.ChangeRecords[0].DataChangeRecords[0].Mods[0]
+				if mod.RowStale != test.wantStale {
+					t.Errorf("RowStale = %v, want %v", mod.RowStale, test.wantStale)
+				}
+				for _, read := range reads() {
+					if read == "[1]" {
+						t.Errorf("rows must not be read at the expired commit timestamp")
+					}
+				}
+			})
+		}
+	})
+}
@@ -0,0 +1,200 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package enrich
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	spannerclient "cloud.google.com/go/spanner"
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams"
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams/sink/spanner"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+)
+
+// ErrTimestampExpired is returned with ExpiredTimestampFail when the rows can't be read at the commit timestamp.
+var ErrTimestampExpired = errors.New("commit timestamp is beyond the version retention period")
+
+type batchMod struct {
+	record *changestreams.DataChangeRecord
+	mod    *changestreams.Mod
+}
+
+// batch is the mods whose rows are read in one read at the commit timestamp.
+type batch struct {
+	commitTimestamp time.Time
+	table           string
+	columns         []string
+	keyColumns      []string
+	mods            []batchMod
+}
+
+// addToBatch adds the mods of the record to the batch of the same commit timestamp, table and columns.
+func addToBatch(batches []*batch, record *changestreams.DataChangeRecord) []*batch {
+	columns := columnNames(record)
+	var b *batch
+	for _, candidate := range batches {
+		if candidate.commitTimestamp.Equal(record.CommitTimestamp) && candidate.table == record.TableName && slices.Equal(candidate.columns, columns) {
+			b = candidate
+			break
+		}
+	}
+	if b == nil {
+		b = &batch{commitTimestamp: record.CommitTimestamp, table: record.TableName, columns: columns}
+		for _, c := range record.ColumnTypes {
+			if c.IsPrimaryKey {
+				b.keyColumns = append(b.keyColumns, c.Name)
+			}
+		}
+		batches = append(batches, b)
+	}
+	for _, mod := range record.Mods {
+		b.mods = append(b.mods, batchMod{record: record, mod: mod})
+	}
+	return batches
+}
+
+// exactCacheKey returns the key of the row of the mod at the commit timestamp in the cache.
+func exactCacheKey(record *changestreams.DataChangeRecord, mod *changestreams.Mod) (string, error) {
+	key, err := cacheKey(record, mod)
+	if err != nil {
+		return "", err
+	}
+	return record.CommitTimestamp.UTC().Format(time.RFC3339Nano) + "/" + key, nil
+}
+
+func (e *Enricher) enrichBatch(ctx context.Context, b *batch) error {
+	var (
+		missing   []batchMod
+		cacheKeys []string
+		keys      []spannerclient.Key
+	)
+	for _, m := range b.mods {
+		cacheKey, err := exactCacheKey(m.record, m.mod)
+		if err != nil {
+			return err
+		}
+		if row, ok := e.cache.get(cacheKey, b.commitTimestamp); ok {
+			m.mod.Row = row
+			continue
+		}
+		key, err := spanner.PrimaryKey(m.record, m.mod)
+		if err != nil {
+			return err
+		}
+		missing = append(missing, m)
+		cacheKeys = append(cacheKeys, cacheKey)
+		keys = append(keys, key)
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	if time.Since(b.commitTimestamp) > e.versionRetentionPeriod {
+		return e.enrichExpired(ctx, b.table, b.commitTimestamp, missing, nil)
+	}
+
+	select {
+	case e.sem <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	rows, err := e.readAt(ctx, b.table, keys, b.keyColumns, b.columns, b.commitTimestamp)
+	<-e.sem
+	if spannerclient.ErrCode(err) == codes.FailedPrecondition {
+		// Cloud Spanner refuses to read at a timestamp older than the version retention period.
+		return e.enrichExpired(ctx, b.table, b.commitTimestamp, missing, err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read the rows of table %s at %s: %w", b.table, b.commitTimestamp.Format(time.RFC3339Nano), err)
+	}
+	for i, m := range missing {
+		keys, err := m.mod.PrimaryKeyString()
+		if err != nil {
+			return err
+		}
+		row, ok := rows[keys]
+		if !ok {
+			continue
+		}
+		e.cache.add(cacheKeys[i], row, b.commitTimestamp)
+		m.mod.Row = row
+	}
+	return nil
+}
+
+// enrichExpired enriches the mods whose rows can't be read at the commit timestamp according to the policy.
+func (e *Enricher) enrichExpired(ctx context.Context, table string, commitTimestamp time.Time, mods []batchMod, cause error) error {
+	switch e.expiredTimestampPolicy {
+	case ExpiredTimestampReadCurrent:
+		for _, m := range mods {
+			if err := e.enrichMod(ctx, m.record, m.mod, columnNames(m.record)); err != nil {
+				return err
+			}
+			m.mod.RowStale = m.mod.Row != nil
+		}
+		return nil
+	case ExpiredTimestampSkip:
+		return nil
+	default:
+		err := ErrTimestampExpired
+		if cause != nil {
+			err = fmt.Errorf("%w: %w", ErrTimestampExpired, cause)
+		}
+		return fmt.Errorf("failed to read the rows of table %s at %s: %w", table, commitTimestamp.Format(time.RFC3339Nano), err)
+	}
+}
+
+func (e *Enricher) readRowsAt(ctx context.Context, table string, keys []spannerclient.Key, keyColumns, columns []string, timestamp time.Time) (map[string]json.RawMessage, error) {
+	tx := e.client.Single().WithTimestampBound(spannerclient.ReadTimestamp(timestamp))
+	defer tx.Close()
+
+	rows := make(map[string]json.RawMessage, len(keys))
+	iter := tx.Read(ctx, table, spannerclient.KeySetFromKeys(keys...), columns)
+	defer iter.Stop()
+	for {
+		row, err := iter.Next()
+		if err == iterator.Done {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		values, err := rowValues(row)
+		if err != nil {
+			return nil, err
+		}
+		// The key is encoded in the same way as the keys of the mods to find the mod of the row.
+		key := make(map[string]interface{}, len(keyColumns))
+		for _, name := range keyColumns {
+			key[name] = values[name]
+		}
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		b, err := json.Marshal(values)
+		if err != nil {
+			return nil, err
+		}
+		rows[string(k)] = b
+	}
+}
@@ -0,0 +1,351 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	sppb "cloud.google.com/go/spanner/apiv1/spannerpb"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// fakeSpannerServer is a minimal Cloud Spanner server for the tests. It detects the GoogleSQL dialect,
+// and returns only the child partitions records set in childPartitions for the change stream queries,
+// so every partition finishes immediately.
+type fakeSpannerServer struct {
+	sppb.UnimplementedSpannerServer
+
+	// addr is the address the server listens on.
+	addr     string
+	mu       sync.Mutex
+	sessions int
+	// dialectErrors are returned by the dialect queries in order before they succeed.
+	dialectErrors  []error
+	dialectQueries int
+	// databaseErrors are returned by the dialect queries of the databases, keyed by the database ID.
+	databaseErrors map[string]error
+	// databasesWithoutChangeStream are the IDs of the databases that don't define the change stream.
+	databasesWithoutChangeStream map[string]bool
+	// changeStreams are the change streams listed from information_schema.change_streams.
+	changeStreams []string
+	// If blackholed is true, the dialect queries never return until they are cancelled.
+	blackholed bool
+	// readMetadata is the incoming metadata of each change stream query.
+	readMetadata []metadata.MD
+	// childPartitions are the child partitions records returned by the partition, keyed by the partition token.
+	// The initial query has the empty token.
+	childPartitions map[string][]*ChildPartitionsRecord
+	// dataChangeRecords are the data change records returned by the partition before the child partitions
+	// records, keyed by the partition token. Only the records at or after the start timestamp of the query
+	// are returned, and only their metadata and mods are encoded.
+	dataChangeRecords map[string][]*DataChangeRecord
+	// retentionPeriod is the retention_period option of the change stream. If it's empty, the option is not set.
+	retentionPeriod string
+	// readTokens are the partition tokens of the change stream queries in order.
+	readTokens []string
+	// readStartTimestamps are the start timestamps of the change stream queries in order.
+	readStartTimestamps []string
+	// If holdInitialQuery is true, the initial query holds the rest of the rows after the first one until
+	// another query starts, for up to a second. initialQueryHeld reports whether another query started.
+	holdInitialQuery bool
+	initialQueryHeld bool
+	// hangingQueries are the numbers of the first queries of the partitions that never return until they are
+	// cancelled, keyed by the partition token.
+	hangingQueries map[string]int
+}
+
+// newFakeSpannerServer starts the fake server, and returns the client options to connect to it.
+func newFakeSpannerServer(t *testing.T) (*fakeSpannerServer, []option.ClientOption) {
+	t.Helper()
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	s := &fakeSpannerServer{addr: lis.Addr().String()}
+	server := grpc.NewServer()
+	sppb.RegisterSpannerServer(server, s)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	return s, []option.ClientOption{
+		option.WithEndpoint(lis.Addr().String()),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+	}
+}
+
+func (s *fakeSpannerServer) newSession(database string) *sppb.Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions++
+	return &sppb.Session{Name: fmt.Sprintf("%s/sessions/%d", database, s.sessions)}
+}
+
+func (s *fakeSpannerServer) CreateSession(ctx context.Context, req *sppb.CreateSessionRequest) (*sppb.Session, error) {
+	return s.newSession(req.Database), nil
+}
+
+func (s *fakeSpannerServer) BatchCreateSessions(ctx context.Context, req *sppb.BatchCreateSessionsRequest) (*sppb.BatchCreateSessionsResponse, error) {
+	resp := &sppb.BatchCreateSessionsResponse{}
+	for i := int32(0); i < req.SessionCount; i++ {
+		resp.Session = append(resp.Session, s.newSession(req.Database))
+	}
+	return resp, nil
+}
+
+func (s *fakeSpannerServer) DeleteSession(ctx context.Context, req *sppb.DeleteSessionRequest) (*emptypb.Empty, error) {
+	return &emptypb.Empty{}, nil
+}
+
+func (s *fakeSpannerServer) ExecuteStreamingSql(req *sppb.ExecuteSqlRequest, stream sppb.Spanner_ExecuteStreamingSqlServer) error {
+	if strings.Contains(req.Sql, "information_schema.database_options") {
+		s.mu.Lock()
+		s.dialectQueries++
+		blackholed := s.blackholed
+		err := s.databaseErrors[sessionDatabaseID(req.Session)]
+		if len(s.dialectErrors) > 0 {
+			err, s.dialectErrors = s.dialectErrors[0], s.dialectErrors[1:]
+		}
+		s.mu.Unlock()
+		if blackholed {
+			<-stream.Context().Done()
+			return stream.Context().Err()
+		}
+		if err != nil {
+			return err
+		}
+		return stream.Send(&sppb.PartialResultSet{
+			Metadata: &sppb.ResultSetMetadata{RowType: &sppb.StructType{Fields: []*sppb.StructType_Field{
+				{Name: "option_value", Type: &sppb.Type{Code: sppb.TypeCode_STRING}},
+			}}},
+			Values: []*structpb.Value{structpb.NewStringValue("GOOGLE_STANDARD_SQL")},
+		})
+	}
+
+	if strings.Contains(req.Sql, "information_schema.change_streams") && req.Params.GetFields()["stream"] == nil {
+		resp := &sppb.PartialResultSet{
+			Metadata: &sppb.ResultSetMetadata{RowType: &sppb.StructType{Fields: []*sppb.StructType_Field{
+				{Name: "change_stream_name", Type: &sppb.Type{Code: sppb.TypeCode_STRING}},
+				{Name: "all", Type: &sppb.Type{Code: sppb.TypeCode_BOOL}},
+			}}},
+		}
+		s.mu.Lock()
+		for _, name := range s.changeStreams {
+			resp.Values = append(resp.Values, structpb.NewStringValue(name), structpb.NewBoolValue(true))
+		}
+		s.mu.Unlock()
+		return stream.Send(resp)
+	}
+	if strings.Contains(req.Sql, "information_schema.change_stream_tables") {
+		return stream.Send(&sppb.PartialResultSet{
+			Metadata: &sppb.ResultSetMetadata{RowType: &sppb.StructType{Fields: []*sppb.StructType_Field{
+				{Name: "change_stream_name", Type: &sppb.Type{Code: sppb.TypeCode_STRING}},
+				{Name: "table_name", Type: &sppb.Type{Code: sppb.TypeCode_STRING}},
+			}}},
+		})
+	}
+	if strings.Contains(req.Sql, "information_schema.change_stream_options") && strings.Contains(req.Sql, "option_name,") {
+		resp := &sppb.PartialResultSet{
+			Metadata: &sppb.ResultSetMetadata{RowType: &sppb.StructType{Fields: []*sppb.StructType_Field{
+				{Name: "change_stream_name", Type: &sppb.Type{Code: sppb.TypeCode_STRING}},
+				{Name: "option_name", Type: &sppb.Type{Code: sppb.TypeCode_STRING}},
+				{Name: "option_value", Type: &sppb.Type{Code: sppb.TypeCode_STRING}},
+			}}},
+		}
+		s.mu.Lock()
+		if s.retentionPeriod != "" {
+			for _, name := range s.changeStreams {
+				resp.Values = append(resp.Values, structpb.NewStringValue(name),
+					structpb.NewStringValue("retention_period"), structpb.NewStringValue(s.retentionPeriod))
+			}
+		}
+		s.mu.Unlock()
+		return stream.Send(resp)
+	}
+
+	if strings.Contains(req.Sql, "information_schema.change_streams") {
+		resp := &sppb.PartialResultSet{
+			Metadata: &sppb.ResultSetMetadata{RowType: &sppb.StructType{Fields: []*sppb.StructType_Field{
+				{Name: "change_stream_name", Type: &sppb.Type{Code: sppb.TypeCode_STRING}},
+			}}},
+		}
+		s.mu.Lock()
+		if !s.databasesWithoutChangeStream[sessionDatabaseID(req.Session)] {
+			resp.Values = []*structpb.Value{req.Params.GetFields()["stream"]}
+		}
+		s.mu.Unlock()
+		return stream.Send(resp)
+	}
+	if strings.Contains(req.Sql, "information_schema.change_stream_options") {
+		resp := &sppb.PartialResultSet{
+			Metadata: &sppb.ResultSetMetadata{RowType: &sppb.StructType{Fields: []*sppb.StructType_Field{
+				{Name: "option_value", Type: &sppb.Type{Code: sppb.TypeCode_STRING}},
+			}}},
+		}
+		if s.retentionPeriod != "" {
+			resp.Values = []*structpb.Value{structpb.NewStringValue(s.retentionPeriod)}
+		}
+		return stream.Send(resp)
+	}
+
+	md, _ := metadata.FromIncomingContext(stream.Context())
+	token := req.Params.GetFields()["partition_token"].GetStringValue()
+	s.mu.Lock()
+	s.readMetadata = append(s.readMetadata, md)
+	s.readTokens = append(s.readTokens, token)
+	s.readStartTimestamps = append(s.readStartTimestamps, req.Params.GetFields()["start_timestamp"].GetStringValue())
+	records := s.childPartitions[token]
+	dataRecords := s.dataChangeRecords[token]
+	hanging := s.hangingQueries[token] > 0
+	if hanging {
+		s.hangingQueries[token]--
+	}
+	s.mu.Unlock()
+	if hanging {
+		<-stream.Context().Done()
+		return stream.Context().Err()
+	}
+
+	var values []*structpb.Value
+	start, _ := time.Parse(time.RFC3339Nano, req.Params.GetFields()["start_timestamp"].GetStringValue())
+	for _, record := range dataRecords {
+		if record.CommitTimestamp.Before(start) {
+			continue
+		}
+		var mods []*structpb.Value
+		for _, mod := range record.Mods {
+			mods = append(mods, listValue(jsonValue(mod.Keys), jsonValue(mod.NewValues), jsonValue(mod.OldValues)))
+		}
+		dataChangeRecord := listValue(
+			structpb.NewStringValue(record.CommitTimestamp.UTC().Format(time.RFC3339Nano)),
+			structpb.NewStringValue(record.RecordSequence),
+			structpb.NewStringValue(record.ServerTransactionID),
+			structpb.NewBoolValue(record.IsLastRecordInTransactionInPartition),
+			structpb.NewStringValue(record.TableName),
+			structpb.NewStringValue(record.ModType),
+			listValue(mods...),
+		)
+		values = append(values, listValue(listValue(listValue(dataChangeRecord), listValue())))
+	}
+	for _, record := range records {
+		var children []*structpb.Value
+		for _, child := range record.ChildPartitions {
+			var parents []*structpb.Value
+			for _, parent := range child.ParentPartitionTokens {
+				parents = append(parents, structpb.NewStringValue(parent))
+			}
+			children = append(children, listValue(structpb.NewStringValue(child.Token), listValue(parents...)))
+		}
+		childPartitionsRecord := listValue(
+			structpb.NewStringValue(record.StartTimestamp.UTC().Format(time.RFC3339Nano)),
+			structpb.NewStringValue(record.RecordSequence),
+			listValue(children...),
+		)
+		// A row has a single ChangeRecord with a single record.
+		values = append(values, listValue(listValue(listValue(), listValue(childPartitionsRecord))))
+	}
+	metadata := &sppb.ResultSetMetadata{RowType: &sppb.StructType{Fields: []*sppb.StructType_Field{
+		{Name: "ChangeRecord", Type: arrayType(structType(
+			field("data_change_record", arrayType(structType(
+				field("commit_timestamp", &sppb.Type{Code: sppb.TypeCode_TIMESTAMP}),
+				field("record_sequence", &sppb.Type{Code: sppb.TypeCode_STRING}),
+				field("server_transaction_id", &sppb.Type{Code: sppb.TypeCode_STRING}),
+				field("is_last_record_in_transaction_in_partition", &sppb.Type{Code: sppb.TypeCode_BOOL}),
+				field("table_name", &sppb.Type{Code: sppb.TypeCode_STRING}),
+				field("mod_type", &sppb.Type{Code: sppb.TypeCode_STRING}),
+				field("mods", arrayType(structType(
+					field("keys", &sppb.Type{Code: sppb.TypeCode_JSON}),
+					field("new_values", &sppb.Type{Code: sppb.TypeCode_JSON}),
+					field("old_values", &sppb.Type{Code: sppb.TypeCode_JSON}),
+				))),
+			))),
+			field("child_partitions_record", arrayType(structType(
+				field("start_timestamp", &sppb.Type{Code: sppb.TypeCode_TIMESTAMP}),
+				field("record_sequence", &sppb.Type{Code: sppb.TypeCode_STRING}),
+				field("child_partitions", arrayType(structType(
+					field("token", &sppb.Type{Code: sppb.TypeCode_STRING}),
+					field("parent_partition_tokens", arrayType(&sppb.Type{Code: sppb.TypeCode_STRING})),
+				))),
+			))),
+		))},
+	}}}
+	if !s.holdInitialQuery || token != "" || len(values) < 2 {
+		return stream.Send(&sppb.PartialResultSet{Metadata: metadata, Values: values})
+	}
+
+	// The resume token lets the client yield the first row without waiting for the rest.
+	if err := stream.Send(&sppb.PartialResultSet{Metadata: metadata, Values: values[:1], ResumeToken: []byte("1")}); err != nil {
+		return err
+	}
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		s.mu.Lock()
+		held := len(s.readTokens) > 1
+		s.initialQueryHeld = held
+		s.mu.Unlock()
+		if held {
+			break
+		}
+	}
+	return stream.Send(&sppb.PartialResultSet{Values: values[1:], ResumeToken: []byte("2")})
+}
+
+// sessionDatabaseID returns the database ID of the session name, i.e. projects/p/instances/i/databases/d/sessions/s.
+func sessionDatabaseID(session string) string {
+	parts := strings.Split(session, "/")
+	if len(parts) < 6 {
+		return ""
+	}
+	return parts[5]
+}
+
+func listValue(values ...*structpb.Value) *structpb.Value {
+	return structpb.NewListValue(&structpb.ListValue{Values: values})
+}
+
+// jsonValue encodes the JSON value of a mod, or NULL if it's not valid.
+func jsonValue(v spanner.NullJSON) *structpb.Value {
+	if !v.Valid {
+		return structpb.NewNullValue()
+	}
+	b, _ := json.Marshal(v.Value)
+	return structpb.NewStringValue(string(b))
+}
+
+func arrayType(elem *sppb.Type) *sppb.Type {
+	return &sppb.Type{Code: sppb.TypeCode_ARRAY, ArrayElementType: elem}
+}
+
+func structType(fields ...*sppb.StructType_Field) *sppb.Type {
+	return &sppb.Type{Code: sppb.TypeCode_STRUCT, StructType: &sppb.StructType{Fields: fields}}
+}
+
+func field(name string, t *sppb.Type) *sppb.StructType_Field {
+	return &sppb.StructType_Field{Name: name, Type: t}
+}
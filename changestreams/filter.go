@@ -0,0 +1,73 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+const (
+	modTypeInsert = "INSERT"
+	modTypeUpdate = "UPDATE"
+	modTypeDelete = "DELETE"
+
+	valueCaptureTypeOldAndNewValues = "OLD_AND_NEW_VALUES"
+)
+
+// skipNoOpUpdates removes the mods that didn't change any value from the UPDATE records,
+// and removes the records that have no mods left.
+func skipNoOpUpdates(changeRecord *ChangeRecord) error {
+	records := changeRecord.DataChangeRecords[:0]
+	for _, record := range changeRecord.DataChangeRecords {
+		if record.ModType != modTypeUpdate || record.ValueCaptureType != valueCaptureTypeOldAndNewValues {
+			records = append(records, record)
+			continue
+		}
+
+		mods := record.Mods[:0]
+		for _, mod := range record.Mods {
+			noOp, err := isNoOpUpdate(mod)
+			if err != nil {
+				return err
+			}
+			if !noOp {
+				mods = append(mods, mod)
+			}
+		}
+		record.Mods = mods
+		if len(record.Mods) > 0 {
+			records = append(records, record)
+		}
+	}
+	changeRecord.DataChangeRecords = records
+	return nil
+}
+
+// isNoOpUpdate reports whether the new values of the mod are the same as the old values.
+// The values are compared in JSON, since encoding/json sorts the object keys.
+func isNoOpUpdate(mod *Mod) (bool, error) {
+	newValues, err := json.Marshal(mod.NewValues)
+	if err != nil {
+		return false, err
+	}
+	oldValues, err := json.Marshal(mod.OldValues)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(newValues, oldValues), nil
+}
@@ -0,0 +1,85 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"testing"
+
+	"cloud.google.com/go/spanner"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSkipNoOpUpdates(t *testing.T) {
+	jsonValue := func(v interface{}) spanner.NullJSON {
+		return spanner.NullJSON{Value: v, Valid: true}
+	}
+	noOpMod := &Mod{
+		Keys:      jsonValue(map[string]interface{}{"id": "1"}),
+		NewValues: jsonValue(map[string]interface{}{"name": "a", "active": true}),
+		OldValues: jsonValue(map[string]interface{}{"active": true, "name": "a"}),
+	}
+	changedMod := &Mod{
+		Keys:      jsonValue(map[string]interface{}{"id": "2"}),
+		NewValues: jsonValue(map[string]interface{}{"name": "b"}),
+		OldValues: jsonValue(map[string]interface{}{"name": "a"}),
+	}
+
+	for _, test := range []struct {
+		desc    string
+		records []*DataChangeRecord
+		want    []*DataChangeRecord
+	}{
+		{
+			desc: "no-op mods are removed",
+			records: []*DataChangeRecord{
+				{ModType: "UPDATE", ValueCaptureType: "OLD_AND_NEW_VALUES", Mods: []*Mod{noOpMod, changedMod}},
+			},
+			want: []*DataChangeRecord{
+				{ModType: "UPDATE", ValueCaptureType: "OLD_AND_NEW_VALUES", Mods: []*Mod{changedMod}},
+			},
+		},
+		{
+			desc: "record without mods is removed",
+			records: []*DataChangeRecord{
+				{ModType: "UPDATE", ValueCaptureType: "OLD_AND_NEW_VALUES", Mods: []*Mod{noOpMod}},
+				{ModType: "INSERT", ValueCaptureType: "OLD_AND_NEW_VALUES", Mods: []*Mod{changedMod}},
+			},
+			want: []*DataChangeRecord{
+				{ModType: "INSERT", ValueCaptureType: "OLD_AND_NEW_VALUES", Mods: []*Mod{changedMod}},
+			},
+		},
+		{
+			desc: "other value capture types are not changed",
+			records: []*DataChangeRecord{
+				{ModType: "UPDATE", ValueCaptureType: "NEW_VALUES", Mods: []*Mod{noOpMod}},
+			},
+			want: []*DataChangeRecord{
+				{ModType: "UPDATE", ValueCaptureType: "NEW_VALUES", Mods: []*Mod{noOpMod}},
+			},
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			changeRecord := &ChangeRecord{DataChangeRecords: test.records}
+			if err := skipNoOpUpdates(changeRecord); err != nil {
+				t.Fatalf("skipNoOpUpdates error: %v", err)
+			}
+			if diff := cmp.Diff(changeRecord.DataChangeRecords, test.want); diff != "" {
+				t.Errorf("diff = %v", diff)
+			}
+		})
+	}
+}
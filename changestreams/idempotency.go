@@ -0,0 +1,66 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// idempotencyKeyVersion is a part of the key so that a future change of the format never collides with the current keys.
+const idempotencyKeyVersion = "v1"
+
+// IdempotencyKey returns a deterministic key that identifies the mod of the data change record read from the partition.
+//
+// The key is the hex-encoded SHA-256 of the commit timestamp, the server transaction ID, the partition token,
+// the record sequence, Mod.Index and the primary key of the mod. Mod.Index is the position of the mod in the record
+// returned by Cloud Spanner, so the key is the same with and without Config.SkipNoOpUpdates. It's set by the Reader,
+// so a mod built by hand has Index 0 unless it's set.
+// The same change always has the same key, even when it's redelivered after a restart, so downstream systems can
+// use the key to deduplicate changes. Different mods have different keys.
+// The format of the key is stable across releases; if it ever needs to change, the new keys never collide
+// with the current ones.
+//
+// It returns an error if the primary key of the mod can't be encoded in JSON.
+func IdempotencyKey(partitionToken string, rec *DataChangeRecord, mod *Mod) (string, error) {
+	// encoding/json sorts the object keys, so the primary key is canonical.
+	keys, err := json.Marshal(mod.Keys)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode the primary key of the mod: %w", err)
+	}
+
+	h := sha256.New()
+	for _, field := range []string{
+		idempotencyKeyVersion,
+		rec.CommitTimestamp.UTC().Format(time.RFC3339Nano),
+		rec.ServerTransactionID,
+		partitionToken,
+		rec.RecordSequence,
+		strconv.Itoa(mod.Index),
+		string(keys),
+	} {
+		// Length prefix keeps the fields unambiguous.
+		h.Write([]byte(strconv.Itoa(len(field))))
+		h.Write([]byte{':'})
+		h.Write([]byte(field))
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,128 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"testing"
+
+	"cloud.google.com/go/spanner"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	newRecord := func() *DataChangeRecord {
+		return &DataChangeRecord{
+			CommitTimestamp:     mustParseTime("2023-02-24T17:17:00.678847-08:00"),
+			ServerTransactionID: "NTQ5MTAxNjk2MzM2OTMxOTM5NQ==",
+			RecordSequence:      "00000000",
+			Mods: []*Mod{
+				{Keys: spanner.NullJSON{Value: map[string]interface{}{"id": "1", "name": "a"}, Valid: true}},
+				{Keys: spanner.NullJSON{Value: map[string]interface{}{"id": "2", "name": "a"}, Valid: true}, Index: 1},
+			},
+		}
+	}
+
+	idempotencyKey := func(t *testing.T, partitionToken string, rec *DataChangeRecord, mod *Mod) string {
+		t.Helper()
+		key, err := IdempotencyKey(partitionToken, rec, mod)
+		if err != nil {
+			t.Fatalf("IdempotencyKey error: %v", err)
+		}
+		return key
+	}
+
+	rec := newRecord()
+	key := idempotencyKey(t, "token", rec, rec.Mods[0])
+
+	// The format must be stable across releases.
+	if want := "e2d94def068ee6a0fa1d07a08157ee82edf6986f365ec8ae9de8f22055719da3"; key != want {
+		t.Errorf("IdempotencyKey = %q, want %q", key, want)
+	}
+
+	redelivered := newRecord()
+	if got := idempotencyKey(t, "token", redelivered, redelivered.Mods[0]); got != key {
+		t.Errorf("IdempotencyKey of the redelivered record = %q, want %q", got, key)
+	}
+
+	for _, test := range []struct {
+		desc           string
+		partitionToken string
+		modify         func(rec *DataChangeRecord)
+		modIndex       int
+	}{
+		{desc: "different mod", partitionToken: "token", modIndex: 1},
+		{desc: "different partition", partitionToken: "other", modIndex: 0},
+		{desc: "different record sequence", partitionToken: "token", modify: func(rec *DataChangeRecord) { rec.RecordSequence = "00000001" }},
+		{desc: "different transaction", partitionToken: "token", modify: func(rec *DataChangeRecord) { rec.ServerTransactionID = "other" }},
+		{desc: "different index", partitionToken: "token", modify: func(rec *DataChangeRecord) { rec.Mods[0].Index = 1 }},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			rec := newRecord()
+			if test.modify != nil {
+				test.modify(rec)
+			}
+			if got := idempotencyKey(t, test.partitionToken, rec, rec.Mods[test.modIndex]); got == key {
+				t.Errorf("IdempotencyKey = %q, must be different", got)
+			}
+		})
+	}
+
+	t.Run("no-op updates skipped", func(t *testing.T) {
+		newChangeRecord := func() *ChangeRecord {
+			return &ChangeRecord{DataChangeRecords: []*DataChangeRecord{{
+				CommitTimestamp:  mustParseTime("2023-02-24T17:17:00.678847-08:00"),
+				RecordSequence:   "00000000",
+				ModType:          modTypeUpdate,
+				ValueCaptureType: valueCaptureTypeOldAndNewValues,
+				Mods: []*Mod{
+					{
+						Keys:      spanner.NullJSON{Value: map[string]interface{}{"id": "1"}, Valid: true},
+						NewValues: spanner.NullJSON{Value: map[string]interface{}{"name": "a"}, Valid: true},
+						OldValues: spanner.NullJSON{Value: map[string]interface{}{"name": "a"}, Valid: true},
+					},
+					{
+						Keys:      spanner.NullJSON{Value: map[string]interface{}{"id": "2"}, Valid: true},
+						NewValues: spanner.NullJSON{Value: map[string]interface{}{"name": "b"}, Valid: true},
+						OldValues: spanner.NullJSON{Value: map[string]interface{}{"name": "a"}, Valid: true},
+					},
+				},
+			}}}
+		}
+		read := func(skip bool) string {
+			result := &ReadResult{ChangeRecords: []*ChangeRecord{newChangeRecord()}}
+			indexMods(result)
+			if skip {
+				if err := skipNoOpUpdates(result.ChangeRecords[0]); err != nil {
+					t.Fatalf("skipNoOpUpdates error: %v", err)
+				}
+			}
+			rec := result.ChangeRecords[0].DataChangeRecords[0]
+			return idempotencyKey(t, "token", rec, rec.Mods[len(rec.Mods)-1])
+		}
+		// The key of the second mod is the same after the no-op mod before it is removed.
+		if got, want := read(true), read(false); got != want {
+			t.Errorf("IdempotencyKey after SkipNoOpUpdates = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("invalid primary key", func(t *testing.T) {
+		rec := newRecord()
+		rec.Mods[0].Keys = spanner.NullJSON{Value: make(chan int), Valid: true}
+		if _, err := IdempotencyKey("token", rec, rec.Mods[0]); err == nil {
+			t.Error("IdempotencyKey must fail with the primary key that can't be encoded in JSON")
+		}
+	})
+}
@@ -0,0 +1,137 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"context"
+	"errors"
+	"iter"
+)
+
+// ModEvent is a single mod of a data change record, flattened with the partition it was read from.
+type ModEvent struct {
+	PartitionToken string
+	Record         *DataChangeRecord
+	Mod            *Mod
+}
+
+var errModEventsStopped = errors.New("mod events stopped")
+
+// DataChangeRecords returns an iterator over the data change records of all the change records in the result.
+//
+// The iterator only reads the result, so it's safe to iterate the same result from multiple goroutines
+// as long as nobody modifies it.
+func (r *ReadResult) DataChangeRecords() iter.Seq[*DataChangeRecord] {
+	return func(yield func(*DataChangeRecord) bool) {
+		for _, changeRecord := range r.ChangeRecords {
+			for _, record := range changeRecord.DataChangeRecords {
+				if !yield(record) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// HeartbeatRecords returns an iterator over the heartbeat records of all the change records in the result.
+func (r *ReadResult) HeartbeatRecords() iter.Seq[*HeartbeatRecord] {
+	return func(yield func(*HeartbeatRecord) bool) {
+		for _, changeRecord := range r.ChangeRecords {
+			for _, record := range changeRecord.HeartbeatRecords {
+				if !yield(record) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// ChildPartitionsRecords returns an iterator over the child partitions records of all the change records in the result.
+func (r *ReadResult) ChildPartitionsRecords() iter.Seq[*ChildPartitionsRecord] {
+	return func(yield func(*ChildPartitionsRecord) bool) {
+		for _, changeRecord := range r.ChangeRecords {
+			for _, record := range changeRecord.ChildPartitionsRecords {
+				if !yield(record) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// ModEvents returns an iterator over the mods of all the data change records in the result.
+func (r *ReadResult) ModEvents() iter.Seq[*ModEvent] {
+	return func(yield func(*ModEvent) bool) {
+		for record := range r.DataChangeRecords() {
+			for _, mod := range record.Mods {
+				if !yield(&ModEvent{PartitionToken: r.PartitionToken, Record: record, Mod: mod}) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// ModEvents reads the change stream and returns an iterator over the mods of the data change records,
+// and a function to stop reading. It's an alternative to Read that can be used with a for-range loop.
+//
+// The reading starts when the iterator is ranged over, and the iterator yields an error once if the read fails.
+// Breaking the loop or calling the stop function stops reading without an error.
+// Like Read, the iterator can be ranged over only once.
+func (r *Reader) ModEvents(ctx context.Context) (iter.Seq2[*ModEvent, error], func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	stop := func() {
+		cancel(errModEventsStopped)
+	}
+
+	seq := func(yield func(*ModEvent, error) bool) {
+		defer stop()
+
+		// The read function is called from the goroutines of the partitions, but yield must be called
+		// from the goroutine of the loop.
+		events := make(chan *ModEvent)
+		errc := make(chan error, 1)
+		go func() {
+			errc <- r.Read(ctx, func(result *ReadResult) error {
+				for event := range result.ModEvents() {
+					select {
+					case events <- event:
+					case <-ctx.Done():
+						return context.Cause(ctx)
+					}
+				}
+				return nil
+			})
+			close(events)
+		}()
+
+		for event := range events {
+			if !yield(event, nil) {
+				stop()
+				// Drain the events so that Read can return.
+				for range events {
+				}
+				<-errc
+				return
+			}
+		}
+		if err := <-errc; err != nil && !errors.Is(context.Cause(ctx), errModEventsStopped) {
+			yield(nil, err)
+		}
+	}
+	return seq, stop
+}
@@ -0,0 +1,206 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReadResultIterators(t *testing.T) {
+	result := &ReadResult{
+		ChangeRecords: []*ChangeRecord{
+			{
+				DataChangeRecords: []*DataChangeRecord{{RecordSequence: "00000000"}, {RecordSequence: "00000001"}},
+				HeartbeatRecords:  []*HeartbeatRecord{{Timestamp: mustParseTime("2023-02-24T17:16:43Z")}},
+			},
+			{
+				DataChangeRecords:      []*DataChangeRecord{{RecordSequence: "00000002"}},
+				ChildPartitionsRecords: []*ChildPartitionsRecord{{RecordSequence: "00000003"}},
+			},
+		},
+	}
+
+	var sequences []string
+	for r := range result.DataChangeRecords() {
+		sequences = append(sequences, r.RecordSequence)
+	}
+	if diff := cmp.Diff(sequences, []string{"00000000", "00000001", "00000002"}); diff != "" {
+		t.Errorf("DataChangeRecords diff = %v", diff)
+	}
+
+	// Stop iterating in the middle.
+	for r := range result.DataChangeRecords() {
+		if r.RecordSequence != "00000000" {
+			t.Errorf("iterator must stop after break, got %q", r.RecordSequence)
+		}
+		break
+	}
+
+	if diff := cmp.Diff(slices.Collect(result.HeartbeatRecords()), result.ChangeRecords[0].HeartbeatRecords); diff != "" {
+		t.Errorf("HeartbeatRecords diff = %v", diff)
+	}
+	if diff := cmp.Diff(slices.Collect(result.ChildPartitionsRecords()), result.ChangeRecords[1].ChildPartitionsRecords); diff != "" {
+		t.Errorf("ChildPartitionsRecords diff = %v", diff)
+	}
+}
+
+func TestReadResultModEvents(t *testing.T) {
+	mod1, mod2, mod3 := &Mod{}, &Mod{}, &Mod{}
+	record1 := &DataChangeRecord{Mods: []*Mod{mod1, mod2}}
+	record2 := &DataChangeRecord{Mods: []*Mod{mod3}}
+	result := &ReadResult{
+		PartitionToken: "token",
+		ChangeRecords: []*ChangeRecord{
+			{DataChangeRecords: []*DataChangeRecord{record1}},
+			{DataChangeRecords: []*DataChangeRecord{record2}},
+		},
+	}
+
+	want := []*ModEvent{
+		{PartitionToken: "token", Record: record1, Mod: mod1},
+		{PartitionToken: "token", Record: record1, Mod: mod2},
+		{PartitionToken: "token", Record: record2, Mod: mod3},
+	}
+	got := slices.Collect(result.ModEvents())
+	if len(got) != len(want) {
+		t.Fatalf("ModEvents returned %d events, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].PartitionToken != want[i].PartitionToken || got[i].Record != want[i].Record || got[i].Mod != want[i].Mod {
+			t.Errorf("event %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestReaderModEvents(t *testing.T) {
+	start := time.Date(2023, 2, 24, 17, 17, 0, 0, time.UTC)
+	key := func(id int) spanner.NullJSON {
+		return spanner.NullJSON{Value: map[string]interface{}{"id": fmt.Sprint(id)}, Valid: true}
+	}
+	newReader := func(t *testing.T, config Config) *Reader {
+		t.Helper()
+		server, opts := newFakeSpannerServer(t)
+		server.childPartitions = map[string][]*ChildPartitionsRecord{
+			"": {{StartTimestamp: start, RecordSequence: "00000001", ChildPartitions: []*ChildPartition{{Token: "a"}}}},
+		}
+		server.dataChangeRecords = map[string][]*DataChangeRecord{
+			"a": {
+				{CommitTimestamp: start.Add(time.Second), RecordSequence: "00000000", IsLastRecordInTransactionInPartition: true,
+					ModType: "INSERT", Mods: []*Mod{{Keys: key(1)}, {Keys: key(2)}}},
+				{CommitTimestamp: start.Add(2 * time.Second), RecordSequence: "00000001", IsLastRecordInTransactionInPartition: true,
+					ModType: "INSERT", Mods: []*Mod{{Keys: key(3)}}},
+			},
+		}
+		config.StartTimestamp = start
+		config.EndTimestamp = start.Add(time.Minute)
+		config.SpannerClientOptions = opts
+		r, err := NewReaderWithConfig(context.Background(), "project", "instance", "database", "stream", config)
+		if err != nil {
+			t.Fatalf("NewReaderWithConfig error: %v", err)
+		}
+		t.Cleanup(r.Close)
+		return r
+	}
+	// ranged ranges over the events in a goroutine, so that a deadlock fails the test instead of hanging it.
+	ranged := func(t *testing.T, f func()) {
+		t.Helper()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			f()
+		}()
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatal("the loop over ModEvents never returned")
+		}
+	}
+
+	t.Run("full read", func(t *testing.T) {
+		r := newReader(t, Config{})
+		events, stop := r.ModEvents(context.Background())
+		defer stop()
+		var keys []string
+		ranged(t, func() {
+			for event, err := range events {
+				if err != nil {
+					t.Errorf("ModEvents error: %v", err)
+					continue
+				}
+				if event.PartitionToken != "a" {
+					t.Errorf("PartitionToken = %q, want a", event.PartitionToken)
+				}
+				keys = append(keys, event.Mod.Keys.Value.(map[string]interface{})["id"].(string))
+			}
+		})
+		if diff := cmp.Diff(keys, []string{"1", "2", "3"}); diff != "" {
+			t.Errorf("keys of the events diff = %v", diff)
+		}
+	})
+
+	t.Run("break after the first event", func(t *testing.T) {
+		r := newReader(t, Config{})
+		events, stop := r.ModEvents(context.Background())
+		defer stop()
+		var n int
+		ranged(t, func() {
+			for _, err := range events {
+				if err != nil {
+					t.Errorf("ModEvents error after the break: %v", err)
+				}
+				n++
+				break
+			}
+		})
+		// The loop returns only after Read has returned, and the cancellation of the break is never yielded.
+		if n != 1 {
+			t.Errorf("loop ran %d times, want 1", n)
+		}
+	})
+
+	t.Run("read error", func(t *testing.T) {
+		errCanceled := errors.New("canceled by the caller")
+		r := newReader(t, Config{})
+		ctx, cancel := context.WithCancelCause(context.Background())
+		events, stop := r.ModEvents(ctx)
+		defer stop()
+		var errs []error
+		ranged(t, func() {
+			for event, err := range events {
+				if err != nil {
+					errs = append(errs, err)
+					continue
+				}
+				if event.Mod.Keys.Value.(map[string]interface{})["id"] == "1" {
+					// The read function is blocked on the next event until the cancellation.
+					cancel(errCanceled)
+				}
+			}
+		})
+		if len(errs) != 1 || !errors.Is(errs[0], errCanceled) {
+			t.Errorf("ModEvents errors = %v, want %v once", errs, errCanceled)
+		}
+	})
+}
@@ -0,0 +1,601 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	database "cloud.google.com/go/spanner/admin/database/apiv1"
+	"cloud.google.com/go/spanner/admin/database/apiv1/databasepb"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+const defaultDatabaseRefreshInterval = 5 * time.Minute
+
+// The states of the readers of the change streams of MultiDatabaseReader.
+const (
+	DatabaseStateConnecting = "connecting"
+	DatabaseStateReading    = "reading"
+	DatabaseStateFinished   = "finished"
+	DatabaseStateFailed     = "failed"
+)
+
+// DatabaseStats is the statistics of the reader of a change stream of a database of MultiDatabaseReader.
+type DatabaseStats struct {
+	DatabaseID string `json:"database_id"`
+	StreamID   string `json:"stream_id"`
+	// State is one of DatabaseStateConnecting, DatabaseStateReading, DatabaseStateFinished and DatabaseStateFailed.
+	State string `json:"state"`
+	// Error is the error of the failed reader.
+	Error string `json:"error,omitempty"`
+	Stats Stats  `json:"stats"`
+}
+
+// MultiDatabaseConfig is the configuration for the multi-database reader.
+type MultiDatabaseConfig struct {
+	// DatabasePattern is the pattern of the IDs of the databases to read, where "%" matches any sequence of
+	// characters and "_" matches any single character, as in the LIKE operator of SQL, e.g. "tenant_%".
+	DatabasePattern string
+	// RefreshInterval is the interval to list the databases again to attach to the new ones and detach from
+	// the dropped ones. If RefreshInterval is zero, 5 minutes is used.
+	RefreshInterval time.Duration
+	// StartTimestamp returns the timestamp to start reading the database from, e.g. from a per-database checkpoint.
+	// If StartTimestamp is nil or returns the zero time, the databases found by the first listing are read from
+	// Config.StartTimestamp or Config.StartOffset, and the ones found later are read from the time they're found.
+	StartTimestamp func(ctx context.Context, databaseID string) (time.Time, error)
+	// AdminClientOptions are the options of the Database Admin API client to list the databases.
+	// If AdminClientOptions is nil, Config.SpannerClientOptions and Config.Dialer are used.
+	AdminClientOptions []option.ClientOption
+	// Config is the configuration of the reader of each database. Config.IncludeSource is always true,
+	// so that each result tells the database it was read from. Config.ResumeFrom is not supported.
+	Config Config
+}
+
+// MultiStreamConfig is the configuration for the reader of all the change streams of a database.
+type MultiStreamConfig struct {
+	// RefreshInterval is the interval to list the change streams again to attach to the new ones and detach
+	// from the dropped ones. If RefreshInterval is zero, 5 minutes is used.
+	RefreshInterval time.Duration
+	// StartTimestamp returns the timestamp to start reading the change stream from. If StartTimestamp is nil or
+	// returns the zero time, the change streams found by the first listing are read from Config.StartTimestamp
+	// or Config.StartOffset, and the ones found later are read from the time they're found.
+	StartTimestamp func(ctx context.Context, streamID string) (time.Time, error)
+	// Config is the configuration of the reader of each change stream. Config.IncludeSource is always true,
+	// so that each result tells the change stream it was read from. Config.ResumeFrom is not supported.
+	Config Config
+}
+
+// MultiDatabaseReader reads the change stream of the same name from all the databases of the instance whose IDs
+// match a pattern, or all the change streams of a database, and keeps the set of the databases or the change streams
+// fresh. The failure of a change stream doesn't stop the others, and it's reported in Stats until the change stream
+// is read again; a failed change stream is attached again at every listing, from the StartTimestamp of the config
+// if it's set, or from now otherwise.
+type MultiDatabaseReader struct {
+	projectID  string
+	instanceID string
+	// streamID is the change stream read from the databases matching the pattern, if allStreams is false.
+	streamID string
+	pattern  *regexp.Regexp
+	// databaseID is the database whose change streams are all read, if allStreams is true.
+	databaseID      string
+	allStreams      bool
+	refreshInterval time.Duration
+	startTimestamp  func(ctx context.Context, target streamTarget) (time.Time, error)
+	config          Config
+	adminClient     *database.DatabaseAdminClient
+	listDatabases   func(ctx context.Context) ([]string, error)
+	client          *spanner.Client
+	listStreams     func(ctx context.Context) ([]string, error)
+	logger          *slog.Logger
+	databases       map[streamTarget]*databaseReader
+	reading         bool
+	mu              sync.Mutex
+}
+
+// streamTarget is a change stream of a database read by MultiDatabaseReader.
+type streamTarget struct {
+	databaseID string
+	streamID   string
+}
+
+// databaseReader is the reader of a change stream of a database of MultiDatabaseReader.
+type databaseReader struct {
+	target streamTarget
+	cancel context.CancelFunc
+	done   chan struct{}
+	mu     sync.Mutex
+	reader *Reader
+	state  string
+	err    error
+}
+
+// NewMultiDatabaseReader creates a new multi-database reader of the change stream of the databases in the instance.
+func NewMultiDatabaseReader(ctx context.Context, projectID, instanceID, streamID string, config MultiDatabaseConfig) (*MultiDatabaseReader, error) {
+	if config.DatabasePattern == "" {
+		return nil, errors.New("DatabasePattern must be set")
+	}
+	if config.Config.ResumeFrom != nil {
+		return nil, errors.New("ResumeFrom is not supported by MultiDatabaseReader")
+	}
+	refreshInterval := config.RefreshInterval
+	if refreshInterval == 0 {
+		refreshInterval = defaultDatabaseRefreshInterval
+	}
+	if refreshInterval < 0 {
+		return nil, fmt.Errorf("invalid RefreshInterval: %s", refreshInterval)
+	}
+
+	logger := config.Config.Logger
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	instancePath := fmt.Sprintf("projects/%s/instances/%s", projectID, instanceID)
+
+	opts := config.AdminClientOptions
+	if opts == nil {
+		opts = config.Config.spannerClientOptions()
+	}
+	adminClient, err := database.NewDatabaseAdminClient(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	readerConfig := config.Config
+	readerConfig.IncludeSource = true
+	m := &MultiDatabaseReader{
+		projectID:       projectID,
+		instanceID:      instanceID,
+		streamID:        streamID,
+		pattern:         likePattern(config.DatabasePattern),
+		refreshInterval: refreshInterval,
+		config:          readerConfig,
+		adminClient:     adminClient,
+		logger:          logger.With("stream", streamID, "instance", instancePath),
+		databases:       make(map[streamTarget]*databaseReader),
+	}
+	if config.StartTimestamp != nil {
+		m.startTimestamp = func(ctx context.Context, target streamTarget) (time.Time, error) {
+			return config.StartTimestamp(ctx, target.databaseID)
+		}
+	}
+	m.listDatabases = func(ctx context.Context) ([]string, error) {
+		var ids []string
+		it := adminClient.ListDatabases(ctx, &databasepb.ListDatabasesRequest{Parent: instancePath})
+		for {
+			db, err := it.Next()
+			if err == iterator.Done {
+				return ids, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			ids = append(ids, db.Name[strings.LastIndex(db.Name, "/")+1:])
+		}
+	}
+	return m, nil
+}
+
+// NewMultiStreamReader creates a new multi-database reader of all the change streams of the database.
+func NewMultiStreamReader(ctx context.Context, projectID, instanceID, databaseID string, config MultiStreamConfig) (*MultiDatabaseReader, error) {
+	if config.Config.ResumeFrom != nil {
+		return nil, errors.New("ResumeFrom is not supported by MultiDatabaseReader")
+	}
+	refreshInterval := config.RefreshInterval
+	if refreshInterval == 0 {
+		refreshInterval = defaultDatabaseRefreshInterval
+	}
+	if refreshInterval < 0 {
+		return nil, fmt.Errorf("invalid RefreshInterval: %s", refreshInterval)
+	}
+
+	logger := config.Config.Logger
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	dbPath := fmt.Sprintf("projects/%s/instances/%s/databases/%s", projectID, instanceID, databaseID)
+	clientConfig := config.Config.SpannerClientConfig
+	if isZeroSessionPoolConfig(clientConfig.SessionPoolConfig) {
+		clientConfig.SessionPoolConfig = spanner.DefaultSessionPoolConfig
+	}
+	client, err := spanner.NewClientWithConfig(ctx, dbPath, clientConfig, config.Config.spannerClientOptions()...)
+	if err != nil {
+		return nil, err
+	}
+
+	readerConfig := config.Config
+	readerConfig.IncludeSource = true
+	m := &MultiDatabaseReader{
+		projectID:       projectID,
+		instanceID:      instanceID,
+		databaseID:      databaseID,
+		allStreams:      true,
+		refreshInterval: refreshInterval,
+		config:          readerConfig,
+		client:          client,
+		logger:          logger.With("database", dbPath),
+		databases:       make(map[streamTarget]*databaseReader),
+	}
+	if config.StartTimestamp != nil {
+		m.startTimestamp = func(ctx context.Context, target streamTarget) (time.Time, error) {
+			return config.StartTimestamp(ctx, target.streamID)
+		}
+	}
+	m.listStreams = func(ctx context.Context) ([]string, error) {
+		streams, err := ListChangeStreams(ctx, client)
+		if err != nil {
+			return nil, err
+		}
+		ids := make([]string, 0, len(streams))
+		for _, s := range streams {
+			ids = append(ids, s.Name)
+		}
+		return ids, nil
+	}
+	return m, nil
+}
+
+// Close closes the reader.
+func (m *MultiDatabaseReader) Close() {
+	if m.adminClient != nil {
+		m.adminClient.Close()
+	}
+	if m.client != nil {
+		m.client.Close()
+	}
+}
+
+// Read lists the databases and reads the change stream of each matching database that defines it, or lists and
+// reads each change stream of the database, until ctx is done or function f returns an error. The databases
+// or the change streams are listed again every RefreshInterval of the config.
+//
+// Function f is called concurrently for the results of all the change streams; ReadResult.Source tells the
+// database and the change stream. If f returns an error, Read stops reading all the change streams and returns
+// the error, while the other errors of a change stream only fail the change stream. Read fails if the first
+// listing fails.
+func (m *MultiDatabaseReader) Read(ctx context.Context, f func(result *ReadResult) error) error {
+	m.mu.Lock()
+	if m.reading {
+		m.mu.Unlock()
+		return errors.New("reader has already been read")
+	}
+	m.reading = true
+	m.mu.Unlock()
+
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var readErr error
+	var once sync.Once
+	read := func(result *ReadResult) error {
+		err := f(result)
+		if err != nil {
+			once.Do(func() {
+				readErr = err
+				cancel()
+			})
+		}
+		return err
+	}
+
+	ticker := time.NewTicker(m.refreshInterval)
+	defer ticker.Stop()
+	for initial := true; ; initial = false {
+		if err := m.refresh(ctx, initial, read); err != nil {
+			if initial {
+				return fmt.Errorf("failed to list the %s: %w", m.kind()+"s", err)
+			}
+			if ctx.Err() == nil {
+				m.logger.Warn("failed to list the "+m.kind()+"s", "event", m.kind()+"s_list_failed", "error", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+			continue
+		}
+		break
+	}
+
+	m.mu.Lock()
+	var databases []*databaseReader
+	for _, d := range m.databases {
+		databases = append(databases, d)
+	}
+	m.mu.Unlock()
+	for _, d := range databases {
+		<-d.done
+	}
+	if readErr != nil {
+		return readErr
+	}
+	return parent.Err()
+}
+
+// kind returns what MultiDatabaseReader lists, i.e. "database" or "stream", for the logs.
+func (m *MultiDatabaseReader) kind() string {
+	if m.allStreams {
+		return "stream"
+	}
+	return "database"
+}
+
+// event returns the event of the logs about a database or a change stream, e.g. "database_attached".
+func (m *MultiDatabaseReader) event(name string) string {
+	return m.kind() + "_" + name
+}
+
+// targetAttrs returns the attributes of the logs about the change stream of the database that are not in m.logger.
+func (m *MultiDatabaseReader) targetAttrs(target streamTarget) []any {
+	if m.allStreams {
+		return []any{"stream", target.streamID}
+	}
+	return []any{"database_id", target.databaseID}
+}
+
+// list returns the change streams of the databases to read.
+func (m *MultiDatabaseReader) list(ctx context.Context) ([]streamTarget, error) {
+	var targets []streamTarget
+	if m.allStreams {
+		ids, err := m.listStreams(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			targets = append(targets, streamTarget{databaseID: m.databaseID, streamID: id})
+		}
+		return targets, nil
+	}
+
+	ids, err := m.listDatabases(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		if m.pattern.MatchString(id) {
+			targets = append(targets, streamTarget{databaseID: id, streamID: m.streamID})
+		}
+	}
+	return targets, nil
+}
+
+// refresh lists the databases or the change streams, attaches to the new and the failed ones, and detaches from
+// the dropped ones.
+func (m *MultiDatabaseReader) refresh(ctx context.Context, initial bool, f func(result *ReadResult) error) error {
+	targets, err := m.list(ctx)
+	if err != nil {
+		return err
+	}
+	matched := make(map[streamTarget]bool)
+	for _, target := range targets {
+		matched[target] = true
+	}
+
+	m.mu.Lock()
+	var detached []*databaseReader
+	for target, d := range m.databases {
+		if !matched[target] {
+			m.logger.With(m.targetAttrs(target)...).Info(m.kind()+" disappeared, detaching", "event", m.event("detached"))
+			d.cancel()
+			delete(m.databases, target)
+			detached = append(detached, d)
+		}
+	}
+	for target := range matched {
+		d := &databaseReader{target: target, done: make(chan struct{}), state: DatabaseStateConnecting}
+		if prev, ok := m.databases[target]; ok {
+			prev.mu.Lock()
+			state, err := prev.state, prev.err
+			prev.mu.Unlock()
+			if state != DatabaseStateFailed {
+				continue
+			}
+			// The failed change stream stays failed until it's read again, so that the failure remains visible.
+			d.state = DatabaseStateFailed
+			d.err = err
+		}
+		dbCtx, cancel := context.WithCancel(ctx)
+		d.cancel = cancel
+		m.databases[target] = d
+		go func() {
+			defer close(d.done)
+			defer cancel()
+			m.read(dbCtx, d, initial, f)
+		}()
+	}
+	m.mu.Unlock()
+
+	for _, d := range detached {
+		<-d.done
+	}
+	return nil
+}
+
+// read reads the change stream of the database until it finishes, fails or is detached.
+func (m *MultiDatabaseReader) read(ctx context.Context, d *databaseReader, initial bool, f func(result *ReadResult) error) {
+	logger := m.logger.With(m.targetAttrs(d.target)...)
+	fail := func(err error) {
+		if ctx.Err() != nil {
+			return
+		}
+		logger.Error(m.kind()+" read failed", "event", m.event("failed"), "error", err)
+		d.mu.Lock()
+		d.state = DatabaseStateFailed
+		d.err = err
+		d.mu.Unlock()
+	}
+
+	config := m.config
+	if !initial {
+		// The database or the change stream was created after the read started.
+		config.StartTimestamp = time.Now()
+		config.StartOffset = 0
+	}
+	if m.startTimestamp != nil {
+		start, err := m.startTimestamp(ctx, d.target)
+		if err != nil {
+			fail(fmt.Errorf("failed to get the start timestamp: %w", err))
+			return
+		}
+		if !start.IsZero() {
+			config.StartTimestamp = start
+			config.StartOffset = 0
+		}
+	}
+
+	r, err := NewReaderWithConfig(ctx, m.projectID, m.instanceID, d.target.databaseID, d.target.streamID, config)
+	if errors.Is(err, ErrStreamNotFound) && !m.allStreams {
+		logger.Debug("database doesn't define the change stream, skipped", "event", "database_skipped")
+		// The database is attached again at the next listing in case it defines the change stream by then.
+		m.mu.Lock()
+		if m.databases[d.target] == d {
+			delete(m.databases, d.target)
+		}
+		m.mu.Unlock()
+		return
+	}
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer r.Close()
+
+	logger.Info(m.kind()+" attached", "event", m.event("attached"))
+	d.mu.Lock()
+	d.reader = r
+	d.state = DatabaseStateReading
+	d.err = nil
+	d.mu.Unlock()
+	if err := r.Read(ctx, f); err != nil {
+		fail(err)
+		return
+	}
+	d.mu.Lock()
+	d.state = DatabaseStateFinished
+	d.mu.Unlock()
+}
+
+func (d *databaseReader) snapshot() *DatabaseStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	stats := &DatabaseStats{DatabaseID: d.target.databaseID, StreamID: d.target.streamID, State: d.state}
+	if d.err != nil {
+		stats.Error = d.err.Error()
+	}
+	if d.reader != nil {
+		stats.Stats = d.reader.Stats()
+	}
+	return stats
+}
+
+// Stats returns a snapshot of the statistics of the readers of the change streams in Stats.Databases. The partitions
+// are only in the statistics of each change stream. WatermarkLag, OldestPartitionAge and MaxPartitionDepth are
+// the maximum of the change streams, and FinishedPartitions, Rows, DataChangeRecords, Retries, StalledPartitions,
+// BufferedRecords, ModTypes, TruncatedValues, JSONParseFailures and CallbackDurations are the sum.
+func (m *MultiDatabaseReader) Stats() Stats {
+	m.mu.Lock()
+	databases := make([]*databaseReader, 0, len(m.databases))
+	for _, d := range m.databases {
+		databases = append(databases, d)
+	}
+	m.mu.Unlock()
+
+	var stats Stats
+	for _, d := range databases {
+		s := d.snapshot()
+		stats.Databases = append(stats.Databases, s)
+		stats.WatermarkLag = max(stats.WatermarkLag, s.Stats.WatermarkLag)
+		stats.OldestPartitionAge = max(stats.OldestPartitionAge, s.Stats.OldestPartitionAge)
+		stats.MaxPartitionDepth = max(stats.MaxPartitionDepth, s.Stats.MaxPartitionDepth)
+		stats.FinishedPartitions += s.Stats.FinishedPartitions
+		stats.Rows += s.Stats.Rows
+		stats.DataChangeRecords += s.Stats.DataChangeRecords
+		stats.Retries += s.Stats.Retries
+		stats.StalledPartitions += s.Stats.StalledPartitions
+		stats.BufferedRecords += s.Stats.BufferedRecords
+		stats.ModTypes.Inserts += s.Stats.ModTypes.Inserts
+		stats.ModTypes.Updates += s.Stats.ModTypes.Updates
+		stats.ModTypes.Deletes += s.Stats.ModTypes.Deletes
+		stats.JSONParseFailures += s.Stats.JSONParseFailures
+		stats.CallbackDurations.merge(s.Stats.CallbackDurations)
+		for table, columns := range s.Stats.TruncatedValues {
+			if stats.TruncatedValues == nil {
+				stats.TruncatedValues = make(map[string]map[string]int64)
+			}
+			if stats.TruncatedValues[table] == nil {
+				stats.TruncatedValues[table] = make(map[string]int64)
+			}
+			for column, n := range columns {
+				stats.TruncatedValues[table][column] += n
+			}
+		}
+	}
+	sort.Slice(stats.Databases, func(i, j int) bool {
+		if stats.Databases[i].DatabaseID != stats.Databases[j].DatabaseID {
+			return stats.Databases[i].DatabaseID < stats.Databases[j].DatabaseID
+		}
+		return stats.Databases[i].StreamID < stats.Databases[j].StreamID
+	})
+	return stats
+}
+
+// IncompleteTransactions returns the incomplete transactions of all the change streams being read.
+// See Reader.IncompleteTransactions.
+func (m *MultiDatabaseReader) IncompleteTransactions(minAge time.Duration) []*IncompleteTransaction {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var transactions []*IncompleteTransaction
+	for _, d := range m.databases {
+		d.mu.Lock()
+		r := d.reader
+		d.mu.Unlock()
+		if r != nil {
+			transactions = append(transactions, r.IncompleteTransactions(minAge)...)
+		}
+	}
+	return transactions
+}
+
+// likePattern converts the pattern of the LIKE operator to the regular expression that matches the whole string.
+func likePattern(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, c := range pattern {
+		switch c {
+		case '%':
+			b.WriteString(".*")
+		case '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
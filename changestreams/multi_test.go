@@ -0,0 +1,181 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMultiDatabaseReader(t *testing.T) {
+	newReader := func(t *testing.T, databaseIDs []string) (*MultiDatabaseReader, *fakeSpannerServer, func(ids []string)) {
+		server, opts := newFakeSpannerServer(t)
+		m, err := NewMultiDatabaseReader(context.Background(), "project", "instance", "stream", MultiDatabaseConfig{
+			DatabasePattern: "tenant_%",
+			RefreshInterval: 20 * time.Millisecond,
+			Config:          Config{SpannerClientOptions: opts},
+		})
+		if err != nil {
+			t.Fatalf("NewMultiDatabaseReader error: %v", err)
+		}
+		t.Cleanup(m.Close)
+
+		var mu sync.Mutex
+		m.listDatabases = func(ctx context.Context) ([]string, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			return databaseIDs, nil
+		}
+		return m, server, func(ids []string) {
+			mu.Lock()
+			defer mu.Unlock()
+			databaseIDs = ids
+		}
+	}
+	waitForStates := func(t *testing.T, m *MultiDatabaseReader, want map[string]string) []*DatabaseStats {
+		t.Helper()
+		var diff string
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			databases := m.Stats().Databases
+			states := make(map[string]string)
+			for _, d := range databases {
+				states[d.DatabaseID] = d.State
+			}
+			if diff = cmp.Diff(states, want); diff == "" {
+				return databases
+			}
+		}
+		t.Fatalf("database states diff = %v", diff)
+		return nil
+	}
+
+	t.Run("databases are attached and detached", func(t *testing.T) {
+		m, server, setDatabases := newReader(t, []string{"tenant_a", "tenant_broken", "tenant_nostream", "other"})
+		server.databaseErrors = map[string]error{"tenant_broken": status.Error(codes.PermissionDenied, "permission denied")}
+		server.databasesWithoutChangeStream = map[string]bool{"tenant_nostream": true}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		errs := make(chan error, 1)
+		go func() { errs <- m.Read(ctx, func(result *ReadResult) error { return nil }) }()
+
+		databases := waitForStates(t, m, map[string]string{"tenant_a": DatabaseStateFinished, "tenant_broken": DatabaseStateFailed})
+		if !strings.Contains(databases[1].Error, "permission denied") {
+			t.Errorf("error of the broken database = %q, want permission denied", databases[1].Error)
+		}
+
+		setDatabases([]string{"tenant_b"})
+		waitForStates(t, m, map[string]string{"tenant_b": DatabaseStateFinished})
+
+		cancel()
+		if err := <-errs; !errors.Is(err, context.Canceled) {
+			t.Errorf("Read error = %v, want %v", err, context.Canceled)
+		}
+	})
+
+	t.Run("error of the read function stops all the databases", func(t *testing.T) {
+		m, server, _ := newReader(t, []string{"tenant_a"})
+		server.childPartitions = map[string][]*ChildPartitionsRecord{
+			"": {{StartTimestamp: time.Now(), ChildPartitions: []*ChildPartition{{Token: "a"}}}},
+		}
+		errRead := errors.New("read error")
+		var sources []*Source
+		err := m.Read(context.Background(), func(result *ReadResult) error {
+			sources = append(sources, result.Source)
+			return errRead
+		})
+		if !errors.Is(err, errRead) {
+			t.Errorf("Read error = %v, want %v", err, errRead)
+		}
+		want := []*Source{{ProjectID: "project", InstanceID: "instance", DatabaseID: "tenant_a", StreamID: "stream"}}
+		if diff := cmp.Diff(sources, want); diff != "" {
+			t.Errorf("sources diff = %v", diff)
+		}
+	})
+}
+
+func TestMultiStreamReader(t *testing.T) {
+	server, opts := newFakeSpannerServer(t)
+	server.changeStreams = []string{"a", "b"}
+	m, err := NewMultiStreamReader(context.Background(), "project", "instance", "database", MultiStreamConfig{
+		RefreshInterval: 20 * time.Millisecond,
+		Config:          Config{SpannerClientOptions: opts},
+	})
+	if err != nil {
+		t.Fatalf("NewMultiStreamReader error: %v", err)
+	}
+	defer m.Close()
+
+	waitForStreams := func(want map[string]string) {
+		t.Helper()
+		var diff string
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			states := make(map[string]string)
+			for _, d := range m.Stats().Databases {
+				states[d.DatabaseID+"/"+d.StreamID] = d.State
+			}
+			if diff = cmp.Diff(states, want); diff == "" {
+				return
+			}
+		}
+		t.Fatalf("stream states diff = %v", diff)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errs := make(chan error, 1)
+	go func() { errs <- m.Read(ctx, func(result *ReadResult) error { return nil }) }()
+	waitForStreams(map[string]string{"database/a": DatabaseStateFinished, "database/b": DatabaseStateFinished})
+
+	// The stream created while reading is picked up, and the dropped one is detached.
+	server.mu.Lock()
+	server.changeStreams = []string{"b", "c"}
+	server.mu.Unlock()
+	waitForStreams(map[string]string{"database/b": DatabaseStateFinished, "database/c": DatabaseStateFinished})
+
+	cancel()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Errorf("Read error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestLikePattern(t *testing.T) {
+	for _, test := range []struct {
+		pattern string
+		id      string
+		want    bool
+	}{
+		{pattern: "tenant_%", id: "tenant_a", want: true},
+		{pattern: "tenant_%", id: "tenant", want: false},
+		{pattern: "tenant%", id: "tenant", want: true},
+		{pattern: "tenant%", id: "my-tenant", want: false},
+		{pattern: "db_1", id: "db-1", want: true},
+		{pattern: "db.1", id: "db-1", want: false},
+	} {
+		if got := likePattern(test.pattern).MatchString(test.id); got != test.want {
+			t.Errorf("likePattern(%q) matches %q = %v, want %v", test.pattern, test.id, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"fmt"
+
+	"cloud.google.com/go/spanner"
+)
+
+// NullPolicy decides how the NULL columns appear in Mod.NewValues and Mod.OldValues.
+type NullPolicy int
+
+const (
+	// NullPolicyKeep leaves the values as returned from Cloud Spanner.
+	NullPolicyKeep NullPolicy = iota
+	// NullPolicyOmit removes the NULL columns from the values.
+	NullPolicyOmit
+	// NullPolicyExplicit adds the non-key columns of ColumnTypes missing from the values as NULL to the values
+	// that have the whole row, i.e. the new values of the INSERT and UPDATE records with NEW_ROW and
+	// NEW_ROW_AND_OLD_VALUES value capture types. The other values only have the modified columns, so
+	// a missing column means that it wasn't modified rather than NULL.
+	NullPolicyExplicit
+	// NullPolicyZeroValue replaces the NULL columns with the zero value of their types: false for BOOL,
+	// "0" for INT64 and NUMERIC, 0 for FLOAT32 and FLOAT64, "" for STRING and BYTES, and an empty array
+	// for ARRAY. The columns of the other types, e.g. TIMESTAMP, DATE and JSON, whose zero value would
+	// be mistaken for a real value, stay NULL.
+	NullPolicyZeroValue
+)
+
+const (
+	valueCaptureTypeNewRow             = "NEW_ROW"
+	valueCaptureTypeNewRowAndOldValues = "NEW_ROW_AND_OLD_VALUES"
+)
+
+// normalizeNulls applies the policy to the values of the mods of the data change records.
+// NULL values, i.e. no values at all, become an empty object with any policy but NullPolicyKeep.
+func normalizeNulls(changeRecord *ChangeRecord, policy NullPolicy) error {
+	if policy == NullPolicyKeep {
+		return nil
+	}
+	for _, record := range changeRecord.DataChangeRecords {
+		types := make(map[string]*Type, len(record.ColumnTypes))
+		var nonKeyColumns []string
+		for _, c := range record.ColumnTypes {
+			if c.IsPrimaryKey {
+				continue
+			}
+			nonKeyColumns = append(nonKeyColumns, c.Name)
+			if policy == NullPolicyZeroValue {
+				t, err := c.DecodeType()
+				if err != nil {
+					return err
+				}
+				types[c.Name] = t
+			}
+		}
+		hasNewRow := record.ModType != modTypeDelete &&
+			(record.ValueCaptureType == valueCaptureTypeNewRow || record.ValueCaptureType == valueCaptureTypeNewRowAndOldValues)
+
+		for _, mod := range record.Mods {
+			newValues, err := valuesObject(&mod.NewValues)
+			if err != nil {
+				return err
+			}
+			oldValues, err := valuesObject(&mod.OldValues)
+			if err != nil {
+				return err
+			}
+			switch policy {
+			case NullPolicyOmit:
+				omitNulls(newValues)
+				omitNulls(oldValues)
+			case NullPolicyExplicit:
+				if hasNewRow {
+					for _, name := range nonKeyColumns {
+						if _, ok := newValues[name]; !ok {
+							newValues[name] = nil
+						}
+					}
+				}
+			case NullPolicyZeroValue:
+				zeroNulls(newValues, types)
+				zeroNulls(oldValues, types)
+			}
+		}
+	}
+	return nil
+}
+
+// valuesObject returns the object of the values, after replacing NULL values with an empty object.
+func valuesObject(values *spanner.NullJSON) (map[string]interface{}, error) {
+	if !values.Valid || values.Value == nil {
+		values.Value = map[string]interface{}{}
+		values.Valid = true
+	}
+	object, ok := values.Value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("values are not an object: %T", values.Value)
+	}
+	return object, nil
+}
+
+func omitNulls(values map[string]interface{}) {
+	for name, v := range values {
+		if v == nil {
+			delete(values, name)
+		}
+	}
+}
+
+func zeroNulls(values map[string]interface{}, types map[string]*Type) {
+	for name, v := range values {
+		if v != nil {
+			continue
+		}
+		if t, ok := types[name]; ok {
+			if zero, ok := zeroValue(t); ok {
+				values[name] = zero
+			}
+		}
+	}
+}
+
+// zeroValue returns the zero value of the type as it's encoded in the values of the mods.
+func zeroValue(t *Type) (interface{}, bool) {
+	switch t.Code {
+	case "BOOL":
+		return false, true
+	case "INT64":
+		return "0", true
+	case "NUMERIC":
+		// PostgreSQL numeric is encoded in the same way.
+		return "0", true
+	case "FLOAT32", "FLOAT64":
+		return float64(0), true
+	case "STRING", "BYTES":
+		return "", true
+	case "ARRAY":
+		return []interface{}{}, true
+	}
+	return nil, false
+}
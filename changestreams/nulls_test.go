@@ -0,0 +1,119 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"testing"
+
+	"cloud.google.com/go/spanner"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNormalizeNulls(t *testing.T) {
+	jsonValue := func(v interface{}) spanner.NullJSON {
+		return spanner.NullJSON{Value: v, Valid: true}
+	}
+	columnTypes := []*ColumnType{
+		{Name: "id", Type: jsonValue(map[string]interface{}{"code": "INT64"}), IsPrimaryKey: true},
+		{Name: "name", Type: jsonValue(map[string]interface{}{"code": "STRING"})},
+		{Name: "score", Type: jsonValue(map[string]interface{}{"code": "INT64"})},
+		{Name: "tags", Type: jsonValue(map[string]interface{}{"code": "ARRAY", "array_element_type": map[string]interface{}{"code": "STRING"}})},
+		{Name: "updated_at", Type: jsonValue(map[string]interface{}{"code": "TIMESTAMP"})},
+	}
+	newRecord := func(modType, valueCaptureType string) *DataChangeRecord {
+		return &DataChangeRecord{
+			ModType:          modType,
+			ValueCaptureType: valueCaptureType,
+			ColumnTypes:      columnTypes,
+			Mods: []*Mod{
+				{
+					Keys:      jsonValue(map[string]interface{}{"id": "1"}),
+					NewValues: jsonValue(map[string]interface{}{"name": nil, "score": nil, "tags": nil, "updated_at": nil}),
+					OldValues: spanner.NullJSON{},
+				},
+			},
+		}
+	}
+
+	for _, test := range []struct {
+		desc          string
+		policy        NullPolicy
+		record        *DataChangeRecord
+		wantNewValues map[string]interface{}
+		wantOldValues map[string]interface{}
+	}{
+		{
+			desc:          "omit",
+			policy:        NullPolicyOmit,
+			record:        newRecord("UPDATE", "OLD_AND_NEW_VALUES"),
+			wantNewValues: map[string]interface{}{},
+			wantOldValues: map[string]interface{}{},
+		},
+		{
+			desc:          "zero value",
+			policy:        NullPolicyZeroValue,
+			record:        newRecord("UPDATE", "OLD_AND_NEW_VALUES"),
+			wantNewValues: map[string]interface{}{"name": "", "score": "0", "tags": []interface{}{}, "updated_at": nil},
+			wantOldValues: map[string]interface{}{},
+		},
+		{
+			desc:   "explicit with new row",
+			policy: NullPolicyExplicit,
+			record: func() *DataChangeRecord {
+				r := newRecord("INSERT", "NEW_ROW")
+				r.Mods[0].NewValues = jsonValue(map[string]interface{}{"name": "a"})
+				return r
+			}(),
+			wantNewValues: map[string]interface{}{"name": "a", "score": nil, "tags": nil, "updated_at": nil},
+			wantOldValues: map[string]interface{}{},
+		},
+		{
+			desc:   "explicit with modified columns only",
+			policy: NullPolicyExplicit,
+			record: func() *DataChangeRecord {
+				r := newRecord("UPDATE", "NEW_VALUES")
+				r.Mods[0].NewValues = jsonValue(map[string]interface{}{"name": "a"})
+				return r
+			}(),
+			wantNewValues: map[string]interface{}{"name": "a"},
+			wantOldValues: map[string]interface{}{},
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			if err := normalizeNulls(&ChangeRecord{DataChangeRecords: []*DataChangeRecord{test.record}}, test.policy); err != nil {
+				t.Fatalf("normalizeNulls error: %v", err)
+			}
+			mod := test.record.Mods[0]
+			if diff := cmp.Diff(mod.NewValues.Value, interface{}(test.wantNewValues)); diff != "" {
+				t.Errorf("new values diff = %v", diff)
+			}
+			if diff := cmp.Diff(mod.OldValues, jsonValue(test.wantOldValues)); diff != "" {
+				t.Errorf("old values diff = %v", diff)
+			}
+		})
+	}
+
+	t.Run("keep", func(t *testing.T) {
+		record := newRecord("UPDATE", "OLD_AND_NEW_VALUES")
+		if err := normalizeNulls(&ChangeRecord{DataChangeRecords: []*DataChangeRecord{record}}, NullPolicyKeep); err != nil {
+			t.Fatalf("normalizeNulls error: %v", err)
+		}
+		if diff := cmp.Diff(record, newRecord("UPDATE", "OLD_AND_NEW_VALUES")); diff != "" {
+			t.Errorf("record diff = %v", diff)
+		}
+	})
+}
@@ -0,0 +1,127 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// NumericFormat decides how the NUMERIC columns appear in Mod.NewValues and Mod.OldValues.
+type NumericFormat int
+
+const (
+	// NumericFormatString leaves the values as returned from Cloud Spanner, i.e. decimal strings such as "123.45".
+	NumericFormatString NumericFormat = iota
+	// NumericFormatNumber replaces the values with JSON numbers such as 123.45. The digits are written as they are,
+	// but most JSON consumers parse the numbers as double, which loses the precision beyond 15 digits.
+	NumericFormatNumber
+	// NumericFormatObject replaces the values with objects of the unscaled value and the scale, such as
+	// {"value": "12345", "scale": 2}, which the consumers can decode without losing the precision.
+	NumericFormatObject
+)
+
+// ParseNumericFormat parses the name of the format, i.e. "string", "number" or "object".
+func ParseNumericFormat(name string) (NumericFormat, error) {
+	switch name {
+	case "string":
+		return NumericFormatString, nil
+	case "number":
+		return NumericFormatNumber, nil
+	case "object":
+		return NumericFormatObject, nil
+	default:
+		return 0, fmt.Errorf("invalid numeric format: %q", name)
+	}
+}
+
+// NonFiniteFloatPolicy decides how NaN, Infinity and -Infinity of the FLOAT32 and FLOAT64 columns appear in
+// Mod.NewValues and Mod.OldValues, since JSON has no number for them.
+type NonFiniteFloatPolicy int
+
+const (
+	// NonFiniteFloatString leaves the values as returned from Cloud Spanner, i.e. "NaN", "Infinity" and "-Infinity".
+	NonFiniteFloatString NonFiniteFloatPolicy = iota
+	// NonFiniteFloatNull replaces the values with NULL.
+	NonFiniteFloatNull
+	// NonFiniteFloatError fails the read of the partition with the value.
+	NonFiniteFloatError
+)
+
+// ParseNonFiniteFloatPolicy parses the name of the policy, i.e. "string", "null" or "error".
+func ParseNonFiniteFloatPolicy(name string) (NonFiniteFloatPolicy, error) {
+	switch name {
+	case "string":
+		return NonFiniteFloatString, nil
+	case "null":
+		return NonFiniteFloatNull, nil
+	case "error":
+		return NonFiniteFloatError, nil
+	default:
+		return 0, fmt.Errorf("invalid non-finite float policy: %q", name)
+	}
+}
+
+var decimalPattern = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+
+// formatNumericValue formats the NUMERIC value, which is a decimal string. The PostgreSQL numeric NaN is left
+// as a string with any format, since it's neither a number nor a decimal.
+func formatNumericValue(v interface{}, format NumericFormat) (interface{}, error) {
+	if format == NumericFormatString {
+		return v, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("unexpected value of NUMERIC: %T", v)
+	}
+	if s == "NaN" {
+		return s, nil
+	}
+	if !decimalPattern.MatchString(s) {
+		return nil, fmt.Errorf("invalid NUMERIC: %q", s)
+	}
+	if format == NumericFormatNumber {
+		return json.Number(s), nil
+	}
+
+	negative := strings.HasPrefix(s, "-")
+	integer, fraction, _ := strings.Cut(strings.TrimPrefix(s, "-"), ".")
+	unscaled := strings.TrimLeft(integer+fraction, "0")
+	if unscaled == "" {
+		unscaled = "0"
+	} else if negative {
+		unscaled = "-" + unscaled
+	}
+	return map[string]interface{}{"value": unscaled, "scale": len(fraction)}, nil
+}
+
+// formatFloatValue formats the FLOAT32 or FLOAT64 value, which is a number, or a string if it's not finite.
+func formatFloatValue(v interface{}, policy NonFiniteFloatPolicy) (interface{}, error) {
+	s, ok := v.(string)
+	if !ok {
+		return v, nil
+	}
+	switch policy {
+	case NonFiniteFloatNull:
+		return nil, nil
+	case NonFiniteFloatError:
+		return nil, fmt.Errorf("non-finite float: %s", s)
+	}
+	return v, nil
+}
@@ -0,0 +1,142 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"cloud.google.com/go/spanner"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFormatNumericValue(t *testing.T) {
+	for _, test := range []struct {
+		value      string
+		wantNumber json.Number
+		wantObject map[string]interface{}
+	}{
+		{
+			value:      "99999999999999999999999999999.999999999",
+			wantNumber: "99999999999999999999999999999.999999999",
+			wantObject: map[string]interface{}{"value": "99999999999999999999999999999999999999", "scale": 9},
+		},
+		{
+			value:      "-99999999999999999999999999999.999999999",
+			wantNumber: "-99999999999999999999999999999.999999999",
+			wantObject: map[string]interface{}{"value": "-99999999999999999999999999999999999999", "scale": 9},
+		},
+		{value: "0.000000001", wantNumber: "0.000000001", wantObject: map[string]interface{}{"value": "1", "scale": 9}},
+		{value: "-0.5", wantNumber: "-0.5", wantObject: map[string]interface{}{"value": "-5", "scale": 1}},
+		{value: "100", wantNumber: "100", wantObject: map[string]interface{}{"value": "100", "scale": 0}},
+		{value: "0", wantNumber: "0", wantObject: map[string]interface{}{"value": "0", "scale": 0}},
+		{value: "-0.0", wantNumber: "-0.0", wantObject: map[string]interface{}{"value": "0", "scale": 1}},
+	} {
+		t.Run(test.value, func(t *testing.T) {
+			if got, err := formatNumericValue(test.value, NumericFormatString); err != nil || got != test.value {
+				t.Errorf("string = %v, %v, want %v", got, err, test.value)
+			}
+			number, err := formatNumericValue(test.value, NumericFormatNumber)
+			if err != nil || number != test.wantNumber {
+				t.Errorf("number = %v, %v, want %v", number, err, test.wantNumber)
+			}
+			// The number is a valid JSON number with all the digits.
+			if b, err := json.Marshal(number); err != nil || string(b) != test.value {
+				t.Errorf("number in JSON = %s, %v, want %s", b, err, test.value)
+			}
+			object, err := formatNumericValue(test.value, NumericFormatObject)
+			if err != nil {
+				t.Fatalf("object error: %v", err)
+			}
+			if diff := cmp.Diff(object, test.wantObject); diff != "" {
+				t.Errorf("object diff = %v", diff)
+			}
+		})
+	}
+
+	// PostgreSQL numeric NaN stays a string.
+	for _, format := range []NumericFormat{NumericFormatNumber, NumericFormatObject} {
+		if got, err := formatNumericValue("NaN", format); err != nil || got != "NaN" {
+			t.Errorf("NaN in format %d = %v, %v, want NaN", format, got, err)
+		}
+	}
+	if _, err := formatNumericValue("1e10", NumericFormatNumber); err == nil {
+		t.Error("formatNumericValue must fail for an invalid NUMERIC")
+	}
+}
+
+func TestFormatFloatValue(t *testing.T) {
+	for _, v := range []interface{}{math.MaxFloat64, -math.MaxFloat64, math.SmallestNonzeroFloat64, 0.0} {
+		for _, policy := range []NonFiniteFloatPolicy{NonFiniteFloatString, NonFiniteFloatNull, NonFiniteFloatError} {
+			if got, err := formatFloatValue(v, policy); err != nil || got != v {
+				t.Errorf("formatFloatValue(%v, %d) = %v, %v, want %v", v, policy, got, err, v)
+			}
+		}
+	}
+	for _, v := range []string{"NaN", "Infinity", "-Infinity"} {
+		if got, err := formatFloatValue(v, NonFiniteFloatString); err != nil || got != v {
+			t.Errorf("string = %v, %v, want %v", got, err, v)
+		}
+		if got, err := formatFloatValue(v, NonFiniteFloatNull); err != nil || got != nil {
+			t.Errorf("null = %v, %v, want nil", got, err)
+		}
+		if _, err := formatFloatValue(v, NonFiniteFloatError); err == nil {
+			t.Errorf("formatFloatValue(%s) must fail with NonFiniteFloatError", v)
+		}
+	}
+}
+
+func TestFormatValues(t *testing.T) {
+	jsonValue := func(v interface{}) spanner.NullJSON {
+		return spanner.NullJSON{Value: v, Valid: true}
+	}
+	record := &DataChangeRecord{
+		TableName: "Measurements",
+		ModType:   "INSERT",
+		ColumnTypes: []*ColumnType{
+			{Name: "id", Type: jsonValue(map[string]interface{}{"code": "NUMERIC"}), IsPrimaryKey: true},
+			{Name: "amount", Type: jsonValue(map[string]interface{}{"code": "NUMERIC"})},
+			{Name: "amounts", Type: jsonValue(map[string]interface{}{"code": "ARRAY", "array_element_type": map[string]interface{}{"code": "NUMERIC"}})},
+			{Name: "ratio", Type: jsonValue(map[string]interface{}{"code": "FLOAT64"})},
+			{Name: "ratios", Type: jsonValue(map[string]interface{}{"code": "ARRAY", "array_element_type": map[string]interface{}{"code": "FLOAT32"}})},
+		},
+		Mods: []*Mod{{
+			Keys:      jsonValue(map[string]interface{}{"id": "1.5"}),
+			NewValues: jsonValue(map[string]interface{}{"id": "1.5", "amount": "12.34", "amounts": []interface{}{"1", nil}, "ratio": "NaN", "ratios": []interface{}{0.5, "-Infinity"}}),
+		}},
+	}
+	format := valueFormat{numeric: NumericFormatObject, nonFiniteFloats: NonFiniteFloatNull}
+	if err := formatValues(&ChangeRecord{DataChangeRecords: []*DataChangeRecord{record}}, format); err != nil {
+		t.Fatalf("formatValues error: %v", err)
+	}
+	want := map[string]interface{}{
+		"id":      "1.5",
+		"amount":  map[string]interface{}{"value": "1234", "scale": 2},
+		"amounts": []interface{}{map[string]interface{}{"value": "1", "scale": 0}, nil},
+		"ratio":   nil,
+		"ratios":  []interface{}{0.5, nil},
+	}
+	if diff := cmp.Diff(record.Mods[0].NewValues.Value, want); diff != "" {
+		t.Errorf("new values diff = %v", diff)
+	}
+
+	record.Mods[0].NewValues = jsonValue(map[string]interface{}{"ratio": "Infinity"})
+	if err := formatValues(&ChangeRecord{DataChangeRecords: []*DataChangeRecord{record}}, valueFormat{nonFiniteFloats: NonFiniteFloatError}); err == nil {
+		t.Error("formatValues must fail for Infinity with NonFiniteFloatError")
+	}
+}
@@ -0,0 +1,52 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicError is the error returned by Read when the read function, or the function of a subscription, panicked.
+// See Config.DisablePanicRecovery.
+type PanicError struct {
+	// Value is the value passed to panic.
+	Value any
+	// Stack is the stack trace of the goroutine that panicked.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("read function panicked: %v\n%s", e.Value, e.Stack)
+}
+
+// recoverPanics returns the function that calls f and converts a panic of f into *PanicError.
+func recoverPanics(f func(result *ReadResult) error) func(result *ReadResult) error {
+	return func(result *ReadResult) error {
+		return callRecoveringPanics(func() error { return f(result) })
+	}
+}
+
+// callRecoveringPanics calls f and converts a panic of f into *PanicError.
+func callRecoveringPanics(f func() error) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = &PanicError{Value: v, Stack: debug.Stack()}
+		}
+	}()
+	return f()
+}
@@ -0,0 +1,76 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPanicRecovery(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2023, 2, 24, 17, 17, 0, 0, time.UTC)
+	server, opts := newFakeSpannerServer(t)
+	server.childPartitions = map[string][]*ChildPartitionsRecord{
+		"": {{StartTimestamp: start, RecordSequence: "00000001", ChildPartitions: []*ChildPartition{{Token: "a"}}}},
+	}
+	server.dataChangeRecords = map[string][]*DataChangeRecord{
+		"a": {{CommitTimestamp: start.Add(time.Second), RecordSequence: "00000000", IsLastRecordInTransactionInPartition: true}},
+	}
+	store := &fakeCheckpointStore{}
+	r, err := NewReaderWithConfig(ctx, "project", "instance", "database", "stream", Config{
+		StartTimestamp:       start,
+		EndTimestamp:         start.Add(time.Minute),
+		SpannerClientOptions: opts,
+		CheckpointStore:      store,
+		CheckpointInterval:   time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("NewReaderWithConfig error: %v", err)
+	}
+	defer r.Close()
+
+	err = r.Read(ctx, func(result *ReadResult) error {
+		for range result.DataChangeRecords() {
+			panic("boom")
+		}
+		return nil
+	})
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) {
+		t.Fatalf("Read error = %v, want *PanicError", err)
+	}
+	if panicErr.Value != "boom" || !strings.Contains(string(panicErr.Stack), "TestPanicRecovery") {
+		t.Errorf("PanicError = %v, want the value and the stack of the panic", panicErr)
+	}
+
+	// The final checkpoint doesn't include the record that panicked.
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if len(store.checkpoints) == 0 {
+		t.Fatal("final checkpoint must be saved after the panic")
+	}
+	got := store.checkpoints[len(store.checkpoints)-1].PendingPartitions
+	if diff := cmp.Diff(got, []*PendingPartition{{Token: "a", StartTimestamp: start, ParentPartitionTokens: []string{}}}); diff != "" {
+		t.Errorf("pending partitions of the final checkpoint diff = %v", diff)
+	}
+}
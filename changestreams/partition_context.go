@@ -0,0 +1,62 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"log/slog"
+	"time"
+)
+
+// partitionContext is the identity of a partition being read. It's passed to startRead, and labels all the logs
+// and the stats of the partition, so that they can be told apart and joined.
+type partitionContext struct {
+	token string
+	// id is the short ID of the partition, e.g. P-0001, or "root" for the initial query.
+	id    string
+	depth int
+	// parentTokens and parentIDs are empty for the initial query and the root partitions.
+	parentTokens   []string
+	parentIDs      []string
+	startTimestamp time.Time
+}
+
+// assignIDs sets the IDs of the partition and the parents. The partitions and the parents resumed from
+// a checkpoint without the IDs are assigned the IDs here, the parents first as they were discovered first.
+func (p *partitionContext) assignIDs(ids *partitionIDs) {
+	p.parentIDs = make([]string, 0, len(p.parentTokens))
+	for _, parent := range p.parentTokens {
+		id, _ := ids.assign(parent)
+		p.parentIDs = append(p.parentIDs, id)
+	}
+	p.id, _ = ids.assign(p.token)
+}
+
+// logger returns the logger with the attributes of the partition. The parents are logged by the IDs, whose tokens
+// are in the logs of the parents.
+func (p *partitionContext) logger(logger *slog.Logger) *slog.Logger {
+	return logger.With(p.logAttrs()...)
+}
+
+func (p *partitionContext) logAttrs() []any {
+	return []any{
+		slog.String("partition", p.id),
+		slog.String("partition_token", p.token),
+		slog.Int("depth", p.depth),
+		slog.Any("parent_partitions", p.parentIDs),
+		slog.Time("start_timestamp", p.startTimestamp),
+	}
+}
@@ -0,0 +1,59 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPartitionContextLogger(t *testing.T) {
+	ids := newPartitionIDs(&Checkpoint{PartitionIDs: map[string]string{"parent1": "P-0001"}})
+	partition := &partitionContext{
+		token:          "child",
+		depth:          2,
+		parentTokens:   []string{"parent1", "parent2"},
+		startTimestamp: mustParseTime("2023-02-24T17:00:00Z"),
+	}
+	// The parent missing in the checkpoint is assigned an ID before the child.
+	partition.assignIDs(ids)
+
+	var buf bytes.Buffer
+	partition.logger(slog.New(slog.NewJSONHandler(&buf, nil))).Info("partition query stats", "event", "partition_query_stats")
+	var got map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON log: %v", err)
+	}
+	delete(got, "time")
+	want := map[string]interface{}{
+		"level":             "INFO",
+		"msg":               "partition query stats",
+		"event":             "partition_query_stats",
+		"partition":         "P-0003",
+		"partition_token":   "child",
+		"depth":             float64(2),
+		"parent_partitions": []interface{}{"P-0001", "P-0002"},
+		"start_timestamp":   "2023-02-24T17:00:00Z",
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("log diff = %v", diff)
+	}
+}
@@ -0,0 +1,49 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import "fmt"
+
+const defaultPartitionMaxAttempts = 3
+
+// PartitionErrorPolicy decides what happens when the read of a partition fails.
+type PartitionErrorPolicy int
+
+const (
+	// PartitionErrorAbortAll cancels the whole read, and Read returns the error.
+	PartitionErrorAbortAll PartitionErrorPolicy = iota
+	// PartitionErrorIsolateFailures retries the read of the failed partition from its watermark, and skips
+	// the partition after Config.PartitionMaxAttempts attempts while the other partitions keep being read.
+	// The data change records at the watermark that have already been delivered are skipped on a retry.
+	// Read returns the errors of all the skipped partitions, joined, as *PartitionError after the other
+	// partitions have finished. The child partitions of a skipped partition are never read.
+	PartitionErrorIsolateFailures
+)
+
+// PartitionError is the error of the read of a partition skipped by PartitionErrorIsolateFailures policy.
+type PartitionError struct {
+	PartitionToken string
+	Err            error
+}
+
+func (e *PartitionError) Error() string {
+	return fmt.Sprintf("failed to read partition %q: %v", e.PartitionToken, e.Err)
+}
+
+func (e *PartitionError) Unwrap() error {
+	return e.Err
+}
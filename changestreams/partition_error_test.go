@@ -0,0 +1,35 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPartitionError(t *testing.T) {
+	errQuery := errors.New("query error")
+	err := errors.Join(&PartitionError{PartitionToken: "a", Err: errQuery}, &PartitionError{PartitionToken: "b", Err: errQuery})
+
+	var partitionErr *PartitionError
+	if !errors.As(err, &partitionErr) || partitionErr.PartitionToken != "a" {
+		t.Errorf("errors.As = %v, want the error of partition a", partitionErr)
+	}
+	if !errors.Is(err, errQuery) {
+		t.Errorf("errors.Is(%v, %v) = false, want true", err, errQuery)
+	}
+}
@@ -0,0 +1,92 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	partitionIDPrefix = "P-"
+	// rootPartitionID is the ID of the initial query, whose token is empty.
+	rootPartitionID = "root"
+)
+
+// partitionIDs assigns the short IDs, e.g. P-0001, to the partition tokens in the order the partitions are
+// discovered, since the tokens are too long for the humans to read.
+type partitionIDs struct {
+	ids  map[string]string
+	next int
+	mu   sync.Mutex
+}
+
+// newPartitionIDs returns the IDs restored from the checkpoint, so that the partitions keep their IDs across
+// the resumes. The checkpoint may be nil.
+func newPartitionIDs(checkpoint *Checkpoint) *partitionIDs {
+	p := &partitionIDs{ids: make(map[string]string), next: 1}
+	if checkpoint == nil {
+		return p
+	}
+	for token, id := range checkpoint.PartitionIDs {
+		p.ids[token] = id
+		if n, err := strconv.Atoi(strings.TrimPrefix(id, partitionIDPrefix)); err == nil && n >= p.next {
+			p.next = n + 1
+		}
+	}
+	return p
+}
+
+// assign returns the ID of the partition, and true if the ID has been newly assigned.
+func (p *partitionIDs) assign(token string) (string, bool) {
+	if token == "" {
+		return rootPartitionID, false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if id, ok := p.ids[token]; ok {
+		return id, false
+	}
+	id := fmt.Sprintf("%s%04d", partitionIDPrefix, p.next)
+	p.next++
+	p.ids[token] = id
+	return id, true
+}
+
+// get returns the ID of the partition, or the empty string if it's not assigned.
+func (p *partitionIDs) get(token string) string {
+	if token == "" {
+		return rootPartitionID
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.ids[token]
+}
+
+func (p *partitionIDs) snapshot() map[string]string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ids := make(map[string]string, len(p.ids))
+	for token, id := range p.ids {
+		ids[token] = id
+	}
+	return ids
+}
@@ -0,0 +1,101 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPartitionIDs(t *testing.T) {
+	t.Run("discovery order", func(t *testing.T) {
+		p := newPartitionIDs(nil)
+		for _, test := range []struct {
+			token        string
+			wantID       string
+			wantAssigned bool
+		}{
+			{token: "", wantID: "root"},
+			{token: "a", wantID: "P-0001", wantAssigned: true},
+			{token: "b", wantID: "P-0002", wantAssigned: true},
+			{token: "a", wantID: "P-0001"},
+		} {
+			if id, assigned := p.assign(test.token); id != test.wantID || assigned != test.wantAssigned {
+				t.Errorf("assign(%q) = %q, %v, want %q, %v", test.token, id, assigned, test.wantID, test.wantAssigned)
+			}
+		}
+		if got := p.get("c"); got != "" {
+			t.Errorf("get of the unknown partition = %q, want empty", got)
+		}
+	})
+
+	t.Run("restored from checkpoint", func(t *testing.T) {
+		p := newPartitionIDs(&Checkpoint{PartitionIDs: map[string]string{"a": "P-0001", "b": "P-0007"}})
+		if id, _ := p.assign("b"); id != "P-0007" {
+			t.Errorf("restored ID = %q, want %q", id, "P-0007")
+		}
+		// The new IDs never collide with the restored ones.
+		if id, _ := p.assign("c"); id != "P-0008" {
+			t.Errorf("new ID = %q, want %q", id, "P-0008")
+		}
+	})
+}
+
+func TestReaderPartitionIDs(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2023, 2, 24, 17, 17, 0, 0, time.UTC)
+	split := start.Add(time.Minute)
+	server, opts := newFakeSpannerServer(t)
+	server.childPartitions = map[string][]*ChildPartitionsRecord{
+		"":  {{StartTimestamp: start, ChildPartitions: []*ChildPartition{{Token: "a"}}}},
+		"a": {{StartTimestamp: split, ChildPartitions: []*ChildPartition{{Token: "b", ParentPartitionTokens: []string{"a"}}}}},
+	}
+	server.dataChangeRecords = map[string][]*DataChangeRecord{
+		"b": {{CommitTimestamp: split.Add(time.Second), RecordSequence: "00000000", IsLastRecordInTransactionInPartition: true}},
+	}
+	r, err := NewReaderWithConfig(ctx, "project", "instance", "database", "stream", Config{
+		StartTimestamp:       start,
+		EndTimestamp:         split.Add(time.Minute),
+		SpannerClientOptions: opts,
+	})
+	if err != nil {
+		t.Fatalf("NewReaderWithConfig error: %v", err)
+	}
+	defer r.Close()
+	// The finished partitions are dropped from the stats, so the IDs are taken while each partition is read.
+	ids := make(map[string]string)
+	if err := r.Read(ctx, func(result *ReadResult) error {
+		for _, p := range r.Stats().Partitions {
+			if p.PartitionToken == result.PartitionToken {
+				ids[p.PartitionToken] = p.PartitionID
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+
+	if diff := cmp.Diff(r.PartitionIDs(), map[string]string{"a": "P-0001", "b": "P-0002"}); diff != "" {
+		t.Errorf("partition IDs diff = %v", diff)
+	}
+	if diff := cmp.Diff(ids, map[string]string{"": "root", "a": "P-0001", "b": "P-0002"}); diff != "" {
+		t.Errorf("partition IDs of the stats diff = %v", diff)
+	}
+}
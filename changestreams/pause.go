@@ -0,0 +1,130 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+// PartitionPause is a partition paused by Config.PauseOnError.
+type PartitionPause struct {
+	PartitionToken string
+	// PartitionID is the short ID of the partition in the logs and the stats.
+	PartitionID string
+	// Err is the error returned by the read function.
+	Err error
+	// Result is the result for which the read function returned Err. It must not be modified.
+	Result   *ReadResult
+	PausedAt time.Time
+}
+
+type partitionPause struct {
+	pause *PartitionPause
+	// skip receives the decision of ResumePartition.
+	skip chan bool
+}
+
+// pausePartition pauses the partition until ResumePartition is called for it, and returns whether the result
+// should be skipped. It returns the error of ctx if ctx is done first.
+func (r *Reader) pausePartition(ctx context.Context, logger *slog.Logger, pause *PartitionPause) (bool, error) {
+	p := &partitionPause{pause: pause, skip: make(chan bool, 1)}
+	r.mu.Lock()
+	if r.pauses == nil {
+		r.pauses = make(map[string]*partitionPause)
+	}
+	r.pauses[pause.PartitionToken] = p
+	r.mu.Unlock()
+
+	r.stats.queryPaused(pause.PartitionToken, pause.Err)
+	defer r.stats.queryUnpaused(pause.PartitionToken)
+	logger.Error("read function failed, partition paused until it's resumed", "event", "partition_paused", "error", pause.Err)
+	if r.onPartitionPaused != nil {
+		r.onPartitionPaused(pause)
+	}
+
+	select {
+	case <-ctx.Done():
+		r.mu.Lock()
+		delete(r.pauses, pause.PartitionToken)
+		r.mu.Unlock()
+		return false, ctx.Err()
+	case skip := <-p.skip:
+		logger.Info("partition resumed", "event", "partition_resumed", "skip", skip)
+		return skip, nil
+	}
+}
+
+// ResumePartition resumes the partition paused by Config.PauseOnError. If skip is false, the same result
+// is delivered to the read function again, and the partition is paused again if it fails again. If skip is
+// true, the result is skipped and never delivered, and the partition continues with the next result.
+// It returns an error if the partition is not paused.
+func (r *Reader) ResumePartition(partitionToken string, skip bool) error {
+	r.mu.Lock()
+	p, ok := r.pauses[partitionToken]
+	delete(r.pauses, partitionToken)
+	r.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("partition %q is not paused", partitionToken)
+	}
+	p.skip <- skip
+	return nil
+}
+
+// PausedPartitions returns the partitions paused by Config.PauseOnError, ordered by the tokens.
+func (r *Reader) PausedPartitions() []*PartitionPause {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	pauses := make([]*PartitionPause, 0, len(r.pauses))
+	for _, p := range r.pauses {
+		pauses = append(pauses, p.pause)
+	}
+	sort.Slice(pauses, func(i, j int) bool {
+		return pauses[i].PartitionToken < pauses[j].PartitionToken
+	})
+	return pauses
+}
+
+// retryPaused pauses the partition while the read function fails for the result with Config.PauseOnError, and
+// calls it again each time the partition is resumed. err is the error of the first call, and records is the number
+// of the data change records of the result before the first call, which may have modified the result. It returns
+// nil if the result is skipped by ResumePartition.
+func (r *Reader) retryPaused(ctx context.Context, logger *slog.Logger, partitionToken, partitionID string, err error, records int,
+	f func(ctx context.Context, result *ReadResult) error, result *ReadResult) error {
+	for err != nil && r.pauseOnError && ctx.Err() == nil {
+		skip, pauseErr := r.pausePartition(ctx, logger, &PartitionPause{
+			PartitionToken: partitionToken,
+			PartitionID:    partitionID,
+			Err:            err,
+			Result:         result,
+			PausedAt:       time.Now(),
+		})
+		if pauseErr != nil {
+			return pauseErr
+		}
+		if skip {
+			logger.Warn("result skipped after the pause", "event", "result_skipped", "data_change_records", records)
+			return nil
+		}
+		err = r.callWithTimeout(ctx, logger, f, result)
+	}
+	return err
+}
@@ -0,0 +1,99 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPauseOnError(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2023, 2, 24, 17, 17, 0, 0, time.UTC)
+	server, opts := newFakeSpannerServer(t)
+	server.childPartitions = map[string][]*ChildPartitionsRecord{
+		"": {{StartTimestamp: start, RecordSequence: "00000001", ChildPartitions: []*ChildPartition{{Token: "a"}}}},
+	}
+	server.dataChangeRecords = map[string][]*DataChangeRecord{
+		"a": {
+			{CommitTimestamp: start.Add(time.Second), RecordSequence: "00000000", IsLastRecordInTransactionInPartition: true},
+			{CommitTimestamp: start.Add(2 * time.Second), RecordSequence: "00000001", IsLastRecordInTransactionInPartition: true},
+		},
+	}
+	errPoison := errors.New("poison record")
+
+	var r *Reader
+	pauses := make(chan *PartitionPause, 1)
+	r, err := NewReaderWithConfig(ctx, "project", "instance", "database", "stream", Config{
+		StartTimestamp:       start,
+		EndTimestamp:         start.Add(time.Minute),
+		SpannerClientOptions: opts,
+		PauseOnError:         true,
+		OnPartitionPaused:    func(pause *PartitionPause) { pauses <- pause },
+	})
+	if err != nil {
+		t.Fatalf("NewReaderWithConfig error: %v", err)
+	}
+	defer r.Close()
+	if err := r.ResumePartition("a", false); err == nil {
+		t.Error("ResumePartition of the partition not paused must fail")
+	}
+
+	// The operator retries the poison record once, and then skips it.
+	operator := make(chan error, 1)
+	go func() {
+		operator <- func() error {
+			for _, skip := range []bool{false, true} {
+				pause := <-pauses
+				if pause.PartitionToken != "a" || pause.Err != errPoison {
+					return errors.New("unexpected pause")
+				}
+				if stats := r.Stats(); stats.PausedPartitions != 1 || len(r.PausedPartitions()) != 1 {
+					return errors.New("paused partition is not in the stats")
+				}
+				if err := r.ResumePartition("a", skip); err != nil {
+					return err
+				}
+			}
+			return nil
+		}()
+	}()
+
+	calls := make(map[string]int)
+	if err := r.Read(ctx, func(result *ReadResult) error {
+		for record := range result.DataChangeRecords() {
+			calls[record.RecordSequence]++
+			if record.RecordSequence == "00000000" {
+				return errPoison
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	if err := <-operator; err != nil {
+		t.Fatalf("operator error: %v", err)
+	}
+	if calls["00000000"] != 2 || calls["00000001"] != 1 {
+		t.Errorf("calls = %v, want the poison record twice and the next record once", calls)
+	}
+	if stats := r.Stats(); stats.PausedPartitions != 0 {
+		t.Errorf("PausedPartitions = %d, want 0", stats.PausedPartitions)
+	}
+}
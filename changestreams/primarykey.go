@@ -0,0 +1,43 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// PrimaryKeyString returns the canonical string of the primary key of the mod, e.g. for logging, sharding and
+// deduplication. It's the JSON of Keys with the columns sorted by name, so the same key always has the same string
+// whatever the order of the columns is, and the columns of a composite key are never confused with each other.
+// The values are encoded as in Keys, e.g. INT64 as a string.
+func (m *Mod) PrimaryKeyString() (string, error) {
+	if !m.Keys.Valid {
+		return "", errors.New("mod has no keys")
+	}
+	keys, ok := m.Keys.Value.(map[string]interface{})
+	if !ok {
+		return "", fmt.Errorf("keys are not an object: %T", m.Keys.Value)
+	}
+	// encoding/json sorts the object keys, so the string is canonical.
+	b, err := json.Marshal(keys)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,69 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"testing"
+
+	"cloud.google.com/go/spanner"
+)
+
+func TestPrimaryKeyString(t *testing.T) {
+	jsonValue := func(v interface{}) spanner.NullJSON {
+		return spanner.NullJSON{Value: v, Valid: true}
+	}
+	for _, test := range []struct {
+		desc    string
+		keys    spanner.NullJSON
+		want    string
+		wantErr bool
+	}{
+		{desc: "single key", keys: jsonValue(map[string]interface{}{"id": "1"}), want: `{"id":"1"}`},
+		{
+			desc: "composite key",
+			keys: jsonValue(map[string]interface{}{"SingerId": "1", "AlbumId": "2", "Title": "a\"b"}),
+			want: `{"AlbumId":"2","SingerId":"1","Title":"a\"b"}`,
+		},
+		{
+			desc: "different value types",
+			keys: jsonValue(map[string]interface{}{"b": true, "f": 1.5, "n": nil, "s": "x", "t": "2023-02-24T17:00:00Z"}),
+			want: `{"b":true,"f":1.5,"n":null,"s":"x","t":"2023-02-24T17:00:00Z"}`,
+		},
+		{desc: "no keys", keys: spanner.NullJSON{}, wantErr: true},
+		{desc: "not an object", keys: jsonValue([]interface{}{"1"}), wantErr: true},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			mod := &Mod{Keys: test.keys}
+			got, err := mod.PrimaryKeyString()
+			if (err != nil) != test.wantErr {
+				t.Fatalf("PrimaryKeyString error = %v, wantErr %v", err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("PrimaryKeyString = %s, want %s", got, test.want)
+			}
+		})
+	}
+
+	// The order of the columns doesn't matter.
+	a := &Mod{Keys: jsonValue(map[string]interface{}{"x": "1", "y": "2"})}
+	b := &Mod{Keys: jsonValue(map[string]interface{}{"y": "2", "x": "1"})}
+	ka, _ := a.PrimaryKeyString()
+	kb, _ := b.PrimaryKeyString()
+	if ka != kb {
+		t.Errorf("PrimaryKeyString = %s and %s, want the same", ka, kb)
+	}
+}
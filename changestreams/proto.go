@@ -0,0 +1,181 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// ReadProtoStream reads the change stream like Read, and writes each change record to w as a length-delimited
+// frame: the length of the message in bytes as a varint, followed by the ChangeRecord message defined in
+// changestreams.proto. It's the same framing as writeDelimitedTo of the Java protobuf library.
+//
+// The results of the partitions are written one at a time, and w is flushed after each result, including
+// the Flush method of w if it has one.
+func (r *Reader) ReadProtoStream(ctx context.Context, w io.Writer) error {
+	var mu sync.Mutex
+	bw := bufio.NewWriter(w)
+	flusher, _ := w.(interface{ Flush() error })
+	var frame []byte
+	return r.Read(ctx, func(result *ReadResult) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		for _, changeRecord := range result.ChangeRecords {
+			frame = appendProtoFrame(frame[:0], result.PartitionToken, changeRecord)
+			if _, err := bw.Write(frame); err != nil {
+				return err
+			}
+		}
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+		if flusher != nil {
+			return flusher.Flush()
+		}
+		return nil
+	})
+}
+
+// appendProtoFrame appends the length-delimited ChangeRecord message of the change record.
+func appendProtoFrame(b []byte, partitionToken string, changeRecord *ChangeRecord) []byte {
+	return protowire.AppendBytes(b, appendChangeRecordProto(nil, partitionToken, changeRecord))
+}
+
+func appendChangeRecordProto(b []byte, partitionToken string, changeRecord *ChangeRecord) []byte {
+	b = appendProtoString(b, 1, partitionToken)
+	for _, record := range changeRecord.DataChangeRecords {
+		b = appendProtoMessage(b, 2, appendDataChangeRecordProto(nil, record))
+	}
+	for _, record := range changeRecord.HeartbeatRecords {
+		b = appendProtoMessage(b, 3, appendProtoTimestamp(nil, 1, record.Timestamp))
+	}
+	for _, record := range changeRecord.ChildPartitionsRecords {
+		b = appendProtoMessage(b, 4, appendChildPartitionsRecordProto(nil, record))
+	}
+	return b
+}
+
+func appendDataChangeRecordProto(b []byte, record *DataChangeRecord) []byte {
+	b = appendProtoTimestamp(b, 1, record.CommitTimestamp)
+	b = appendProtoString(b, 2, record.RecordSequence)
+	b = appendProtoString(b, 3, record.ServerTransactionID)
+	b = appendProtoBool(b, 4, record.IsLastRecordInTransactionInPartition)
+	b = appendProtoString(b, 5, record.TableName)
+	for _, columnType := range record.ColumnTypes {
+		var m []byte
+		m = appendProtoString(m, 1, columnType.Name)
+		if columnType.Type.Valid {
+			m = appendProtoString(m, 2, protoJSON(columnType.Type))
+		}
+		m = appendProtoBool(m, 3, columnType.IsPrimaryKey)
+		m = appendProtoInt64(m, 4, columnType.OrdinalPosition)
+		b = appendProtoMessage(b, 6, m)
+	}
+	for _, mod := range record.Mods {
+		var m []byte
+		// The values are optional fields, which are written even if empty, unless NULL.
+		for i, value := range []spanner.NullJSON{mod.Keys, mod.NewValues, mod.OldValues} {
+			if value.Valid {
+				m = protowire.AppendTag(m, protowire.Number(i+1), protowire.BytesType)
+				m = protowire.AppendString(m, protoJSON(value))
+			}
+		}
+		b = appendProtoMessage(b, 7, m)
+	}
+	b = appendProtoString(b, 8, record.ModType)
+	b = appendProtoString(b, 9, record.ValueCaptureType)
+	b = appendProtoInt64(b, 10, record.NumberOfRecordsInTransaction)
+	b = appendProtoInt64(b, 11, record.NumberOfPartitionsInTransaction)
+	b = appendProtoString(b, 12, record.TransactionTag)
+	b = appendProtoBool(b, 13, record.IsSystemTransaction)
+	return b
+}
+
+func appendChildPartitionsRecordProto(b []byte, record *ChildPartitionsRecord) []byte {
+	b = appendProtoTimestamp(b, 1, record.StartTimestamp)
+	b = appendProtoString(b, 2, record.RecordSequence)
+	for _, child := range record.ChildPartitions {
+		var m []byte
+		m = appendProtoString(m, 1, child.Token)
+		for _, parent := range child.ParentPartitionTokens {
+			m = protowire.AppendTag(m, 2, protowire.BytesType)
+			m = protowire.AppendString(m, parent)
+		}
+		b = appendProtoMessage(b, 3, m)
+	}
+	return b
+}
+
+// protoJSON returns the JSON text of the value, as the JSON of the record does.
+func protoJSON(value spanner.NullJSON) string {
+	b, err := json.Marshal(value.Value)
+	if err != nil {
+		return value.String()
+	}
+	return string(b)
+}
+
+// The append functions below omit the zero values, as proto3 does for the fields without presence.
+
+func appendProtoMessage(b []byte, num protowire.Number, m []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, m)
+}
+
+func appendProtoString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendProtoBool(b []byte, num protowire.Number, v bool) []byte {
+	if !v {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, protowire.EncodeBool(v))
+}
+
+func appendProtoInt64(b []byte, num protowire.Number, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(v))
+}
+
+// appendProtoTimestamp appends the google.protobuf.Timestamp message of t.
+func appendProtoTimestamp(b []byte, num protowire.Number, t time.Time) []byte {
+	if t.IsZero() {
+		return b
+	}
+	var m []byte
+	m = appendProtoInt64(m, 1, t.Unix())
+	m = appendProtoInt64(m, 2, int64(t.Nanosecond()))
+	return appendProtoMessage(b, num, m)
+}
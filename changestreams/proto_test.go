@@ -0,0 +1,182 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// protoFields parses the message into the values of the fields: []byte for the length-delimited fields,
+// and uint64 for the varints.
+func protoFields(t *testing.T, m []byte) map[protowire.Number][]interface{} {
+	t.Helper()
+	fields := make(map[protowire.Number][]interface{})
+	for len(m) > 0 {
+		num, typ, n := protowire.ConsumeTag(m)
+		if n < 0 {
+			t.Fatalf("invalid tag: %v", protowire.ParseError(n))
+		}
+		m = m[n:]
+		switch typ {
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(m)
+			if n < 0 {
+				t.Fatalf("invalid field %d: %v", num, protowire.ParseError(n))
+			}
+			fields[num] = append(fields[num], v)
+			m = m[n:]
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(m)
+			if n < 0 {
+				t.Fatalf("invalid field %d: %v", num, protowire.ParseError(n))
+			}
+			fields[num] = append(fields[num], v)
+			m = m[n:]
+		default:
+			t.Fatalf("unexpected wire type %d of field %d", typ, num)
+		}
+	}
+	return fields
+}
+
+// protoFrames splits the length-delimited frames.
+func protoFrames(t *testing.T, b []byte) [][]byte {
+	t.Helper()
+	var frames [][]byte
+	for len(b) > 0 {
+		frame, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			t.Fatalf("invalid frame: %v", protowire.ParseError(n))
+		}
+		frames = append(frames, frame)
+		b = b[n:]
+	}
+	return frames
+}
+
+func TestAppendProtoFrame(t *testing.T) {
+	commitTimestamp := time.Date(2023, 2, 24, 17, 0, 0, 123, time.UTC)
+	changeRecord := &ChangeRecord{
+		DataChangeRecords: []*DataChangeRecord{{
+			CommitTimestamp:              commitTimestamp,
+			RecordSequence:               "00000001",
+			TableName:                    "Singers",
+			ColumnTypes:                  []*ColumnType{{Name: "id", Type: spanner.NullJSON{Value: map[string]interface{}{"code": "STRING"}, Valid: true}, IsPrimaryKey: true, OrdinalPosition: 1}},
+			Mods:                         []*Mod{{Keys: spanner.NullJSON{Value: map[string]interface{}{"id": "1"}, Valid: true}, NewValues: spanner.NullJSON{Value: map[string]interface{}{}, Valid: true}}},
+			ModType:                      "DELETE",
+			NumberOfRecordsInTransaction: 2,
+		}},
+		HeartbeatRecords: []*HeartbeatRecord{{Timestamp: commitTimestamp}},
+	}
+
+	frames := protoFrames(t, appendProtoFrame(nil, "token", changeRecord))
+	if len(frames) != 1 {
+		t.Fatalf("frames = %d, want 1", len(frames))
+	}
+	fields := protoFields(t, frames[0])
+	if got := string(fields[1][0].([]byte)); got != "token" {
+		t.Errorf("partition_token = %q, want token", got)
+	}
+	if len(fields[2]) != 1 || len(fields[3]) != 1 || len(fields[4]) != 0 {
+		t.Fatalf("records = %d, %d, %d, want 1, 1, 0", len(fields[2]), len(fields[3]), len(fields[4]))
+	}
+
+	record := protoFields(t, fields[2][0].([]byte))
+	timestamp := protoFields(t, record[1][0].([]byte))
+	if diff := cmp.Diff(timestamp, map[protowire.Number][]interface{}{1: {uint64(commitTimestamp.Unix())}, 2: {uint64(123)}}); diff != "" {
+		t.Errorf("commit_timestamp diff = %v", diff)
+	}
+	if got := string(record[5][0].([]byte)); got != "Singers" {
+		t.Errorf("table_name = %q, want Singers", got)
+	}
+	if got := record[10][0].(uint64); got != 2 {
+		t.Errorf("number_of_records_in_transaction = %d, want 2", got)
+	}
+	// The zero values are omitted.
+	if _, ok := record[3]; ok {
+		t.Error("server_transaction_id is set, want omitted")
+	}
+
+	columnType := protoFields(t, record[6][0].([]byte))
+	if got := string(columnType[2][0].([]byte)); got != `{"code":"STRING"}` {
+		t.Errorf("column type = %q, want the JSON", got)
+	}
+	mod := protoFields(t, record[7][0].([]byte))
+	if got := string(mod[1][0].([]byte)); got != `{"id":"1"}` {
+		t.Errorf("keys = %q, want the JSON", got)
+	}
+	// The empty object is set, but NULL is not.
+	if got := string(mod[2][0].([]byte)); got != "{}" {
+		t.Errorf("new_values = %q, want {}", got)
+	}
+	if _, ok := mod[3]; ok {
+		t.Error("old_values is set, want unset for NULL")
+	}
+}
+
+// flushRecorder records the calls of Flush.
+type flushRecorder struct {
+	bytes.Buffer
+	flushes int
+}
+
+func (w *flushRecorder) Flush() error {
+	w.flushes++
+	return nil
+}
+
+func TestReadProtoStream(t *testing.T) {
+	ctx := context.Background()
+	server, opts := newFakeSpannerServer(t)
+	start := time.Now().UTC().Truncate(time.Microsecond)
+	server.childPartitions = map[string][]*ChildPartitionsRecord{
+		"": {{StartTimestamp: start, RecordSequence: "1", ChildPartitions: []*ChildPartition{{Token: "a", ParentPartitionTokens: []string{}}}}},
+	}
+	r, err := NewReaderWithConfig(ctx, "project", "instance", "database", "stream", Config{
+		StartTimestamp:       start,
+		EndTimestamp:         start.Add(time.Second),
+		SpannerClientOptions: opts,
+	})
+	if err != nil {
+		t.Fatalf("NewReaderWithConfig error: %v", err)
+	}
+	defer r.Close()
+
+	var w flushRecorder
+	if err := r.ReadProtoStream(ctx, &w); err != nil {
+		t.Fatalf("ReadProtoStream error: %v", err)
+	}
+	frames := protoFrames(t, w.Bytes())
+	if len(frames) != 1 {
+		t.Fatalf("frames = %d, want 1", len(frames))
+	}
+	if w.flushes != 1 {
+		t.Errorf("flushes = %d, want 1", w.flushes)
+	}
+	record := protoFields(t, protoFields(t, frames[0])[4][0].([]byte))
+	child := protoFields(t, record[3][0].([]byte))
+	if got := string(child[1][0].([]byte)); got != "a" {
+		t.Errorf("child partition token = %q, want a", got)
+	}
+}
@@ -21,18 +21,57 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
 
 	"cloud.google.com/go/spanner"
 	"golang.org/x/sync/errgroup"
+	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 )
 
 // ReadResult is the result of the read change records from the partition.
 type ReadResult struct {
 	PartitionToken string          `json:"partition_token"`
 	ChangeRecords  []*ChangeRecord `spanner:"ChangeRecord" json:"change_record"`
+	// Metadata is only set if Config.IncludeReadMetadata is true.
+	Metadata *ReadMetadata `spanner:"-" json:"metadata,omitempty"`
+	// SequenceNumber is only set if Config.AssignSequenceNumbers is true.
+	SequenceNumber uint64 `spanner:"-" json:"sequence_number,omitempty"`
+	// Source is only set if Config.IncludeSource is true. It's shared by all the results of the reader.
+	Source *Source `spanner:"-" json:"source,omitempty"`
+}
+
+// Source identifies the change stream that the result was read from, e.g. to aggregate the results of
+// multiple readers.
+type Source struct {
+	ProjectID  string `json:"project_id"`
+	InstanceID string `json:"instance_id"`
+	DatabaseID string `json:"database_id"`
+	StreamID   string `json:"stream_id"`
+	// Label is Config.SourceLabel.
+	Label string `json:"label,omitempty"`
+}
+
+// ReadMetadata is the metadata of the query of the partition at the time the result arrived.
+type ReadMetadata struct {
+	// QueryElapsed is the time since the query of the partition started, including the retries.
+	QueryElapsed time.Duration `json:"query_elapsed"`
+	// Rows is the number of the rows decoded so far from the partition, including this result.
+	Rows        int64     `json:"rows"`
+	ArrivalTime time.Time `json:"arrival_time"`
 }
 
 // ChangeRecord is the single unit of the records from the change stream.
@@ -43,6 +82,7 @@ type ChangeRecord struct {
 }
 
 // DataChangeRecord contains a set of changes to the table.
+// CommitTimestamp keeps the nanosecond precision of Cloud Spanner in both dialects, and so does its JSON encoding.
 type DataChangeRecord struct {
 	CommitTimestamp                      time.Time     `spanner:"commit_timestamp" json:"commit_timestamp"`
 	RecordSequence                       string        `spanner:"record_sequence" json:"record_sequence"`
@@ -72,6 +112,15 @@ type Mod struct {
 	Keys      spanner.NullJSON `spanner:"keys" json:"keys"`
 	NewValues spanner.NullJSON `spanner:"new_values" json:"new_values"`
 	OldValues spanner.NullJSON `spanner:"old_values" json:"old_values"`
+	// Row is the image of the whole row in JSON, encoded like NewValues, attached by the enrich package.
+	// It's nil unless the mod has been enriched.
+	Row json.RawMessage `spanner:"-" json:"row,omitempty"`
+	// RowStale is true if Row is the current row instead of the row at the commit timestamp, because the commit
+	// timestamp was beyond the version retention period when the mod was enriched at the commit timestamp.
+	RowStale bool `spanner:"-" json:"row_stale,omitempty"`
+	// Index is the position of the mod in the data change record returned by Cloud Spanner. It's kept when the mods
+	// before it are removed by Config.SkipNoOpUpdates, so that IdempotencyKey of the mod never changes.
+	Index int `spanner:"-" json:"-"`
 }
 
 // HeartbeatRecord is the heartbeat record returned from Cloud Spanner.
@@ -107,28 +156,384 @@ const (
 	partitionStateFinished
 )
 
+const defaultPostgresFunctionSchema = "spanner"
+
+// readOnceWindow is the time ReadOnce waits for the first row.
+const readOnceWindow = 5 * time.Second
+
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// metadataKeyPattern is the pattern of the gRPC metadata keys. The keys are case-insensitive.
+var metadataKeyPattern = regexp.MustCompile(`^[0-9A-Za-z_.-]+$`)
+
 // Reader is the change stream reader.
 type Reader struct {
-	client            *spanner.Client
-	streamID          string
-	startTimestamp    time.Time
-	endTimestamp      time.Time
-	heartbeatInterval time.Duration
-	dialect           dialect
-	states            map[string]partitionState
-	group             *errgroup.Group
-	mu                sync.Mutex
+	dbPath                 string
+	clientConfig           spanner.ClientConfig
+	clientOptions          []option.ClientOption
+	initMaxAttempts        int
+	initAttemptTimeout     time.Duration
+	connectTimeout         time.Duration
+	client                 *spanner.Client
+	streamID               string
+	startTimestamp         time.Time
+	startOffset            time.Duration
+	startFromEarliest      bool
+	endTimestamp           time.Time
+	clampEndToNow          bool
+	heartbeatInterval      time.Duration
+	dialect                dialect
+	postgresFunctionSchema string
+	customFunctionSchema   bool
+	postgresReadOptions    []string
+	transactions           *transactionTracker
+	skipNoOpUpdates        bool
+	nullPolicy             NullPolicy
+	valueFormat            valueFormat
+	truncator              *valueTruncator
+	partitionIDs           *partitionIDs
+	sampler                *recordSampler
+	subscriptions          []*Subscription
+	stats                  *statsRecorder
+	modTypes               *modTypeCounter
+	coalesceWindow         time.Duration
+	coalesceKey            func(record *DataChangeRecord) string
+	coalescer              *coalescer
+	batcher                *batcher
+	stallTimeout           time.Duration
+	clockJumps             *clockJumpDetector
+	includeReadMetadata    bool
+	shouldReadChild        func(partition *ChildPartition) bool
+	partitionAllowlist     map[string]bool
+	partitionDenylist      map[string]bool
+	collectQueryStats      bool
+	logger                 *slog.Logger
+	maxPartitionDepth      int
+	assignSequenceNumbers  bool
+	source                 *Source
+	traceRecordValues      bool
+	partitionErrorPolicy   PartitionErrorPolicy
+	partitionMaxAttempts   int
+	partitionErrors        []error
+	grpcMetadata           []string
+	beforeChildPartitions  func(ctx context.Context, finish *PartitionFinish) error
+	resumeFrom             *Checkpoint
+	staleResumePolicy      StaleResumePolicy
+	maxResumeAge           time.Duration
+	onResumeGap            func(from, to time.Time)
+	bestEffortDecoding     bool
+	checkpoints            *checkpointer
+	deliveryMode           DeliveryMode
+	disablePanicRecovery   bool
+	trackOnly              bool
+	pauseOnError           bool
+	onPartitionPaused      func(pause *PartitionPause)
+	pauses                 map[string]*partitionPause
+	slowCallbackThreshold  time.Duration
+	callbackTimeout        time.Duration
+	callbackMaxAttempts    int
+	callbackDurations      *durationHistogram
+	onDecodeError          func(err *DecodeError)
+	sequenceNumber         atomic.Uint64
+	states                 map[string]partitionState
+	depths                 map[string]int
+	group                  *errgroup.Group
+	mu                     sync.Mutex
 }
 
 // Config is the configuration for the reader.
 type Config struct {
 	// If StartTimestamp is a zero value of time.Time, reader reads from the current timestamp.
 	StartTimestamp time.Time
+	// If StartOffset is set, reader reads from StartOffset before the time Read is called, e.g. an hour ago.
+	// It must not be set with StartTimestamp, and must not exceed the retention period of the change stream,
+	// whose records older than that no longer exist.
+	StartOffset time.Duration
+	// If StartFromEarliest is true, reader reads everything the change stream still has, from the retention
+	// period of the change stream before the time Read is called, with a minute of buffer so that the start is
+	// still retained when the query starts. It must not be set with StartTimestamp or StartOffset.
+	StartFromEarliest bool
 	// If EndTimestamp is a zero value of time.Time, reader reads until it is cancelled.
-	EndTimestamp         time.Time
-	HeartbeatInterval    time.Duration
+	// Otherwise, the records committed at or before EndTimestamp are read, and the child partitions starting
+	// after EndTimestamp are not read.
+	// If EndTimestamp is in the future when Read is called, reader tails the live changes until then,
+	// and a warning is logged. See ClampEndToNow.
+	EndTimestamp time.Time
+	// If ClampEndToNow is true, EndTimestamp in the future is replaced with the time Read is called,
+	// so that a bounded read never waits for the future changes.
+	ClampEndToNow bool
+	// HeartbeatInterval is the interval of the heartbeat records of the partitions without any change. It must be
+	// a whole number of milliseconds between 1s and 5m. If HeartbeatInterval is zero, 10s is used.
+	HeartbeatInterval time.Duration
+	// If SpannerClientConfig.SessionPoolConfig is a zero value, spanner.DefaultSessionPoolConfig is used.
 	SpannerClientConfig  spanner.ClientConfig
 	SpannerClientOptions []option.ClientOption
+	// Dialer dials the connections to Cloud Spanner instead of the default dialer, e.g. through a SOCKS proxy
+	// or a tunnel in a restricted network. It's a shorthand of grpc.WithContextDialer in SpannerClientOptions,
+	// and is used by all the connections of the reader, including the dialect detection and the partition
+	// queries. addr is the address of the endpoint, e.g. spanner.googleapis.com:443.
+	Dialer func(ctx context.Context, addr string) (net.Conn, error)
+	// PostgresFunctionSchema is the schema of the change stream read function for PostgreSQL-dialect databases.
+	// If PostgresFunctionSchema is empty, "spanner" is used, i.e. spanner.read_json_<stream>.
+	PostgresFunctionSchema string
+	// PostgresReadOptions is passed as the read_options argument of the change stream read function
+	// for PostgreSQL-dialect databases. If PostgresReadOptions is empty, NULL is passed.
+	//
+	// The options are passed to Cloud Spanner as they are, so any option supported by the Spanner version
+	// can be used. At the time of writing Cloud Spanner reserves the argument for future use and rejects
+	// any value other than NULL. Each option must be a non-empty string without whitespace, and must not
+	// be repeated.
+	PostgresReadOptions []string
+	// If TrackTransactions is true, reader counts the data change records read for each transaction
+	// to detect incomplete transactions. See Reader.IncompleteTransactions.
+	TrackTransactions bool
+	// OnTransactionComplete is called once when all the data change records of a transaction have been
+	// delivered to the read function, with the tables modified by the transaction and its number of records.
+	// Setting OnTransactionComplete implies TrackTransactions.
+	OnTransactionComplete func(txnID string, commitTimestamp time.Time, tables []string, recordCount int64)
+	// OnTransactionSummary is called once when all the data change records of a transaction have been
+	// delivered to the read function, with the summary of the transaction such as the tables and the number
+	// of the mods of each table, e.g. for an audit trail without every mod. The mods are counted as read from
+	// Cloud Spanner, including the ones dropped by the filters such as SkipNoOpUpdates and SampleRate.
+	// Setting OnTransactionSummary implies TrackTransactions.
+	OnTransactionSummary func(summary *TransactionSummary)
+	// If SkipNoOpUpdates is true, reader drops the UPDATE mods whose new values are the same as the old values.
+	// It's only applied to the records with OLD_AND_NEW_VALUES value capture type.
+	// Note that the values are compared in JSON, so it costs CPU for every UPDATE record.
+	SkipNoOpUpdates bool
+	// If SampleRate is larger than 1, reader delivers only 1 of every SampleRate data change records, e.g. for
+	// the approximate dashboards, while the heartbeat and child partitions records are always delivered.
+	// The records are sampled in the order they are decoded across all the partitions, or randomly with the
+	// probability of 1/SampleRate if SampleRandomly is true. Note that sampling is applied client-side after
+	// decoding, so all the records are still read from Cloud Spanner. The records dropped by sampling still
+	// count towards the transaction completeness.
+	SampleRate     int
+	SampleRandomly bool
+	// NullPolicy decides how the NULL columns appear in the new and old values of the mods, since the consumers
+	// disagree on whether a NULL column should be absent, NULL or the zero value. It's applied after decoding,
+	// so the values are consistent across the dialects. By default, the values are left as returned.
+	NullPolicy NullPolicy
+	// If MaxValueBytes is set, the column values in the new and old values of the mods larger than MaxValueBytes
+	// are replaced with a marker object {"_truncated": true, "bytes": N, "prefix": "..."}, where N is the size of
+	// the value and prefix is its first MaxValueBytes bytes at most. The size of a string value is the size of
+	// the string, and the size of the other values is the size of their JSON encoding. The primary key columns are
+	// never truncated. The truncated values are counted in Stats.TruncatedValues.
+	MaxValueBytes int
+	// BytesFormat decides how the BYTES columns, including the elements of ARRAY<BYTES>, appear in the new and
+	// old values of the mods, according to the column types of the records. By default, they are base64-encoded
+	// strings as returned. The primary key columns are always base64-encoded, since they identify the rows,
+	// e.g. for the sinks that write the rows back to Cloud Spanner, which also need the default format.
+	// It's applied before MaxValueBytes.
+	BytesFormat BytesFormat
+	// NumericFormat decides how the NUMERIC columns, including the elements of ARRAY<NUMERIC>, appear in the new
+	// and old values of the mods. By default, they are decimal strings as returned.
+	NumericFormat NumericFormat
+	// NonFiniteFloatPolicy decides how NaN, Infinity and -Infinity of the FLOAT32 and FLOAT64 columns appear in
+	// the new and old values of the mods. By default, they are strings as returned.
+	NonFiniteFloatPolicy NonFiniteFloatPolicy
+	// If ParseJSONColumns is true, the JSON columns, including the elements of ARRAY<JSON>, are parsed from
+	// the strings into the structured values in the new and old values of the mods, so that the consumers don't
+	// get the JSON encoded twice. The numbers are parsed as json.Number to keep their precision. A value that
+	// fails to parse is left as the string, and counted in Stats.JSONParseFailures.
+	ParseJSONColumns bool
+	// If TimestampLocation or TimestampLayout is set, the TIMESTAMP columns, including the elements of
+	// ARRAY<TIMESTAMP>, are rendered in TimestampLocation with TimestampLayout in the new and old values of
+	// the mods. If TimestampLocation is nil, the values stay in UTC, and if TimestampLayout is empty,
+	// time.RFC3339Nano is used. By default, they are RFC 3339 strings in UTC as returned. DATE columns are
+	// always left as they are.
+	TimestampLocation *time.Location
+	TimestampLayout   string
+	// If CountModTypesPerTable is true, Stats.TableModTypes reports the number of the mods of each mod type
+	// for each table as well as in total.
+	CountModTypesPerTable bool
+	// If CoalesceWindow is set, reader buffers the data change records and delivers only the latest record
+	// per CoalesceKey at the end of every window. A later record always replaces the earlier one, so a DELETE
+	// after UPDATEs is delivered as the DELETE. Heartbeat and child partitions records are not buffered.
+	// The buffered records are dropped if the read fails or is cancelled.
+	CoalesceWindow time.Duration
+	// CoalesceKey returns the key to coalesce the records. If CoalesceKey is nil, the table name and
+	// the primary keys of the mods are used.
+	CoalesceKey func(record *DataChangeRecord) string
+	// If StallTimeout is set, the query of a partition is cancelled when no row, including heartbeat records,
+	// arrives from Cloud Spanner within StallTimeout, and it's retried from the latest timestamp of the records
+	// delivered from the partition. The data change records at that timestamp that have already been delivered
+	// are skipped, even in the middle of a transaction, so the retry delivers nothing twice.
+	// StallTimeout must be longer than HeartbeatInterval.
+	StallTimeout time.Duration
+	// If ClockJumpThreshold is set, reader detects that the wall clock jumped by ClockJumpThreshold or more
+	// against the monotonic clock, e.g. after the machine slept or the network was partitioned with the process
+	// frozen, when the streams of the queries are likely dead. Then it replaces the Cloud Spanner client, whose
+	// sessions may have expired, and re-issues the queries of all the partitions from their watermarks in the
+	// same way as StallTimeout. Unlike StallTimeout, it doesn't wait for each partition to time out.
+	ClockJumpThreshold time.Duration
+	// If IncludeReadMetadata is true, each ReadResult carries the Metadata of the query of the partition.
+	IncludeReadMetadata bool
+	// ShouldReadChild decides whether to read the child partition once all of its parents have finished.
+	// Returning false skips the partition and all of its descendants; a child partition merged from a skipped
+	// partition is never read either. If ShouldReadChild is nil, all the child partitions are read.
+	// It may be called concurrently, and more than once for a child partition merged from multiple parents.
+	ShouldReadChild func(partition *ChildPartition) bool
+	// If PartitionAllowlist is set, only the partitions whose tokens are in PartitionAllowlist are read, and
+	// the partitions whose tokens are in PartitionDenylist are never read. The initial query is always executed.
+	// They are meant for debugging, or for sharding the partitions across processes when the assignment is managed
+	// externally: a skipped partition never returns its child partitions, so its descendants are never read by
+	// this reader, even if they are in PartitionAllowlist.
+	PartitionAllowlist []string
+	PartitionDenylist  []string
+	// If CollectQueryStats is true, the partitions are read with QueryWithStats, and the query statistics
+	// returned by Cloud Spanner at the end of each partition query are logged in the partition_query_stats
+	// events. EXPERIMENTAL: the stats mode has overhead on the server, and Cloud Spanner
+	// may return different statistics for the change stream queries in the future. It's off by default.
+	CollectQueryStats bool
+	// If MaxPartitionDepth is set, Read fails when a partition is deeper than MaxPartitionDepth from the root,
+	// as a guardrail against runaway splits and merges. The partitions returned by the initial query are
+	// at depth 1, and a child partition is one deeper than its deepest parent.
+	MaxPartitionDepth int
+	// If AssignSequenceNumbers is true, each result delivered to the read function gets a SequenceNumber
+	// that increases monotonically from 1 in the order the results are delivered. The sequence is local to
+	// the reader: it restarts from 1 on every run, so it's useful for ordering and deduplication within a run,
+	// but it's not stable across restarts. Use IdempotencyKey for the latter.
+	AssignSequenceNumbers bool
+	// If IncludeSource is true, each result delivered to the read function carries the Source of the reader,
+	// i.e. the project, instance, database and stream IDs, and SourceLabel if it's set.
+	IncludeSource bool
+	// SourceLabel is an arbitrary label of the reader added to the Source, e.g. the region of the reader.
+	// It's only used if IncludeSource is true.
+	SourceLabel string
+	// PartitionErrorPolicy decides what happens when the read of a partition fails, including an error
+	// returned by the read function. By default, the whole read is cancelled.
+	PartitionErrorPolicy PartitionErrorPolicy
+	// PartitionMaxAttempts is the number of the attempts to read a partition, including the first one,
+	// with PartitionErrorIsolateFailures policy. If PartitionMaxAttempts is zero, 3 is used.
+	PartitionMaxAttempts int
+	// GRPCMetadata is the gRPC metadata added to the change stream read RPCs of all the partitions,
+	// e.g. for the routing layers and the interceptors that audit the requests. The keys must not start
+	// with "grpc-", which is reserved by gRPC.
+	GRPCMetadata map[string]string
+	// BeforeChildPartitions is called when the query of a partition has finished, before the partition is marked
+	// as finished and any of its child partitions is read. It lets the caller durably record that the parent has
+	// finished and its children are pending in one transaction, together with the watermark, so that no child is
+	// skipped after a crash during a split or a merge. If it returns an error, Read fails with the error.
+	// It may be called concurrently for different partitions.
+	BeforeChildPartitions func(ctx context.Context, finish *PartitionFinish) error
+	// If ResumeFrom is set, Read resumes the read from the pending partitions of the checkpoint instead of
+	// the initial query, and StartTimestamp is ignored. A pending partition is read from its StartTimestamp once
+	// all of its parents have finished, either in the checkpoint or in this read, so the records after
+	// StartTimestamp that were delivered before the checkpoint may be delivered again.
+	// The depths of Config.MaxPartitionDepth are counted from the pending partitions.
+	ResumeFrom *Checkpoint
+	// OnStaleResume decides what happens when StartTimestamp, or the StartTimestamp of any pending partition
+	// of ResumeFrom, is older than MaxResumeAge when Read is called. By default, it's not checked.
+	OnStaleResume StaleResumePolicy
+	// MaxResumeAge is the maximum age of the start checked by OnStaleResume. If MaxResumeAge is zero,
+	// the retention period of the change stream is used.
+	MaxResumeAge time.Duration
+	// If CheckpointStore is set, reader saves the checkpoints of the read to CheckpointStore every
+	// CheckpointInterval or every CheckpointEveryNRecords data change records, whichever comes first, and
+	// whenever a partition has finished. The final checkpoint is saved when Read finishes without an error or
+	// is cancelled by the context. No checkpoint is saved until the initial query has returned all the root
+	// partitions, unless the read is resumed by ResumeFrom. A failed checkpoint is logged and retried with
+	// the next one. The records up to the checkpoint have been returned from the read function, but the
+	// subscriptions may still have them buffered.
+	CheckpointStore CheckpointStore
+	// CheckpointInterval is the interval of the checkpoints. If both CheckpointInterval and
+	// CheckpointEveryNRecords are zero, 10 seconds is used.
+	CheckpointInterval time.Duration
+	// CheckpointEveryNRecords is the number of the data change records delivered between the checkpoints.
+	// If it's zero, the checkpoints are not triggered by the records.
+	CheckpointEveryNRecords int
+	// If CheckpointAtTransactionBoundaries is true, the watermark of a partition in the checkpoints advances only
+	// to the commit timestamps at which the records of all the transactions at or before them have been
+	// delivered from the partition, i.e. the records with IsLastRecordInTransactionInPartition, so a resume never
+	// replays only a part of a transaction of the partition. While a transaction is open, neither its records nor
+	// the heartbeats advance the watermark; a heartbeat advances it only when no transaction is open. Note that
+	// a transaction may still be split across the partitions.
+	CheckpointAtTransactionBoundaries bool
+	// DeliveryMode decides whether the checkpoints are saved after or before the records are delivered to
+	// the read function. A read function that buffers the records, e.g. a sink writing them in the background,
+	// must not return before the records are written for DeliveryAtLeastOnce to hold. By default, it's
+	// DeliveryAtLeastOnce.
+	DeliveryMode DeliveryMode
+	// If SlowCallbackThreshold is set, every call of the read function that takes longer than
+	// SlowCallbackThreshold is logged at LevelWarn with the partition and the metadata of the first data change
+	// record of the result, to tell when the read function, rather than the stream, is the bottleneck. The
+	// durations of the calls are always recorded in Stats.CallbackDurations.
+	SlowCallbackThreshold time.Duration
+	// If CallbackTimeout is set, every call of the read function must return within CallbackTimeout. The context
+	// passed to the read function of ReadWithContext is done after CallbackTimeout, and a call that overruns it and
+	// returns an error is retried with the same result up to CallbackMaxAttempts attempts. Then the read fails with
+	// ErrCallbackTimeout, unless PauseOnError pauses the partition. A call that returns nil is never retried, even
+	// if it overran CallbackTimeout.
+	CallbackTimeout time.Duration
+	// CallbackMaxAttempts is the number of the attempts of a call of the read function that overruns
+	// CallbackTimeout. If CallbackMaxAttempts is zero, 3 is used, or 1 with DeliveryAtMostOnce, which can't
+	// deliver a result more than once.
+	CallbackMaxAttempts int
+	// If BestEffortDecoding is true, a row of the query that fails to decode, e.g. with a malformed record, is
+	// decoded record by record, and the records that decoded are delivered instead of failing the read.
+	// Each record that failed is reported to OnDecodeError, and is never delivered. Note that a child partitions
+	// record that failed loses its child partitions, which are never read.
+	BestEffortDecoding bool
+	// OnDecodeError is called with the record that failed to decode with BestEffortDecoding. If OnDecodeError is
+	// nil, the failures are logged.
+	OnDecodeError func(err *DecodeError)
+	// OnResumeGap is called when the start is advanced by StaleResumeAdvance, with the start and the advanced
+	// start. The changes committed between them are never delivered.
+	OnResumeGap func(from, to time.Time)
+	// InitMaxAttempts is the number of the attempts to create the client and detect the dialect of the database
+	// in NewReaderWithConfig, or in Read with LazyConnect, including the first one. Only the transient errors
+	// such as Unavailable are retried with backoff, and the permanent errors such as PermissionDenied fail
+	// immediately. If InitMaxAttempts is zero, 5 is used.
+	InitMaxAttempts int
+	// InitAttemptTimeout is the timeout of each attempt to detect the dialect. If InitAttemptTimeout is zero,
+	// 10 seconds is used.
+	InitAttemptTimeout time.Duration
+	// ConnectTimeout is the timeout of the whole connection to Cloud Spanner, including the retries
+	// by InitMaxAttempts, e.g. when the network path to Cloud Spanner is blackholed. It doesn't apply to
+	// the read queries. If ConnectTimeout is zero, 30 seconds is used.
+	ConnectTimeout time.Duration
+	// If LazyConnect is true, NewReaderWithConfig only validates the configuration, and the client is created
+	// and the dialect is detected on the first call of Read with its context instead. Then the errors of
+	// the connection, and of the configuration that depends on the dialect, are returned by Read.
+	LazyConnect bool
+	// If PauseOnError is true, an error of the read function pauses the partition instead of failing the read,
+	// so that an operator can inspect the result, e.g. a poison record, fix the cause, and then retry or skip
+	// it by Reader.ResumePartition. The paused partition keeps its query and watermark, and the other
+	// partitions keep being read. The paused partitions are in Reader.PausedPartitions and Stats, and are passed
+	// to OnPartitionPaused. The errors after the context is done still fail the read. It must not be set with
+	// DeliveryAtMostOnce.
+	PauseOnError bool
+	// OnPartitionPaused is called when a partition is paused by PauseOnError.
+	OnPartitionPaused func(pause *PartitionPause)
+	// Unless DisablePanicRecovery is true, a panic of the read function is recovered and returned from Read as
+	// *PanicError with the stack trace, the other partitions are cancelled, and the final checkpoint of
+	// CheckpointStore is still saved. A panic of the function of a subscription is recovered as well, and is
+	// handled as its error by SubscriptionConfig.ErrorPolicy. If DisablePanicRecovery is true, the panic crashes
+	// the process.
+	DisablePanicRecovery bool
+	// Logger is the logger for the operational logs of the reader, such as the start and the end of
+	// the partition queries. Every log has the "stream", "database" and "event" attributes, and the
+	// "partition" attribute if it's about a partition. The partition is the short ID, e.g. P-0001, assigned in
+	// the order the partitions are discovered, since the tokens are too long to read; the tokens of the IDs are
+	// logged at LevelDebug when they are assigned and when the partitions start. If Logger is nil, nothing is
+	// logged.
+	//
+	// If Logger is enabled for LevelTrace, every data change record delivered to the read function is logged
+	// with its metadata such as the table name, the mod type and the commit timestamp, but without the values.
+	Logger *slog.Logger
+	// If TraceRecordValues is true, the records logged at LevelTrace include the keys and the values of the mods.
+	// Note that the values may contain personal data.
+	TraceRecordValues bool
+}
+
+// spannerClientOptions returns SpannerClientOptions with the option of Dialer.
+func (c Config) spannerClientOptions() []option.ClientOption {
+	if c.Dialer == nil {
+		return c.SpannerClientOptions
+	}
+	// The options are copied, so that the caller's slice is never modified.
+	opts := append([]option.ClientOption(nil), c.SpannerClientOptions...)
+	return append(opts, option.WithGRPCDialOption(grpc.WithContextDialer(c.Dialer)))
 }
 
 // NewReader creates a new reader.
@@ -141,44 +546,486 @@ func NewReader(ctx context.Context, projectID, instanceID, databaseID, streamID
 }
 
 // NewReaderWithConfig creates a new reader with a given configuration.
+//
+// Unless Config.LazyConnect is true, it creates the Cloud Spanner client, detects the dialect of the database and
+// checks that the database defines the change stream. If it doesn't, the error wraps ErrStreamNotFound.
 func NewReaderWithConfig(ctx context.Context, projectID, instanceID, databaseID, streamID string, config Config) (*Reader, error) {
 	dbPath := fmt.Sprintf("projects/%s/instances/%s/databases/%s", projectID, instanceID, databaseID)
-	client, err := spanner.NewClientWithConfig(ctx, dbPath, config.SpannerClientConfig, config.SpannerClientOptions...)
-	if err != nil {
+
+	logger := config.Logger
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	logger = logger.With("stream", streamID, "database", dbPath)
+
+	clientConfig := config.SpannerClientConfig
+	if isZeroSessionPoolConfig(clientConfig.SessionPoolConfig) {
+		// Cloud Spanner client doesn't work with the zero SessionPoolConfig, e.g. MaxOpened is zero.
+		clientConfig.SessionPoolConfig = spanner.DefaultSessionPoolConfig
+		logger.Info("SessionPoolConfig is not set, using the default", "event", "default_session_pool_config")
+	}
+	initMaxAttempts := config.InitMaxAttempts
+	if initMaxAttempts == 0 {
+		initMaxAttempts = defaultInitMaxAttempts
+	}
+	initAttemptTimeout := config.InitAttemptTimeout
+	if initAttemptTimeout == 0 {
+		initAttemptTimeout = defaultInitAttemptTimeout
+	}
+	connectTimeout := config.ConnectTimeout
+	if connectTimeout == 0 {
+		connectTimeout = defaultConnectTimeout
+	}
+
+	// The stream ID is a part of the names of the read functions in the queries.
+	if !identifierPattern.MatchString(streamID) {
+		return nil, fmt.Errorf("invalid stream ID: %q", streamID)
+	}
+
+	postgresFunctionSchema := config.PostgresFunctionSchema
+	if postgresFunctionSchema != "" {
+		if !identifierPattern.MatchString(postgresFunctionSchema) {
+			return nil, fmt.Errorf("invalid PostgresFunctionSchema: %q", postgresFunctionSchema)
+		}
+	} else {
+		postgresFunctionSchema = defaultPostgresFunctionSchema
+	}
+
+	if err := validatePostgresReadOptions(config.PostgresReadOptions); err != nil {
 		return nil, err
 	}
 
-	dialect, err := detectDialect(ctx, client)
-	if err != nil {
-		return nil, fmt.Errorf("failed to detect dialect: %w", err)
+	if config.StartOffset < 0 {
+		return nil, fmt.Errorf("invalid StartOffset: %s", config.StartOffset)
+	}
+	if config.StartOffset > 0 && !config.StartTimestamp.IsZero() {
+		return nil, errors.New("StartOffset must not be set with StartTimestamp")
+	}
+	if config.StartFromEarliest && (config.StartOffset > 0 || !config.StartTimestamp.IsZero()) {
+		return nil, errors.New("StartFromEarliest must not be set with StartTimestamp or StartOffset")
 	}
 
 	heartbeatInterval := config.HeartbeatInterval
 	if heartbeatInterval == 0 {
-		heartbeatInterval = 10 * time.Second
+		heartbeatInterval = defaultHeartbeatInterval
+	}
+	if err := validateHeartbeatInterval(heartbeatInterval); err != nil {
+		return nil, err
+	}
+
+	if config.ClockJumpThreshold < 0 {
+		return nil, fmt.Errorf("invalid ClockJumpThreshold: %s", config.ClockJumpThreshold)
+	}
+	if config.StallTimeout > 0 && config.StallTimeout <= heartbeatInterval {
+		return nil, fmt.Errorf("StallTimeout must be longer than HeartbeatInterval %s, but got %s", heartbeatInterval, config.StallTimeout)
+	}
+
+	partitionMaxAttempts := config.PartitionMaxAttempts
+	if partitionMaxAttempts == 0 {
+		partitionMaxAttempts = defaultPartitionMaxAttempts
+	}
+	if partitionMaxAttempts < 0 {
+		return nil, fmt.Errorf("invalid PartitionMaxAttempts: %d", partitionMaxAttempts)
+	}
+
+	grpcMetadata, err := metadataPairs(config.GRPCMetadata)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.ResumeFrom != nil {
+		if err := config.ResumeFrom.validate(); err != nil {
+			return nil, err
+		}
+	}
+	if config.MaxValueBytes < 0 {
+		return nil, fmt.Errorf("invalid MaxValueBytes: %d", config.MaxValueBytes)
+	}
+	if config.SampleRate < 0 {
+		return nil, fmt.Errorf("invalid SampleRate: %d", config.SampleRate)
+	}
+	if config.SlowCallbackThreshold < 0 {
+		return nil, fmt.Errorf("invalid SlowCallbackThreshold: %s", config.SlowCallbackThreshold)
+	}
+	if config.CheckpointInterval < 0 {
+		return nil, fmt.Errorf("invalid CheckpointInterval: %s", config.CheckpointInterval)
+	}
+	if config.CheckpointEveryNRecords < 0 {
+		return nil, fmt.Errorf("invalid CheckpointEveryNRecords: %d", config.CheckpointEveryNRecords)
+	}
+	if config.DeliveryMode == DeliveryAtMostOnce {
+		if config.CheckpointStore == nil {
+			return nil, errors.New("DeliveryAtMostOnce requires CheckpointStore")
+		}
+		if config.CheckpointAtTransactionBoundaries {
+			return nil, errors.New("DeliveryAtMostOnce can't be used with CheckpointAtTransactionBoundaries")
+		}
+		if config.PauseOnError {
+			return nil, errors.New("DeliveryAtMostOnce can't be used with PauseOnError")
+		}
+		if config.CallbackMaxAttempts > 1 {
+			return nil, errors.New("DeliveryAtMostOnce can't be used with CallbackMaxAttempts more than 1")
+		}
+	}
+	if config.CallbackTimeout < 0 {
+		return nil, fmt.Errorf("invalid CallbackTimeout: %s", config.CallbackTimeout)
+	}
+	if config.CallbackMaxAttempts < 0 {
+		return nil, fmt.Errorf("invalid CallbackMaxAttempts: %d", config.CallbackMaxAttempts)
+	}
+	callbackMaxAttempts := config.CallbackMaxAttempts
+	if callbackMaxAttempts == 0 {
+		callbackMaxAttempts = defaultCallbackMaxAttempts
+		if config.DeliveryMode == DeliveryAtMostOnce {
+			callbackMaxAttempts = 1
+		}
+	}
+	if config.CheckpointStore == nil && (config.CheckpointInterval > 0 || config.CheckpointEveryNRecords > 0 || config.CheckpointAtTransactionBoundaries) {
+		return nil, errors.New("CheckpointInterval, CheckpointEveryNRecords and CheckpointAtTransactionBoundaries require CheckpointStore")
+	}
+	if config.MaxResumeAge < 0 {
+		return nil, fmt.Errorf("invalid MaxResumeAge: %s", config.MaxResumeAge)
+	}
+
+	var source *Source
+	if config.IncludeSource {
+		source = &Source{
+			ProjectID:  projectID,
+			InstanceID: instanceID,
+			DatabaseID: databaseID,
+			StreamID:   streamID,
+			Label:      config.SourceLabel,
+		}
+	}
+
+	var transactions *transactionTracker
+	if config.TrackTransactions || config.OnTransactionComplete != nil || config.OnTransactionSummary != nil {
+		onComplete, onSummary := config.OnTransactionComplete, config.OnTransactionSummary
+		transactions = newTransactionTracker(func(summary *TransactionSummary) {
+			if onComplete != nil {
+				onComplete(summary.ServerTransactionID, summary.CommitTimestamp, summary.Tables, summary.Records)
+			}
+			if onSummary != nil {
+				onSummary(summary)
+			}
+		})
+	}
+
+	format := valueFormat{
+		bytes:           config.BytesFormat,
+		numeric:         config.NumericFormat,
+		nonFiniteFloats: config.NonFiniteFloatPolicy,
+		parseJSON:       config.ParseJSONColumns,
+		// The TIMESTAMP columns are the most common, so the column types are cached for every format.
+		timestampLocation: config.TimestampLocation,
+		timestampLayout:   config.TimestampLayout,
+		types:             &columnTypeCache{},
+	}
+	if format.parseJSON {
+		format.jsonParseFailures = &atomic.Int64{}
+	}
+
+	r := &Reader{
+		dbPath:                 dbPath,
+		clientConfig:           clientConfig,
+		clientOptions:          config.spannerClientOptions(),
+		initMaxAttempts:        initMaxAttempts,
+		initAttemptTimeout:     initAttemptTimeout,
+		connectTimeout:         connectTimeout,
+		streamID:               streamID,
+		startTimestamp:         config.StartTimestamp,
+		startOffset:            config.StartOffset,
+		startFromEarliest:      config.StartFromEarliest,
+		endTimestamp:           config.EndTimestamp,
+		clampEndToNow:          config.ClampEndToNow,
+		heartbeatInterval:      heartbeatInterval,
+		postgresFunctionSchema: postgresFunctionSchema,
+		customFunctionSchema:   config.PostgresFunctionSchema != "",
+		postgresReadOptions:    config.PostgresReadOptions,
+		transactions:           transactions,
+		skipNoOpUpdates:        config.SkipNoOpUpdates,
+		nullPolicy:             config.NullPolicy,
+		valueFormat:            format,
+		truncator:              newValueTruncator(config.MaxValueBytes),
+		partitionIDs:           newPartitionIDs(config.ResumeFrom),
+		sampler:                newRecordSampler(config.SampleRate, config.SampleRandomly),
+		stats:                  newStatsRecorder(),
+		modTypes:               &modTypeCounter{perTable: config.CountModTypesPerTable},
+		coalesceWindow:         config.CoalesceWindow,
+		coalesceKey:            config.CoalesceKey,
+		stallTimeout:           config.StallTimeout,
+		clockJumps:             newClockJumpDetector(config.ClockJumpThreshold),
+		includeReadMetadata:    config.IncludeReadMetadata,
+		shouldReadChild:        config.ShouldReadChild,
+		partitionAllowlist:     tokenSet(config.PartitionAllowlist),
+		partitionDenylist:      tokenSet(config.PartitionDenylist),
+		collectQueryStats:      config.CollectQueryStats,
+		maxPartitionDepth:      config.MaxPartitionDepth,
+		assignSequenceNumbers:  config.AssignSequenceNumbers,
+		source:                 source,
+		traceRecordValues:      config.TraceRecordValues,
+		partitionErrorPolicy:   config.PartitionErrorPolicy,
+		partitionMaxAttempts:   partitionMaxAttempts,
+		grpcMetadata:           grpcMetadata,
+		beforeChildPartitions:  config.BeforeChildPartitions,
+		resumeFrom:             config.ResumeFrom,
+		staleResumePolicy:      config.OnStaleResume,
+		maxResumeAge:           config.MaxResumeAge,
+		onResumeGap:            config.OnResumeGap,
+		bestEffortDecoding:     config.BestEffortDecoding,
+		slowCallbackThreshold:  config.SlowCallbackThreshold,
+		callbackTimeout:        config.CallbackTimeout,
+		callbackMaxAttempts:    callbackMaxAttempts,
+		deliveryMode:           config.DeliveryMode,
+		disablePanicRecovery:   config.DisablePanicRecovery,
+		pauseOnError:           config.PauseOnError,
+		onPartitionPaused:      config.OnPartitionPaused,
+		callbackDurations:      newDurationHistogram(callbackDurationBounds),
+		checkpoints: newCheckpointer(config.CheckpointStore, config.CheckpointInterval, int64(config.CheckpointEveryNRecords),
+			config.CheckpointAtTransactionBoundaries, config.ResumeFrom),
+		onDecodeError: config.OnDecodeError,
+		logger:        logger,
+		states:        make(map[string]partitionState),
+		depths:        make(map[string]int),
+	}
+	if !config.LazyConnect {
+		if err := r.open(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// open creates the client and detects the dialect of the database, and validates the configuration
+// that depends on the dialect.
+func (r *Reader) open(ctx context.Context) error {
+	// The client outlives connectCtx, which is only used to create it.
+	connectCtx, cancel := context.WithTimeout(ctx, r.connectTimeout)
+	defer cancel()
+	err := r.initialize(connectCtx)
+	if err != nil && ctx.Err() == nil && connectCtx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("failed to connect to Spanner within %s: %w", r.connectTimeout, err)
+	}
+	return err
+}
+
+func (r *Reader) initialize(ctx context.Context) error {
+	client, dialect, err := connect(ctx, r.dbPath, r.clientConfig, r.clientOptions, r.initMaxAttempts, r.initAttemptTimeout, r.logger)
+	if err != nil {
+		return err
+	}
+	if r.customFunctionSchema && dialect != dialectPostgreSQL {
+		client.Close()
+		return fmt.Errorf("PostgresFunctionSchema is only supported for %s dialect, but database is %s", dialectPostgreSQL, dialect)
+	}
+	if len(r.postgresReadOptions) > 0 && dialect != dialectPostgreSQL {
+		client.Close()
+		return fmt.Errorf("PostgresReadOptions is only supported for %s dialect, but database is %s", dialectPostgreSQL, dialect)
+	}
+	found, err := hasChangeStream(ctx, client, dialect, r.streamID)
+	if err != nil {
+		client.Close()
+		return fmt.Errorf("failed to find the change stream: %w", err)
+	}
+	if !found {
+		client.Close()
+		return fmt.Errorf("%w: %s is not defined in %s", ErrStreamNotFound, r.streamID, r.dbPath)
+	}
+	if r.startFromEarliest {
+		retention, err := retentionPeriod(ctx, client, dialect, r.streamID)
+		if err != nil {
+			client.Close()
+			return fmt.Errorf("failed to get the retention period of the change stream: %w", err)
+		}
+		r.startOffset = earliestStartOffset(retention)
+		r.logger.Info("reading from the earliest retained changes", "event", "start_from_earliest",
+			"retention_period", retention, "start_offset", r.startOffset)
+	} else if r.startOffset > 0 {
+		retention, err := retentionPeriod(ctx, client, dialect, r.streamID)
+		if err != nil {
+			client.Close()
+			return fmt.Errorf("failed to get the retention period of the change stream: %w", err)
+		}
+		if r.startOffset > retention {
+			client.Close()
+			return fmt.Errorf("StartOffset %s exceeds the retention period %s of the change stream", r.startOffset, retention)
+		}
 	}
 
-	return &Reader{
-		client:            client,
-		streamID:          streamID,
-		startTimestamp:    config.StartTimestamp,
-		endTimestamp:      config.EndTimestamp,
-		heartbeatInterval: heartbeatInterval,
-		dialect:           dialect,
-		states:            make(map[string]partitionState),
-	}, nil
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.client = client
+	r.dialect = dialect
+	return nil
+}
+
+// spannerClient returns the current client, which may be replaced at a clock jump.
+func (r *Reader) spannerClient() *spanner.Client {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.client
 }
 
-// Close closes the reader.
+// replaceClient replaces the client with a new one after a clock jump, since the sessions and the connections
+// of the current one may be dead. The current client is closed after the queries on it have been cancelled.
+func (r *Reader) replaceClient(ctx context.Context, jump time.Duration) {
+	r.logger.Warn("clock jumped, re-issuing the partition queries", "event", "clock_jumped", "jump", jump)
+	client, err := spanner.NewClientWithConfig(ctx, r.dbPath, r.clientConfig, r.clientOptions...)
+	if err != nil {
+		r.logger.Warn("failed to replace the client after the clock jump", "event", "client_replace_failed", "error", err)
+		return
+	}
+	r.mu.Lock()
+	retired := r.client
+	r.client = client
+	r.mu.Unlock()
+	time.AfterFunc(retiredClientCloseDelay, retired.Close)
+}
+
+// Close closes the reader. It's safe to call Close on a reader that has never connected to Cloud Spanner.
 func (r *Reader) Close() {
-	r.client.Close()
+	r.mu.Lock()
+	client := r.client
+	r.mu.Unlock()
+	if client != nil {
+		client.Close()
+	}
+}
+
+// Stats returns a snapshot of the statistics of the reader.
+func (r *Reader) Stats() Stats {
+	stats := r.stats.snapshot(time.Now(), r.heartbeatInterval)
+	r.modTypes.snapshot(&stats)
+	r.truncator.snapshot(&stats)
+	stats.CallbackDurations = r.callbackDurations.snapshot()
+	if r.valueFormat.jsonParseFailures != nil {
+		stats.JSONParseFailures = r.valueFormat.jsonParseFailures.Load()
+	}
+
+	r.mu.Lock()
+	coalescer, batcher := r.coalescer, r.batcher
+	r.mu.Unlock()
+	if coalescer != nil {
+		stats.BufferedRecords = coalescer.buffered()
+	}
+	if batcher != nil {
+		stats.BufferedRecords += batcher.buffered()
+	}
+	return stats
+}
+
+// Barrier flushes the records buffered by Config.CoalesceWindow and ReadBatch to the read function, and returns the
+// low watermark of the read. All the records up to the returned watermark have been returned from the read function
+// when Barrier returns, so it can be persisted as a checkpoint. The subscriptions may still have them buffered.
+//
+// Barrier must be called while Read is running.
+func (r *Reader) Barrier(ctx context.Context) (time.Time, error) {
+	if err := ctx.Err(); err != nil {
+		return time.Time{}, err
+	}
+
+	r.mu.Lock()
+	group := r.group
+	coalescer, batcher := r.coalescer, r.batcher
+	r.mu.Unlock()
+	if group == nil {
+		return time.Time{}, errors.New("reader is not being read")
+	}
+
+	// Every record up to the watermark has been either delivered or buffered before the flush.
+	watermark := r.stats.lowWatermark()
+	if coalescer != nil {
+		if err := coalescer.flush(); err != nil {
+			return time.Time{}, err
+		}
+	}
+	if batcher != nil {
+		if err := batcher.flush(batcher.ctx); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return watermark, nil
+}
+
+// LowWatermark returns the low watermark of the read, i.e. the oldest watermark of the partitions being read and
+// the start timestamp of the child partitions waiting to be read, or the latest watermark of the finished
+// partitions if none is being read or waiting. All the records of each partition up to its watermark have been
+// returned from the read function, so a record committed before the low watermark never arrives later. Unlike
+// Barrier, the records buffered by Config.CoalesceWindow are not flushed.
+//
+// It returns the zero time before Read.
+func (r *Reader) LowWatermark() time.Time {
+	return r.stats.lowWatermark()
+}
+
+// saveCheckpoint saves the checkpoint of the partitions to Config.CheckpointStore. The records buffered by
+// Config.CoalesceWindow and ReadBatch are flushed after the snapshot, so all the records up to the watermarks have
+// been returned from the read function when the checkpoint is saved.
+func (r *Reader) saveCheckpoint(ctx context.Context) error {
+	c := r.checkpoints
+	c.saveMu.Lock()
+	defer c.saveMu.Unlock()
+
+	checkpoint, ok := c.snapshot()
+	if !ok {
+		return nil
+	}
+	r.mu.Lock()
+	coalescer, batcher := r.coalescer, r.batcher
+	r.mu.Unlock()
+	if coalescer != nil {
+		if err := coalescer.flush(); err != nil {
+			return err
+		}
+	}
+	if batcher != nil {
+		if err := batcher.flush(batcher.ctx); err != nil {
+			return err
+		}
+	}
+	checkpoint.PartitionIDs = r.partitionIDs.snapshot()
+	if err := c.store.SaveCheckpoint(ctx, checkpoint); err != nil {
+		return err
+	}
+	r.logger.Debug("checkpoint saved", "event", "checkpoint_saved", "pending_partitions", len(checkpoint.PendingPartitions))
+	return nil
+}
+
+// IncompleteTransactions returns the transactions of which only a part of the data change records
+// have been read, and whose first record was read more than minAge ago.
+//
+// It returns nil unless Config.TrackTransactions, Config.OnTransactionComplete or Config.OnTransactionSummary is set.
+func (r *Reader) IncompleteTransactions(minAge time.Duration) []*IncompleteTransaction {
+	if r.transactions == nil {
+		return nil
+	}
+	return r.transactions.incomplete(minAge)
 }
 
 // Read starts reading the change stream.
 //
+// If Config.LazyConnect is true, Read first connects to Cloud Spanner, and returns the error if it fails.
 // If function f returns an error, Read finishes the process and returns the error.
+// If f is nil, Read delivers no result, as Track does.
+// The results are also delivered to the subscriptions registered by Subscribe.
 // Once this method is called, reader must not be reused in any other places (i.e. not reentrant).
 func (r *Reader) Read(ctx context.Context, f func(result *ReadResult) error) error {
+	if f == nil {
+		return r.ReadWithContext(ctx, nil)
+	}
+	return r.ReadWithContext(ctx, func(ctx context.Context, result *ReadResult) error {
+		return f(result)
+	})
+}
+
+// ReadWithContext reads the change stream like Read, and passes the context of each call to f, which is done
+// after Config.CallbackTimeout so that f can give up a slow write. The results flushed by Config.CoalesceWindow
+// are passed the context of the read instead.
+func (r *Reader) ReadWithContext(ctx context.Context, f func(ctx context.Context, result *ReadResult) error) error {
+	callerCtx := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	r.mu.Lock()
 	if r.group != nil {
 		r.mu.Unlock()
@@ -186,24 +1033,544 @@ func (r *Reader) Read(ctx context.Context, f func(result *ReadResult) error) err
 	}
 	group, ctx := errgroup.WithContext(ctx)
 	r.group = group
+	subscriptions := r.subscriptions
+	connected := r.client != nil
 	r.mu.Unlock()
 
-	r.group.Go(func() error {
-		start := r.startTimestamp
-		if start.IsZero() {
-			start = time.Now()
+	if !connected {
+		if err := r.open(ctx); err != nil {
+			// The subscriptions end without any result, as they do when the read finishes.
+			for _, s := range subscriptions {
+				s.end(nil)
+			}
+			return err
 		}
-		return r.startRead(ctx, "", start, f)
-	})
+	}
+
+	now := time.Now()
+	start := r.startTimestamp
+	if start.IsZero() {
+		start = now.Add(-r.startOffset)
+	}
+	start, resume, err := r.resolveStaleResume(ctx, now, start)
+	if err != nil {
+		for _, s := range subscriptions {
+			s.end(nil)
+		}
+		return err
+	}
+
+	var subscribers errgroup.Group
+	for _, s := range subscriptions {
+		s := s
+		subscribers.Go(func() error {
+			err := s.run()
+			if err != nil {
+				cancel()
+			}
+			return err
+		})
+	}
+
+	if f == nil {
+		r.trackOnly = true
+		f = func(ctx context.Context, result *ReadResult) error { return nil }
+	}
+	if !r.disablePanicRecovery {
+		read := f
+		f = func(ctx context.Context, result *ReadResult) error {
+			return callRecoveringPanics(func() error { return read(ctx, result) })
+		}
+	}
+	deliver := func(ctx context.Context, result *ReadResult) error {
+		r.traceRecords(ctx, result)
+		return f(ctx, result)
+	}
+	if r.source != nil {
+		// The results flushed by the coalescer are new, so the source is set just before f.
+		read := deliver
+		deliver = func(ctx context.Context, result *ReadResult) error {
+			result.Source = r.source
+			return read(ctx, result)
+		}
+	}
+	if r.assignSequenceNumbers {
+		read := deliver
+		deliver = func(ctx context.Context, result *ReadResult) error {
+			result.SequenceNumber = r.sequenceNumber.Add(1)
+			return read(ctx, result)
+		}
+	}
+	if len(subscriptions) > 0 {
+		read := deliver
+		deliver = func(ctx context.Context, result *ReadResult) error {
+			if err := read(ctx, result); err != nil {
+				return err
+			}
+			for _, s := range subscriptions {
+				if err := s.send(ctx, result); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	var flusher errgroup.Group
+	stopFlusher := make(chan struct{})
+	var coalescer *coalescer
+	if r.coalesceWindow > 0 {
+		read := deliver
+		coalescer = newCoalescer(r.coalesceWindow, r.coalesceKey, func(result *ReadResult) error {
+			return read(ctx, result)
+		})
+		r.mu.Lock()
+		r.coalescer = coalescer
+		r.mu.Unlock()
+		deliver = func(ctx context.Context, result *ReadResult) error {
+			return coalescer.add(result)
+		}
+		flusher.Go(func() error {
+			err := coalescer.run(stopFlusher)
+			if err != nil {
+				cancel()
+			}
+			return err
+		})
+	}
+
+	stopClockJumps := make(chan struct{})
+	if r.clockJumps != nil {
+		r.clockJumps.onJump = func(jump time.Duration) { r.replaceClient(ctx, jump) }
+		go r.clockJumps.run(stopClockJumps)
+	}
+
+	stopCheckpoints := make(chan struct{})
+	var checkpoints sync.WaitGroup
+	if r.checkpoints != nil {
+		checkpoints.Add(1)
+		go func() {
+			defer checkpoints.Done()
+			r.checkpoints.run(stopCheckpoints, func() {
+				if err := r.saveCheckpoint(ctx); err != nil && ctx.Err() == nil {
+					r.logger.Warn("failed to save the checkpoint", "event", "checkpoint_failed", "error", err)
+				}
+			})
+		}()
+	}
 
-	return group.Wait()
+	r.resolveEndTimestamp(now)
+	if resume {
+		r.logger.Info("resuming the read from the checkpoint", "event", "read_resumed",
+			"pending_partitions", len(r.resumeFrom.PendingPartitions))
+		r.markStatesFinished(r.resumeFrom.FinishedPartitionTokens)
+		r.readChildren(ctx, r.logger, r.resumeFrom.PendingPartitions, 0, deliver)
+	} else {
+		r.group.Go(func() error {
+			return r.startRead(ctx, &partitionContext{startTimestamp: start}, deliver)
+		})
+	}
+
+	err = group.Wait()
+	close(stopClockJumps)
+	close(stopCheckpoints)
+	checkpoints.Wait()
+	close(stopFlusher)
+	if flushErr := flusher.Wait(); flushErr != nil && err == nil {
+		err = flushErr
+	}
+	if coalescer != nil && err == nil {
+		err = coalescer.flush()
+	}
+	// The final checkpoint on a graceful shutdown or a recovered panic, which flushes the coalescer as well.
+	var panicErr *PanicError
+	if r.checkpoints != nil && (err == nil || callerCtx.Err() != nil || errors.As(err, &panicErr)) {
+		if saveErr := r.saveCheckpoint(context.WithoutCancel(callerCtx)); saveErr != nil && err == nil {
+			err = fmt.Errorf("failed to save the final checkpoint: %w", saveErr)
+		}
+	}
+	// No more results are sent after all the partitions have finished.
+	for _, s := range subscriptions {
+		close(s.ch)
+	}
+	if err := subscribers.Wait(); err != nil {
+		return err
+	}
+	if err == nil {
+		r.mu.Lock()
+		err = errors.Join(r.partitionErrors...)
+		r.mu.Unlock()
+	}
+	return err
 }
 
-func (r *Reader) startRead(ctx context.Context, partitionToken string, startTimestamp time.Time, f func(result *ReadResult) error) error {
-	if !r.markStateReading(partitionToken) {
+// ReadOnce executes the initial query of the change stream from start, and returns its first result without
+// reading any partition. It returns nil if the query returns no row within a short window, i.e. 5 seconds,
+// and the query is always cancelled before ReadOnce returns. It's meant for fast, deterministic integration
+// tests, e.g. against the emulator, so the filters and the normalization of the values are not applied.
+//
+// If Config.LazyConnect is true, ReadOnce connects to Cloud Spanner first. ReadOnce may be called more than once,
+// but not concurrently with Read or itself.
+func (r *Reader) ReadOnce(ctx context.Context, start time.Time) (*ReadResult, error) {
+	if r.client == nil {
+		if err := r.open(ctx); err != nil {
+			return nil, err
+		}
+	}
+	stmt, err := r.QueryForPartition("", start)
+	if err != nil {
+		return nil, err
+	}
+
+	queryCtx, cancel := context.WithTimeout(ctx, readOnceWindow)
+	defer cancel()
+	if len(r.grpcMetadata) > 0 {
+		queryCtx = metadata.AppendToOutgoingContext(queryCtx, r.grpcMetadata...)
+	}
+	iter := r.client.Single().Query(queryCtx, stmt)
+	defer iter.Stop()
+	row, err := iter.Next()
+	if err == iterator.Done || (err != nil && queryCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	result := &ReadResult{}
+	if err := r.decodeRow(row, result); err != nil {
+		return nil, err
+	}
+	result.Source = r.source
+	return result, nil
+}
+
+// resolveEndTimestamp warns about or clamps the end timestamp in the future. It must be called before
+// any partition is read.
+func (r *Reader) resolveEndTimestamp(now time.Time) {
+	if r.endTimestamp.IsZero() || !r.endTimestamp.After(now) {
+		return
+	}
+	if r.clampEndToNow {
+		r.logger.Info("end timestamp is in the future, clamped to now", "event", "end_timestamp_clamped",
+			"end_timestamp", r.endTimestamp, "clamped_end_timestamp", now)
+		r.endTimestamp = now
+		return
+	}
+	r.logger.Warn("end timestamp is in the future, reading the live changes until then", "event", "end_timestamp_in_future",
+		"end_timestamp", r.endTimestamp)
+}
+
+func (r *Reader) startRead(ctx context.Context, partition *partitionContext, f func(ctx context.Context, result *ReadResult) error) error {
+	partitionToken, startTimestamp, depth := partition.token, partition.startTimestamp, partition.depth
+	if !r.isAssigned(partitionToken) {
+		r.logger.Debug("partition not assigned to the reader, skipped", "event", "partition_not_assigned", "partition", r.partitionIDs.get(partitionToken))
+		r.stats.childSkipped(partitionToken)
 		return nil
 	}
+	if !r.markStateReading(partitionToken, depth) {
+		return nil
+	}
+
+	partition.assignIDs(r.partitionIDs)
+	partitionID := partition.id
+	// All the logs of the partition carry its identity.
+	logger := partition.logger(r.logger)
+	if r.maxPartitionDepth > 0 && depth > r.maxPartitionDepth {
+		logger.Error("partition exceeds the max depth", "event", "partition_depth_exceeded")
+		return fmt.Errorf("partition %q at depth %d exceeds MaxPartitionDepth %d", partitionToken, depth, r.maxPartitionDepth)
+	}
+	logger.Debug("partition query started", "event", "partition_started")
+
+	var childPartitionRecords []*ChildPartitionsRecord
+	// The root partitions returned by the initial query have no parent, so they're read as soon as
+	// they arrive to reach the full parallelism quickly, unless BeforeChildPartitions must see them first.
+	readChildrenEagerly := partitionToken == "" && r.beforeChildPartitions == nil
+	// watermark is the latest timestamp of the records delivered from the partition.
+	watermark := startTimestamp
+	// resume is the data change records delivered at the latest commit timestamp, which are skipped on a retry.
+	resume := &resumePoint{}
+	queryStartTime := time.Now()
+	var rows int64
+	// failures is the number of the failed attempts with PartitionErrorIsolateFailures policy.
+	var failures int
+	r.stats.queryStarted(partition, queryStartTime)
+	for {
+		stmt, err := r.QueryForPartition(partitionToken, watermark)
+		if err != nil {
+			return err
+		}
+
+		queryCtx, watchdog := newStallWatchdog(ctx, r.stallTimeout)
+		queryCtx, stopClockWatch := r.clockJumps.watch(queryCtx)
+		if len(r.grpcMetadata) > 0 {
+			queryCtx = metadata.AppendToOutgoingContext(queryCtx, r.grpcMetadata...)
+		}
+		client := r.spannerClient()
+		var iter *spanner.RowIterator
+		if r.collectQueryStats {
+			iter = client.Single().QueryWithStats(queryCtx, stmt)
+		} else {
+			iter = client.Single().Query(queryCtx, stmt)
+		}
+		err = iter.Do(func(row *spanner.Row) error {
+			// Time spent in the read function doesn't count as a stall.
+			watchdog.pause()
+			defer watchdog.resume()
+
+			arrivalTime := time.Now()
+			r.stats.rowArrived(partitionToken, arrivalTime)
+			r.stats.bytesRead(partitionToken, rowSize(row))
+			rows++
+			readResult := ReadResult{PartitionToken: partitionToken}
+			decode := r.decodeRow
+			if r.trackOnly {
+				decode = r.decodeTrackedRow
+			}
+			if err := decode(row, &readResult); err != nil {
+				if !r.bestEffortDecoding {
+					return err
+				}
+				logger.Warn("failed to decode the row, salvaging the records", "event", "row_decode_failed", "error", err)
+				readResult.ChangeRecords = r.salvageRow(row, partitionToken, func(err *DecodeError) {
+					r.reportDecodeError(logger, err)
+				})
+			}
+			indexMods(&readResult)
+			if skipped := resume.skipDelivered(&readResult); skipped > 0 {
+				logger.Debug("records delivered before the retry skipped", "event", "delivered_records_skipped", "records", skipped)
+			}
+			deliveredRecords := dataChangeRecords(&readResult)
+			r.stats.recordsArrived(partitionToken, len(deliveredRecords))
+			for range readResult.HeartbeatRecords() {
+				r.stats.heartbeatArrived(partitionToken, arrivalTime)
+				break
+			}
+			if r.includeReadMetadata {
+				readResult.Metadata = &ReadMetadata{
+					QueryElapsed: arrivalTime.Sub(queryStartTime),
+					Rows:         rows,
+					ArrivalTime:  arrivalTime,
+				}
+			}
+
+			// Records dropped by the filters below still count towards the transaction completeness.
+			var observedRecords []*DataChangeRecord
+			if r.transactions != nil {
+				for _, changeRecord := range readResult.ChangeRecords {
+					for _, record := range changeRecord.DataChangeRecords {
+						// The copy keeps the table name and the mods, which the filters and f may modify.
+						observed := *record
+						observedRecords = append(observedRecords, &observed)
+					}
+				}
+			}
+
+			var rowChildPartitionRecords []*ChildPartitionsRecord
+			for _, changeRecord := range readResult.ChangeRecords {
+				// The records are sampled first, so the dropped ones cost nothing further.
+				r.sampler.sample(changeRecord)
+				if err := normalizeNulls(changeRecord, r.nullPolicy); err != nil {
+					return err
+				}
+				if r.skipNoOpUpdates {
+					if err := skipNoOpUpdates(changeRecord); err != nil {
+						return err
+					}
+				}
+				if err := formatValues(changeRecord, r.valueFormat); err != nil {
+					return err
+				}
+				// The values are truncated after the no-op updates are compared in full.
+				if err := r.truncator.truncate(changeRecord); err != nil {
+					return err
+				}
+				for _, record := range changeRecord.DataChangeRecords {
+					r.modTypes.count(record)
+				}
+				rowChildPartitionRecords = append(rowChildPartitionRecords, changeRecord.ChildPartitionsRecords...)
+			}
+			for _, record := range rowChildPartitionRecords {
+				for _, child := range record.ChildPartitions {
+					if id, assigned := r.partitionIDs.assign(child.Token); assigned {
+						logger.Debug("child partition discovered", "event", "partition_id_assigned", "child_partition", id,
+							"child_partition_token", child.Token)
+					}
+				}
+			}
+			childPartitionRecords = append(childPartitionRecords, rowChildPartitionRecords...)
+
+			// The read function may modify the result, e.g. when the records are coalesced.
+			latest := latestTimestamp(&readResult)
+			// The records at the latest commit timestamp are never delivered again with the start past it.
+			atMostOnce := r.deliveryMode == DeliveryAtMostOnce && len(deliveredRecords) > 0
+			if atMostOnce {
+				r.checkpoints.advance(partitionToken, latest.Add(time.Nanosecond), deliveredRecords)
+				if err := r.saveCheckpoint(ctx); err != nil {
+					logger.Error("failed to save the checkpoint before the delivery", "event", "checkpoint_failed", "error", err)
+					return fmt.Errorf("failed to save the checkpoint before the delivery: %w", err)
+				}
+			}
+			var err error
+			if r.batcher != nil {
+				// The batcher delivers the batches of ReadBatch through the same wrappers, so the results are
+				// passed to it as they are.
+				err = f(ctx, &readResult)
+				r.stats.callbackFinished(partitionToken, time.Now())
+			} else {
+				err = r.callReadFunction(ctx, logger, f, &readResult)
+				r.stats.callbackFinished(partitionToken, time.Now())
+				err = r.retryPaused(ctx, logger, partitionToken, partitionID, err, len(deliveredRecords), f, &readResult)
+			}
+			if err != nil {
+				return err
+			}
+			if latest.After(watermark) {
+				watermark = latest
+			}
+			resume.advance(deliveredRecords)
+			if !atMostOnce {
+				r.checkpoints.advance(partitionToken, watermark, deliveredRecords)
+			}
+			r.stats.advanceWatermark(partitionToken, latest)
+
+			for _, record := range observedRecords {
+				r.transactions.observe(partitionToken, record)
+			}
+			if readChildrenEagerly {
+				r.readChildren(ctx, logger, pendingChildren(rowChildPartitionRecords), depth, f)
+			}
+			return nil
+		})
+		clockJumped := stopClockWatch()
+		stalled := watchdog.stalled()
+		watchdog.stop()
+		// Errors of the read function are never retried.
+		if stalled && spanner.ErrCode(err) == codes.Canceled && ctx.Err() == nil {
+			r.stats.queryRetried(partitionToken)
+			logger.Warn("partition query stalled, retrying from the watermark", "event", "partition_retried", "watermark", watermark)
+			continue
+		}
+		if clockJumped && spanner.ErrCode(err) == codes.Canceled && ctx.Err() == nil {
+			r.stats.queryRetried(partitionToken)
+			logger.Info("partition query cancelled after the clock jump, retrying from the watermark", "event", "partition_retried",
+				"watermark", watermark)
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			if r.partitionErrorPolicy == PartitionErrorIsolateFailures {
+				failures++
+				if failures < r.partitionMaxAttempts {
+					backoff := retryBackoff(failures)
+					r.stats.queryRetried(partitionToken)
+					logger.Warn("partition read failed, retrying from the watermark", "event", "partition_retried",
+						"watermark", watermark, "attempt", failures, "backoff", backoff, "error", err)
+					if !sleep(ctx, backoff) {
+						return ctx.Err()
+					}
+					continue
+				}
+				logger.Error("partition read failed, skipped", "event", "partition_skipped", "attempts", failures, "error", err)
+				r.stats.queryFailed(partitionToken)
+				r.mu.Lock()
+				r.partitionErrors = append(r.partitionErrors, &PartitionError{PartitionToken: partitionToken, Err: err})
+				r.mu.Unlock()
+				return nil
+			}
+			logger.Error("partition read failed", "event", "partition_failed", "error", err)
+			return err
+		}
+		if r.collectQueryStats {
+			logger.Info("partition query stats", "event", "partition_query_stats", "query_stats", iter.QueryStats, "row_count", iter.RowCount)
+		}
+		break
+	}
+
+	children := pendingChildren(childPartitionRecords)
+	if r.beforeChildPartitions != nil {
+		if err := r.beforeChildPartitions(ctx, &PartitionFinish{
+			PartitionToken: partitionToken,
+			Watermark:      watermark,
+			Children:       children,
+		}); err != nil {
+			logger.Error("BeforeChildPartitions failed", "event", "before_child_partitions_failed", "error", err)
+			return err
+		}
+	}
+
+	r.checkpoints.finish(partitionToken, children)
+	// The children keep the low watermark until their queries start, since their records may still arrive.
+	r.stats.childrenReturned(children)
+	r.markStateFinished(partitionToken)
+	r.stats.queryFinished(partitionToken)
+	logger.Debug("partition query finished", "event", "partition_finished", "child_partitions_records", len(childPartitionRecords))
+	if !readChildrenEagerly {
+		r.readChildren(ctx, logger, children, depth, f)
+	}
+	return nil
+}
 
+// readChildren starts reading the child partitions of which all the parents have finished.
+// parentDepth is the depth of the partition that returned the children.
+func (r *Reader) readChildren(ctx context.Context, logger *slog.Logger, children []*PendingPartition, parentDepth int, f func(ctx context.Context, result *ReadResult) error) {
+	r.checkpoints.add(children)
+	for _, child := range children {
+		childPartition := &ChildPartition{Token: child.Token, ParentPartitionTokens: child.ParentPartitionTokens}
+		childDepth, ok := r.canReadChild(childPartition, parentDepth)
+		if !ok {
+			continue
+		}
+		// The queries return the records committed at or before the end timestamp, so a child starting after it
+		// has nothing to read. A child starting exactly at the end timestamp may still have records at it.
+		if !r.endTimestamp.IsZero() && child.StartTimestamp.After(r.endTimestamp) {
+			logger.Debug("child partition starting after the end timestamp skipped", "event", "child_partition_skipped",
+				"child_partition", r.partitionIDs.get(child.Token), "start_timestamp", child.StartTimestamp)
+			r.checkpoints.remove(child.Token)
+			r.stats.childSkipped(child.Token)
+			continue
+		}
+		if r.shouldReadChild != nil && !r.shouldReadChild(childPartition) {
+			logger.Debug("child partition skipped", "event", "child_partition_skipped", "child_partition", r.partitionIDs.get(child.Token))
+			r.checkpoints.remove(child.Token)
+			r.stats.childSkipped(child.Token)
+			continue
+		}
+		// The start timestamp of a child is always later than r.startTimestamp.
+		partition := &partitionContext{
+			token:          child.Token,
+			depth:          childDepth,
+			parentTokens:   child.ParentPartitionTokens,
+			startTimestamp: child.StartTimestamp,
+		}
+		r.group.Go(func() error {
+			return r.startRead(ctx, partition, f)
+		})
+	}
+}
+
+// PartitionID returns the short ID of the partition in the logs and the stats, e.g. P-0001, or "root" for
+// the empty token of the initial query. It returns the empty string if the partition hasn't been discovered.
+func (r *Reader) PartitionID(partitionToken string) string {
+	return r.partitionIDs.get(partitionToken)
+}
+
+// PartitionIDs returns the short IDs of the partitions discovered so far keyed by the tokens, which should be
+// saved in Checkpoint.PartitionIDs so that the partitions keep their IDs after the resume.
+func (r *Reader) PartitionIDs() map[string]string {
+	return r.partitionIDs.snapshot()
+}
+
+// QueryForPartition returns the statement that reader uses to read the given partition from startTimestamp.
+//
+// An empty partitionToken means the initial query to get the root partitions.
+// The statement can be used to reproduce the read, e.g. in the Cloud Spanner console.
+func (r *Reader) QueryForPartition(partitionToken string, startTimestamp time.Time) (spanner.Statement, error) {
+	heartbeat, err := r.dialect.heartbeatParam(r.heartbeatInterval)
+	if err != nil {
+		return spanner.Statement{}, err
+	}
 	var stmt spanner.Statement
 	switch r.dialect {
 	case dialectGoogleSQL:
@@ -213,7 +1580,7 @@ func (r *Reader) startRead(ctx context.Context, partitionToken string, startTime
 				"start_timestamp":         startTimestamp,
 				"end_timestamp":           r.endTimestamp,
 				"partition_token":         partitionToken,
-				"heartbeat_millis_second": r.heartbeatInterval / time.Millisecond,
+				"heartbeat_millis_second": heartbeat,
 			},
 		}
 		if r.endTimestamp.IsZero() {
@@ -226,12 +1593,13 @@ func (r *Reader) startRead(ctx context.Context, partitionToken string, startTime
 		}
 	case dialectPostgreSQL:
 		stmt = spanner.Statement{
-			SQL: fmt.Sprintf("SELECT * FROM spanner.read_json_%s($1, $2, $3, $4, null)", r.streamID),
+			SQL: fmt.Sprintf("SELECT * FROM %s.read_json_%s($1, $2, $3, $4, $5)", r.postgresFunctionSchema, r.streamID),
 			Params: map[string]interface{}{
 				"p1": startTimestamp,
 				"p2": r.endTimestamp,
 				"p3": partitionToken,
-				"p4": r.heartbeatInterval / time.Millisecond,
+				"p4": heartbeat,
+				"p5": r.postgresReadOptions,
 			},
 		}
 		if r.endTimestamp.IsZero() {
@@ -242,58 +1610,56 @@ func (r *Reader) startRead(ctx context.Context, partitionToken string, startTime
 			// Must be converted to NULL.
 			stmt.Params["p3"] = nil
 		}
+		if len(r.postgresReadOptions) == 0 {
+			// Must be converted to NULL.
+			stmt.Params["p5"] = nil
+		}
 	default:
-		return fmt.Errorf("unexpected dialect: %s", r.dialect)
+		return spanner.Statement{}, fmt.Errorf("unexpected dialect: %s", r.dialect)
 	}
+	return stmt, nil
+}
 
-	var childPartitionRecords []*ChildPartitionsRecord
-	if err := r.client.Single().Query(ctx, stmt).Do(func(row *spanner.Row) error {
-		readResult := ReadResult{PartitionToken: partitionToken}
-		switch r.dialect {
-		case dialectGoogleSQL:
-			if err := row.ToStructLenient(&readResult); err != nil {
-				return err
-			}
-		case dialectPostgreSQL:
-			changeRecord, err := decodePostgresRow(row)
-			if err != nil {
-				return err
-			}
-			readResult.ChangeRecords = []*ChangeRecord{changeRecord}
-		default:
-			return fmt.Errorf("unexpected dialect: %s", r.dialect)
+// metadataPairs validates the gRPC metadata and returns the key-value pairs sorted by the keys.
+func metadataPairs(md map[string]string) ([]string, error) {
+	keys := make([]string, 0, len(md))
+	for key := range md {
+		if !metadataKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("invalid GRPCMetadata key: %q", key)
 		}
-
-		for _, changeRecord := range readResult.ChangeRecords {
-			if len(changeRecord.ChildPartitionsRecords) > 0 {
-				childPartitionRecords = append(childPartitionRecords, changeRecord.ChildPartitionsRecords...)
-			}
+		if strings.HasPrefix(strings.ToLower(key), "grpc-") {
+			return nil, fmt.Errorf("GRPCMetadata key %q is reserved by gRPC", key)
 		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
 
-		return f(&readResult)
-	}); err != nil {
-		return err
+	var pairs []string
+	for _, key := range keys {
+		pairs = append(pairs, key, md[key])
 	}
+	return pairs, nil
+}
 
-	r.markStateFinished(partitionToken)
-	fmt.Printf("Child partitions: %v\n", childPartitionRecords)
-	for _, childPartitionsRecord := range childPartitionRecords {
-		// childStartTimestamp is always later than r.startTimestamp.
-		childStartTimestamp := childPartitionsRecord.StartTimestamp
-		for _, childPartition := range childPartitionsRecord.ChildPartitions {
-			if r.canReadChild(childPartition) {
-				partition := childPartition
-				r.group.Go(func() error {
-					return r.startRead(ctx, partition.Token, childStartTimestamp, f)
-				})
-			}
+func isZeroSessionPoolConfig(config spanner.SessionPoolConfig) bool {
+	return reflect.DeepEqual(config, spanner.SessionPoolConfig{})
+}
+
+func validatePostgresReadOptions(options []string) error {
+	seen := make(map[string]bool, len(options))
+	for _, option := range options {
+		if option == "" || strings.IndexFunc(option, unicode.IsSpace) >= 0 {
+			return fmt.Errorf("invalid PostgresReadOptions: %q", option)
+		}
+		if seen[option] {
+			return fmt.Errorf("duplicate PostgresReadOptions: %q", option)
 		}
+		seen[option] = true
 	}
-
 	return nil
 }
 
-func (r *Reader) markStateReading(partitionToken string) bool {
+func (r *Reader) markStateReading(partitionToken string, depth int) bool {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
@@ -302,26 +1668,96 @@ func (r *Reader) markStateReading(partitionToken string) bool {
 		return false
 	}
 	r.states[partitionToken] = partitionStateReading
+	r.depths[partitionToken] = depth
 	return true
 }
 
 func (r *Reader) markStateFinished(partitionToken string) {
+	r.markStatesFinished([]string{partitionToken})
+}
+
+func (r *Reader) markStatesFinished(partitionTokens []string) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	r.states[partitionToken] = partitionStateFinished
+	for _, token := range partitionTokens {
+		r.states[token] = partitionStateFinished
+	}
 }
 
-func (r *Reader) canReadChild(partition *ChildPartition) bool {
+// canReadChild reports whether all the parents of the child partition have finished, and returns the depth of the child.
+// parentDepth is the depth of the partition that returned the child.
+func (r *Reader) canReadChild(partition *ChildPartition, parentDepth int) (int, bool) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
 	for _, parent := range partition.ParentPartitionTokens {
 		if r.states[parent] != partitionStateFinished {
-			return false
+			return 0, false
+		}
+		if r.depths[parent] > parentDepth {
+			parentDepth = r.depths[parent]
+		}
+	}
+	return parentDepth + 1, true
+}
+
+// isAssigned reports whether the partition is read by the reader according to Config.PartitionAllowlist
+// and Config.PartitionDenylist.
+func (r *Reader) isAssigned(partitionToken string) bool {
+	if partitionToken == "" {
+		return true
+	}
+	if r.partitionAllowlist != nil && !r.partitionAllowlist[partitionToken] {
+		return false
+	}
+	return !r.partitionDenylist[partitionToken]
+}
+
+func tokenSet(tokens []string) map[string]bool {
+	if len(tokens) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(tokens))
+	for _, token := range tokens {
+		set[token] = true
+	}
+	return set
+}
+
+// reportDecodeError reports the record that failed to decode with Config.BestEffortDecoding.
+func (r *Reader) reportDecodeError(logger *slog.Logger, err *DecodeError) {
+	if r.onDecodeError != nil {
+		r.onDecodeError(err)
+		return
+	}
+	logger.Warn("failed to decode the record, skipping it", "event", "record_decode_failed", "path", err.Path, "error", err.Err)
+}
+
+// decodeRow decodes the row of the change stream query into the result.
+func (r *Reader) decodeRow(row *spanner.Row, result *ReadResult) error {
+	switch r.dialect {
+	case dialectGoogleSQL:
+		return row.ToStructLenient(result)
+	case dialectPostgreSQL:
+		changeRecord, err := decodePostgresRow(row)
+		if err != nil {
+			return err
+		}
+		result.ChangeRecords = []*ChangeRecord{changeRecord}
+		return nil
+	default:
+		return fmt.Errorf("unexpected dialect: %s", r.dialect)
+	}
+}
+
+// indexMods sets Mod.Index of the mods of the decoded result before any of them is removed.
+func indexMods(result *ReadResult) {
+	for record := range result.DataChangeRecords() {
+		for i, mod := range record.Mods {
+			mod.Index = i
 		}
 	}
-	return true
 }
 
 func decodePostgresRow(row *spanner.Row) (*ChangeRecord, error) {
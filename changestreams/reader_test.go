@@ -17,13 +17,23 @@
 package changestreams
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"net"
+	"sort"
+	"sync"
 	"testing"
 	"time"
 
 	"cloud.google.com/go/spanner"
 	"github.com/google/go-cmp/cmp"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestDecodePostgresRow(t *testing.T) {
@@ -211,6 +221,87 @@ func TestDecodePostgresRow(t *testing.T) {
 	}
 }
 
+func TestQueryForPartition(t *testing.T) {
+	start := mustParseTime("2023-02-24T17:17:00Z")
+	end := mustParseTime("2023-02-24T18:17:00Z")
+
+	for _, test := range []struct {
+		desc           string
+		reader         *Reader
+		partitionToken string
+		want           spanner.Statement
+	}{
+		{
+			desc: "GoogleSQL initial query",
+			reader: &Reader{
+				dialect:           dialectGoogleSQL,
+				streamID:          "mystream",
+				heartbeatInterval: 10 * time.Second,
+			},
+			want: spanner.Statement{
+				SQL: "SELECT ChangeRecord FROM READ_mystream(@start_timestamp, @end_timestamp, @partition_token, @heartbeat_millis_second)",
+				Params: map[string]interface{}{
+					"start_timestamp":         start,
+					"end_timestamp":           nil,
+					"partition_token":         nil,
+					"heartbeat_millis_second": int64(10000),
+				},
+			},
+		},
+		{
+			desc: "GoogleSQL partition query",
+			reader: &Reader{
+				dialect:           dialectGoogleSQL,
+				streamID:          "mystream",
+				endTimestamp:      end,
+				heartbeatInterval: 10 * time.Second,
+			},
+			partitionToken: "token",
+			want: spanner.Statement{
+				SQL: "SELECT ChangeRecord FROM READ_mystream(@start_timestamp, @end_timestamp, @partition_token, @heartbeat_millis_second)",
+				Params: map[string]interface{}{
+					"start_timestamp":         start,
+					"end_timestamp":           end,
+					"partition_token":         "token",
+					"heartbeat_millis_second": int64(10000),
+				},
+			},
+		},
+		{
+			desc: "PostgreSQL partition query",
+			reader: &Reader{
+				dialect:                dialectPostgreSQL,
+				streamID:               "mystream",
+				endTimestamp:           end,
+				heartbeatInterval:      10 * time.Second,
+				postgresFunctionSchema: "myschema",
+				postgresReadOptions:    []string{"option"},
+			},
+			partitionToken: "token",
+			want: spanner.Statement{
+				SQL: "SELECT * FROM myschema.read_json_mystream($1, $2, $3, $4, $5)",
+				Params: map[string]interface{}{
+					"p1": start,
+					"p2": end,
+					"p3": "token",
+					"p4": int64(10000),
+					"p5": []string{"option"},
+				},
+			},
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			got, err := test.reader.QueryForPartition(test.partitionToken, start)
+			if err != nil {
+				t.Fatalf("QueryForPartition error: %v", err)
+			}
+			if diff := cmp.Diff(got, test.want); diff != "" {
+				t.Errorf("diff = %v", diff)
+			}
+		})
+	}
+}
+
 func mustParseTime(value string) time.Time {
 	t, err := time.Parse(time.RFC3339Nano, value)
 	if err != nil {
@@ -218,3 +309,783 @@ func mustParseTime(value string) time.Time {
 	}
 	return t
 }
+
+func TestValidatePostgresReadOptions(t *testing.T) {
+	for _, test := range []struct {
+		desc    string
+		options []string
+		wantErr bool
+	}{
+		{desc: "no options", options: nil},
+		{desc: "valid options", options: []string{"a", "b=1"}},
+		{desc: "empty option", options: []string{""}, wantErr: true},
+		{desc: "option with whitespace", options: []string{"a b"}, wantErr: true},
+		{desc: "duplicate options", options: []string{"a", "a"}, wantErr: true},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			err := validatePostgresReadOptions(test.options)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Errorf("validatePostgresReadOptions(%q) = %v, want error: %v", test.options, err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestIsZeroSessionPoolConfig(t *testing.T) {
+	if !isZeroSessionPoolConfig(spanner.SessionPoolConfig{}) {
+		t.Error("zero SessionPoolConfig must be detected")
+	}
+	if isZeroSessionPoolConfig(spanner.DefaultSessionPoolConfig) {
+		t.Error("DefaultSessionPoolConfig must not be detected as zero")
+	}
+	if isZeroSessionPoolConfig(spanner.SessionPoolConfig{MinOpened: 1}) {
+		t.Error("partially set SessionPoolConfig must not be detected as zero")
+	}
+}
+
+func TestCanReadChild(t *testing.T) {
+	r := &Reader{
+		states: map[string]partitionState{"a": partitionStateFinished, "b": partitionStateFinished, "c": partitionStateReading},
+		depths: map[string]int{"a": 1, "b": 3, "c": 2},
+	}
+
+	// A merged child is one deeper than its deepest parent.
+	if depth, ok := r.canReadChild(&ChildPartition{Token: "d", ParentPartitionTokens: []string{"a", "b"}}, 1); !ok || depth != 4 {
+		t.Errorf("canReadChild = (%d, %v), want (4, true)", depth, ok)
+	}
+	// A root partition has no parents.
+	if depth, ok := r.canReadChild(&ChildPartition{Token: "e"}, 0); !ok || depth != 1 {
+		t.Errorf("canReadChild = (%d, %v), want (1, true)", depth, ok)
+	}
+	if _, ok := r.canReadChild(&ChildPartition{Token: "f", ParentPartitionTokens: []string{"a", "c"}}, 1); ok {
+		t.Error("canReadChild must be false until all the parents finish")
+	}
+}
+
+func TestChildrenAfterEndTimestampAreSkipped(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2023, 2, 24, 17, 17, 0, 0, time.UTC)
+	end := start.Add(time.Minute)
+	server, opts := newFakeSpannerServer(t)
+	server.childPartitions = map[string][]*ChildPartitionsRecord{
+		"": {{StartTimestamp: start, ChildPartitions: []*ChildPartition{{Token: "a"}}}},
+		"a": {
+			// The end timestamp is inclusive, so the child starting at it is read.
+			{StartTimestamp: end, RecordSequence: "1", ChildPartitions: []*ChildPartition{{Token: "b", ParentPartitionTokens: []string{"a"}}}},
+			{StartTimestamp: end.Add(time.Nanosecond), RecordSequence: "2", ChildPartitions: []*ChildPartition{{Token: "c", ParentPartitionTokens: []string{"a"}}}},
+		},
+	}
+	r, err := NewReaderWithConfig(ctx, "project", "instance", "database", "stream", Config{
+		StartTimestamp:       start,
+		EndTimestamp:         end,
+		SpannerClientOptions: opts,
+	})
+	if err != nil {
+		t.Fatalf("NewReaderWithConfig error: %v", err)
+	}
+	defer r.Close()
+	if err := r.Read(ctx, func(result *ReadResult) error { return nil }); err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+
+	if diff := cmp.Diff(server.readTokens, []string{"", "a", "b"}); diff != "" {
+		t.Errorf("read tokens diff = %v", diff)
+	}
+}
+
+func TestResolveEndTimestamp(t *testing.T) {
+	now := mustParseTime("2023-02-24T17:00:00Z")
+	past := now.Add(-time.Hour)
+	future := now.Add(time.Hour)
+
+	for _, test := range []struct {
+		desc          string
+		endTimestamp  time.Time
+		clampEndToNow bool
+		want          time.Time
+	}{
+		{desc: "no end", endTimestamp: time.Time{}, clampEndToNow: true, want: time.Time{}},
+		{desc: "end in the past", endTimestamp: past, clampEndToNow: true, want: past},
+		{desc: "end in the future", endTimestamp: future, want: future},
+		{desc: "end in the future clamped", endTimestamp: future, clampEndToNow: true, want: now},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			var logs bytes.Buffer
+			r := &Reader{
+				endTimestamp:  test.endTimestamp,
+				clampEndToNow: test.clampEndToNow,
+				logger:        slog.New(slog.NewTextHandler(&logs, nil)),
+			}
+			r.resolveEndTimestamp(now)
+			if !r.endTimestamp.Equal(test.want) {
+				t.Errorf("endTimestamp = %v, want %v", r.endTimestamp, test.want)
+			}
+			if inFuture := test.endTimestamp.After(now); inFuture != (logs.Len() > 0) {
+				t.Errorf("logs = %q, want logged = %v", logs.String(), inFuture)
+			}
+		})
+	}
+}
+
+func TestGRPCMetadata(t *testing.T) {
+	ctx := context.Background()
+	server, opts := newFakeSpannerServer(t)
+	r, err := NewReaderWithConfig(ctx, "project", "instance", "database", "stream", Config{
+		EndTimestamp:         time.Now(),
+		SpannerClientOptions: opts,
+		GRPCMetadata:         map[string]string{"x-routing-key": "a", "X-Audit-Id": "b"},
+	})
+	if err != nil {
+		t.Fatalf("NewReaderWithConfig error: %v", err)
+	}
+	defer r.Close()
+
+	if err := r.Read(ctx, func(result *ReadResult) error { return nil }); err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	if len(server.readMetadata) != 1 {
+		t.Fatalf("read RPCs = %d, want 1", len(server.readMetadata))
+	}
+	md := server.readMetadata[0]
+	for key, want := range map[string]string{"x-routing-key": "a", "x-audit-id": "b"} {
+		if got := md.Get(key); len(got) != 1 || got[0] != want {
+			t.Errorf("metadata %s = %v, want [%s]", key, got, want)
+		}
+	}
+}
+
+func TestIncludeReadMetadata(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2023, 2, 24, 17, 17, 0, 0, time.UTC)
+	for _, include := range []bool{false, true} {
+		t.Run(fmt.Sprintf("IncludeReadMetadata=%v", include), func(t *testing.T) {
+			server, opts := newFakeSpannerServer(t)
+			server.childPartitions = map[string][]*ChildPartitionsRecord{
+				"": {{StartTimestamp: start, RecordSequence: "00000001", ChildPartitions: []*ChildPartition{{Token: "a"}}}},
+			}
+			server.dataChangeRecords = map[string][]*DataChangeRecord{
+				"a": {
+					{CommitTimestamp: start.Add(time.Second), RecordSequence: "00000000", IsLastRecordInTransactionInPartition: true},
+					{CommitTimestamp: start.Add(2 * time.Second), RecordSequence: "00000001", IsLastRecordInTransactionInPartition: true},
+				},
+			}
+			r, err := NewReaderWithConfig(ctx, "project", "instance", "database", "stream", Config{
+				StartTimestamp:       start,
+				EndTimestamp:         start.Add(time.Minute),
+				SpannerClientOptions: opts,
+				IncludeReadMetadata:  include,
+			})
+			if err != nil {
+				t.Fatalf("NewReaderWithConfig error: %v", err)
+			}
+			defer r.Close()
+
+			var mu sync.Mutex
+			rows := make(map[string][]int64)
+			readStart := time.Now()
+			if err := r.Read(ctx, func(result *ReadResult) error {
+				mu.Lock()
+				defer mu.Unlock()
+				if !include {
+					if result.Metadata != nil {
+						t.Errorf("Metadata of the partition %q = %+v, want nil", result.PartitionToken, result.Metadata)
+					}
+					return nil
+				}
+				if result.Metadata == nil {
+					t.Errorf("Metadata of the partition %q is nil", result.PartitionToken)
+					return nil
+				}
+				if result.Metadata.QueryElapsed <= 0 {
+					t.Errorf("QueryElapsed = %s, want positive", result.Metadata.QueryElapsed)
+				}
+				if arrival := result.Metadata.ArrivalTime; arrival.Before(readStart) || arrival.After(time.Now()) {
+					t.Errorf("ArrivalTime = %v, want between the start of the read and now", arrival)
+				}
+				rows[result.PartitionToken] = append(rows[result.PartitionToken], result.Metadata.Rows)
+				return nil
+			}); err != nil {
+				t.Fatalf("Read error: %v", err)
+			}
+			if !include {
+				return
+			}
+			// Rows counts the rows of each partition, including the result.
+			if diff := cmp.Diff(rows, map[string][]int64{"": {1}, "a": {1, 2}}); diff != "" {
+				t.Errorf("Rows of the results diff = %v", diff)
+			}
+		})
+	}
+}
+
+func TestMetadataPairs(t *testing.T) {
+	pairs, err := metadataPairs(map[string]string{"b": "2", "a": "1"})
+	if err != nil {
+		t.Fatalf("metadataPairs error: %v", err)
+	}
+	if diff := cmp.Diff(pairs, []string{"a", "1", "b", "2"}); diff != "" {
+		t.Errorf("diff = %v", diff)
+	}
+
+	for _, key := range []string{"", "grpc-timeout", "invalid key"} {
+		if _, err := metadataPairs(map[string]string{key: "v"}); err == nil {
+			t.Errorf("metadataPairs must fail with key %q", key)
+		}
+	}
+}
+
+func TestCommitTimestampPrecision(t *testing.T) {
+	const timestamp = "2023-02-24T17:17:00.123456789-08:00"
+	want := mustParseTime(timestamp)
+	if want.Nanosecond() != 123456789 {
+		t.Fatalf("Nanosecond = %d, want 123456789", want.Nanosecond())
+	}
+
+	assertTimestamps := func(t *testing.T, changeRecord *ChangeRecord) {
+		t.Helper()
+		if got := changeRecord.DataChangeRecords[0].CommitTimestamp; !got.Equal(want) {
+			t.Errorf("CommitTimestamp = %s, want %s", got.Format(time.RFC3339Nano), timestamp)
+		}
+		if got := changeRecord.HeartbeatRecords[0].Timestamp; !got.Equal(want) {
+			t.Errorf("Timestamp = %s, want %s", got.Format(time.RFC3339Nano), timestamp)
+		}
+		if got := changeRecord.ChildPartitionsRecords[0].StartTimestamp; !got.Equal(want) {
+			t.Errorf("StartTimestamp = %s, want %s", got.Format(time.RFC3339Nano), timestamp)
+		}
+
+		// The JSON output keeps the precision as well.
+		b, err := json.Marshal(changeRecord.DataChangeRecords[0])
+		if err != nil {
+			t.Fatalf("json.Marshal error: %v", err)
+		}
+		var record DataChangeRecord
+		if err := json.Unmarshal(b, &record); err != nil {
+			t.Fatalf("json.Unmarshal error: %v", err)
+		}
+		if !record.CommitTimestamp.Equal(want) {
+			t.Errorf("CommitTimestamp in JSON = %s, want %s", record.CommitTimestamp.Format(time.RFC3339Nano), timestamp)
+		}
+	}
+
+	t.Run("GoogleSQL", func(t *testing.T) {
+		row, err := spanner.NewRow([]string{"ChangeRecord"}, []interface{}{[]*ChangeRecord{
+			{
+				DataChangeRecords:      []*DataChangeRecord{{CommitTimestamp: want}},
+				HeartbeatRecords:       []*HeartbeatRecord{{Timestamp: want}},
+				ChildPartitionsRecords: []*ChildPartitionsRecord{{StartTimestamp: want}},
+			},
+		}})
+		if err != nil {
+			t.Fatalf("spanner.NewRow error: %v", err)
+		}
+		var result ReadResult
+		if err := row.ToStructLenient(&result); err != nil {
+			t.Fatalf("ToStructLenient error: %v", err)
+		}
+		assertTimestamps(t, result.ChangeRecords[0])
+	})
+
+	t.Run("PostgreSQL", func(t *testing.T) {
+		var jsonVal interface{}
+		if err := json.Unmarshal([]byte(fmt.Sprintf(`{
+  "data_change_record": {"commit_timestamp": %[1]q},
+  "heartbeat_record": {"timestamp": %[1]q},
+  "child_partitions_record": {"start_timestamp": %[1]q}
+}`, timestamp)), &jsonVal); err != nil {
+			t.Fatalf("json.Unmarshal error: %v", err)
+		}
+		row, err := spanner.NewRow([]string{"read_json_playersstream"}, []interface{}{spanner.NullJSON{Valid: true, Value: jsonVal}})
+		if err != nil {
+			t.Fatalf("spanner.NewRow error: %v", err)
+		}
+		changeRecord, err := decodePostgresRow(row)
+		if err != nil {
+			t.Fatalf("decodePostgresRow error: %v", err)
+		}
+		assertTimestamps(t, changeRecord)
+	})
+}
+
+func TestReadOnce(t *testing.T) {
+	ctx := context.Background()
+	server, opts := newFakeSpannerServer(t)
+	r, err := NewReaderWithConfig(ctx, "project", "instance", "database", "stream", Config{
+		SpannerClientOptions: opts,
+		LazyConnect:          true,
+	})
+	if err != nil {
+		t.Fatalf("NewReaderWithConfig error: %v", err)
+	}
+	defer r.Close()
+
+	start := time.Now().UTC().Truncate(time.Microsecond)
+	result, err := r.ReadOnce(ctx, start)
+	if err != nil {
+		t.Fatalf("ReadOnce error: %v", err)
+	}
+	if result != nil {
+		t.Errorf("ReadOnce without data = %+v, want nil", result)
+	}
+
+	server.mu.Lock()
+	server.childPartitions = map[string][]*ChildPartitionsRecord{
+		"": {
+			{StartTimestamp: start, RecordSequence: "1", ChildPartitions: []*ChildPartition{{Token: "a", ParentPartitionTokens: []string{}}}},
+			{StartTimestamp: start, RecordSequence: "2", ChildPartitions: []*ChildPartition{{Token: "b", ParentPartitionTokens: []string{}}}},
+		},
+	}
+	server.mu.Unlock()
+	result, err = r.ReadOnce(ctx, start)
+	if err != nil {
+		t.Fatalf("ReadOnce error: %v", err)
+	}
+	var tokens []string
+	for record := range result.ChildPartitionsRecords() {
+		for _, child := range record.ChildPartitions {
+			tokens = append(tokens, child.Token)
+		}
+	}
+	if diff := cmp.Diff(tokens, []string{"a"}); diff != "" {
+		t.Errorf("ReadOnce child partitions diff = %v", diff)
+	}
+	// Only the initial queries were executed.
+	if diff := cmp.Diff(server.readTokens, []string{"", ""}); diff != "" {
+		t.Errorf("read tokens diff = %v", diff)
+	}
+}
+
+func TestLazyConnect(t *testing.T) {
+	ctx := context.Background()
+	read := func(result *ReadResult) error { return nil }
+
+	t.Run("connects on Read", func(t *testing.T) {
+		server, opts := newFakeSpannerServer(t)
+		r, err := NewReaderWithConfig(ctx, "project", "instance", "database", "stream", Config{
+			EndTimestamp:         time.Now(),
+			SpannerClientOptions: opts,
+			LazyConnect:          true,
+		})
+		if err != nil {
+			t.Fatalf("NewReaderWithConfig error: %v", err)
+		}
+		defer r.Close()
+		if server.dialectQueries != 0 {
+			t.Errorf("dialect queries before Read = %d, want 0", server.dialectQueries)
+		}
+		if err := r.Read(ctx, read); err != nil {
+			t.Fatalf("Read error: %v", err)
+		}
+		if server.dialectQueries != 1 || len(server.readTokens) != 1 {
+			t.Errorf("dialect queries = %d, read RPCs = %d, want 1 and 1", server.dialectQueries, len(server.readTokens))
+		}
+	})
+
+	t.Run("connection error is returned by Read", func(t *testing.T) {
+		server, opts := newFakeSpannerServer(t)
+		server.dialectErrors = []error{status.Error(codes.PermissionDenied, "permission denied")}
+		r, err := NewReaderWithConfig(ctx, "project", "instance", "database", "stream", Config{
+			SpannerClientOptions: opts,
+			LazyConnect:          true,
+		})
+		if err != nil {
+			t.Fatalf("NewReaderWithConfig error: %v", err)
+		}
+		defer r.Close()
+		if err := r.Read(ctx, read); spanner.ErrCode(errors.Unwrap(err)) != codes.PermissionDenied {
+			t.Errorf("Read error = %v, want PermissionDenied", err)
+		}
+	})
+
+	t.Run("dialect-dependent configuration is validated by Read", func(t *testing.T) {
+		_, opts := newFakeSpannerServer(t)
+		r, err := NewReaderWithConfig(ctx, "project", "instance", "database", "stream", Config{
+			SpannerClientOptions:   opts,
+			PostgresFunctionSchema: "changes",
+			LazyConnect:            true,
+		})
+		if err != nil {
+			t.Fatalf("NewReaderWithConfig error: %v", err)
+		}
+		defer r.Close()
+		if err := r.Read(ctx, read); err == nil {
+			t.Error("Read must fail with PostgresFunctionSchema for GoogleSQL database")
+		}
+	})
+
+	t.Run("invalid configuration fails without connection", func(t *testing.T) {
+		server, opts := newFakeSpannerServer(t)
+		if _, err := NewReaderWithConfig(ctx, "project", "instance", "database", "stream", Config{
+			SpannerClientOptions: opts,
+			PartitionMaxAttempts: -1,
+			LazyConnect:          true,
+		}); err == nil {
+			t.Error("NewReaderWithConfig must fail with invalid PartitionMaxAttempts")
+		}
+		if server.dialectQueries != 0 {
+			t.Errorf("dialect queries = %d, want 0", server.dialectQueries)
+		}
+	})
+
+	t.Run("Close without connection", func(t *testing.T) {
+		r, err := NewReaderWithConfig(ctx, "project", "instance", "database", "stream", Config{LazyConnect: true})
+		if err != nil {
+			t.Fatalf("NewReaderWithConfig error: %v", err)
+		}
+		r.Close()
+	})
+}
+
+func TestHeartbeatInterval(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range []struct {
+		interval time.Duration
+		wantErr  bool
+	}{
+		{interval: 0},
+		{interval: time.Second},
+		{interval: 1500 * time.Millisecond},
+		{interval: 5 * time.Minute},
+		{interval: 999 * time.Millisecond, wantErr: true},
+		{interval: 5*time.Minute + time.Millisecond, wantErr: true},
+		{interval: time.Second + time.Microsecond, wantErr: true},
+		{interval: -time.Second, wantErr: true},
+	} {
+		// LazyConnect validates the configuration without connecting to Cloud Spanner.
+		r, err := NewReaderWithConfig(ctx, "project", "instance", "database", "stream", Config{
+			HeartbeatInterval: test.interval,
+			LazyConnect:       true,
+		})
+		if test.wantErr {
+			if err == nil {
+				r.Close()
+				t.Errorf("NewReaderWithConfig with HeartbeatInterval %s must fail", test.interval)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("NewReaderWithConfig with HeartbeatInterval %s error: %v", test.interval, err)
+			continue
+		}
+		r.Close()
+	}
+}
+
+func TestIncludeSource(t *testing.T) {
+	ctx := context.Background()
+	server, opts := newFakeSpannerServer(t)
+	start := time.Date(2023, 2, 24, 17, 17, 0, 0, time.UTC)
+	server.childPartitions = map[string][]*ChildPartitionsRecord{
+		"": {{StartTimestamp: start, ChildPartitions: []*ChildPartition{{Token: "a"}}}},
+	}
+	r, err := NewReaderWithConfig(ctx, "project", "instance", "database", "stream", Config{
+		StartTimestamp:       start,
+		EndTimestamp:         start.Add(time.Minute),
+		SpannerClientOptions: opts,
+		IncludeSource:        true,
+		SourceLabel:          "asia-northeast1",
+	})
+	if err != nil {
+		t.Fatalf("NewReaderWithConfig error: %v", err)
+	}
+	defer r.Close()
+
+	var sources []*Source
+	if err := r.Read(ctx, func(result *ReadResult) error {
+		sources = append(sources, result.Source)
+		return nil
+	}); err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	want := []*Source{{ProjectID: "project", InstanceID: "instance", DatabaseID: "database", StreamID: "stream", Label: "asia-northeast1"}}
+	if diff := cmp.Diff(sources, want); diff != "" {
+		t.Errorf("sources diff = %v", diff)
+	}
+}
+
+func TestAssignSequenceNumbersWithSource(t *testing.T) {
+	ctx := context.Background()
+	server, opts := newFakeSpannerServer(t)
+	start := time.Date(2023, 2, 24, 17, 17, 0, 0, time.UTC)
+	server.childPartitions = map[string][]*ChildPartitionsRecord{
+		"": {
+			{StartTimestamp: start, RecordSequence: "00000001", ChildPartitions: []*ChildPartition{{Token: "a"}}},
+			{StartTimestamp: start, RecordSequence: "00000002", ChildPartitions: []*ChildPartition{{Token: "b"}}},
+		},
+	}
+	r, err := NewReaderWithConfig(ctx, "project", "instance", "database", "stream", Config{
+		StartTimestamp:        start,
+		EndTimestamp:          start.Add(time.Minute),
+		SpannerClientOptions:  opts,
+		AssignSequenceNumbers: true,
+		IncludeSource:         true,
+	})
+	if err != nil {
+		t.Fatalf("NewReaderWithConfig error: %v", err)
+	}
+	defer r.Close()
+
+	var sequenceNumbers []uint64
+	var sources []*Source
+	if err := r.Read(ctx, func(result *ReadResult) error {
+		sequenceNumbers = append(sequenceNumbers, result.SequenceNumber)
+		sources = append(sources, result.Source)
+		return nil
+	}); err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	if diff := cmp.Diff(sequenceNumbers, []uint64{1, 2}); diff != "" {
+		t.Errorf("sequence numbers diff = %v", diff)
+	}
+	source := &Source{ProjectID: "project", InstanceID: "instance", DatabaseID: "database", StreamID: "stream"}
+	if diff := cmp.Diff(sources, []*Source{source, source}); diff != "" {
+		t.Errorf("sources diff = %v", diff)
+	}
+}
+
+func TestRootPartitionsAreReadEagerly(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2023, 2, 24, 17, 17, 0, 0, time.UTC)
+	for _, test := range []struct {
+		desc                  string
+		beforeChildPartitions func(ctx context.Context, finish *PartitionFinish) error
+		want                  bool
+	}{
+		{desc: "root partitions are read as they arrive", want: true},
+		{
+			desc:                  "root partitions are read after BeforeChildPartitions",
+			beforeChildPartitions: func(ctx context.Context, finish *PartitionFinish) error { return nil },
+			want:                  false,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			server, opts := newFakeSpannerServer(t)
+			server.holdInitialQuery = true
+			server.childPartitions = map[string][]*ChildPartitionsRecord{
+				"": {
+					{StartTimestamp: start, RecordSequence: "00000001", ChildPartitions: []*ChildPartition{{Token: "a"}}},
+					{StartTimestamp: start, RecordSequence: "00000002", ChildPartitions: []*ChildPartition{{Token: "b"}}},
+				},
+			}
+			r, err := NewReaderWithConfig(ctx, "project", "instance", "database", "stream", Config{
+				StartTimestamp:        start,
+				EndTimestamp:          start.Add(time.Minute),
+				SpannerClientOptions:  opts,
+				BeforeChildPartitions: test.beforeChildPartitions,
+			})
+			if err != nil {
+				t.Fatalf("NewReaderWithConfig error: %v", err)
+			}
+			defer r.Close()
+
+			if err := r.Read(ctx, func(result *ReadResult) error { return nil }); err != nil {
+				t.Fatalf("Read error: %v", err)
+			}
+			if server.initialQueryHeld != test.want {
+				t.Errorf("root partition read during the initial query = %v, want %v", server.initialQueryHeld, test.want)
+			}
+			tokens := append([]string(nil), server.readTokens...)
+			sort.Strings(tokens)
+			if diff := cmp.Diff(tokens, []string{"", "a", "b"}); diff != "" {
+				t.Errorf("read partitions diff = %v", diff)
+			}
+		})
+	}
+}
+
+func TestPartitionAllowlist(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2023, 2, 24, 17, 17, 0, 0, time.UTC)
+	split := start.Add(time.Minute)
+	for _, test := range []struct {
+		desc   string
+		config Config
+		want   []string
+	}{
+		{desc: "all partitions", want: []string{"", "a", "b", "c", "d"}},
+		{desc: "allowlist", config: Config{PartitionAllowlist: []string{"a", "c"}}, want: []string{"", "a", "c"}},
+		// d is allowed but never read, since its parent b is skipped.
+		{desc: "allowlist without the parent", config: Config{PartitionAllowlist: []string{"a", "d"}}, want: []string{"", "a"}},
+		{desc: "denylist", config: Config{PartitionDenylist: []string{"a"}}, want: []string{"", "b", "d"}},
+		{desc: "allowlist and denylist", config: Config{PartitionAllowlist: []string{"a", "b"}, PartitionDenylist: []string{"b"}}, want: []string{"", "a"}},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			server, opts := newFakeSpannerServer(t)
+			server.childPartitions = map[string][]*ChildPartitionsRecord{
+				"":  {{StartTimestamp: start, ChildPartitions: []*ChildPartition{{Token: "a"}, {Token: "b"}}}},
+				"a": {{StartTimestamp: split, ChildPartitions: []*ChildPartition{{Token: "c", ParentPartitionTokens: []string{"a"}}}}},
+				"b": {{StartTimestamp: split, ChildPartitions: []*ChildPartition{{Token: "d", ParentPartitionTokens: []string{"b"}}}}},
+			}
+			config := test.config
+			config.StartTimestamp = start
+			config.EndTimestamp = split.Add(time.Minute)
+			config.SpannerClientOptions = opts
+			r, err := NewReaderWithConfig(ctx, "project", "instance", "database", "stream", config)
+			if err != nil {
+				t.Fatalf("NewReaderWithConfig error: %v", err)
+			}
+			defer r.Close()
+
+			if err := r.Read(ctx, func(result *ReadResult) error { return nil }); err != nil {
+				t.Fatalf("Read error: %v", err)
+			}
+			tokens := append([]string(nil), server.readTokens...)
+			sort.Strings(tokens)
+			if diff := cmp.Diff(tokens, test.want); diff != "" {
+				t.Errorf("read partitions diff = %v", diff)
+			}
+		})
+	}
+}
+
+func TestShouldReadChild(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2023, 2, 24, 17, 17, 0, 0, time.UTC)
+	split := start.Add(time.Minute)
+	server, opts := newFakeSpannerServer(t)
+	// c is the merge of a and b, and d is a split of a.
+	merged := &ChildPartition{Token: "c", ParentPartitionTokens: []string{"a", "b"}}
+	server.childPartitions = map[string][]*ChildPartitionsRecord{
+		"":  {{StartTimestamp: start, ChildPartitions: []*ChildPartition{{Token: "a"}, {Token: "b"}}}},
+		"a": {{StartTimestamp: split, ChildPartitions: []*ChildPartition{merged, {Token: "d", ParentPartitionTokens: []string{"a"}}}}},
+		"b": {{StartTimestamp: split, ChildPartitions: []*ChildPartition{merged}}},
+	}
+	store := &fakeCheckpointStore{}
+	var mu sync.Mutex
+	var asked []*ChildPartition
+	r, err := NewReaderWithConfig(ctx, "project", "instance", "database", "stream", Config{
+		StartTimestamp:       start,
+		EndTimestamp:         split.Add(time.Minute),
+		SpannerClientOptions: opts,
+		CheckpointStore:      store,
+		ShouldReadChild: func(partition *ChildPartition) bool {
+			mu.Lock()
+			defer mu.Unlock()
+			asked = append(asked, partition)
+			return partition.Token != "c"
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewReaderWithConfig error: %v", err)
+	}
+	defer r.Close()
+
+	if err := r.Read(ctx, func(result *ReadResult) error { return nil }); err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	tokens := append([]string(nil), server.readTokens...)
+	sort.Strings(tokens)
+	if diff := cmp.Diff(tokens, []string{"", "a", "b", "d"}); diff != "" {
+		t.Errorf("read partitions diff = %v", diff)
+	}
+	// The merged partition is asked once, after both of its parents have finished.
+	sort.Slice(asked, func(i, j int) bool { return asked[i].Token < asked[j].Token })
+	want := []*ChildPartition{
+		{Token: "a", ParentPartitionTokens: []string{}},
+		{Token: "b", ParentPartitionTokens: []string{}},
+		merged,
+		{Token: "d", ParentPartitionTokens: []string{"a"}},
+	}
+	if diff := cmp.Diff(asked, want); diff != "" {
+		t.Errorf("ShouldReadChild calls diff = %v", diff)
+	}
+
+	// The skipped partition is removed from the checkpoints, so it's never resumed either.
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if len(store.checkpoints) == 0 {
+		t.Fatal("no checkpoint saved")
+	}
+	last := store.checkpoints[len(store.checkpoints)-1]
+	if len(last.PendingPartitions) != 0 || len(last.FinishedPartitionTokens) != 0 {
+		t.Errorf("final checkpoint has %v pending and %v finished, want none", last.PendingPartitions, last.FinishedPartitionTokens)
+	}
+}
+
+func TestStreamValidation(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("stream not found", func(t *testing.T) {
+		server, opts := newFakeSpannerServer(t)
+		server.databasesWithoutChangeStream = map[string]bool{"database": true}
+		_, err := NewReaderWithConfig(ctx, "project", "instance", "database", "stream", Config{SpannerClientOptions: opts})
+		if !errors.Is(err, ErrStreamNotFound) {
+			t.Errorf("NewReaderWithConfig error = %v, want %v", err, ErrStreamNotFound)
+		}
+	})
+
+	t.Run("stream not found with lazy connect", func(t *testing.T) {
+		server, opts := newFakeSpannerServer(t)
+		server.databasesWithoutChangeStream = map[string]bool{"database": true}
+		r, err := NewReaderWithConfig(ctx, "project", "instance", "database", "stream", Config{SpannerClientOptions: opts, LazyConnect: true})
+		if err != nil {
+			t.Fatalf("NewReaderWithConfig error: %v", err)
+		}
+		defer r.Close()
+		if err := r.Read(ctx, func(result *ReadResult) error { return nil }); !errors.Is(err, ErrStreamNotFound) {
+			t.Errorf("Read error = %v, want %v", err, ErrStreamNotFound)
+		}
+	})
+
+	t.Run("invalid stream ID", func(t *testing.T) {
+		server, opts := newFakeSpannerServer(t)
+		for _, streamID := range []string{"", "stream(NULL, NULL, NULL, NULL) --", "my-stream", "1stream"} {
+			if _, err := NewReaderWithConfig(ctx, "project", "instance", "database", streamID, Config{SpannerClientOptions: opts}); err == nil {
+				t.Errorf("NewReaderWithConfig with %q must fail", streamID)
+			}
+		}
+		// The configuration is invalid, so the database is never queried.
+		if server.dialectQueries != 0 {
+			t.Errorf("dialect queries = %d, want 0", server.dialectQueries)
+		}
+	})
+}
+
+func TestDialer(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2023, 2, 24, 17, 17, 0, 0, time.UTC)
+	server, opts := newFakeSpannerServer(t)
+	server.childPartitions = map[string][]*ChildPartitionsRecord{
+		"": {{StartTimestamp: start, ChildPartitions: []*ChildPartition{{Token: "a"}}}},
+	}
+
+	var mu sync.Mutex
+	var dialed []string
+	// The endpoint can't be resolved, so the reader only works through the dialer, as behind a proxy.
+	opts = append(opts, option.WithEndpoint("spanner.invalid:443"))
+	r, err := NewReaderWithConfig(ctx, "project", "instance", "database", "stream", Config{
+		StartTimestamp:       start,
+		EndTimestamp:         start.Add(time.Minute),
+		SpannerClientOptions: opts,
+		Dialer: func(ctx context.Context, addr string) (net.Conn, error) {
+			mu.Lock()
+			dialed = append(dialed, addr)
+			mu.Unlock()
+			var d net.Dialer
+			return d.DialContext(ctx, "tcp", server.addr)
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewReaderWithConfig error: %v", err)
+	}
+	defer r.Close()
+	if err := r.Read(ctx, func(result *ReadResult) error { return nil }); err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+
+	if server.dialectQueries == 0 {
+		t.Error("dialect must be detected through the dialer")
+	}
+	if diff := cmp.Diff(server.readTokens, []string{"", "a"}); diff != "" {
+		t.Errorf("read partitions diff = %v", diff)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(dialed) == 0 {
+		t.Fatal("dialer must be used")
+	}
+	for _, addr := range dialed {
+		if addr != "spanner.invalid:443" {
+			t.Errorf("dialed address = %q, want the endpoint", addr)
+		}
+	}
+}
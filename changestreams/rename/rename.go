@@ -0,0 +1,189 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package rename renames the tables and the columns of the data change records, e.g. for the downstream systems
+// whose schemas use different names than Cloud Spanner.
+package rename
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams"
+)
+
+// Config is the configuration for the renamer. The tables and the columns that are not mapped keep their names.
+type Config struct {
+	// Tables maps the table names to the names in the output.
+	Tables map[string]string
+	// Columns maps the column names to the names in the output, keyed by the table names in Cloud Spanner,
+	// not the renamed ones.
+	Columns map[string]map[string]string
+}
+
+// ParseConfig parses the column mappings of the form "Table.column=name" and the table mappings of the form
+// "Table=name", e.g. "Orders.order_id=orderId" and "Orders=orders_v2".
+func ParseConfig(columns, tables []string) (Config, error) {
+	config := Config{Tables: make(map[string]string), Columns: make(map[string]map[string]string)}
+	for _, s := range columns {
+		from, to, ok := strings.Cut(s, "=")
+		table, column, hasColumn := strings.Cut(from, ".")
+		if !ok || !hasColumn {
+			return Config{}, fmt.Errorf("invalid column rename: %q", s)
+		}
+		if config.Columns[table] == nil {
+			config.Columns[table] = make(map[string]string)
+		}
+		if _, ok := config.Columns[table][column]; ok {
+			return Config{}, fmt.Errorf("column %s.%s is renamed more than once", table, column)
+		}
+		config.Columns[table][column] = to
+	}
+	for _, s := range tables {
+		table, to, ok := strings.Cut(s, "=")
+		if !ok {
+			return Config{}, fmt.Errorf("invalid table rename: %q", s)
+		}
+		if _, ok := config.Tables[table]; ok {
+			return Config{}, fmt.Errorf("table %s is renamed more than once", table)
+		}
+		config.Tables[table] = to
+	}
+	return config, nil
+}
+
+// validate checks that the names are not empty, and that no two tables or columns of a table get the same name.
+func (c Config) validate() error {
+	if err := validateMapping("table", c.Tables); err != nil {
+		return err
+	}
+	for table, columns := range c.Columns {
+		if table == "" {
+			return fmt.Errorf("invalid column rename: empty table name")
+		}
+		if err := validateMapping("column of "+table, columns); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateMapping(kind string, mapping map[string]string) error {
+	from := make(map[string]string, len(mapping))
+	for name, to := range mapping {
+		if name == "" || to == "" {
+			return fmt.Errorf("invalid %s rename: %q to %q", kind, name, to)
+		}
+		if other, ok := from[to]; ok {
+			return fmt.Errorf("%s %s and %s are renamed to the same name %s", kind, other, name, to)
+		}
+		from[to] = name
+	}
+	return nil
+}
+
+// Renamer renames the tables and the columns of the data change records.
+type Renamer struct {
+	tables  map[string]string
+	columns map[string]map[string]string
+}
+
+// New creates a renamer with the configuration.
+func New(config Config) (*Renamer, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return &Renamer{tables: config.Tables, columns: config.Columns}, nil
+}
+
+// Wrap returns the read function that renames the result before calling f, e.g. with the Read method of a sink.
+func (r *Renamer) Wrap(f func(result *changestreams.ReadResult) error) func(result *changestreams.ReadResult) error {
+	return func(result *changestreams.ReadResult) error {
+		if err := r.Rename(result); err != nil {
+			return err
+		}
+		return f(result)
+	}
+}
+
+// Rename renames the tables of the data change records, and the columns in the column types, the keys, the new
+// and old values and the rows attached by the enrich package of the mods. The enricher must run before Rename,
+// since it reads the rows by the names in Cloud Spanner.
+func (r *Renamer) Rename(result *changestreams.ReadResult) error {
+	for record := range result.DataChangeRecords() {
+		columns := r.columns[record.TableName]
+		if len(columns) > 0 {
+			for _, c := range record.ColumnTypes {
+				if to, ok := columns[c.Name]; ok {
+					c.Name = to
+				}
+			}
+			for _, mod := range record.Mods {
+				renameColumns(mod.Keys.Value, columns)
+				renameColumns(mod.NewValues.Value, columns)
+				renameColumns(mod.OldValues.Value, columns)
+				if len(mod.Row) > 0 {
+					row, err := renameRow(mod.Row, columns)
+					if err != nil {
+						return fmt.Errorf("failed to rename the row of table %s: %w", record.TableName, err)
+					}
+					mod.Row = row
+				}
+			}
+		}
+		if to, ok := r.tables[record.TableName]; ok {
+			record.TableName = to
+		}
+	}
+	return nil
+}
+
+// renameColumns renames the columns of the values in place. The values that are not JSON objects,
+// e.g. NULL, are left as they are.
+func renameColumns(v interface{}, columns map[string]string) {
+	values, ok := v.(map[string]interface{})
+	if !ok {
+		return
+	}
+	renamed := make(map[string]interface{}, len(values))
+	for name, v := range values {
+		if to, ok := columns[name]; ok {
+			name = to
+		}
+		renamed[name] = v
+	}
+	clear(values)
+	for name, v := range renamed {
+		values[name] = v
+	}
+}
+
+func renameRow(row json.RawMessage, columns map[string]string) (json.RawMessage, error) {
+	d := json.NewDecoder(bytes.NewReader(row))
+	// The numbers keep their precision.
+	d.UseNumber()
+	var values map[string]interface{}
+	if err := d.Decode(&values); err != nil {
+		return nil, err
+	}
+	if values == nil {
+		return row, nil
+	}
+	renameColumns(values, columns)
+	return json.Marshal(values)
+}
@@ -0,0 +1,133 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package rename
+
+import (
+	"encoding/json"
+	"testing"
+
+	spannerclient "cloud.google.com/go/spanner"
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRenamer(t *testing.T) {
+	jsonValue := func(v interface{}) spannerclient.NullJSON {
+		return spannerclient.NullJSON{Value: v, Valid: true}
+	}
+	newRecord := func(table string) *changestreams.DataChangeRecord {
+		return &changestreams.DataChangeRecord{
+			TableName: table,
+			ModType:   "UPDATE",
+			ColumnTypes: []*changestreams.ColumnType{
+				{Name: "order_id", Type: jsonValue(map[string]interface{}{"code": "INT64"}), IsPrimaryKey: true},
+				{Name: "total_price", Type: jsonValue(map[string]interface{}{"code": "NUMERIC"})},
+				{Name: "note", Type: jsonValue(map[string]interface{}{"code": "STRING"})},
+			},
+			Mods: []*changestreams.Mod{{
+				Keys:      jsonValue(map[string]interface{}{"order_id": "1"}),
+				NewValues: jsonValue(map[string]interface{}{"total_price": "1.5", "note": "new"}),
+				OldValues: jsonValue(map[string]interface{}{"total_price": "1.0", "note": nil}),
+				Row:       json.RawMessage(`{"note":"new","order_id":"1","total_price":12.345678901234567890}`),
+			}},
+		}
+	}
+	orders, items := newRecord("Orders"), newRecord("Items")
+	result := &changestreams.ReadResult{ChangeRecords: []*changestreams.ChangeRecord{
+		{DataChangeRecords: []*changestreams.DataChangeRecord{orders, items}},
+	}}
+
+	config, err := ParseConfig([]string{"Orders.order_id=orderId", "Orders.total_price=totalPrice"}, []string{"Orders=orders_v2"})
+	if err != nil {
+		t.Fatalf("ParseConfig error: %v", err)
+	}
+	r, err := New(config)
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	if err := r.Rename(result); err != nil {
+		t.Fatalf("Rename error: %v", err)
+	}
+
+	if orders.TableName != "orders_v2" {
+		t.Errorf("table name = %q, want %q", orders.TableName, "orders_v2")
+	}
+	var columns []string
+	for _, c := range orders.ColumnTypes {
+		columns = append(columns, c.Name)
+	}
+	if diff := cmp.Diff(columns, []string{"orderId", "totalPrice", "note"}); diff != "" {
+		t.Errorf("column types diff = %v", diff)
+	}
+	mod := orders.Mods[0]
+	if diff := cmp.Diff(mod.Keys.Value, map[string]interface{}{"orderId": "1"}); diff != "" {
+		t.Errorf("keys diff = %v", diff)
+	}
+	if diff := cmp.Diff(mod.NewValues.Value, map[string]interface{}{"totalPrice": "1.5", "note": "new"}); diff != "" {
+		t.Errorf("new values diff = %v", diff)
+	}
+	if diff := cmp.Diff(mod.OldValues.Value, map[string]interface{}{"totalPrice": "1.0", "note": nil}); diff != "" {
+		t.Errorf("old values diff = %v", diff)
+	}
+	// The numbers of the row keep their precision.
+	if diff := cmp.Diff(string(mod.Row), `{"note":"new","orderId":"1","totalPrice":12.345678901234567890}`); diff != "" {
+		t.Errorf("row diff = %v", diff)
+	}
+
+	// The unmapped table passes through unchanged.
+	if diff := cmp.Diff(items, newRecord("Items")); diff != "" {
+		t.Errorf("unmapped record diff = %v", diff)
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	for _, test := range []struct {
+		desc    string
+		columns []string
+		tables  []string
+	}{
+		{desc: "column without table", columns: []string{"order_id=orderId"}},
+		{desc: "column without name", columns: []string{"Orders.order_id"}},
+		{desc: "column renamed twice", columns: []string{"Orders.order_id=orderId", "Orders.order_id=id"}},
+		{desc: "table without name", tables: []string{"Orders"}},
+		{desc: "table renamed twice", tables: []string{"Orders=orders_v2", "Orders=orders_v3"}},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			if _, err := ParseConfig(test.columns, test.tables); err == nil {
+				t.Error("ParseConfig must fail")
+			}
+		})
+	}
+}
+
+func TestNewValidatesConfig(t *testing.T) {
+	for _, test := range []struct {
+		desc   string
+		config Config
+	}{
+		{desc: "empty column name", config: Config{Columns: map[string]map[string]string{"Orders": {"order_id": ""}}}},
+		{desc: "same column names", config: Config{Columns: map[string]map[string]string{"Orders": {"order_id": "id", "OrderId": "id"}}}},
+		{desc: "empty table name", config: Config{Tables: map[string]string{"": "orders"}}},
+		{desc: "same table names", config: Config{Tables: map[string]string{"Orders": "orders", "LegacyOrders": "orders"}}},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			if _, err := New(test.config); err == nil {
+				t.Error("New must fail")
+			}
+		})
+	}
+}
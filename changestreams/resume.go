@@ -0,0 +1,90 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// staleResumeMargin is added to the earliest retained timestamp when the start is advanced by StaleResumeAdvance,
+// so that the start is still retained when the query reaches Cloud Spanner.
+const staleResumeMargin = time.Minute
+
+// StaleResumePolicy decides what happens when the read is resumed from a start older than the maximum age,
+// e.g. after a long outage, since the records older than the retention period no longer exist.
+type StaleResumePolicy int
+
+const (
+	// StaleResumeUnchecked reads from the start as it is, and Cloud Spanner decides whether the start is retained.
+	StaleResumeUnchecked StaleResumePolicy = iota
+	// StaleResumeFail fails the read before any query.
+	StaleResumeFail
+	// StaleResumeAdvance advances the start to the earliest retained timestamp, logs a warning and reports
+	// the gap to Config.OnResumeGap. With Config.ResumeFrom, the checkpoint is discarded and the read starts
+	// from the initial query, since the partitions of the checkpoint are no longer retained either.
+	StaleResumeAdvance
+)
+
+// resolveStaleResume applies the stale resume policy to the start of the read. It returns the start, which is
+// zero if the read resumes from the checkpoint, and whether the checkpoint is still used.
+func (r *Reader) resolveStaleResume(ctx context.Context, now, start time.Time) (time.Time, bool, error) {
+	resume := r.resumeFrom != nil
+	if r.staleResumePolicy == StaleResumeUnchecked {
+		return start, resume, nil
+	}
+	oldest := start
+	if resume {
+		oldest = time.Time{}
+		for _, p := range r.resumeFrom.PendingPartitions {
+			if oldest.IsZero() || p.StartTimestamp.Before(oldest) {
+				oldest = p.StartTimestamp
+			}
+		}
+		if oldest.IsZero() {
+			return start, resume, nil
+		}
+	}
+
+	maxAge := r.maxResumeAge
+	if maxAge == 0 {
+		retention, err := retentionPeriod(ctx, r.client, r.dialect, r.streamID)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("failed to get the retention period of the change stream: %w", err)
+		}
+		maxAge = retention
+	}
+	earliest := now.Add(-maxAge)
+	if !oldest.Before(earliest) {
+		return start, resume, nil
+	}
+	if r.staleResumePolicy == StaleResumeFail {
+		return time.Time{}, false, fmt.Errorf("start timestamp %s is older than the maximum age %s", oldest.Format(time.RFC3339Nano), maxAge)
+	}
+
+	advanced := earliest.Add(staleResumeMargin)
+	if advanced.After(now) {
+		advanced = now
+	}
+	r.logger.Warn("start timestamp is older than the maximum age, advanced to the earliest retained timestamp", "event", "stale_resume_advanced",
+		"start_timestamp", oldest, "advanced_start_timestamp", advanced, "max_age", maxAge, "checkpoint_discarded", resume)
+	if r.onResumeGap != nil {
+		r.onResumeGap(oldest, advanced)
+	}
+	return advanced, false, nil
+}
@@ -0,0 +1,80 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"time"
+)
+
+// resumePoint is the finest point the query of a partition can be resumed from after an interruption.
+// The query can only restart from a timestamp, so the resume point also remembers the data change records at
+// that timestamp that have been delivered, which are skipped when the query returns them again, e.g. the first
+// half of a transaction delivered before the interruption.
+type resumePoint struct {
+	timestamp time.Time
+	// delivered is the set of the server transaction IDs and the record sequences of the data change records
+	// delivered at timestamp.
+	delivered map[string]bool
+}
+
+func resumePointKey(record *DataChangeRecord) string {
+	return record.ServerTransactionID + "/" + record.RecordSequence
+}
+
+// dataChangeRecords returns the data change records of the result.
+func dataChangeRecords(result *ReadResult) []*DataChangeRecord {
+	var records []*DataChangeRecord
+	for _, changeRecord := range result.ChangeRecords {
+		records = append(records, changeRecord.DataChangeRecords...)
+	}
+	return records
+}
+
+// advance must be called after the records have been delivered. The records of a partition arrive in
+// commit timestamp order.
+func (p *resumePoint) advance(records []*DataChangeRecord) {
+	for _, record := range records {
+		if record.CommitTimestamp.After(p.timestamp) {
+			p.timestamp = record.CommitTimestamp
+			p.delivered = make(map[string]bool)
+		}
+		if record.CommitTimestamp.Equal(p.timestamp) {
+			p.delivered[resumePointKey(record)] = true
+		}
+	}
+}
+
+// skipDelivered removes the data change records that have already been delivered from the result,
+// and returns the number of the removed records.
+func (p *resumePoint) skipDelivered(result *ReadResult) int {
+	if len(p.delivered) == 0 {
+		return 0
+	}
+	var skipped int
+	for _, changeRecord := range result.ChangeRecords {
+		records := changeRecord.DataChangeRecords[:0]
+		for _, record := range changeRecord.DataChangeRecords {
+			if !record.CommitTimestamp.After(p.timestamp) && p.delivered[resumePointKey(record)] {
+				skipped++
+				continue
+			}
+			records = append(records, record)
+		}
+		changeRecord.DataChangeRecords = records
+	}
+	return skipped
+}
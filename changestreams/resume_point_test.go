@@ -0,0 +1,72 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestResumePoint(t *testing.T) {
+	ts := time.Date(2023, 2, 24, 17, 0, 0, 0, time.UTC)
+	record := func(commitTimestamp time.Time, txnID, recordSequence string) *DataChangeRecord {
+		return &DataChangeRecord{CommitTimestamp: commitTimestamp, ServerTransactionID: txnID, RecordSequence: recordSequence}
+	}
+	result := func(records ...*DataChangeRecord) *ReadResult {
+		return &ReadResult{ChangeRecords: []*ChangeRecord{{DataChangeRecords: records}}}
+	}
+	keys := func(result *ReadResult) []string {
+		var keys []string
+		for _, record := range dataChangeRecords(result) {
+			keys = append(keys, record.CommitTimestamp.Format(time.TimeOnly)+" "+resumePointKey(record))
+		}
+		return keys
+	}
+
+	p := &resumePoint{}
+	// Nothing is skipped before any record is delivered.
+	first := result(record(ts.Add(-time.Second), "a", "00000000"), record(ts, "b", "00000000"))
+	if skipped := p.skipDelivered(first); skipped != 0 {
+		t.Errorf("skipped = %d, want 0", skipped)
+	}
+	p.advance(dataChangeRecords(first))
+	// The query is interrupted in the middle of transaction b.
+	p.advance(dataChangeRecords(result(record(ts, "b", "00000001"))))
+
+	// The retry from the watermark returns all the records of transaction b, and transaction c at the same timestamp.
+	retried := result(record(ts, "b", "00000000"), record(ts, "b", "00000001"), record(ts, "b", "00000002"), record(ts, "c", "00000000"))
+	if skipped := p.skipDelivered(retried); skipped != 2 {
+		t.Errorf("skipped = %d, want 2", skipped)
+	}
+	if diff := cmp.Diff(keys(retried), []string{"17:00:00 b/00000002", "17:00:00 c/00000000"}); diff != "" {
+		t.Errorf("records diff = %v", diff)
+	}
+	p.advance(dataChangeRecords(retried))
+
+	// The records at a later timestamp reset the resume point.
+	later := result(record(ts.Add(time.Second), "d", "00000000"))
+	p.advance(dataChangeRecords(later))
+	again := result(record(ts.Add(time.Second), "d", "00000000"), record(ts.Add(time.Second), "d", "00000001"))
+	if skipped := p.skipDelivered(again); skipped != 1 {
+		t.Errorf("skipped = %d, want 1", skipped)
+	}
+	if diff := cmp.Diff(keys(again), []string{"17:00:01 d/00000001"}); diff != "" {
+		t.Errorf("records diff = %v", diff)
+	}
+}
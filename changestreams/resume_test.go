@@ -0,0 +1,114 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestStaleResume(t *testing.T) {
+	ctx := context.Background()
+	read := func(result *ReadResult) error { return nil }
+	for _, test := range []struct {
+		desc       string
+		config     Config
+		wantErr    bool
+		wantTokens []string
+		wantGap    bool
+	}{
+		{
+			desc:       "unchecked",
+			config:     Config{StartTimestamp: time.Now().Add(-3 * time.Hour)},
+			wantTokens: []string{""},
+		},
+		{
+			desc:       "within retention period",
+			config:     Config{StartTimestamp: time.Now().Add(-time.Hour), OnStaleResume: StaleResumeFail},
+			wantTokens: []string{""},
+		},
+		{
+			desc:    "fail",
+			config:  Config{StartTimestamp: time.Now().Add(-3 * time.Hour), OnStaleResume: StaleResumeFail},
+			wantErr: true,
+		},
+		{
+			desc:    "fail beyond max age",
+			config:  Config{StartTimestamp: time.Now().Add(-time.Hour), OnStaleResume: StaleResumeFail, MaxResumeAge: 30 * time.Minute},
+			wantErr: true,
+		},
+		{
+			desc:       "advance",
+			config:     Config{StartTimestamp: time.Now().Add(-3 * time.Hour), OnStaleResume: StaleResumeAdvance},
+			wantTokens: []string{""},
+			wantGap:    true,
+		},
+		{
+			desc: "advance discards checkpoint",
+			config: Config{
+				OnStaleResume: StaleResumeAdvance,
+				ResumeFrom: &Checkpoint{PendingPartitions: []*PendingPartition{
+					{Token: "a", StartTimestamp: time.Now().Add(-3 * time.Hour)},
+					{Token: "b", StartTimestamp: time.Now()},
+				}},
+			},
+			wantTokens: []string{""},
+			wantGap:    true,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			server, opts := newFakeSpannerServer(t)
+			server.retentionPeriod = "2h"
+			config := test.config
+			config.SpannerClientOptions = opts
+			var from, to time.Time
+			config.OnResumeGap = func(f, t time.Time) { from, to = f, t }
+			r, err := NewReaderWithConfig(ctx, "project", "instance", "database", "stream", config)
+			if err != nil {
+				t.Fatalf("NewReaderWithConfig error: %v", err)
+			}
+			defer r.Close()
+
+			started := time.Now()
+			err = r.Read(ctx, read)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Read error = %v, wantErr %v", err, test.wantErr)
+			}
+			server.mu.Lock()
+			defer server.mu.Unlock()
+			if diff := cmp.Diff(server.readTokens, test.wantTokens); diff != "" {
+				t.Errorf("read tokens diff = %v", diff)
+			}
+			if !test.wantGap {
+				if !from.IsZero() {
+					t.Errorf("OnResumeGap must not be called, but called with %s", from)
+				}
+				return
+			}
+			wantTo := started.Add(-2*time.Hour + staleResumeMargin)
+			if to.Before(wantTo) || to.After(time.Now().Add(-2*time.Hour+staleResumeMargin)) || !from.Before(to) {
+				t.Errorf("OnResumeGap = (%s, %s), want the gap to the earliest retained timestamp %s", from, to, wantTo)
+			}
+			if got, want := server.readStartTimestamps[0], to.UTC().Format(time.RFC3339Nano); got != want {
+				t.Errorf("start timestamp = %s, want %s", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/spanner"
+)
+
+// defaultRetentionPeriod is the retention period of a change stream without the retention_period option.
+const defaultRetentionPeriod = 24 * time.Hour
+
+// earliestStartBuffer is the margin of Config.StartFromEarliest after the oldest retained timestamp, since
+// the records keep being garbage collected while the query starts.
+const earliestStartBuffer = time.Minute
+
+// earliestStartOffset returns the start offset of Config.StartFromEarliest for the retention period.
+func earliestStartOffset(retention time.Duration) time.Duration {
+	// A retention period shorter than the buffer is not practical, but the start is still in it.
+	buffer := min(earliestStartBuffer, retention/2)
+	return retention - buffer
+}
+
+// retentionPeriod returns the retention period of the change stream.
+func retentionPeriod(ctx context.Context, client *spanner.Client, d dialect, streamID string) (time.Duration, error) {
+	var stmt spanner.Statement
+	switch d {
+	case dialectGoogleSQL:
+		stmt = spanner.Statement{
+			SQL:    "SELECT option_value FROM information_schema.change_stream_options WHERE change_stream_name = @stream AND option_name = 'retention_period'",
+			Params: map[string]interface{}{"stream": streamID},
+		}
+	case dialectPostgreSQL:
+		stmt = spanner.Statement{
+			SQL:    "SELECT option_value FROM information_schema.change_stream_options WHERE change_stream_name = $1 AND option_name = 'retention_period'",
+			Params: map[string]interface{}{"p1": streamID},
+		}
+	default:
+		return 0, fmt.Errorf("unexpected dialect: %s", d)
+	}
+
+	var value string
+	if err := client.Single().Query(ctx, stmt).Do(func(r *spanner.Row) error {
+		return r.ColumnByName("option_value", &value)
+	}); err != nil {
+		return 0, err
+	}
+	if value == "" {
+		return defaultRetentionPeriod, nil
+	}
+	return parseRetentionPeriod(value)
+}
+
+// parseRetentionPeriod parses the retention_period option of the change stream, e.g. "7d", "36h", "60m" or "3600s".
+func parseRetentionPeriod(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid retention period: %q", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid retention period: %q", value)
+	}
+	return d, nil
+}
@@ -0,0 +1,120 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseRetentionPeriod(t *testing.T) {
+	for _, test := range []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "7d", want: 7 * 24 * time.Hour},
+		{value: "36h", want: 36 * time.Hour},
+		{value: "60m", want: time.Hour},
+		{value: "3600s", want: time.Hour},
+		{value: "d", wantErr: true},
+		{value: "1w", wantErr: true},
+	} {
+		t.Run(test.value, func(t *testing.T) {
+			got, err := parseRetentionPeriod(test.value)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("parseRetentionPeriod error = %v, wantErr %v", err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("parseRetentionPeriod = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestStartOffset(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range []struct {
+		desc            string
+		retentionPeriod string
+		config          Config
+		wantErr         bool
+	}{
+		{desc: "within retention period", retentionPeriod: "2h", config: Config{StartOffset: time.Hour}},
+		{desc: "exceeds retention period", retentionPeriod: "2h", config: Config{StartOffset: 3 * time.Hour}, wantErr: true},
+		{desc: "within default retention period", config: Config{StartOffset: 23 * time.Hour}},
+		{desc: "exceeds default retention period", config: Config{StartOffset: 25 * time.Hour}, wantErr: true},
+		{desc: "negative", config: Config{StartOffset: -time.Hour}, wantErr: true},
+		{desc: "with StartTimestamp", config: Config{StartOffset: time.Hour, StartTimestamp: time.Now()}, wantErr: true},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			server, opts := newFakeSpannerServer(t)
+			server.retentionPeriod = test.retentionPeriod
+			config := test.config
+			config.SpannerClientOptions = opts
+			r, err := NewReaderWithConfig(ctx, "project", "instance", "database", "stream", config)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("NewReaderWithConfig error = %v, wantErr %v", err, test.wantErr)
+			}
+			if r != nil {
+				r.Close()
+			}
+		})
+	}
+}
+
+func TestStartFromEarliest(t *testing.T) {
+	if got, want := earliestStartOffset(36*time.Hour), 36*time.Hour-time.Minute; got != want {
+		t.Errorf("earliestStartOffset(36h) = %s, want %s", got, want)
+	}
+	if got, want := earliestStartOffset(time.Minute), 30*time.Second; got != want {
+		t.Errorf("earliestStartOffset(1m) = %s, want %s", got, want)
+	}
+
+	ctx := context.Background()
+	server, opts := newFakeSpannerServer(t)
+	server.retentionPeriod = "36h"
+	if _, err := NewReaderWithConfig(ctx, "project", "instance", "database", "stream", Config{
+		StartFromEarliest:    true,
+		StartOffset:          time.Hour,
+		SpannerClientOptions: opts,
+	}); err == nil {
+		t.Error("StartFromEarliest with StartOffset must fail")
+	}
+
+	now := time.Now()
+	r, err := NewReaderWithConfig(ctx, "project", "instance", "database", "stream", Config{
+		StartFromEarliest:    true,
+		EndTimestamp:         now,
+		SpannerClientOptions: opts,
+	})
+	if err != nil {
+		t.Fatalf("NewReaderWithConfig error: %v", err)
+	}
+	defer r.Close()
+	if err := r.Read(ctx, func(result *ReadResult) error { return nil }); err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	start, err := time.Parse(time.RFC3339Nano, server.readStartTimestamps[0])
+	if err != nil {
+		t.Fatalf("invalid start timestamp: %v", err)
+	}
+	if want := now.Add(-36*time.Hour + time.Minute); start.Before(want) || start.After(want.Add(time.Minute)) {
+		t.Errorf("start timestamp = %v, want about %v", start, want)
+	}
+}
@@ -0,0 +1,140 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	retryInitialBackoff = time.Second
+	retryMaxBackoff     = 30 * time.Second
+
+	defaultInitMaxAttempts    = 5
+	defaultInitAttemptTimeout = 10 * time.Second
+	defaultConnectTimeout     = 30 * time.Second
+)
+
+// retryBackoff returns the backoff before the retry after the given number of the failures.
+func retryBackoff(failures int) time.Duration {
+	backoff := retryInitialBackoff
+	for i := 1; i < failures && backoff < retryMaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, retryMaxBackoff)
+}
+
+// sleep waits for d, and returns false if ctx is done in the meantime.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// isRetryableInitError returns true if the error of creating the client or detecting the dialect is transient.
+func isRetryableInitError(err error) bool {
+	// The error may be wrapped, and spanner.ErrCode doesn't unwrap it.
+	var grpcErr interface{ GRPCStatus() *status.Status }
+	if !errors.As(err, &grpcErr) {
+		return false
+	}
+	switch grpcErr.GRPCStatus().Code() {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted, codes.Internal:
+		return true
+	default:
+		return false
+	}
+}
+
+// connect creates the client and detects the dialect of the database, retrying the transient errors
+// up to maxAttempts attempts. Each attempt to detect the dialect times out after attemptTimeout, because
+// the client retries some errors such as Unavailable by itself until the context is done.
+func connect(ctx context.Context, dbPath string, clientConfig spanner.ClientConfig, opts []option.ClientOption,
+	maxAttempts int, attemptTimeout time.Duration, logger *slog.Logger) (*spanner.Client, dialect, error) {
+	var client *spanner.Client
+	for attempt := 1; ; attempt++ {
+		d, err := func() (dialect, error) {
+			if client == nil {
+				c, err := spanner.NewClientWithConfig(ctx, dbPath, clientConfig, opts...)
+				if err != nil {
+					return dialectUnknown, err
+				}
+				client = c
+			}
+			attemptCtx, cancel := context.WithTimeout(ctx, attemptTimeout)
+			defer cancel()
+			d, err := detectDialect(attemptCtx, client)
+			if err != nil {
+				return dialectUnknown, fmt.Errorf("failed to detect dialect: %w", err)
+			}
+			return d, nil
+		}()
+		if err == nil {
+			return client, d, nil
+		}
+
+		if attempt >= maxAttempts || ctx.Err() != nil || !isRetryableInitError(err) {
+			if client != nil {
+				client.Close()
+			}
+			return nil, dialectUnknown, err
+		}
+		backoff := retryBackoff(attempt)
+		logger.Warn("failed to initialize the reader, retrying", "event", "init_retried",
+			"attempt", attempt, "backoff", backoff, "error", err)
+		if !sleep(ctx, backoff) {
+			if client != nil {
+				client.Close()
+			}
+			return nil, dialectUnknown, err
+		}
+	}
+}
+
+// detectDialectWithRetry detects the dialect of the database of the client, retrying the transient errors
+// up to maxAttempts attempts.
+func detectDialectWithRetry(ctx context.Context, client *spanner.Client, maxAttempts int, attemptTimeout time.Duration) (dialect, error) {
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, attemptTimeout)
+		d, err := detectDialect(attemptCtx, client)
+		cancel()
+		if err == nil {
+			return d, nil
+		}
+		err = fmt.Errorf("failed to detect dialect: %w", err)
+		if attempt >= maxAttempts || ctx.Err() != nil || !isRetryableInitError(err) {
+			return dialectUnknown, err
+		}
+		if !sleep(ctx, retryBackoff(attempt)) {
+			return dialectUnknown, err
+		}
+	}
+}
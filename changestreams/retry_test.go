@@ -0,0 +1,114 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	for failures, want := range map[int]time.Duration{
+		1:  time.Second,
+		2:  2 * time.Second,
+		3:  4 * time.Second,
+		10: 30 * time.Second,
+	} {
+		if got := retryBackoff(failures); got != want {
+			t.Errorf("retryBackoff(%d) = %v, want %v", failures, got, want)
+		}
+	}
+}
+
+func TestNewReaderWithConfigRetry(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("transient error is retried", func(t *testing.T) {
+		server, opts := newFakeSpannerServer(t)
+		server.dialectErrors = []error{status.Error(codes.ResourceExhausted, "quota exceeded")}
+		var logs bytes.Buffer
+		r, err := NewReaderWithConfig(ctx, "project", "instance", "database", "stream", Config{
+			SpannerClientOptions: opts,
+			Logger:               slog.New(slog.NewTextHandler(&logs, nil)),
+		})
+		if err != nil {
+			t.Fatalf("NewReaderWithConfig error: %v", err)
+		}
+		r.Close()
+		if server.dialectQueries != 2 {
+			t.Errorf("dialect queries = %d, want 2", server.dialectQueries)
+		}
+		if !strings.Contains(logs.String(), "event=init_retried") {
+			t.Errorf("retry must be logged, got %q", logs.String())
+		}
+	})
+
+	t.Run("permanent error fails immediately", func(t *testing.T) {
+		server, opts := newFakeSpannerServer(t)
+		server.dialectErrors = []error{status.Error(codes.PermissionDenied, "permission denied")}
+		if _, err := NewReaderWithConfig(ctx, "project", "instance", "database", "stream", Config{
+			SpannerClientOptions: opts,
+		}); spanner.ErrCode(errors.Unwrap(err)) != codes.PermissionDenied {
+			t.Errorf("NewReaderWithConfig error = %v, want PermissionDenied", err)
+		}
+		if server.dialectQueries != 1 {
+			t.Errorf("dialect queries = %d, want 1", server.dialectQueries)
+		}
+	})
+}
+
+func TestConnectTimeout(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("blackholed", func(t *testing.T) {
+		server, opts := newFakeSpannerServer(t)
+		server.blackholed = true
+		_, err := NewReaderWithConfig(ctx, "project", "instance", "database", "stream", Config{
+			SpannerClientOptions: opts,
+			ConnectTimeout:       100 * time.Millisecond,
+		})
+		if err == nil || !strings.Contains(err.Error(), "failed to connect to Spanner within 100ms") {
+			t.Errorf("NewReaderWithConfig error = %v, want the connect timeout", err)
+		}
+	})
+
+	t.Run("read queries are not bounded", func(t *testing.T) {
+		_, opts := newFakeSpannerServer(t)
+		r, err := NewReaderWithConfig(ctx, "project", "instance", "database", "stream", Config{
+			EndTimestamp:         time.Now(),
+			SpannerClientOptions: opts,
+			ConnectTimeout:       100 * time.Millisecond,
+		})
+		if err != nil {
+			t.Fatalf("NewReaderWithConfig error: %v", err)
+		}
+		defer r.Close()
+		time.Sleep(200 * time.Millisecond)
+		if err := r.Read(ctx, func(result *ReadResult) error { return nil }); err != nil {
+			t.Errorf("Read error: %v", err)
+		}
+	})
+}
@@ -0,0 +1,60 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"math/rand/v2"
+	"sync/atomic"
+)
+
+// recordSampler delivers 1 of every rate data change records, and drops the rest.
+type recordSampler struct {
+	rate   int64
+	random bool
+	// count is the number of the data change records sampled so far, shared by all the partitions.
+	count atomic.Int64
+}
+
+func newRecordSampler(rate int, random bool) *recordSampler {
+	if rate <= 1 {
+		return nil
+	}
+	return &recordSampler{rate: int64(rate), random: random}
+}
+
+// sample drops the data change records that are not sampled. The heartbeat and child partitions records are
+// always kept. It's a no-op on a nil sampler.
+func (s *recordSampler) sample(changeRecord *ChangeRecord) {
+	if s == nil {
+		return
+	}
+	records := changeRecord.DataChangeRecords[:0]
+	for _, record := range changeRecord.DataChangeRecords {
+		if s.sampled() {
+			records = append(records, record)
+		}
+	}
+	changeRecord.DataChangeRecords = records
+}
+
+func (s *recordSampler) sampled() bool {
+	if s.random {
+		return rand.Int64N(s.rate) == 0
+	}
+	// The first record is always delivered.
+	return (s.count.Add(1)-1)%s.rate == 0
+}
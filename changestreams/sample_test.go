@@ -0,0 +1,85 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRecordSampler(t *testing.T) {
+	newChangeRecord := func(sequences ...string) *ChangeRecord {
+		changeRecord := &ChangeRecord{
+			HeartbeatRecords:       []*HeartbeatRecord{{}},
+			ChildPartitionsRecords: []*ChildPartitionsRecord{{}},
+		}
+		for _, sequence := range sequences {
+			changeRecord.DataChangeRecords = append(changeRecord.DataChangeRecords, &DataChangeRecord{RecordSequence: sequence})
+		}
+		return changeRecord
+	}
+	sequences := func(changeRecord *ChangeRecord) []string {
+		var sequences []string
+		for _, record := range changeRecord.DataChangeRecords {
+			sequences = append(sequences, record.RecordSequence)
+		}
+		return sequences
+	}
+
+	t.Run("every Nth record", func(t *testing.T) {
+		s := newRecordSampler(3, false)
+		first := newChangeRecord("0", "1", "2", "3")
+		second := newChangeRecord("4", "5", "6")
+		s.sample(first)
+		s.sample(second)
+		if diff := cmp.Diff(sequences(first), []string{"0", "3"}); diff != "" {
+			t.Errorf("first diff = %v", diff)
+		}
+		// The count continues across the change records.
+		if diff := cmp.Diff(sequences(second), []string{"6"}); diff != "" {
+			t.Errorf("second diff = %v", diff)
+		}
+		if len(first.HeartbeatRecords) != 1 || len(first.ChildPartitionsRecords) != 1 {
+			t.Error("heartbeat and child partitions records must be kept")
+		}
+	})
+
+	t.Run("random", func(t *testing.T) {
+		s := newRecordSampler(10, true)
+		var sampled int
+		for i := 0; i < 1000; i++ {
+			changeRecord := newChangeRecord("0")
+			s.sample(changeRecord)
+			sampled += len(changeRecord.DataChangeRecords)
+		}
+		if sampled == 0 || sampled >= 500 {
+			t.Errorf("sampled %d of 1000 records with rate 10", sampled)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		for _, rate := range []int{0, 1} {
+			s := newRecordSampler(rate, false)
+			changeRecord := newChangeRecord("0", "1")
+			s.sample(changeRecord)
+			if diff := cmp.Diff(sequences(changeRecord), []string{"0", "1"}); diff != "" {
+				t.Errorf("rate %d diff = %v", rate, diff)
+			}
+		}
+	})
+}
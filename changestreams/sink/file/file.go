@@ -0,0 +1,235 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package file implements the sink that writes the change records to local files partitioned by
+// the commit timestamp in the Hive-style directory layout.
+package file
+
+import (
+	"bufio"
+	"container/list"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams"
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams/sink"
+)
+
+const defaultMaxOpenFiles = 16
+
+// Partitioning is the granularity of the directories of the commit timestamps.
+type Partitioning int
+
+const (
+	// PartitionByDay writes the events to dt=YYYY-MM-DD directories.
+	PartitionByDay Partitioning = iota
+	// PartitionByHour writes the events to dt=YYYY-MM-DD/hr=HH directories.
+	PartitionByHour
+)
+
+// ParsePartitioning parses the partitioning of "day" or "hour".
+func ParsePartitioning(s string) (Partitioning, error) {
+	switch s {
+	case "day":
+		return PartitionByDay, nil
+	case "hour":
+		return PartitionByHour, nil
+	default:
+		return 0, fmt.Errorf("invalid partitioning: %q", s)
+	}
+}
+
+// dir returns the directory of the commit timestamp relative to Config.Dir.
+func (p Partitioning) dir(commitTimestamp time.Time) string {
+	t := commitTimestamp.UTC()
+	if p == PartitionByHour {
+		return filepath.Join("dt="+t.Format("2006-01-02"), "hr="+t.Format("15"))
+	}
+	return "dt=" + t.Format("2006-01-02")
+}
+
+// Config is the configuration for the file sink.
+type Config struct {
+	// Dir is the root directory of the partitions. It's created if it doesn't exist.
+	Dir string
+	// Partitioning is the granularity of the partitions. By default, the events are partitioned by day.
+	Partitioning Partitioning
+	// FileName is the name of the file in each partition. If FileName is empty, part-<nanoseconds>.jsonl of
+	// the time the sink was created is used, so that the files of the different runs never mix.
+	FileName string
+	// MaxOpenFiles is the maximum number of the files kept open at a time, since the records arrive out of
+	// order across the partitions of the change stream. The least recently written file is closed when
+	// another one has to be opened, and is appended to if it's written again. If MaxOpenFiles is zero, 16 is used.
+	MaxOpenFiles int
+}
+
+type openFile struct {
+	path string
+	f    *os.File
+	w    *bufio.Writer
+}
+
+// Sink writes each mod of the data change records to the file of the partition of its commit timestamp as
+// a line of sink.Event in JSON, e.g. changes/dt=2023-02-24/hr=17/part-1677258000000000000.jsonl, so that data lake
+// consumers such as BigQuery external tables and Spark can prune the partitions.
+//
+// The events of each result are flushed to the files before Read returns. Read may be called concurrently
+// for the different partitions.
+type Sink struct {
+	config Config
+	// mu serializes the writes and guards the open files.
+	mu    sync.Mutex
+	files map[string]*list.Element
+	// lru has the open files in the order they have been written, the most recent first.
+	lru *list.List
+}
+
+// New creates the root directory and returns the sink.
+func New(config Config) (*Sink, error) {
+	if config.Dir == "" {
+		return nil, errors.New("directory of the files must be specified")
+	}
+	if config.MaxOpenFiles < 0 {
+		return nil, fmt.Errorf("invalid max open files: %d", config.MaxOpenFiles)
+	}
+	if config.MaxOpenFiles == 0 {
+		config.MaxOpenFiles = defaultMaxOpenFiles
+	}
+	if config.FileName == "" {
+		config.FileName = fmt.Sprintf("part-%d.jsonl", time.Now().UnixNano())
+	}
+	if config.FileName != filepath.Base(config.FileName) {
+		return nil, fmt.Errorf("invalid file name: %q", config.FileName)
+	}
+	if err := os.MkdirAll(config.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Sink{config: config, files: make(map[string]*list.Element), lru: list.New()}, nil
+}
+
+// NewFromURL creates a new file sink from the URL such as "file://changes" or
+// "file:///var/lib/changes?partition=hour&max_open_files=32". The partition parameter sets Config.Partitioning,
+// and max_open_files sets Config.MaxOpenFiles.
+func NewFromURL(rawURL string) (*Sink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	query := u.Query()
+	// A relative path is parsed as the host, e.g. "changes" of "file://changes".
+	config := Config{Dir: u.Host + u.Path}
+	if s := query.Get("partition"); s != "" {
+		if config.Partitioning, err = ParsePartitioning(s); err != nil {
+			return nil, err
+		}
+	}
+	if s := query.Get("max_open_files"); s != "" {
+		if config.MaxOpenFiles, err = strconv.Atoi(s); err != nil {
+			return nil, fmt.Errorf("invalid max_open_files: %w", err)
+		}
+	}
+	return New(config)
+}
+
+// Read writes the events of the result to the files of their partitions, and flushes them.
+func (s *Sink) Read(result *changestreams.ReadResult) error {
+	events, err := sink.Events(result)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	written := make(map[*openFile]bool)
+	for _, event := range events {
+		b, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		file, err := s.open(filepath.Join(s.config.Dir, s.config.Partitioning.dir(event.CommitTimestamp), s.config.FileName), written)
+		if err != nil {
+			return err
+		}
+		if _, err := file.w.Write(append(b, '\n')); err != nil {
+			return err
+		}
+		written[file] = true
+	}
+	for file := range written {
+		if err := file.w.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// open returns the open file of the path, opening it and closing the least recently written one if needed.
+// The files written in the current result are flushed when they're closed.
+func (s *Sink) open(path string, written map[*openFile]bool) (*openFile, error) {
+	if e, ok := s.files[path]; ok {
+		s.lru.MoveToFront(e)
+		return e.Value.(*openFile), nil
+	}
+	for s.lru.Len() >= s.config.MaxOpenFiles {
+		e := s.lru.Back()
+		file := e.Value.(*openFile)
+		s.lru.Remove(e)
+		delete(s.files, file.path)
+		delete(written, file)
+		if err := file.close(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	file := &openFile{path: path, f: f, w: bufio.NewWriter(f)}
+	s.files[path] = s.lru.PushFront(file)
+	return file, nil
+}
+
+func (f *openFile) close() error {
+	if err := f.w.Flush(); err != nil {
+		f.f.Close()
+		return err
+	}
+	return f.f.Close()
+}
+
+// Close flushes and closes all the open files.
+func (s *Sink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	for e := s.lru.Front(); e != nil; e = e.Next() {
+		errs = append(errs, e.Value.(*openFile).close())
+	}
+	s.files = make(map[string]*list.Element)
+	s.lru.Init()
+	return errors.Join(errs...)
+}
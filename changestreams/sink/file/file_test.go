@@ -0,0 +1,131 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package file
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams"
+	"github.com/google/go-cmp/cmp"
+)
+
+func newResult(commitTimestamp time.Time, playerID string) *changestreams.ReadResult {
+	return &changestreams.ReadResult{
+		PartitionToken: "token",
+		ChangeRecords: []*changestreams.ChangeRecord{
+			{
+				DataChangeRecords: []*changestreams.DataChangeRecord{
+					{
+						CommitTimestamp: commitTimestamp,
+						RecordSequence:  "00000000",
+						TableName:       "Players",
+						ModType:         "INSERT",
+						Mods: []*changestreams.Mod{
+							{
+								Keys:      spanner.NullJSON{Value: map[string]interface{}{"PlayerId": playerID}, Valid: true},
+								NewValues: spanner.NullJSON{Value: map[string]interface{}{}, Valid: true},
+								OldValues: spanner.NullJSON{Value: map[string]interface{}{}, Valid: true},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// readKeys returns the keys of the events in the file.
+func readKeys(t *testing.T, path string) []string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open %s: %v", path, err)
+	}
+	defer f.Close()
+	var keys []string
+	for scanner := bufio.NewScanner(f); scanner.Scan(); {
+		var event struct {
+			Keys json.RawMessage `json:"keys"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("invalid event %s: %v", scanner.Text(), err)
+		}
+		keys = append(keys, string(event.Keys))
+	}
+	return keys
+}
+
+func TestSink(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFromURL("file://" + dir + "?partition=hour&max_open_files=1")
+	if err != nil {
+		t.Fatalf("NewFromURL error: %v", err)
+	}
+	s.config.FileName = "part.jsonl"
+
+	// The records arrive out of order across the partitions, so the file of 17:00 is reopened.
+	for _, result := range []*changestreams.ReadResult{
+		newResult(time.Date(2023, 2, 24, 17, 59, 0, 0, time.UTC), "1"),
+		newResult(time.Date(2023, 2, 24, 18, 0, 0, 0, time.UTC), "2"),
+		newResult(time.Date(2023, 2, 24, 17, 0, 0, 0, time.FixedZone("PST", -8*60*60)), "3"),
+		newResult(time.Date(2023, 2, 24, 17, 30, 0, 0, time.UTC), "4"),
+	} {
+		if err := s.Read(result); err != nil {
+			t.Fatalf("Read error: %v", err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+
+	for path, want := range map[string][]string{
+		"dt=2023-02-24/hr=17/part.jsonl": {`{"PlayerId":"1"}`, `{"PlayerId":"4"}`},
+		"dt=2023-02-24/hr=18/part.jsonl": {`{"PlayerId":"2"}`},
+		"dt=2023-02-25/hr=01/part.jsonl": {`{"PlayerId":"3"}`},
+	} {
+		if diff := cmp.Diff(readKeys(t, filepath.Join(dir, path)), want); diff != "" {
+			t.Errorf("%s diff = %v", path, diff)
+		}
+	}
+}
+
+func TestNewFromURL(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFromURL("file://" + dir)
+	if err != nil {
+		t.Fatalf("NewFromURL error: %v", err)
+	}
+	if s.config.Partitioning != PartitionByDay || s.config.MaxOpenFiles != defaultMaxOpenFiles {
+		t.Errorf("config = %+v, want the defaults", s.config)
+	}
+
+	for _, rawURL := range []string{
+		"file://" + dir + "?partition=minute",
+		"file://" + dir + "?max_open_files=-1",
+		"file://",
+	} {
+		if _, err := NewFromURL(rawURL); err == nil {
+			t.Errorf("NewFromURL(%q) must fail", rawURL)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package kafka builds the Kafka messages of the change records, with the message keys and the headers derived from
+// the identity of each record. It doesn't include a producer, so the messages can be passed to any Kafka client,
+// whose partitioner places the messages of the same key in the same topic partition.
+//
+// It's not a sink: the messages are never written to Kafka by this package, and the command has no Kafka sink, since
+// it doesn't depend on a Kafka client. The delivery, the retries and the dead letters are up to the caller's client.
+package kafka
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams"
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams/sink"
+)
+
+// KeyMode selects the message key of the events.
+//
+// The keys are part of the compatibility of this package: the same row always has the same key bytes across the
+// releases, so that the messages of a row stay in the same topic partition after an upgrade.
+type KeyMode string
+
+const (
+	// KeyPK is the canonical string of the primary key, changestreams.Mod.PrimaryKeyString, e.g.
+	// `{"SingerId":"1"}`, which keeps the changes of each row in order within a topic partition.
+	KeyPK KeyMode = "pk"
+	// KeyTablePK is the table name and the primary key separated by a slash, e.g. `Singers/{"SingerId":"1"}`,
+	// for the topics shared by the tables whose primary keys may collide.
+	KeyTablePK KeyMode = "table+pk"
+	// KeyTransaction is the server transaction ID, which keeps the records of a transaction together.
+	KeyTransaction KeyMode = "transaction"
+)
+
+const (
+	// cloudEventsSpecVersion is the version of CloudEvents of the headers.
+	cloudEventsSpecVersion = "1.0"
+	// cloudEventsTypePrefix is the prefix of the ce_type header, followed by the lowercased mod type.
+	cloudEventsTypePrefix = "spanner.datachange."
+	defaultSource         = "spanner-change-streams-tail"
+)
+
+// Header is a header of a Kafka message.
+type Header struct {
+	Key   string
+	Value []byte
+}
+
+// Message is a Kafka message of an event, whose value is the JSON of sink.Event.
+//
+// The headers follow the binary content mode of CloudEvents for Kafka: ce_id is the idempotency key, ce_time is the
+// commit timestamp, ce_subject is the table name, and ce_type is "spanner.datachange." followed by the lowercased
+// mod type, e.g. "spanner.datachange.insert".
+type Message struct {
+	Key     []byte
+	Value   []byte
+	Headers []Header
+}
+
+// ParseKeyMode returns the key mode of the name, which is one of "pk", "table+pk" and "transaction".
+func ParseKeyMode(name string) (KeyMode, error) {
+	switch mode := KeyMode(name); mode {
+	case KeyPK, KeyTablePK, KeyTransaction:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid key mode: %q", name)
+	}
+}
+
+// Messages returns the messages of all the mods of the data change records in the result, in the order of
+// sink.Events.
+func Messages(result *changestreams.ReadResult, mode KeyMode) ([]*Message, error) {
+	events, err := sink.Events(result)
+	if err != nil {
+		return nil, err
+	}
+	// sink.Events flattens the mods in the same order as ModEvents.
+	messages := make([]*Message, 0, len(events))
+	i := 0
+	for modEvent := range result.ModEvents() {
+		event := events[i]
+		i++
+		key, err := messageKey(mode, modEvent.Record, modEvent.Mod)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(event)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, &Message{
+			Key:     key,
+			Value:   value,
+			Headers: headers(event),
+		})
+	}
+	return messages, nil
+}
+
+// messageKey returns the key of the mod of the record.
+func messageKey(mode KeyMode, record *changestreams.DataChangeRecord, mod *changestreams.Mod) ([]byte, error) {
+	switch mode {
+	case KeyPK:
+		pk, err := mod.PrimaryKeyString()
+		if err != nil {
+			return nil, err
+		}
+		return []byte(pk), nil
+	case KeyTablePK:
+		pk, err := mod.PrimaryKeyString()
+		if err != nil {
+			return nil, err
+		}
+		return []byte(record.TableName + "/" + pk), nil
+	case KeyTransaction:
+		return []byte(record.ServerTransactionID), nil
+	default:
+		return nil, fmt.Errorf("invalid key mode: %q", mode)
+	}
+}
+
+// headers returns the CloudEvents headers of the event.
+func headers(event *sink.Event) []Header {
+	source := defaultSource
+	if s := event.Source; s != nil {
+		source = fmt.Sprintf("//spanner.googleapis.com/projects/%s/instances/%s/databases/%s/changeStreams/%s", s.ProjectID, s.InstanceID, s.DatabaseID, s.StreamID)
+	}
+	return []Header{
+		{Key: "ce_specversion", Value: []byte(cloudEventsSpecVersion)},
+		{Key: "ce_id", Value: []byte(event.IdempotencyKey)},
+		{Key: "ce_source", Value: []byte(source)},
+		{Key: "ce_type", Value: []byte(cloudEventsTypePrefix + strings.ToLower(event.ModType))},
+		{Key: "ce_subject", Value: []byte(event.TableName)},
+		{Key: "ce_time", Value: []byte(event.CommitTimestamp.UTC().Format(time.RFC3339Nano))},
+		{Key: "content-type", Value: []byte("application/json")},
+	}
+}
@@ -0,0 +1,132 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package kafka
+
+import (
+	"testing"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams"
+	"github.com/google/go-cmp/cmp"
+)
+
+func newResult(modType, txnID string, keys ...map[string]interface{}) *changestreams.ReadResult {
+	var mods []*changestreams.Mod
+	for _, k := range keys {
+		mods = append(mods, &changestreams.Mod{Keys: spanner.NullJSON{Value: k, Valid: true}})
+	}
+	return &changestreams.ReadResult{
+		PartitionToken: "token",
+		ChangeRecords: []*changestreams.ChangeRecord{{DataChangeRecords: []*changestreams.DataChangeRecord{{
+			CommitTimestamp:     time.Date(2023, 2, 24, 17, 0, 0, 123000000, time.UTC),
+			RecordSequence:      "00000000",
+			ServerTransactionID: txnID,
+			TableName:           "Albums",
+			ModType:             modType,
+			Mods:                mods,
+		}}}},
+	}
+}
+
+// TestMessageKeys pins the key bytes, which must never change across the releases.
+func TestMessageKeys(t *testing.T) {
+	album := map[string]interface{}{"SingerId": "1", "AlbumId": "2"}
+	for _, tt := range []struct {
+		mode KeyMode
+		want string
+	}{
+		{KeyPK, `{"AlbumId":"2","SingerId":"1"}`},
+		{KeyTablePK, `Albums/{"AlbumId":"2","SingerId":"1"}`},
+		{KeyTransaction, "txn"},
+	} {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			// The same row has the same key in the different records and mod types.
+			for _, result := range []*changestreams.ReadResult{
+				newResult("INSERT", "txn", album),
+				newResult("DELETE", "txn", map[string]interface{}{"AlbumId": "2", "SingerId": "1"}),
+			} {
+				messages, err := Messages(result, tt.mode)
+				if err != nil {
+					t.Fatalf("Messages error: %v", err)
+				}
+				if len(messages) != 1 {
+					t.Fatalf("messages = %d, want 1", len(messages))
+				}
+				if got := string(messages[0].Key); got != tt.want {
+					t.Errorf("key = %s, want %s", got, tt.want)
+				}
+			}
+		})
+	}
+
+	// The keys of the rows of a record differ by the mode.
+	messages, err := Messages(newResult("UPDATE", "txn", map[string]interface{}{"SingerId": "1", "AlbumId": "2"}, map[string]interface{}{"SingerId": "1", "AlbumId": "3"}), KeyPK)
+	if err != nil {
+		t.Fatalf("Messages error: %v", err)
+	}
+	if string(messages[0].Key) == string(messages[1].Key) {
+		t.Errorf("keys of the different rows = %s, want different keys", messages[0].Key)
+	}
+
+	noKeys := newResult("INSERT", "txn", map[string]interface{}{"SingerId": "1"})
+	noKeys.ChangeRecords[0].DataChangeRecords[0].Mods[0].Keys = spanner.NullJSON{}
+	if _, err := Messages(noKeys, KeyPK); err == nil {
+		t.Error("Messages of the mod without the keys succeeded, want error")
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	result := newResult("INSERT", "txn", map[string]interface{}{"SingerId": "1"})
+	result.Source = &changestreams.Source{ProjectID: "p", InstanceID: "i", DatabaseID: "d", StreamID: "s"}
+	messages, err := Messages(result, KeyTransaction)
+	if err != nil {
+		t.Fatalf("Messages error: %v", err)
+	}
+	got := make(map[string]string)
+	for _, h := range messages[0].Headers {
+		got[h.Key] = string(h.Value)
+	}
+	record := result.ChangeRecords[0].DataChangeRecords[0]
+	id, err := changestreams.IdempotencyKey(result.PartitionToken, record, record.Mods[0])
+	if err != nil {
+		t.Fatalf("IdempotencyKey error: %v", err)
+	}
+	want := map[string]string{
+		"ce_specversion": "1.0",
+		"ce_id":          id,
+		"ce_source":      "//spanner.googleapis.com/projects/p/instances/i/databases/d/changeStreams/s",
+		"ce_type":        "spanner.datachange.insert",
+		"ce_subject":     "Albums",
+		"ce_time":        "2023-02-24T17:00:00.123Z",
+		"content-type":   "application/json",
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("headers diff = %v", diff)
+	}
+}
+
+func TestParseKeyMode(t *testing.T) {
+	for _, name := range []string{"pk", "table+pk", "transaction"} {
+		if mode, err := ParseKeyMode(name); err != nil || string(mode) != name {
+			t.Errorf("ParseKeyMode(%q) = %q, %v", name, mode, err)
+		}
+	}
+	if _, err := ParseKeyMode("table"); err == nil {
+		t.Error("ParseKeyMode(table) succeeded, want error")
+	}
+}
@@ -0,0 +1,322 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package pubsub implements the sink that publishes the change records to a Cloud Pub/Sub topic.
+package pubsub
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams"
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams/sink"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	pubsubapi "google.golang.org/api/pubsub/v1"
+)
+
+const (
+	defaultMaxMessagesPerRequest = 100
+	// maxMessagesPerRequest is the limit of the messages in a publish request of Pub/Sub.
+	maxMessagesPerRequest = 1000
+	// maxOrderingKeyBytes is the limit of the ordering key of Pub/Sub.
+	maxOrderingKeyBytes = 1024
+)
+
+// OrderingKey selects the ordering key of the messages, which Pub/Sub delivers in order to the subscriptions with
+// message ordering enabled.
+type OrderingKey string
+
+const (
+	// OrderingKeyNone publishes the messages without an ordering key, which has the highest throughput.
+	OrderingKeyNone OrderingKey = "none"
+	// OrderingKeyTable orders the messages of each table.
+	OrderingKeyTable OrderingKey = "table"
+	// OrderingKeyTablePK orders the messages of each row, keyed by the table name and the primary key.
+	OrderingKeyTablePK OrderingKey = "table+pk"
+)
+
+// ErrOrderingKeyPaused is the error of the events that are not published because the publishing of their ordering
+// key is paused after a failure.
+var ErrOrderingKeyPaused = errors.New("publishing of the ordering key is paused")
+
+// Config is the configuration for the Pub/Sub sink.
+type Config struct {
+	// Topic is the name of the topic, e.g. "projects/myproject/topics/changes".
+	Topic string
+	// OrderingKey selects the ordering key of the messages. If OrderingKey is empty, OrderingKeyNone is used.
+	OrderingKey OrderingKey
+	// ClientOptions are the options of the Pub/Sub client, e.g. the regional endpoint.
+	ClientOptions []option.ClientOption
+	// MaxMessagesPerRequest is the number of the messages published in a request, up to 1000.
+	// If MaxMessagesPerRequest is zero, 100 is used.
+	MaxMessagesPerRequest int
+	// Retry is the retry policy of the failed publish requests.
+	Retry sink.RetryPolicy
+	// DeadLetter receives the events that are not published after all the retries, and the events of a paused
+	// ordering key. If DeadLetter is nil, the error stops the read.
+	DeadLetter sink.DeadLetterFunc
+}
+
+// Sink publishes each mod of the data change records as a JSON message of sink.Event.
+//
+// Read returns only after Pub/Sub has accepted all the messages of the result, so the read is checkpointed only
+// after the messages are durable. The idempotency key of the event is in the idempotency_key attribute, which can be
+// used to deduplicate the messages published again after a retry or a restart.
+//
+// With an ordering key, the requests are published one at a time, so that the messages of a key are accepted in the
+// order of the records. When a request fails after all the retries, its ordering keys are paused, as the Pub/Sub
+// client libraries do, so that the later events of a key are never published ahead of the failed ones. If DeadLetter
+// accepts the failed events by returning nil, the keys are resumed, since the gap is then recorded by DeadLetter.
+// Otherwise the keys stay paused until ResumePublish is called.
+type Sink struct {
+	ctx     context.Context
+	config  Config
+	publish func(ctx context.Context, messages []*pubsubapi.PubsubMessage) error
+	// mu serializes the publishings so that the messages of a key are accepted in order.
+	mu     sync.Mutex
+	paused map[string]bool
+}
+
+// New creates a new Pub/Sub sink with the Application Default Credentials. ctx is used for all the publishings of
+// the sink.
+func New(ctx context.Context, config Config) (*Sink, error) {
+	if config.Topic == "" {
+		return nil, errors.New("topic must be specified")
+	}
+	switch config.OrderingKey {
+	case "":
+		config.OrderingKey = OrderingKeyNone
+	case OrderingKeyNone, OrderingKeyTable, OrderingKeyTablePK:
+	default:
+		return nil, fmt.Errorf("invalid ordering key: %q", config.OrderingKey)
+	}
+	if config.MaxMessagesPerRequest <= 0 {
+		config.MaxMessagesPerRequest = defaultMaxMessagesPerRequest
+	}
+	if config.MaxMessagesPerRequest > maxMessagesPerRequest {
+		return nil, fmt.Errorf("max messages per request must be %d or less: %d", maxMessagesPerRequest, config.MaxMessagesPerRequest)
+	}
+
+	service, err := pubsubapi.NewService(ctx, config.ClientOptions...)
+	if err != nil {
+		return nil, err
+	}
+	return &Sink{
+		ctx:    ctx,
+		config: config,
+		publish: func(ctx context.Context, messages []*pubsubapi.PubsubMessage) error {
+			_, err := service.Projects.Topics.Publish(config.Topic, &pubsubapi.PublishRequest{Messages: messages}).Context(ctx).Do()
+			return classify(err)
+		},
+		paused: make(map[string]bool),
+	}, nil
+}
+
+// NewFromURL creates a new Pub/Sub sink from the URL such as
+// "pubsub://myproject/changes?max_messages=100&endpoint=https://us-east1-pubsub.googleapis.com/".
+// The host and the path are the project and the topic. The max_messages parameter sets MaxMessagesPerRequest, and the
+// endpoint parameter sets the endpoint of the client, e.g. the regional endpoint recommended for the ordering keys.
+func NewFromURL(ctx context.Context, rawURL string) (*Sink, error) {
+	config, err := ParseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return New(ctx, config)
+}
+
+// ParseURL returns the configuration of the URL accepted by NewFromURL.
+func ParseURL(rawURL string) (Config, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return Config{}, err
+	}
+	if u.Scheme != "pubsub" {
+		return Config{}, fmt.Errorf("unsupported scheme: %q", u.Scheme)
+	}
+	topic := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || topic == "" || strings.Contains(topic, "/") {
+		return Config{}, fmt.Errorf("URL must be pubsub://PROJECT/TOPIC: %q", rawURL)
+	}
+	query := u.Query()
+	config := Config{Topic: fmt.Sprintf("projects/%s/topics/%s", u.Host, topic)}
+	if s := query.Get("max_messages"); s != "" {
+		maxMessages, err := strconv.Atoi(s)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid max_messages: %w", err)
+		}
+		config.MaxMessagesPerRequest = maxMessages
+	}
+	if endpoint := query.Get("endpoint"); endpoint != "" {
+		config.ClientOptions = append(config.ClientOptions, option.WithEndpoint(endpoint))
+	}
+	return config, nil
+}
+
+// Read publishes the events of the result in order, MaxMessagesPerRequest at a time.
+func (s *Sink) Read(result *changestreams.ReadResult) error {
+	events, err := sink.Events(result)
+	if err != nil {
+		return err
+	}
+	// sink.Events flattens the mods in the same order as ModEvents.
+	keys := make([]string, 0, len(events))
+	for modEvent := range result.ModEvents() {
+		key, err := s.orderingKey(modEvent.Record, modEvent.Mod)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(events) > 0 {
+		n := min(len(events), s.config.MaxMessagesPerRequest)
+		pending, pendingKeys := events[:n], keys[:n]
+		events, keys = events[n:], keys[n:]
+
+		// The events of the paused keys are not published.
+		var messages []*pubsubapi.PubsubMessage
+		var published []*sink.Event
+		for i, event := range pending {
+			if s.paused[pendingKeys[i]] {
+				if err := s.deadLetter(event, fmt.Errorf("%w: %q", ErrOrderingKeyPaused, pendingKeys[i])); err != nil {
+					return err
+				}
+				continue
+			}
+			msg, err := message(event, pendingKeys[i])
+			if err != nil {
+				return err
+			}
+			messages = append(messages, msg)
+			published = append(published, event)
+		}
+		if len(messages) == 0 {
+			continue
+		}
+
+		err := sink.Retry(s.ctx, s.config.Retry, func() error {
+			return s.publish(s.ctx, messages)
+		})
+		if err == nil {
+			continue
+		}
+		if s.ctx.Err() != nil {
+			return err
+		}
+		failedKeys := make(map[string]bool)
+		for _, msg := range messages {
+			if msg.OrderingKey != "" {
+				failedKeys[msg.OrderingKey] = true
+				s.paused[msg.OrderingKey] = true
+			}
+		}
+		for _, event := range published {
+			if err := s.deadLetter(event, err); err != nil {
+				return err
+			}
+		}
+		// DeadLetter has accepted the failed events.
+		for key := range failedKeys {
+			delete(s.paused, key)
+		}
+	}
+	return nil
+}
+
+// ResumePublish resumes the publishing of the ordering key paused after a failure.
+func (s *Sink) ResumePublish(orderingKey string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.paused, orderingKey)
+}
+
+// Close does nothing, since every message has been published when Read returns.
+func (s *Sink) Close() error {
+	return nil
+}
+
+func (s *Sink) deadLetter(event *sink.Event, err error) error {
+	if s.config.DeadLetter == nil {
+		return err
+	}
+	return s.config.DeadLetter(event, err)
+}
+
+// orderingKey returns the ordering key of the mod. The primary key is the canonical string of
+// changestreams.Mod.PrimaryKeyString, and a key longer than the limit of Pub/Sub is replaced by its SHA-256.
+func (s *Sink) orderingKey(record *changestreams.DataChangeRecord, mod *changestreams.Mod) (string, error) {
+	switch s.config.OrderingKey {
+	case OrderingKeyTable:
+		return record.TableName, nil
+	case OrderingKeyTablePK:
+		pk, err := mod.PrimaryKeyString()
+		if err != nil {
+			return "", err
+		}
+		key := record.TableName + "/" + pk
+		if len(key) > maxOrderingKeyBytes {
+			sum := sha256.Sum256([]byte(pk))
+			key = record.TableName + "/" + hex.EncodeToString(sum[:])
+		}
+		return key, nil
+	default:
+		return "", nil
+	}
+}
+
+func message(event *sink.Event, orderingKey string) (*pubsubapi.PubsubMessage, error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	return &pubsubapi.PubsubMessage{
+		Data:        base64.StdEncoding.EncodeToString(body),
+		OrderingKey: orderingKey,
+		Attributes: map[string]string{
+			"idempotency_key": event.IdempotencyKey,
+			"table_name":      event.TableName,
+			"mod_type":        event.ModType,
+		},
+	}, nil
+}
+
+// classify marks the errors of the requests that fail again when retried, e.g. a missing topic, as permanent.
+func classify(err error) error {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	switch {
+	case apiErr.Code == http.StatusTooManyRequests || apiErr.Code == http.StatusRequestTimeout || apiErr.Code >= 500:
+		return err
+	default:
+		return sink.Permanent(err)
+	}
+}
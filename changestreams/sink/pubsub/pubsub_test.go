@@ -0,0 +1,239 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams"
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams/sink"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/api/option"
+	pubsubapi "google.golang.org/api/pubsub/v1"
+)
+
+func TestParseURL(t *testing.T) {
+	config, err := ParseURL("pubsub://myproject/changes?max_messages=10&endpoint=https://us-east1-pubsub.googleapis.com/")
+	if err != nil {
+		t.Fatalf("ParseURL error: %v", err)
+	}
+	if config.Topic != "projects/myproject/topics/changes" || config.MaxMessagesPerRequest != 10 || len(config.ClientOptions) != 1 {
+		t.Errorf("config = %+v, want the topic, max_messages and endpoint", config)
+	}
+
+	for _, rawURL := range []string{
+		"redis://myproject/changes",
+		"pubsub://myproject",
+		"pubsub://myproject/topics/changes",
+		"pubsub://myproject/changes?max_messages=x",
+	} {
+		if _, err := ParseURL(rawURL); err == nil {
+			t.Errorf("ParseURL(%q) succeeded, want error", rawURL)
+		}
+	}
+}
+
+func TestNewInvalidConfig(t *testing.T) {
+	ctx := context.Background()
+	for _, config := range []Config{
+		{},
+		{Topic: "projects/p/topics/t", OrderingKey: "pk"},
+		{Topic: "projects/p/topics/t", MaxMessagesPerRequest: maxMessagesPerRequest + 1},
+	} {
+		if _, err := New(ctx, config); err == nil {
+			t.Errorf("New(%+v) succeeded, want error", config)
+		}
+	}
+}
+
+// newResult returns the result of a record of the table with the mods of the IDs.
+func newResult(table string, ids ...string) *changestreams.ReadResult {
+	var mods []*changestreams.Mod
+	for _, id := range ids {
+		mods = append(mods, &changestreams.Mod{Keys: spanner.NullJSON{Value: map[string]interface{}{"Id": id}, Valid: true}})
+	}
+	return &changestreams.ReadResult{ChangeRecords: []*changestreams.ChangeRecord{{DataChangeRecords: []*changestreams.DataChangeRecord{{
+		CommitTimestamp: time.Date(2023, 2, 24, 17, 0, 0, 0, time.UTC),
+		TableName:       table,
+		ModType:         "UPDATE",
+		Mods:            mods,
+	}}}}}
+}
+
+func newSink(t *testing.T, config Config) *Sink {
+	config.Topic = "projects/p/topics/t"
+	config.ClientOptions = []option.ClientOption{option.WithoutAuthentication()}
+	s, err := New(context.Background(), config)
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	return s
+}
+
+func TestOrderingKey(t *testing.T) {
+	result := newResult("Singers", "1", strings.Repeat("x", maxOrderingKeyBytes))
+	for _, tt := range []struct {
+		orderingKey OrderingKey
+		want        []string
+	}{
+		{OrderingKeyNone, []string{"", ""}},
+		{OrderingKeyTable, []string{"Singers", "Singers"}},
+		{OrderingKeyTablePK, []string{`Singers/{"Id":"1"}`, "Singers/"}},
+	} {
+		t.Run(string(tt.orderingKey), func(t *testing.T) {
+			s := newSink(t, Config{OrderingKey: tt.orderingKey})
+			var got []string
+			s.publish = func(ctx context.Context, messages []*pubsubapi.PubsubMessage) error {
+				for _, msg := range messages {
+					got = append(got, msg.OrderingKey)
+				}
+				return nil
+			}
+			if err := s.Read(result); err != nil {
+				t.Fatalf("Read error: %v", err)
+			}
+			if len(got) != 2 || got[0] != tt.want[0] || !strings.HasPrefix(got[1], tt.want[1]) || len(got[1]) > maxOrderingKeyBytes {
+				t.Errorf("ordering keys = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPausedOrderingKey(t *testing.T) {
+	s := newSink(t, Config{
+		OrderingKey:           OrderingKeyTablePK,
+		MaxMessagesPerRequest: 2,
+		Retry:                 sink.RetryPolicy{InitialBackoff: time.Millisecond, MaxAttempts: 2},
+	})
+	errPublish := errors.New("unavailable")
+	var published [][]string
+	s.publish = func(ctx context.Context, messages []*pubsubapi.PubsubMessage) error {
+		var keys []string
+		for _, msg := range messages {
+			keys = append(keys, msg.OrderingKey)
+		}
+		published = append(published, keys)
+		if len(published) <= 2 {
+			return errPublish
+		}
+		return nil
+	}
+
+	// The first request fails after the retries, which pauses its keys and stops the read.
+	if err := s.Read(newResult("Singers", "1", "2", "3")); !errors.Is(err, errPublish) {
+		t.Fatalf("Read error = %v, want %v", err, errPublish)
+	}
+	if err := s.Read(newResult("Singers", "2")); !errors.Is(err, ErrOrderingKeyPaused) {
+		t.Errorf("Read of the paused key error = %v, want %v", err, ErrOrderingKeyPaused)
+	}
+	s.ResumePublish(`Singers/{"Id":"2"}`)
+	if err := s.Read(newResult("Singers", "2", "4")); err != nil {
+		t.Errorf("Read after ResumePublish error: %v", err)
+	}
+	want := [][]string{
+		{`Singers/{"Id":"1"}`, `Singers/{"Id":"2"}`},
+		{`Singers/{"Id":"1"}`, `Singers/{"Id":"2"}`},
+		{`Singers/{"Id":"2"}`, `Singers/{"Id":"4"}`},
+	}
+	if diff := cmp.Diff(published, want); diff != "" {
+		t.Errorf("published diff = %v", diff)
+	}
+
+	// The key whose failed events are accepted by DeadLetter is resumed, and the key paused before stays paused.
+	var deadLetters []string
+	s.config.DeadLetter = func(event *sink.Event, err error) error {
+		deadLetters = append(deadLetters, string(event.Keys))
+		return nil
+	}
+	published = nil
+	if err := s.Read(newResult("Singers", "6", "7", "6", "1")); err != nil {
+		t.Fatalf("Read with DeadLetter error: %v", err)
+	}
+	if diff := cmp.Diff(deadLetters, []string{`{"Id":"6"}`, `{"Id":"7"}`, `{"Id":"1"}`}); diff != "" {
+		t.Errorf("dead letters diff = %v", diff)
+	}
+	if got := published[len(published)-1]; len(got) != 1 || got[0] != `Singers/{"Id":"6"}` {
+		t.Errorf("last request = %q, want the resumed key", got)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	var mu sync.Mutex
+	var requests []*pubsubapi.PublishRequest
+	notFound := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/projects/p/topics/t:publish" {
+			mu.Lock()
+			notFound++
+			mu.Unlock()
+			http.NotFound(w, r)
+			return
+		}
+		var request pubsubapi.PublishRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("invalid request: %v", err)
+		}
+		mu.Lock()
+		requests = append(requests, &request)
+		mu.Unlock()
+		w.Write([]byte(`{"messageIds":["1"]}`))
+	}))
+	defer server.Close()
+
+	s, err := New(context.Background(), Config{
+		Topic:         "projects/p/topics/t",
+		OrderingKey:   OrderingKeyTable,
+		ClientOptions: []option.ClientOption{option.WithoutAuthentication(), option.WithEndpoint(server.URL)},
+	})
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	if err := s.Read(newResult("Singers", "1")); err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	if len(requests) != 1 || len(requests[0].Messages) != 1 {
+		t.Fatalf("requests = %+v, want a message", requests)
+	}
+	msg := requests[0].Messages[0]
+	if msg.OrderingKey != "Singers" || msg.Attributes["table_name"] != "Singers" || msg.Attributes["mod_type"] != "UPDATE" {
+		t.Errorf("message = %+v, want the ordering key and the attributes", msg)
+	}
+
+	// A missing topic is not retried.
+	s, err = New(context.Background(), Config{
+		Topic:         "projects/p/topics/missing",
+		ClientOptions: []option.ClientOption{option.WithoutAuthentication(), option.WithEndpoint(server.URL)},
+	})
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	if err := s.Read(newResult("Singers", "1")); err == nil {
+		t.Error("Read to the missing topic succeeded, want error")
+	}
+	if notFound != 1 {
+		t.Errorf("requests to the missing topic = %d, want no retry", notFound)
+	}
+}
@@ -0,0 +1,171 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package sink contains the building blocks shared by the sinks that write the change records to
+// external systems. Each sink lives in its own subpackage and has a Read method that can be passed
+// to changestreams.Reader.Read.
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams"
+)
+
+// Event is a mod of a data change record flattened for the sinks, with the values encoded in JSON.
+type Event struct {
+	IdempotencyKey      string          `json:"idempotency_key"`
+	PartitionToken      string          `json:"partition_token"`
+	CommitTimestamp     time.Time       `json:"commit_timestamp"`
+	ServerTransactionID string          `json:"server_transaction_id"`
+	RecordSequence      string          `json:"record_sequence"`
+	TableName           string          `json:"table_name"`
+	ModType             string          `json:"mod_type"`
+	Keys                json.RawMessage `json:"keys"`
+	NewValues           json.RawMessage `json:"new_values"`
+	OldValues           json.RawMessage `json:"old_values"`
+	// Row is only set if the mod has been enriched with the whole row by the enrich package.
+	Row json.RawMessage `json:"row,omitempty"`
+	// RowStale is true if Row is the current row instead of the row at the commit timestamp.
+	RowStale bool `json:"row_stale,omitempty"`
+	// Source is only set if changestreams.Config.IncludeSource is true.
+	Source *changestreams.Source `json:"source,omitempty"`
+}
+
+// Events returns the events of all the mods of the data change records in the result.
+func Events(result *changestreams.ReadResult) ([]*Event, error) {
+	var events []*Event
+	for modEvent := range result.ModEvents() {
+		record, mod := modEvent.Record, modEvent.Mod
+		keys, err := json.Marshal(mod.Keys)
+		if err != nil {
+			return nil, err
+		}
+		newValues, err := json.Marshal(mod.NewValues)
+		if err != nil {
+			return nil, err
+		}
+		oldValues, err := json.Marshal(mod.OldValues)
+		if err != nil {
+			return nil, err
+		}
+		idempotencyKey, err := changestreams.IdempotencyKey(result.PartitionToken, record, mod)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, &Event{
+			IdempotencyKey:      idempotencyKey,
+			PartitionToken:      result.PartitionToken,
+			CommitTimestamp:     record.CommitTimestamp,
+			ServerTransactionID: record.ServerTransactionID,
+			RecordSequence:      record.RecordSequence,
+			TableName:           record.TableName,
+			ModType:             record.ModType,
+			Keys:                keys,
+			NewValues:           newValues,
+			OldValues:           oldValues,
+			Row:                 mod.Row,
+			RowStale:            mod.RowStale,
+			Source:              result.Source,
+		})
+	}
+	return events, nil
+}
+
+// ExpandTemplate replaces {table} and {mod_type} in the template with the table name and the mod type of the event.
+// The mod type is lowercased, e.g. "spanner.{table}.{mod_type}" becomes "spanner.Players.insert".
+func ExpandTemplate(template string, event *Event) string {
+	return strings.NewReplacer(
+		"{table}", event.TableName,
+		"{mod_type}", strings.ToLower(event.ModType),
+	).Replace(template)
+}
+
+// DeadLetterFunc receives the event that a sink failed to write after all the retries, with the last error.
+// If it returns nil, the sink skips the event and continues; otherwise the error stops the read.
+type DeadLetterFunc func(event *Event, err error) error
+
+// RetryPolicy is the policy to retry the writes of a sink with exponential backoff.
+type RetryPolicy struct {
+	// InitialBackoff is the backoff after the first failure. If InitialBackoff is zero, 100ms is used.
+	InitialBackoff time.Duration
+	// MaxBackoff is the upper limit of the backoff. If MaxBackoff is zero, 10s is used.
+	MaxBackoff time.Duration
+	// MaxAttempts is the number of the attempts including the first one. If MaxAttempts is zero,
+	// the write is retried until it succeeds or the context is done.
+	MaxAttempts int
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent wraps err so that Retry returns err immediately instead of retrying, e.g. for an authentication error.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// Retry calls f until it succeeds, the attempts are exhausted or ctx is done, and returns the last error.
+// If f returns an error wrapped by Permanent, Retry returns the unwrapped error without retrying.
+// While retrying, the caller is blocked, which applies backpressure to the reader.
+func Retry(ctx context.Context, policy RetryPolicy, f func() error) error {
+	backoff := policy.InitialBackoff
+	if backoff == 0 {
+		backoff = 100 * time.Millisecond
+	}
+	maxBackoff := policy.MaxBackoff
+	if maxBackoff == 0 {
+		maxBackoff = 10 * time.Second
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil {
+			return nil
+		}
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			return permanent.err
+		}
+		if policy.MaxAttempts > 0 && attempt >= policy.MaxAttempts {
+			return err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
@@ -0,0 +1,124 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestEvents(t *testing.T) {
+	commitTimestamp := time.Date(2023, 2, 24, 17, 0, 0, 0, time.UTC)
+	record := &changestreams.DataChangeRecord{
+		CommitTimestamp:     commitTimestamp,
+		ServerTransactionID: "txn",
+		RecordSequence:      "00000000",
+		TableName:           "Players",
+		ModType:             "UPDATE",
+		Mods: []*changestreams.Mod{
+			{
+				Keys:      spanner.NullJSON{Value: map[string]interface{}{"PlayerId": "1"}, Valid: true},
+				NewValues: spanner.NullJSON{Value: map[string]interface{}{"Name": "foo"}, Valid: true},
+			},
+		},
+	}
+	source := &changestreams.Source{ProjectID: "project", InstanceID: "instance", DatabaseID: "database", StreamID: "stream"}
+	result := &changestreams.ReadResult{
+		PartitionToken: "token",
+		ChangeRecords:  []*changestreams.ChangeRecord{{DataChangeRecords: []*changestreams.DataChangeRecord{record}}},
+		Source:         source,
+	}
+
+	got, err := Events(result)
+	if err != nil {
+		t.Fatalf("Events error: %v", err)
+	}
+	idempotencyKey, err := changestreams.IdempotencyKey("token", record, record.Mods[0])
+	if err != nil {
+		t.Fatalf("IdempotencyKey error: %v", err)
+	}
+	want := []*Event{
+		{
+			IdempotencyKey:      idempotencyKey,
+			PartitionToken:      "token",
+			CommitTimestamp:     commitTimestamp,
+			ServerTransactionID: "txn",
+			RecordSequence:      "00000000",
+			TableName:           "Players",
+			ModType:             "UPDATE",
+			Keys:                json.RawMessage(`{"PlayerId":"1"}`),
+			NewValues:           json.RawMessage(`{"Name":"foo"}`),
+			OldValues:           json.RawMessage(`null`),
+			Source:              source,
+		},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("diff = %v", diff)
+	}
+
+	if got, want := ExpandTemplate("spanner.{table}.{mod_type}", want[0]), "spanner.Players.update"; got != want {
+		t.Errorf("ExpandTemplate = %q, want %q", got, want)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	ctx := context.Background()
+	policy := RetryPolicy{InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+	errWrite := errors.New("write error")
+
+	attempts := 0
+	if err := Retry(ctx, policy, func() error {
+		attempts++
+		if attempts < 3 {
+			return errWrite
+		}
+		return nil
+	}); err != nil || attempts != 3 {
+		t.Errorf("Retry = %v after %d attempts, want success after 3 attempts", err, attempts)
+	}
+
+	attempts = 0
+	policy.MaxAttempts = 2
+	if err := Retry(ctx, policy, func() error {
+		attempts++
+		return errWrite
+	}); err != errWrite || attempts != 2 {
+		t.Errorf("Retry = %v after %d attempts, want %v after 2 attempts", err, attempts, errWrite)
+	}
+
+	attempts = 0
+	if err := Retry(ctx, policy, func() error {
+		attempts++
+		return Permanent(errWrite)
+	}); err != errWrite || attempts != 1 {
+		t.Errorf("Retry = %v after %d attempts, want %v after 1 attempt", err, attempts, errWrite)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	policy.MaxAttempts = 0
+	if err := Retry(cancelled, policy, func() error { return errWrite }); err != errWrite {
+		t.Errorf("Retry with cancelled context = %v, want %v", err, errWrite)
+	}
+}
@@ -0,0 +1,389 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package spanner implements the sink that replicates the data change records to another Cloud Spanner database.
+package spanner
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/civil"
+	spannerclient "cloud.google.com/go/spanner"
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams"
+)
+
+// Sink applies the data change records to the target database as mutations.
+//
+// INSERT is applied as InsertOrUpdate and DELETE of a missing row is a no-op, so a record applied again after
+// a restart doesn't fail. The mutations of a result are applied atomically, but a transaction whose records are
+// read from multiple partitions is applied in multiple transactions.
+// The target tables must have the same names, columns and primary keys as the source tables.
+type Sink struct {
+	ctx    context.Context
+	client *spannerclient.Client
+}
+
+// New creates a new sink that applies the mutations with client. ctx is used for all the writes of the sink.
+func New(ctx context.Context, client *spannerclient.Client) (*Sink, error) {
+	if client == nil {
+		return nil, errors.New("client of the target database must be specified")
+	}
+	return &Sink{
+		ctx:    ctx,
+		client: client,
+	}, nil
+}
+
+// Read applies the data change records of the result to the target database.
+func (s *Sink) Read(result *changestreams.ReadResult) error {
+	var ms []*spannerclient.Mutation
+	for record := range result.DataChangeRecords() {
+		recordMutations, err := Mutations(record)
+		if err != nil {
+			return err
+		}
+		ms = append(ms, recordMutations...)
+	}
+	if len(ms) == 0 {
+		return nil
+	}
+	_, err := s.client.Apply(s.ctx, ms)
+	return err
+}
+
+// Close does nothing. The client is owned by the caller.
+func (s *Sink) Close() error {
+	return nil
+}
+
+// Mutations returns the mutations that apply the data change record, with the values converted to the types
+// of the columns in record.ColumnTypes.
+func Mutations(record *changestreams.DataChangeRecord) ([]*spannerclient.Mutation, error) {
+	columns, types, err := decodeColumns(record)
+	if err != nil {
+		return nil, err
+	}
+
+	ms := make([]*spannerclient.Mutation, 0, len(record.Mods))
+	for _, mod := range record.Mods {
+		keys, err := convertValues(types, mod.Keys)
+		if err != nil {
+			return nil, err
+		}
+
+		switch record.ModType {
+		case "DELETE":
+			key, err := primaryKey(record.TableName, columns, keys)
+			if err != nil {
+				return nil, err
+			}
+			ms = append(ms, spannerclient.Delete(record.TableName, key))
+		case "INSERT", "UPDATE":
+			values, err := convertValues(types, mod.NewValues)
+			if err != nil {
+				return nil, err
+			}
+			for name, v := range keys {
+				values[name] = v
+			}
+			// The columns are in the order of the table so that the mutation is deterministic.
+			var names []string
+			var vals []interface{}
+			for _, c := range columns {
+				if v, ok := values[c.Name]; ok {
+					names = append(names, c.Name)
+					vals = append(vals, v)
+				}
+			}
+			if record.ModType == "INSERT" {
+				ms = append(ms, spannerclient.InsertOrUpdate(record.TableName, names, vals))
+			} else {
+				ms = append(ms, spannerclient.Update(record.TableName, names, vals))
+			}
+		default:
+			return nil, fmt.Errorf("unexpected mod type: %s", record.ModType)
+		}
+	}
+	return ms, nil
+}
+
+// PrimaryKey returns the primary key of the row modified by the mod of the data change record, with the values
+// converted to the types of the columns in record.ColumnTypes, e.g. to read the row.
+func PrimaryKey(record *changestreams.DataChangeRecord, mod *changestreams.Mod) (spannerclient.Key, error) {
+	columns, types, err := decodeColumns(record)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := convertValues(types, mod.Keys)
+	if err != nil {
+		return nil, err
+	}
+	return primaryKey(record.TableName, columns, keys)
+}
+
+// decodeColumns returns the columns of the record in the order of the table, and their types.
+func decodeColumns(record *changestreams.DataChangeRecord) ([]*changestreams.ColumnType, map[string]*changestreams.Type, error) {
+	columns := make([]*changestreams.ColumnType, len(record.ColumnTypes))
+	copy(columns, record.ColumnTypes)
+	sort.Slice(columns, func(i, j int) bool {
+		return columns[i].OrdinalPosition < columns[j].OrdinalPosition
+	})
+	types := make(map[string]*changestreams.Type, len(columns))
+	for _, c := range columns {
+		t, err := c.DecodeType()
+		if err != nil {
+			return nil, nil, err
+		}
+		types[c.Name] = t
+	}
+	return columns, types, nil
+}
+
+// primaryKey returns the key of the converted values of the primary key columns.
+func primaryKey(table string, columns []*changestreams.ColumnType, keys map[string]interface{}) (spannerclient.Key, error) {
+	var key spannerclient.Key
+	for _, c := range columns {
+		if !c.IsPrimaryKey {
+			continue
+		}
+		v, ok := keys[c.Name]
+		if !ok {
+			return nil, fmt.Errorf("primary key %s of table %s is missing", c.Name, table)
+		}
+		key = append(key, v)
+	}
+	return key, nil
+}
+
+func convertValues(types map[string]*changestreams.Type, values spannerclient.NullJSON) (map[string]interface{}, error) {
+	converted := make(map[string]interface{})
+	if !values.Valid || values.Value == nil {
+		return converted, nil
+	}
+	m, ok := values.Value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected values: %v", values.Value)
+	}
+	for name, v := range m {
+		t, ok := types[name]
+		if !ok {
+			return nil, fmt.Errorf("type of column %s is unknown", name)
+		}
+		cv, err := convertValue(t, v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of column %s: %w", name, err)
+		}
+		converted[name] = cv
+	}
+	return converted, nil
+}
+
+// convertValue converts the value decoded from JSON to the value of the Cloud Spanner type.
+// The scalar values are converted to the Null* types, so NULL and the arrays with NULL elements are typed.
+func convertValue(t *changestreams.Type, v interface{}) (interface{}, error) {
+	if t.Code == "ARRAY" {
+		if t.ArrayElementType == nil {
+			return nil, errors.New("array has no element type")
+		}
+		return convertArray(t.ArrayElementType, v)
+	}
+
+	switch t.Code {
+	case "BOOL":
+		if v == nil {
+			return spannerclient.NullBool{}, nil
+		}
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("unexpected BOOL: %v", v)
+		}
+		return spannerclient.NullBool{Bool: b, Valid: true}, nil
+	case "INT64":
+		if v == nil {
+			return spannerclient.NullInt64{}, nil
+		}
+		var i int64
+		switch v := v.(type) {
+		// INT64 is encoded as a string so as not to lose precision.
+		case string:
+			var err error
+			if i, err = strconv.ParseInt(v, 10, 64); err != nil {
+				return nil, err
+			}
+		case float64:
+			i = int64(v)
+		default:
+			return nil, fmt.Errorf("unexpected INT64: %v", v)
+		}
+		return spannerclient.NullInt64{Int64: i, Valid: true}, nil
+	case "FLOAT64", "FLOAT32":
+		if v == nil {
+			return spannerclient.NullFloat64{}, nil
+		}
+		var f float64
+		switch v := v.(type) {
+		case float64:
+			f = v
+		// NaN and infinities are encoded as strings.
+		case string:
+			switch v {
+			case "NaN":
+				f = math.NaN()
+			case "Infinity":
+				f = math.Inf(1)
+			case "-Infinity":
+				f = math.Inf(-1)
+			default:
+				return nil, fmt.Errorf("unexpected %s: %v", t.Code, v)
+			}
+		default:
+			return nil, fmt.Errorf("unexpected %s: %v", t.Code, v)
+		}
+		return spannerclient.NullFloat64{Float64: f, Valid: true}, nil
+	}
+
+	var s string
+	if v != nil {
+		var ok bool
+		if s, ok = v.(string); !ok {
+			return nil, fmt.Errorf("unexpected %s: %v", t.Code, v)
+		}
+	}
+	switch t.Code {
+	case "STRING":
+		if v == nil {
+			return spannerclient.NullString{}, nil
+		}
+		return spannerclient.NullString{StringVal: s, Valid: true}, nil
+	case "BYTES":
+		if v == nil {
+			return []byte(nil), nil
+		}
+		return base64.StdEncoding.DecodeString(s)
+	case "TIMESTAMP":
+		if v == nil {
+			return spannerclient.NullTime{}, nil
+		}
+		ts, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return nil, err
+		}
+		return spannerclient.NullTime{Time: ts, Valid: true}, nil
+	case "DATE":
+		if v == nil {
+			return spannerclient.NullDate{}, nil
+		}
+		d, err := civil.ParseDate(s)
+		if err != nil {
+			return nil, err
+		}
+		return spannerclient.NullDate{Date: d, Valid: true}, nil
+	case "NUMERIC":
+		if t.TypeAnnotation == "PG_NUMERIC" {
+			return spannerclient.PGNumeric{Numeric: s, Valid: v != nil}, nil
+		}
+		if v == nil {
+			return spannerclient.NullNumeric{}, nil
+		}
+		r, ok := new(big.Rat).SetString(s)
+		if !ok {
+			return nil, fmt.Errorf("unexpected NUMERIC: %v", v)
+		}
+		return spannerclient.NullNumeric{Numeric: *r, Valid: true}, nil
+	case "JSON":
+		var value interface{}
+		if v != nil {
+			// JSON is encoded as a string of the JSON.
+			if err := json.Unmarshal([]byte(s), &value); err != nil {
+				return nil, err
+			}
+		}
+		if t.TypeAnnotation == "PG_JSONB" {
+			return spannerclient.PGJsonB{Value: value, Valid: v != nil}, nil
+		}
+		return spannerclient.NullJSON{Value: value, Valid: v != nil}, nil
+	default:
+		return nil, fmt.Errorf("unsupported type: %s", t.Code)
+	}
+}
+
+func convertArray(elementType *changestreams.Type, v interface{}) (interface{}, error) {
+	var elements []interface{}
+	if v != nil {
+		var ok bool
+		if elements, ok = v.([]interface{}); !ok {
+			return nil, fmt.Errorf("unexpected ARRAY: %v", v)
+		}
+	}
+	converted := make([]interface{}, 0, len(elements))
+	for _, e := range elements {
+		ce, err := convertValue(elementType, e)
+		if err != nil {
+			return nil, err
+		}
+		converted = append(converted, ce)
+	}
+
+	// A nil slice is NULL.
+	switch elementType.Code {
+	case "BOOL":
+		return typedSlice[spannerclient.NullBool](v, converted), nil
+	case "INT64":
+		return typedSlice[spannerclient.NullInt64](v, converted), nil
+	case "FLOAT64", "FLOAT32":
+		return typedSlice[spannerclient.NullFloat64](v, converted), nil
+	case "STRING":
+		return typedSlice[spannerclient.NullString](v, converted), nil
+	case "BYTES":
+		return typedSlice[[]byte](v, converted), nil
+	case "TIMESTAMP":
+		return typedSlice[spannerclient.NullTime](v, converted), nil
+	case "DATE":
+		return typedSlice[spannerclient.NullDate](v, converted), nil
+	case "NUMERIC":
+		if elementType.TypeAnnotation == "PG_NUMERIC" {
+			return typedSlice[spannerclient.PGNumeric](v, converted), nil
+		}
+		return typedSlice[spannerclient.NullNumeric](v, converted), nil
+	case "JSON":
+		if elementType.TypeAnnotation == "PG_JSONB" {
+			return typedSlice[spannerclient.PGJsonB](v, converted), nil
+		}
+		return typedSlice[spannerclient.NullJSON](v, converted), nil
+	default:
+		return nil, fmt.Errorf("unsupported array element type: %s", elementType.Code)
+	}
+}
+
+func typedSlice[T any](v interface{}, elements []interface{}) []T {
+	if v == nil {
+		return nil
+	}
+	s := make([]T, len(elements))
+	for i, e := range elements {
+		s[i] = e.(T)
+	}
+	return s
+}
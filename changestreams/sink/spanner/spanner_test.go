@@ -0,0 +1,139 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package spanner
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/civil"
+	spannerclient "cloud.google.com/go/spanner"
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestConvertValue(t *testing.T) {
+	for _, test := range []struct {
+		desc  string
+		t     *changestreams.Type
+		value interface{}
+		want  interface{}
+	}{
+		{desc: "BOOL", t: &changestreams.Type{Code: "BOOL"}, value: true, want: spannerclient.NullBool{Bool: true, Valid: true}},
+		{desc: "INT64", t: &changestreams.Type{Code: "INT64"}, value: "9007199254740993", want: spannerclient.NullInt64{Int64: 9007199254740993, Valid: true}},
+		{desc: "NULL INT64", t: &changestreams.Type{Code: "INT64"}, value: nil, want: spannerclient.NullInt64{}},
+		{desc: "FLOAT64", t: &changestreams.Type{Code: "FLOAT64"}, value: 1.5, want: spannerclient.NullFloat64{Float64: 1.5, Valid: true}},
+		{desc: "FLOAT64 infinity", t: &changestreams.Type{Code: "FLOAT64"}, value: "-Infinity", want: spannerclient.NullFloat64{Float64: math.Inf(-1), Valid: true}},
+		{desc: "STRING", t: &changestreams.Type{Code: "STRING"}, value: "a", want: spannerclient.NullString{StringVal: "a", Valid: true}},
+		{desc: "BYTES", t: &changestreams.Type{Code: "BYTES"}, value: "AQI=", want: []byte{1, 2}},
+		{desc: "TIMESTAMP", t: &changestreams.Type{Code: "TIMESTAMP"}, value: "2023-02-24T17:17:00.678847Z", want: spannerclient.NullTime{Time: time.Date(2023, 2, 24, 17, 17, 0, 678847000, time.UTC), Valid: true}},
+		{desc: "DATE", t: &changestreams.Type{Code: "DATE"}, value: "2023-02-24", want: spannerclient.NullDate{Date: civil.Date{Year: 2023, Month: 2, Day: 24}, Valid: true}},
+		{desc: "NUMERIC", t: &changestreams.Type{Code: "NUMERIC"}, value: "1.25", want: spannerclient.NullNumeric{Numeric: *big.NewRat(5, 4), Valid: true}},
+		{desc: "PG_NUMERIC", t: &changestreams.Type{Code: "NUMERIC", TypeAnnotation: "PG_NUMERIC"}, value: "NaN", want: spannerclient.PGNumeric{Numeric: "NaN", Valid: true}},
+		{desc: "JSON", t: &changestreams.Type{Code: "JSON"}, value: `{"a":1}`, want: spannerclient.NullJSON{Value: map[string]interface{}{"a": float64(1)}, Valid: true}},
+		{desc: "PG_JSONB", t: &changestreams.Type{Code: "JSON", TypeAnnotation: "PG_JSONB"}, value: nil, want: spannerclient.PGJsonB{}},
+		{
+			desc:  "ARRAY",
+			t:     &changestreams.Type{Code: "ARRAY", ArrayElementType: &changestreams.Type{Code: "INT64"}},
+			value: []interface{}{"1", nil},
+			want:  []spannerclient.NullInt64{{Int64: 1, Valid: true}, {}},
+		},
+		{desc: "NULL ARRAY", t: &changestreams.Type{Code: "ARRAY", ArrayElementType: &changestreams.Type{Code: "STRING"}}, value: nil, want: []spannerclient.NullString(nil)},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			got, err := convertValue(test.t, test.value)
+			if err != nil {
+				t.Fatalf("convertValue error: %v", err)
+			}
+			if diff := cmp.Diff(got, test.want, cmp.Comparer(func(a, b big.Rat) bool { return a.Cmp(&b) == 0 })); diff != "" {
+				t.Errorf("diff = %v", diff)
+			}
+		})
+	}
+
+	if _, err := convertValue(&changestreams.Type{Code: "INT64"}, true); err == nil {
+		t.Error("convertValue must fail for a value of the wrong type")
+	}
+}
+
+func TestMutations(t *testing.T) {
+	columnTypes := []*changestreams.ColumnType{
+		{Name: "Name", Type: spannerclient.NullJSON{Value: map[string]interface{}{"code": "STRING"}, Valid: true}, IsPrimaryKey: true, OrdinalPosition: 2},
+		{Name: "SingerId", Type: spannerclient.NullJSON{Value: map[string]interface{}{"code": "INT64"}, Valid: true}, IsPrimaryKey: true, OrdinalPosition: 1},
+		{Name: "Age", Type: spannerclient.NullJSON{Value: map[string]interface{}{"code": "INT64"}, Valid: true}, OrdinalPosition: 3},
+	}
+	mod := &changestreams.Mod{
+		Keys:      spannerclient.NullJSON{Value: map[string]interface{}{"SingerId": "1", "Name": "a"}, Valid: true},
+		NewValues: spannerclient.NullJSON{Value: map[string]interface{}{"Age": "20"}, Valid: true},
+	}
+
+	for _, test := range []struct {
+		modType string
+		want    *spannerclient.Mutation
+	}{
+		{
+			modType: "INSERT",
+			want: spannerclient.InsertOrUpdate("Singers", []string{"SingerId", "Name", "Age"}, []interface{}{
+				spannerclient.NullInt64{Int64: 1, Valid: true},
+				spannerclient.NullString{StringVal: "a", Valid: true},
+				spannerclient.NullInt64{Int64: 20, Valid: true},
+			}),
+		},
+		{
+			modType: "UPDATE",
+			want: spannerclient.Update("Singers", []string{"SingerId", "Name", "Age"}, []interface{}{
+				spannerclient.NullInt64{Int64: 1, Valid: true},
+				spannerclient.NullString{StringVal: "a", Valid: true},
+				spannerclient.NullInt64{Int64: 20, Valid: true},
+			}),
+		},
+		{
+			modType: "DELETE",
+			want: spannerclient.Delete("Singers", spannerclient.Key{
+				spannerclient.NullInt64{Int64: 1, Valid: true},
+				spannerclient.NullString{StringVal: "a", Valid: true},
+			}),
+		},
+	} {
+		t.Run(test.modType, func(t *testing.T) {
+			got, err := Mutations(&changestreams.DataChangeRecord{
+				TableName:   "Singers",
+				ColumnTypes: columnTypes,
+				Mods:        []*changestreams.Mod{mod},
+				ModType:     test.modType,
+			})
+			if err != nil {
+				t.Fatalf("Mutations error: %v", err)
+			}
+			// Mutation has no exported fields, so the mutations are compared by their string representations.
+			if diff := cmp.Diff(mutationStrings(got), mutationStrings([]*spannerclient.Mutation{test.want})); diff != "" {
+				t.Errorf("diff = %v", diff)
+			}
+		})
+	}
+}
+
+func mutationStrings(ms []*spannerclient.Mutation) []string {
+	var s []string
+	for _, m := range ms {
+		s = append(s, fmt.Sprintf("%+v", *m))
+	}
+	return s
+}
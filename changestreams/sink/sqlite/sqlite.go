@@ -0,0 +1,256 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package sqlite implements the sink that writes the change records to a local SQLite database.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams"
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams/sink"
+	_ "modernc.org/sqlite"
+)
+
+const defaultTable = "changes"
+
+// TimestampFormat is the format of commit_timestamp in the database. The timestamps are in UTC and have
+// the fixed number of fractional digits, so they sort in chronological order as strings.
+const TimestampFormat = "2006-01-02T15:04:05.000000000Z"
+
+var validTableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Config is the configuration for the SQLite sink.
+type Config struct {
+	// Path is the path of the database file. The file is created if it doesn't exist.
+	Path string
+	// Table is the name of the table to write the events to. If Table is empty, "changes" is used.
+	Table string
+}
+
+// Sink writes each mod of the data change records to a table of a SQLite database as a row.
+//
+// The table is created if it doesn't exist, with the following schema:
+//
+//	CREATE TABLE changes (
+//	  idempotency_key       TEXT PRIMARY KEY,
+//	  partition_token       TEXT NOT NULL,
+//	  commit_timestamp      TEXT NOT NULL, -- in TimestampFormat
+//	  server_transaction_id TEXT NOT NULL,
+//	  record_sequence       TEXT NOT NULL,
+//	  table_name            TEXT NOT NULL,
+//	  mod_type              TEXT NOT NULL,
+//	  keys                  TEXT NOT NULL, -- JSON
+//	  new_values            TEXT NOT NULL, -- JSON
+//	  old_values            TEXT NOT NULL, -- JSON
+//	  "row"                 TEXT,          -- JSON, NULL unless the mod is enriched with the whole row
+//	  row_stale             INTEGER,       -- 1 if row is the current row, NULL without row
+//	  source                TEXT           -- JSON, NULL unless changestreams.Config.IncludeSource is true
+//	);
+//	CREATE INDEX changes_table_name_commit_timestamp ON changes (table_name, commit_timestamp);
+//	CREATE INDEX changes_commit_timestamp ON changes (commit_timestamp);
+//
+// The nullable columns are added to a table created by an older version of the sink when it's opened.
+//
+// The events of a result are written in a single transaction, and an event written again after a restart
+// is ignored by its idempotency key. The database is in WAL mode, so it can be queried with the standard tools
+// such as the sqlite3 shell while the sink is writing to it.
+type Sink struct {
+	ctx    context.Context
+	db     *sql.DB
+	insert string
+}
+
+// New opens the SQLite database and creates the table if it doesn't exist. ctx is used for all the writes of the sink.
+func New(ctx context.Context, config Config) (*Sink, error) {
+	if config.Path == "" {
+		return nil, errors.New("path of the database must be specified")
+	}
+	if config.Table == "" {
+		config.Table = defaultTable
+	}
+	if !validTableName.MatchString(config.Table) {
+		return nil, fmt.Errorf("invalid table name: %q", config.Table)
+	}
+
+	db, err := sql.Open("sqlite", config.Path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows only one writer at a time, and the pragmas below apply to the connection.
+	db.SetMaxOpenConns(1)
+
+	for _, stmt := range []string{
+		"PRAGMA journal_mode = WAL",
+		"PRAGMA synchronous = NORMAL",
+		"PRAGMA busy_timeout = 5000",
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %[1]s (
+  idempotency_key       TEXT PRIMARY KEY,
+  partition_token       TEXT NOT NULL,
+  commit_timestamp      TEXT NOT NULL,
+  server_transaction_id TEXT NOT NULL,
+  record_sequence       TEXT NOT NULL,
+  table_name            TEXT NOT NULL,
+  mod_type              TEXT NOT NULL,
+  keys                  TEXT NOT NULL,
+  new_values            TEXT NOT NULL,
+  old_values            TEXT NOT NULL,
+  "row"                 TEXT,
+  row_stale             INTEGER,
+  source                TEXT
+)`, config.Table),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS %[1]s_table_name_commit_timestamp ON %[1]s (table_name, commit_timestamp)", config.Table),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS %[1]s_commit_timestamp ON %[1]s (commit_timestamp)", config.Table),
+	} {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to initialize the database: %w", err)
+		}
+	}
+	if err := addNullableColumns(ctx, db, config.Table); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize the database: %w", err)
+	}
+
+	return &Sink{
+		ctx: ctx,
+		db:  db,
+		insert: fmt.Sprintf(`INSERT OR IGNORE INTO %s (idempotency_key, partition_token, commit_timestamp, server_transaction_id,
+  record_sequence, table_name, mod_type, keys, new_values, old_values, "row", row_stale, source)
+  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, config.Table),
+	}, nil
+}
+
+// NewFromURL creates a new SQLite sink from the URL such as "sqlite://changes.db" or
+// "sqlite:///var/lib/changes.db?table=changes". The table parameter sets Config.Table.
+func NewFromURL(ctx context.Context, rawURL string) (*Sink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	// A relative path is parsed as the host, e.g. "changes.db" of "sqlite://changes.db".
+	return New(ctx, Config{
+		Path:  u.Host + u.Path,
+		Table: u.Query().Get("table"),
+	})
+}
+
+// Read writes the events of the result in a single transaction.
+func (s *Sink) Read(result *changestreams.ReadResult) error {
+	events, err := sink.Events(result)
+	if err != nil {
+		return err
+	}
+	if len(events) == 0 {
+		return nil
+	}
+
+	tx, err := s.db.BeginTx(s.ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(s.ctx, s.insert)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, event := range events {
+		var row, source sql.NullString
+		var rowStale sql.NullBool
+		if len(event.Row) > 0 {
+			row = sql.NullString{String: string(event.Row), Valid: true}
+			rowStale = sql.NullBool{Bool: event.RowStale, Valid: true}
+		}
+		if event.Source != nil {
+			b, err := json.Marshal(event.Source)
+			if err != nil {
+				return err
+			}
+			source = sql.NullString{String: string(b), Valid: true}
+		}
+		if _, err := stmt.ExecContext(s.ctx,
+			event.IdempotencyKey,
+			event.PartitionToken,
+			event.CommitTimestamp.UTC().Format(TimestampFormat),
+			event.ServerTransactionID,
+			event.RecordSequence,
+			event.TableName,
+			event.ModType,
+			string(event.Keys),
+			string(event.NewValues),
+			string(event.OldValues),
+			row,
+			rowStale,
+			source,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// nullableColumns are the columns added after the first version of the table, which are added to the existing
+// tables by addNullableColumns.
+var nullableColumns = []struct{ name, definition string }{
+	{name: "row", definition: `"row" TEXT`},
+	{name: "row_stale", definition: "row_stale INTEGER"},
+	{name: "source", definition: "source TEXT"},
+}
+
+// addNullableColumns adds the nullable columns missing from the table.
+func addNullableColumns(ctx context.Context, db *sql.DB, table string) error {
+	rows, err := db.QueryContext(ctx, "SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, column := range nullableColumns {
+		if existing[column.name] {
+			continue
+		}
+		if _, err := db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", table, column.definition)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the database.
+func (s *Sink) Close() error {
+	return s.db.Close()
+}
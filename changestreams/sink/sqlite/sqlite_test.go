@@ -0,0 +1,207 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSink(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewFromURL(ctx, "sqlite://"+filepath.Join(t.TempDir(), "changes.db")+"?table=events")
+	if err != nil {
+		t.Fatalf("NewFromURL error: %v", err)
+	}
+	defer s.Close()
+
+	result := &changestreams.ReadResult{
+		PartitionToken: "token",
+		ChangeRecords: []*changestreams.ChangeRecord{
+			{
+				DataChangeRecords: []*changestreams.DataChangeRecord{
+					{
+						CommitTimestamp:     time.Date(2023, 2, 24, 17, 0, 0, 500, time.UTC),
+						RecordSequence:      "00000000",
+						ServerTransactionID: "txn",
+						TableName:           "Players",
+						ModType:             "INSERT",
+						Mods: []*changestreams.Mod{
+							{
+								Keys:      spanner.NullJSON{Value: map[string]interface{}{"PlayerId": "1"}, Valid: true},
+								NewValues: spanner.NullJSON{Value: map[string]interface{}{"Name": "foo"}, Valid: true},
+								OldValues: spanner.NullJSON{Value: map[string]interface{}{}, Valid: true},
+							},
+							{
+								Keys:      spanner.NullJSON{Value: map[string]interface{}{"PlayerId": "2"}, Valid: true},
+								NewValues: spanner.NullJSON{Value: map[string]interface{}{"Name": "bar"}, Valid: true},
+								OldValues: spanner.NullJSON{Value: map[string]interface{}{}, Valid: true},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	// The result written again is ignored.
+	for i := 0; i < 2; i++ {
+		if err := s.Read(result); err != nil {
+			t.Fatalf("Read error: %v", err)
+		}
+	}
+
+	rows, err := s.db.QueryContext(ctx, "SELECT commit_timestamp, table_name, mod_type, keys, new_values FROM events ORDER BY keys")
+	if err != nil {
+		t.Fatalf("query error: %v", err)
+	}
+	defer rows.Close()
+	var got [][]string
+	for rows.Next() {
+		row := make([]string, 5)
+		if err := rows.Scan(&row[0], &row[1], &row[2], &row[3], &row[4]); err != nil {
+			t.Fatalf("scan error: %v", err)
+		}
+		got = append(got, row)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("rows error: %v", err)
+	}
+
+	want := [][]string{
+		{"2023-02-24T17:00:00.000000500Z", "Players", "INSERT", `{"PlayerId":"1"}`, `{"Name":"foo"}`},
+		{"2023-02-24T17:00:00.000000500Z", "Players", "INSERT", `{"PlayerId":"2"}`, `{"Name":"bar"}`},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("diff = %v", diff)
+	}
+
+	var journalMode string
+	if err := s.db.QueryRowContext(ctx, "PRAGMA journal_mode").Scan(&journalMode); err != nil {
+		t.Fatalf("query error: %v", err)
+	}
+	if journalMode != "wal" {
+		t.Errorf("journal_mode = %q, want %q", journalMode, "wal")
+	}
+}
+
+func TestNewInvalidTable(t *testing.T) {
+	if _, err := New(context.Background(), Config{Path: filepath.Join(t.TempDir(), "changes.db"), Table: "changes; DROP TABLE x"}); err == nil {
+		t.Error("New must fail with an invalid table name")
+	}
+}
+
+func TestSinkRowAndSource(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "changes.db")
+	// The table created by an older version of the sink gets the nullable columns.
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	if _, err := db.ExecContext(ctx, `CREATE TABLE changes (
+  idempotency_key       TEXT PRIMARY KEY,
+  partition_token       TEXT NOT NULL,
+  commit_timestamp      TEXT NOT NULL,
+  server_transaction_id TEXT NOT NULL,
+  record_sequence       TEXT NOT NULL,
+  table_name            TEXT NOT NULL,
+  mod_type              TEXT NOT NULL,
+  keys                  TEXT NOT NULL,
+  new_values            TEXT NOT NULL,
+  old_values            TEXT NOT NULL
+)`); err != nil {
+		t.Fatalf("create error: %v", err)
+	}
+	db.Close()
+
+	s, err := New(ctx, Config{Path: path})
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	defer s.Close()
+
+	newMod := func(id string) *changestreams.Mod {
+		return &changestreams.Mod{
+			Keys:      spanner.NullJSON{Value: map[string]interface{}{"PlayerId": id}, Valid: true},
+			NewValues: spanner.NullJSON{Value: map[string]interface{}{}, Valid: true},
+			OldValues: spanner.NullJSON{Value: map[string]interface{}{}, Valid: true},
+		}
+	}
+	enriched := newMod("1")
+	enriched.Row = []byte(`{"Name":"foo","PlayerId":"1"}`)
+	enriched.RowStale = true
+	record := &changestreams.DataChangeRecord{
+		CommitTimestamp: time.Date(2023, 2, 24, 17, 0, 0, 0, time.UTC),
+		RecordSequence:  "00000000",
+		TableName:       "Players",
+		ModType:         "UPDATE",
+		Mods:            []*changestreams.Mod{enriched, newMod("2")},
+	}
+	for _, result := range []*changestreams.ReadResult{
+		{PartitionToken: "a", ChangeRecords: []*changestreams.ChangeRecord{{DataChangeRecords: []*changestreams.DataChangeRecord{record}}}},
+		{
+			PartitionToken: "b",
+			ChangeRecords:  []*changestreams.ChangeRecord{{DataChangeRecords: []*changestreams.DataChangeRecord{record}}},
+			Source:         &changestreams.Source{ProjectID: "project", InstanceID: "instance", DatabaseID: "database", StreamID: "stream"},
+		},
+	} {
+		if err := s.Read(result); err != nil {
+			t.Fatalf("Read error: %v", err)
+		}
+	}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT "row", row_stale, source FROM changes ORDER BY partition_token, keys`)
+	if err != nil {
+		t.Fatalf("query error: %v", err)
+	}
+	defer rows.Close()
+	type columns struct {
+		Row      sql.NullString
+		RowStale sql.NullBool
+		Source   sql.NullString
+	}
+	var got []columns
+	for rows.Next() {
+		var c columns
+		if err := rows.Scan(&c.Row, &c.RowStale, &c.Source); err != nil {
+			t.Fatalf("scan error: %v", err)
+		}
+		got = append(got, c)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("rows error: %v", err)
+	}
+
+	row := sql.NullString{String: `{"Name":"foo","PlayerId":"1"}`, Valid: true}
+	source := sql.NullString{String: `{"project_id":"project","instance_id":"instance","database_id":"database","stream_id":"stream"}`, Valid: true}
+	want := []columns{
+		{Row: row, RowStale: sql.NullBool{Bool: true, Valid: true}},
+		{},
+		{Row: row, RowStale: sql.NullBool{Bool: true, Valid: true}, Source: source},
+		{Source: source},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("diff = %v", diff)
+	}
+}
@@ -0,0 +1,85 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var errPartitionStalled = errors.New("partition query stalled")
+
+// stallWatchdog cancels the query context when the query makes no progress within the timeout.
+// The deadline slides every time it's resumed. A nil stallWatchdog never cancels the context.
+type stallWatchdog struct {
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+// newStallWatchdog returns the context for the query and the watchdog of it.
+// If timeout is zero, the returned watchdog is nil.
+func newStallWatchdog(ctx context.Context, timeout time.Duration) (context.Context, *stallWatchdog) {
+	if timeout <= 0 {
+		return ctx, nil
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	w := &stallWatchdog{
+		ctx:     ctx,
+		cancel:  cancel,
+		timeout: timeout,
+	}
+	w.timer = time.AfterFunc(timeout, func() {
+		cancel(errPartitionStalled)
+	})
+	return ctx, w
+}
+
+// pause stops the deadline, e.g. while the read function is processing the row.
+func (w *stallWatchdog) pause() {
+	if w == nil {
+		return
+	}
+	w.timer.Stop()
+}
+
+// resume restarts the deadline from now.
+func (w *stallWatchdog) resume() {
+	if w == nil {
+		return
+	}
+	w.timer.Reset(w.timeout)
+}
+
+// stalled reports whether the query context has been cancelled by the watchdog.
+func (w *stallWatchdog) stalled() bool {
+	if w == nil {
+		return false
+	}
+	return errors.Is(context.Cause(w.ctx), errPartitionStalled)
+}
+
+// stop releases the resources of the watchdog. The query context is cancelled.
+func (w *stallWatchdog) stop() {
+	if w == nil {
+		return
+	}
+	w.timer.Stop()
+	w.cancel(nil)
+}
@@ -0,0 +1,61 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStallWatchdog(t *testing.T) {
+	timeout := 20 * time.Millisecond
+
+	t.Run("no progress stalls", func(t *testing.T) {
+		ctx, w := newStallWatchdog(context.Background(), timeout)
+		defer w.stop()
+
+		<-ctx.Done()
+		if !w.stalled() {
+			t.Error("stalled() = false, want true")
+		}
+	})
+
+	t.Run("paused watchdog never stalls", func(t *testing.T) {
+		ctx, w := newStallWatchdog(context.Background(), timeout)
+		w.pause()
+		time.Sleep(2 * timeout)
+		if ctx.Err() != nil || w.stalled() {
+			t.Errorf("context is cancelled while paused: %v", ctx.Err())
+		}
+		w.stop()
+		if ctx.Err() == nil || w.stalled() {
+			t.Errorf("stop() must cancel the context without a stall: %v", ctx.Err())
+		}
+	})
+
+	t.Run("zero timeout", func(t *testing.T) {
+		parent := context.Background()
+		ctx, w := newStallWatchdog(parent, 0)
+		w.pause()
+		w.resume()
+		w.stop()
+		if ctx != parent || w.stalled() {
+			t.Error("watchdog with zero timeout must be a no-op")
+		}
+	})
+}
@@ -0,0 +1,539 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// stalledHeartbeatIntervals is the number of heartbeat intervals without any record after which
+// a partition is considered stalled.
+const stalledHeartbeatIntervals = 3
+
+// Stats is a snapshot of the statistics of the reader.
+type Stats struct {
+	// Partitions are the partitions being read and the failed partitions. The finished partitions are dropped, and
+	// only counted in FinishedPartitions and the totals below, so that the statistics of a long read don't grow
+	// with every partition ever read.
+	Partitions []*PartitionStats `json:"partitions"`
+	// FinishedPartitions is the number of the partitions whose queries have finished, including the initial query.
+	FinishedPartitions int `json:"finished_partitions"`
+	// Rows, DataChangeRecords and Retries are the totals of PartitionStats of all the partitions read so far,
+	// including the finished partitions.
+	Rows              int64 `json:"rows"`
+	DataChangeRecords int64 `json:"data_change_records"`
+	Retries           int64 `json:"retries"`
+	// WatermarkLag is the time between now and the low watermark, i.e. the oldest watermark of the
+	// partitions being read and the start timestamp of the child partitions waiting to be read. It's zero if
+	// no partition is being read or waiting.
+	WatermarkLag time.Duration `json:"watermark_lag"`
+	// OldestPartitionAge is the time since the query of the oldest partition being read started.
+	OldestPartitionAge time.Duration `json:"oldest_partition_age"`
+	// StalledPartitions is the number of the partitions being read that have returned no record,
+	// including heartbeat records, in the last 3 heartbeat intervals.
+	StalledPartitions int `json:"stalled_partitions"`
+	// PausedPartitions is the number of the partitions paused by Config.PauseOnError. A paused partition
+	// doesn't count towards StalledPartitions.
+	PausedPartitions int `json:"paused_partitions"`
+	// MaxPartitionDepth is the depth of the deepest partition read so far. See Config.MaxPartitionDepth.
+	MaxPartitionDepth int `json:"max_partition_depth"`
+	// BufferedRecords is the number of the data change records buffered by Config.CoalesceWindow and ReadBatch,
+	// and not yet delivered to the read function.
+	BufferedRecords int `json:"buffered_records"`
+	// BytesProcessed is the number of the bytes of the rows returned by all the partitions. See rowSize for the
+	// estimation, which is the same for both dialects.
+	BytesProcessed int64 `json:"bytes_processed"`
+	// BytesPerSecond is BytesProcessed divided by the time since the first query started.
+	BytesPerSecond float64 `json:"bytes_per_second"`
+	// ModTypes is the number of the mods read so far of each mod type. The mods are counted after the records
+	// dropped by Config.SampleRate and the mods dropped by Config.SkipNoOpUpdates are removed, and before the
+	// coalescing of Config.CoalesceWindow.
+	ModTypes ModTypeCounts `json:"mod_types"`
+	// TableModTypes is ModTypes of each table. It's only set if Config.CountModTypesPerTable is true.
+	TableModTypes map[string]ModTypeCounts `json:"table_mod_types,omitempty"`
+	// TruncatedValues is the number of the values truncated by Config.MaxValueBytes, keyed by the table
+	// and the column.
+	TruncatedValues map[string]map[string]int64 `json:"truncated_values,omitempty"`
+	// JSONParseFailures is the number of the values of the JSON columns left as strings by
+	// Config.ParseJSONColumns because they failed to parse.
+	JSONParseFailures int64 `json:"json_parse_failures"`
+	// CallbackDurations is the distribution of the durations of the calls of the read function for the rows, or
+	// for the batches of ReadBatch.
+	CallbackDurations DurationHistogram `json:"callback_durations"`
+	// Databases is the statistics of each database. It's only set by MultiDatabaseReader.Stats.
+	Databases []*DatabaseStats `json:"databases,omitempty"`
+}
+
+// ModTypeCounts is the number of the mods of each mod type, e.g. to tell a spike of deletes by a bulk cleanup.
+type ModTypeCounts struct {
+	Inserts int64 `json:"inserts"`
+	Updates int64 `json:"updates"`
+	Deletes int64 `json:"deletes"`
+}
+
+// PartitionStats is the statistics of the query of a partition.
+type PartitionStats struct {
+	PartitionToken string `json:"partition_token"`
+	// PartitionID is the short ID of the partition in the logs, e.g. P-0001, or "root" for the initial query.
+	PartitionID string `json:"partition_id"`
+	Depth       int    `json:"depth"`
+	// ParentPartitionTokens and ParentPartitionIDs are empty for the initial query and the root partitions.
+	ParentPartitionTokens []string `json:"parent_partition_tokens,omitempty"`
+	ParentPartitionIDs    []string `json:"parent_partition_ids,omitempty"`
+	// StartTimestamp is the timestamp the partition started to be read from.
+	StartTimestamp time.Time `json:"start_timestamp"`
+	QueryStartTime time.Time `json:"query_start_time"`
+	Rows           int64     `json:"rows"`
+	// DataChangeRecords is the number of the data change records read from the partition, counted before
+	// the filters.
+	DataChangeRecords int64 `json:"data_change_records"`
+	// TimeToFirstRow is the time from the start of the query to the arrival of the first row.
+	TimeToFirstRow time.Duration `json:"time_to_first_row"`
+	// AverageRowInterval is the average time between the arrivals of consecutive rows.
+	AverageRowInterval time.Duration `json:"average_row_interval"`
+	// WaitTime is the total time spent waiting for rows from Cloud Spanner.
+	WaitTime time.Duration `json:"wait_time"`
+	// CallbackTime is the total time spent delivering rows to the read function.
+	CallbackTime time.Duration `json:"callback_time"`
+	// Watermark is the timestamp up to which all the records of the partition have been delivered.
+	Watermark time.Time `json:"watermark"`
+	// LastHeartbeatTime is the time the last heartbeat record of the partition arrived.
+	LastHeartbeatTime time.Time `json:"last_heartbeat_time"`
+	// Retries is the number of times the query has been retried after a stall or a failure.
+	// See Config.StallTimeout and Config.PartitionErrorPolicy.
+	Retries int64 `json:"retries"`
+	// Finished is always false, since the finished partitions are dropped from Stats.Partitions.
+	//
+	// Deprecated: Use Stats.FinishedPartitions.
+	Finished bool `json:"finished"`
+	// Bytes is the number of the bytes of the rows returned by the query.
+	Bytes int64 `json:"bytes"`
+	// Failed is true if the partition has been skipped by PartitionErrorIsolateFailures policy.
+	// A failed partition no longer counts towards WatermarkLag, OldestPartitionAge and StalledPartitions.
+	Failed bool `json:"failed"`
+	// PauseError is the error of the read function for which the partition is paused by Config.PauseOnError.
+	// It's empty unless the partition is paused.
+	PauseError string `json:"pause_error,omitempty"`
+	// QueryStats is always nil, since the query statistics are returned when the query finishes and the finished
+	// partitions are dropped from Stats.Partitions.
+	//
+	// Deprecated: The query statistics of Config.CollectQueryStats are logged in the partition_query_stats events.
+	QueryStats map[string]interface{} `json:"query_stats,omitempty"`
+}
+
+type partitionStats struct {
+	partition       *partitionContext
+	queryStartTime  time.Time
+	firstRowTime    time.Time
+	lastRowTime     time.Time
+	lastCallbackEnd time.Time
+	rows            int64
+	records         int64
+	waitTime        time.Duration
+	callbackTime    time.Duration
+	watermark       time.Time
+	lastHeartbeat   time.Time
+	retries         int64
+	bytes           int64
+	failed          bool
+	pauseError      string
+}
+
+// statsRecorder records the statistics of the partitions.
+type statsRecorder struct {
+	// partitions are the partitions being read and the failed partitions. The finished partitions are folded into
+	// finished and removed.
+	partitions map[string]*partitionStats
+	finished   finishedStats
+	// maxDepth is the depth of the deepest partition whose query has started.
+	maxDepth int
+	// pendingChildren are the start timestamps of the child partitions that have been returned by a parent but
+	// whose queries haven't started, so that the low watermark never passes them while a split is in flight.
+	pendingChildren map[string]time.Time
+	// startTime is the start time of the first query, from which BytesPerSecond is measured.
+	startTime time.Time
+	mu        sync.Mutex
+}
+
+// finishedStats is the aggregate of the statistics of the finished partitions.
+type finishedStats struct {
+	partitions int
+	rows       int64
+	records    int64
+	retries    int64
+	bytes      int64
+	// watermark is the latest watermark of the finished partitions.
+	watermark time.Time
+}
+
+func newStatsRecorder() *statsRecorder {
+	return &statsRecorder{
+		partitions:      make(map[string]*partitionStats),
+		pendingChildren: make(map[string]time.Time),
+	}
+}
+
+// queryStarted must be called just before the query of the partition is started.
+func (s *statsRecorder) queryStarted(partition *partitionContext, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.startTime.IsZero() {
+		s.startTime = now
+	}
+	delete(s.pendingChildren, partition.token)
+	s.maxDepth = max(s.maxDepth, partition.depth)
+	s.partitions[partition.token] = &partitionStats{
+		partition:       partition,
+		queryStartTime:  now,
+		lastCallbackEnd: now,
+		watermark:       partition.startTimestamp,
+	}
+}
+
+// childrenReturned must be called with the child partitions returned by a partition before the partition is
+// marked as finished. The children count towards the low watermark at their start timestamps until their
+// queries start or they are skipped.
+func (s *statsRecorder) childrenReturned(children []*PendingPartition) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, child := range children {
+		if _, ok := s.partitions[child.Token]; ok {
+			continue
+		}
+		if start, ok := s.pendingChildren[child.Token]; !ok || child.StartTimestamp.Before(start) {
+			s.pendingChildren[child.Token] = child.StartTimestamp
+		}
+	}
+}
+
+// childSkipped must be called when the child partition returned by a partition is never read by the reader.
+func (s *statsRecorder) childSkipped(partitionToken string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.pendingChildren, partitionToken)
+}
+
+// queryRetried must be called when the query of the partition is retried.
+func (s *statsRecorder) queryRetried(partitionToken string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.partitions[partitionToken].retries++
+}
+
+// queryFinished must be called when the query of the partition has returned all the rows. The statistics of the
+// partition are folded into the totals of the finished partitions.
+func (s *statsRecorder) queryFinished(partitionToken string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := s.partitions[partitionToken]
+	s.finished.partitions++
+	s.finished.rows += p.rows
+	s.finished.records += p.records
+	s.finished.retries += p.retries
+	s.finished.bytes += p.bytes
+	if p.watermark.After(s.finished.watermark) {
+		s.finished.watermark = p.watermark
+	}
+	delete(s.partitions, partitionToken)
+}
+
+// queryFailed must be called when the partition is skipped after its query failed.
+func (s *statsRecorder) queryFailed(partitionToken string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.partitions[partitionToken].failed = true
+}
+
+// queryPaused must be called when the partition is paused by the error of the read function, and
+// queryUnpaused when it's resumed. A batch of ReadBatch may be paused after its partition has finished, in which
+// case nothing is recorded.
+func (s *statsRecorder) queryPaused(partitionToken string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p, ok := s.partitions[partitionToken]; ok {
+		p.pauseError = err.Error()
+	}
+}
+
+func (s *statsRecorder) queryUnpaused(partitionToken string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p, ok := s.partitions[partitionToken]; ok {
+		p.pauseError = ""
+	}
+}
+
+// rowArrived must be called when a row of the partition arrives.
+func (s *statsRecorder) rowArrived(partitionToken string, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := s.partitions[partitionToken]
+	if p.rows == 0 {
+		p.firstRowTime = now
+	}
+	p.rows++
+	p.lastRowTime = now
+	p.waitTime += now.Sub(p.lastCallbackEnd)
+}
+
+// heartbeatArrived must be called when a row of the partition with heartbeat records arrives.
+func (s *statsRecorder) heartbeatArrived(partitionToken string, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.partitions[partitionToken].lastHeartbeat = now
+}
+
+// recordsArrived must be called when the data change records of a row of the partition are decoded.
+func (s *statsRecorder) recordsArrived(partitionToken string, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.partitions[partitionToken].records += int64(n)
+}
+
+// bytesRead must be called with the size of each row of the partition.
+func (s *statsRecorder) bytesRead(partitionToken string, bytes int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.partitions[partitionToken].bytes += bytes
+}
+
+// callbackFinished must be called when the read function returns for a row of the partition.
+func (s *statsRecorder) callbackFinished(partitionToken string, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := s.partitions[partitionToken]
+	p.callbackTime += now.Sub(p.lastRowTime)
+	p.lastCallbackEnd = now
+}
+
+// advanceWatermark must be called when the records of the partition up to timestamp have been delivered.
+func (s *statsRecorder) advanceWatermark(partitionToken string, timestamp time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := s.partitions[partitionToken]
+	if timestamp.After(p.watermark) {
+		p.watermark = timestamp
+	}
+}
+
+// lowWatermark returns the oldest watermark of the partitions being read and the start timestamps of the child
+// partitions waiting to be read. If no partition is being read or waiting, it returns the latest watermark of
+// the finished or failed partitions.
+func (s *statsRecorder) lowWatermark() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	high := s.finished.watermark
+	for _, p := range s.partitions {
+		if p.failed && p.watermark.After(high) {
+			high = p.watermark
+		}
+	}
+	low := s.activeLowWatermark()
+	if low.IsZero() {
+		return high
+	}
+	return low
+}
+
+// activeLowWatermark returns the oldest watermark of the partitions being read and the start timestamps of the
+// child partitions waiting to be read, or the zero time if there is none. s.mu must be held.
+func (s *statsRecorder) activeLowWatermark() time.Time {
+	var low time.Time
+	for _, p := range s.partitions {
+		if !p.failed && (low.IsZero() || p.watermark.Before(low)) {
+			low = p.watermark
+		}
+	}
+	for _, start := range s.pendingChildren {
+		if low.IsZero() || start.Before(low) {
+			low = start
+		}
+	}
+	return low
+}
+
+func (s *statsRecorder) snapshot(now time.Time, heartbeatInterval time.Duration) Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := Stats{
+		FinishedPartitions: s.finished.partitions,
+		Rows:               s.finished.rows,
+		DataChangeRecords:  s.finished.records,
+		Retries:            s.finished.retries,
+		BytesProcessed:     s.finished.bytes,
+		MaxPartitionDepth:  s.maxDepth,
+	}
+	var oldestQueryStartTime time.Time
+	for token, p := range s.partitions {
+		ps := &PartitionStats{
+			PartitionToken:        token,
+			PartitionID:           p.partition.id,
+			Depth:                 p.partition.depth,
+			ParentPartitionTokens: p.partition.parentTokens,
+			ParentPartitionIDs:    p.partition.parentIDs,
+			StartTimestamp:        p.partition.startTimestamp,
+			QueryStartTime:        p.queryStartTime,
+			Rows:                  p.rows,
+			DataChangeRecords:     p.records,
+			WaitTime:              p.waitTime,
+			CallbackTime:          p.callbackTime,
+			Watermark:             p.watermark,
+			LastHeartbeatTime:     p.lastHeartbeat,
+			Retries:               p.retries,
+			Bytes:                 p.bytes,
+			Failed:                p.failed,
+			PauseError:            p.pauseError,
+		}
+		stats.Rows += p.rows
+		stats.DataChangeRecords += p.records
+		stats.Retries += p.retries
+		stats.BytesProcessed += p.bytes
+		if p.rows > 0 {
+			ps.TimeToFirstRow = p.firstRowTime.Sub(p.queryStartTime)
+		}
+		if p.rows > 1 {
+			ps.AverageRowInterval = p.lastRowTime.Sub(p.firstRowTime) / time.Duration(p.rows-1)
+		}
+		stats.Partitions = append(stats.Partitions, ps)
+
+		if p.failed {
+			continue
+		}
+		if oldestQueryStartTime.IsZero() || p.queryStartTime.Before(oldestQueryStartTime) {
+			oldestQueryStartTime = p.queryStartTime
+		}
+		lastRowTime := p.queryStartTime
+		if p.rows > 0 {
+			lastRowTime = p.lastRowTime
+		}
+		switch {
+		case p.pauseError != "":
+			stats.PausedPartitions++
+		case now.Sub(lastRowTime) > stalledHeartbeatIntervals*heartbeatInterval:
+			stats.StalledPartitions++
+		}
+	}
+	if lowWatermark := s.activeLowWatermark(); !lowWatermark.IsZero() {
+		stats.WatermarkLag = now.Sub(lowWatermark)
+	}
+	if !oldestQueryStartTime.IsZero() {
+		stats.OldestPartitionAge = now.Sub(oldestQueryStartTime)
+	}
+	if elapsed := now.Sub(s.startTime); !s.startTime.IsZero() && elapsed > 0 {
+		stats.BytesPerSecond = float64(stats.BytesProcessed) / elapsed.Seconds()
+	}
+	sort.Slice(stats.Partitions, func(i, j int) bool {
+		return stats.Partitions[i].PartitionToken < stats.Partitions[j].PartitionToken
+	})
+	return stats
+}
+
+type atomicModTypeCounts struct {
+	inserts atomic.Int64
+	updates atomic.Int64
+	deletes atomic.Int64
+}
+
+func (c *atomicModTypeCounts) add(modType string, n int64) {
+	switch modType {
+	case modTypeInsert:
+		c.inserts.Add(n)
+	case modTypeUpdate:
+		c.updates.Add(n)
+	case modTypeDelete:
+		c.deletes.Add(n)
+	}
+}
+
+func (c *atomicModTypeCounts) load() ModTypeCounts {
+	return ModTypeCounts{Inserts: c.inserts.Load(), Updates: c.updates.Load(), Deletes: c.deletes.Load()}
+}
+
+// modTypeCounter counts the mods of each mod type with atomics, so that counting every record is cheap.
+type modTypeCounter struct {
+	total    atomicModTypeCounts
+	perTable bool
+	// tables is the *atomicModTypeCounts of each table.
+	tables sync.Map
+}
+
+// count must be called when the data change record is read.
+func (c *modTypeCounter) count(record *DataChangeRecord) {
+	n := int64(len(record.Mods))
+	c.total.add(record.ModType, n)
+	if !c.perTable {
+		return
+	}
+	counts, ok := c.tables.Load(record.TableName)
+	if !ok {
+		counts, _ = c.tables.LoadOrStore(record.TableName, &atomicModTypeCounts{})
+	}
+	counts.(*atomicModTypeCounts).add(record.ModType, n)
+}
+
+func (c *modTypeCounter) snapshot(stats *Stats) {
+	stats.ModTypes = c.total.load()
+	if !c.perTable {
+		return
+	}
+	stats.TableModTypes = make(map[string]ModTypeCounts)
+	c.tables.Range(func(table, counts any) bool {
+		stats.TableModTypes[table.(string)] = counts.(*atomicModTypeCounts).load()
+		return true
+	})
+}
+
+// latestTimestamp returns the latest timestamp of the records in the result, or the zero time if there is none.
+func latestTimestamp(result *ReadResult) time.Time {
+	var latest time.Time
+	advance := func(t time.Time) {
+		if t.After(latest) {
+			latest = t
+		}
+	}
+	for _, changeRecord := range result.ChangeRecords {
+		for _, r := range changeRecord.DataChangeRecords {
+			advance(r.CommitTimestamp)
+		}
+		for _, r := range changeRecord.HeartbeatRecords {
+			advance(r.Timestamp)
+		}
+		for _, r := range changeRecord.ChildPartitionsRecords {
+			advance(r.StartTimestamp)
+		}
+	}
+	return latest
+}
@@ -0,0 +1,217 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestStatsRecorder(t *testing.T) {
+	start := mustParseTime("2023-02-24T17:00:00Z")
+	at := func(seconds int) time.Time {
+		return start.Add(time.Duration(seconds) * time.Second)
+	}
+
+	s := newStatsRecorder()
+	s.queryStarted(&partitionContext{token: "a", depth: 1, startTimestamp: at(-60)}, at(0))
+	s.rowArrived("a", at(3))
+	s.callbackFinished("a", at(4))
+	s.advanceWatermark("a", at(-50))
+	s.rowArrived("a", at(6))
+	s.callbackFinished("a", at(9))
+	s.advanceWatermark("a", at(-40))
+	s.rowArrived("a", at(11))
+	s.heartbeatArrived("a", at(11))
+	s.callbackFinished("a", at(11))
+	s.queryStarted(&partitionContext{token: "b", id: "P-0002", depth: 2, parentTokens: []string{"a"}, parentIDs: []string{"P-0001"}, startTimestamp: at(-10)}, at(5))
+	s.queryRetried("b")
+	s.queryStarted(&partitionContext{token: "c", depth: 0, startTimestamp: at(-120)}, at(-100))
+	s.rowArrived("c", at(-99))
+	s.bytesRead("c", 100)
+	s.queryFinished("c")
+
+	want := Stats{
+		Partitions: []*PartitionStats{
+			{
+				PartitionToken:     "a",
+				Depth:              1,
+				StartTimestamp:     at(-60),
+				QueryStartTime:     at(0),
+				Rows:               3,
+				TimeToFirstRow:     3 * time.Second,
+				AverageRowInterval: 4 * time.Second,
+				WaitTime:           7 * time.Second,
+				CallbackTime:       4 * time.Second,
+				Watermark:          at(-40),
+				LastHeartbeatTime:  at(11),
+			},
+			{
+				PartitionToken:        "b",
+				PartitionID:           "P-0002",
+				Depth:                 2,
+				ParentPartitionTokens: []string{"a"},
+				ParentPartitionIDs:    []string{"P-0001"},
+				StartTimestamp:        at(-10),
+				QueryStartTime:        at(5),
+				Watermark:             at(-10),
+				Retries:               1,
+			},
+		},
+		// The finished partition c is dropped from the partitions, but still counted in the totals.
+		FinishedPartitions: 1,
+		Rows:               4,
+		Retries:            1,
+		BytesProcessed:     100,
+		BytesPerSecond:     5,
+		WatermarkLag:       60 * time.Second,
+		OldestPartitionAge: 20 * time.Second,
+		// Partition b has returned no row since its query started.
+		StalledPartitions: 1,
+		MaxPartitionDepth: 2,
+	}
+	if diff := cmp.Diff(s.snapshot(at(20), 4*time.Second), want); diff != "" {
+		t.Errorf("diff = %v", diff)
+	}
+}
+
+func TestStatsRecorderLowWatermark(t *testing.T) {
+	start := mustParseTime("2023-02-24T17:00:00Z")
+	at := func(seconds int) time.Time {
+		return start.Add(time.Duration(seconds) * time.Second)
+	}
+
+	s := newStatsRecorder()
+	s.queryStarted(&partitionContext{token: "a", depth: 1, startTimestamp: at(0)}, at(0))
+	s.advanceWatermark("a", at(10))
+	s.queryFinished("a")
+	s.queryStarted(&partitionContext{token: "b", depth: 2, startTimestamp: at(10)}, at(10))
+	s.advanceWatermark("b", at(30))
+	s.queryStarted(&partitionContext{token: "c", depth: 2, startTimestamp: at(10)}, at(10))
+	s.advanceWatermark("c", at(20))
+	if got, want := s.lowWatermark(), at(20); !got.Equal(want) {
+		t.Errorf("lowWatermark = %v, want %v", got, want)
+	}
+
+	// A failed partition no longer holds the low watermark.
+	s.queryFailed("c")
+	if got, want := s.lowWatermark(), at(30); !got.Equal(want) {
+		t.Errorf("lowWatermark after failed = %v, want %v", got, want)
+	}
+
+	s.queryFinished("b")
+	if got, want := s.lowWatermark(), at(30); !got.Equal(want) {
+		t.Errorf("lowWatermark after all finished = %v, want %v", got, want)
+	}
+}
+
+func TestStatsRecorderLowWatermarkDuringSplit(t *testing.T) {
+	start := mustParseTime("2023-02-24T17:00:00Z")
+	at := func(seconds int) time.Time {
+		return start.Add(time.Duration(seconds) * time.Second)
+	}
+
+	// a splits into c and d at 10 while b has been read up to 40 and split into e.
+	s := newStatsRecorder()
+	s.queryStarted(&partitionContext{token: "a", depth: 1, startTimestamp: at(0)}, at(0))
+	s.queryStarted(&partitionContext{token: "b", depth: 1, startTimestamp: at(0)}, at(0))
+	s.advanceWatermark("b", at(40))
+	s.childrenReturned([]*PendingPartition{{Token: "e", StartTimestamp: at(40), ParentPartitionTokens: []string{"b"}}})
+	s.queryFinished("b")
+	s.advanceWatermark("a", at(10))
+	s.childrenReturned([]*PendingPartition{
+		{Token: "c", StartTimestamp: at(10), ParentPartitionTokens: []string{"a"}},
+		{Token: "d", StartTimestamp: at(10), ParentPartitionTokens: []string{"a"}},
+	})
+	s.queryFinished("a")
+
+	// No query is running, but the records of the children from 10 may still arrive.
+	if got, want := s.lowWatermark(), at(10); !got.Equal(want) {
+		t.Errorf("lowWatermark before the children start = %v, want %v", got, want)
+	}
+	s.queryStarted(&partitionContext{token: "c", depth: 2, startTimestamp: at(10)}, at(50))
+	s.advanceWatermark("c", at(20))
+	s.childSkipped("d")
+	if got, want := s.lowWatermark(), at(20); !got.Equal(want) {
+		t.Errorf("lowWatermark after the children start = %v, want %v", got, want)
+	}
+	s.queryFinished("c")
+	s.childSkipped("e")
+	if got, want := s.lowWatermark(), at(40); !got.Equal(want) {
+		t.Errorf("lowWatermark after all finished = %v, want %v", got, want)
+	}
+}
+
+func TestStatsRecorderWatermarkLagDuringSplit(t *testing.T) {
+	start := mustParseTime("2023-02-24T17:00:00Z")
+	at := func(seconds int) time.Time {
+		return start.Add(time.Duration(seconds) * time.Second)
+	}
+
+	s := newStatsRecorder()
+	s.queryStarted(&partitionContext{token: "a", depth: 1, startTimestamp: at(0)}, at(0))
+	s.advanceWatermark("a", at(10))
+	s.childrenReturned([]*PendingPartition{{Token: "b", StartTimestamp: at(10), ParentPartitionTokens: []string{"a"}}})
+	s.queryFinished("a")
+
+	// The lag is measured from the child waiting to be read, not reset while the split is in flight.
+	stats := s.snapshot(at(70), 4*time.Second)
+	if want := time.Minute; stats.WatermarkLag != want {
+		t.Errorf("WatermarkLag before the child starts = %v, want %v", stats.WatermarkLag, want)
+	}
+	if stats.OldestPartitionAge != 0 {
+		t.Errorf("OldestPartitionAge before the child starts = %v, want 0", stats.OldestPartitionAge)
+	}
+
+	s.queryStarted(&partitionContext{token: "b", depth: 2, startTimestamp: at(10)}, at(70))
+	s.queryFinished("b")
+	if stats := s.snapshot(at(80), 4*time.Second); stats.WatermarkLag != 0 {
+		t.Errorf("WatermarkLag after all finished = %v, want 0", stats.WatermarkLag)
+	}
+}
+
+func TestModTypeCounter(t *testing.T) {
+	records := []*DataChangeRecord{
+		{TableName: "Singers", ModType: "INSERT", Mods: []*Mod{{}, {}}},
+		{TableName: "Singers", ModType: "DELETE", Mods: []*Mod{{}}},
+		{TableName: "Albums", ModType: "UPDATE", Mods: []*Mod{{}}},
+	}
+	for _, perTable := range []bool{false, true} {
+		c := &modTypeCounter{perTable: perTable}
+		for _, record := range records {
+			c.count(record)
+		}
+		var stats Stats
+		c.snapshot(&stats)
+
+		if want := (ModTypeCounts{Inserts: 2, Updates: 1, Deletes: 1}); stats.ModTypes != want {
+			t.Errorf("perTable=%v: ModTypes = %+v, want %+v", perTable, stats.ModTypes, want)
+		}
+		var want map[string]ModTypeCounts
+		if perTable {
+			want = map[string]ModTypeCounts{
+				"Singers": {Inserts: 2, Deletes: 1},
+				"Albums":  {Updates: 1},
+			}
+		}
+		if diff := cmp.Diff(stats.TableModTypes, want); diff != "" {
+			t.Errorf("perTable=%v: TableModTypes diff = %v", perTable, diff)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/spanner"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// ErrStreamNotFound is returned when the database doesn't define the change stream.
+var ErrStreamNotFound = errors.New("change stream not found")
+
+// ChangeStream is a change stream defined in a database.
+type ChangeStream struct {
+	Name string `json:"name"`
+	// All is true if the change stream tracks all the tables of the database.
+	All bool `json:"all"`
+	// Tables are the tables tracked by the change stream, unless All is true.
+	Tables []string `json:"tables,omitempty"`
+	// Options are the options of the change stream, e.g. retention_period and value_capture_type.
+	Options map[string]string `json:"options,omitempty"`
+}
+
+// ListChangeStreams returns the change streams defined in the database of the client, ordered by the name.
+// The transient errors of detecting the dialect are retried as in NewReaderWithConfig with the default
+// Config.InitMaxAttempts and Config.InitAttemptTimeout.
+func ListChangeStreams(ctx context.Context, client *spanner.Client) ([]*ChangeStream, error) {
+	d, err := detectDialectWithRetry(ctx, client, defaultInitMaxAttempts, defaultInitAttemptTimeout)
+	if err != nil {
+		return nil, err
+	}
+	// ALL is a reserved keyword in both dialects.
+	allColumn := "`all`"
+	if d == dialectPostgreSQL {
+		allColumn = `"all"`
+	}
+
+	streams := make(map[string]*ChangeStream)
+	stmt := spanner.NewStatement("SELECT change_stream_name, " + allColumn + " FROM information_schema.change_streams")
+	if err := client.Single().Query(ctx, stmt).Do(func(r *spanner.Row) error {
+		var name string
+		var all spanner.GenericColumnValue
+		if err := r.Columns(&name, &all); err != nil {
+			return err
+		}
+		// ALL is BOOL in GoogleSQL, and YES or NO in PostgreSQL.
+		switch v := all.Value.GetKind().(type) {
+		case *structpb.Value_BoolValue:
+			streams[name] = &ChangeStream{Name: name, All: v.BoolValue}
+		case *structpb.Value_StringValue:
+			streams[name] = &ChangeStream{Name: name, All: v.StringValue == "YES"}
+		default:
+			streams[name] = &ChangeStream{Name: name}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	stmt = spanner.NewStatement("SELECT change_stream_name, table_name FROM information_schema.change_stream_tables")
+	if err := client.Single().Query(ctx, stmt).Do(func(r *spanner.Row) error {
+		var name, table string
+		if err := r.Columns(&name, &table); err != nil {
+			return err
+		}
+		if s, ok := streams[name]; ok {
+			s.Tables = append(s.Tables, table)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	stmt = spanner.NewStatement("SELECT change_stream_name, option_name, option_value FROM information_schema.change_stream_options")
+	if err := client.Single().Query(ctx, stmt).Do(func(r *spanner.Row) error {
+		var name, option, value string
+		if err := r.Columns(&name, &option, &value); err != nil {
+			return err
+		}
+		if s, ok := streams[name]; ok {
+			if s.Options == nil {
+				s.Options = make(map[string]string)
+			}
+			s.Options[option] = value
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	list := make([]*ChangeStream, 0, len(streams))
+	for _, s := range streams {
+		sort.Strings(s.Tables)
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list, nil
+}
+
+// hasChangeStream returns true if the database defines the change stream.
+func hasChangeStream(ctx context.Context, client *spanner.Client, d dialect, streamID string) (bool, error) {
+	var stmt spanner.Statement
+	switch d {
+	case dialectGoogleSQL:
+		stmt = spanner.Statement{
+			SQL:    "SELECT change_stream_name FROM information_schema.change_streams WHERE change_stream_name = @stream",
+			Params: map[string]interface{}{"stream": streamID},
+		}
+	case dialectPostgreSQL:
+		// The stream ID is not quoted in the queries, so PostgreSQL folds it to lower case.
+		stmt = spanner.Statement{
+			SQL:    "SELECT change_stream_name FROM information_schema.change_streams WHERE change_stream_name = $1",
+			Params: map[string]interface{}{"p1": strings.ToLower(streamID)},
+		}
+	default:
+		return false, fmt.Errorf("unexpected dialect: %s", d)
+	}
+	found := false
+	if err := client.Single().Query(ctx, stmt).Do(func(r *spanner.Row) error {
+		found = true
+		return nil
+	}); err != nil {
+		return false, err
+	}
+	return found, nil
+}
@@ -0,0 +1,54 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"context"
+	"testing"
+
+	"cloud.google.com/go/spanner"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestListChangeStreams(t *testing.T) {
+	server, opts := newFakeSpannerServer(t)
+	server.changeStreams = []string{"b", "a"}
+	server.retentionPeriod = "7d"
+	// The transient error of the dialect detection is retried.
+	server.dialectErrors = []error{status.Error(codes.Unavailable, "unavailable")}
+
+	ctx := context.Background()
+	client, err := spanner.NewClient(ctx, "projects/project/instances/instance/databases/database", opts...)
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+	defer client.Close()
+
+	streams, err := ListChangeStreams(ctx, client)
+	if err != nil {
+		t.Fatalf("ListChangeStreams error: %v", err)
+	}
+	want := []*ChangeStream{
+		{Name: "a", All: true, Options: map[string]string{"retention_period": "7d"}},
+		{Name: "b", All: true, Options: map[string]string{"retention_period": "7d"}},
+	}
+	if diff := cmp.Diff(streams, want); diff != "" {
+		t.Errorf("ListChangeStreams diff = %v", diff)
+	}
+}
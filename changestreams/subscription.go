@@ -0,0 +1,182 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrSlowSubscriber is the error of the subscription dropped because it couldn't keep up with the stream.
+var ErrSlowSubscriber = errors.New("subscriber is too slow")
+
+// SubscriberErrorPolicy decides what happens when the function of a subscription returns an error.
+type SubscriberErrorPolicy int
+
+const (
+	// SubscriberErrorCancelRead cancels the whole read, and Read returns the error.
+	SubscriberErrorCancelRead SubscriberErrorPolicy = iota
+	// SubscriberErrorCancelSubscription ends only the subscription. The error is available from Subscription.Err.
+	SubscriberErrorCancelSubscription
+)
+
+// SlowSubscriberPolicy decides what happens when the buffer of a subscription is full.
+type SlowSubscriberPolicy int
+
+const (
+	// SlowSubscriberBlock blocks the partition reads until the subscriber catches up.
+	SlowSubscriberBlock SlowSubscriberPolicy = iota
+	// SlowSubscriberDrop ends the subscription with ErrSlowSubscriber.
+	SlowSubscriberDrop
+)
+
+// SubscriptionConfig is the configuration for a subscription.
+type SubscriptionConfig struct {
+	// BufferSize is the number of the results buffered for the subscriber.
+	BufferSize  int
+	ErrorPolicy SubscriberErrorPolicy
+	SlowPolicy  SlowSubscriberPolicy
+}
+
+// Subscription is an independent consumer of the results read by the reader.
+type Subscription struct {
+	f      func(result *ReadResult) error
+	config SubscriptionConfig
+	ch     chan *ReadResult
+	done   chan struct{}
+	once   sync.Once
+	err    error
+	errMu  sync.Mutex
+	// deliverMu is held while f is running.
+	deliverMu sync.Mutex
+}
+
+// Subscribe registers function f that receives every result read by the reader, in addition to
+// the function passed to Read. Each subscription runs f in its own goroutine, so subscribers don't
+// block each other except for the backpressure of SlowSubscriberBlock policy.
+//
+// The results are shared between the subscribers and must not be modified.
+// Subscribe must be called before Read.
+func (r *Reader) Subscribe(f func(result *ReadResult) error, config SubscriptionConfig) (*Subscription, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.group != nil {
+		return nil, errors.New("reader has already been read")
+	}
+	if !r.disablePanicRecovery {
+		f = recoverPanics(f)
+	}
+	s := &Subscription{
+		f:      f,
+		config: config,
+		ch:     make(chan *ReadResult, config.BufferSize),
+		done:   make(chan struct{}),
+	}
+	r.subscriptions = append(r.subscriptions, s)
+	return s, nil
+}
+
+// Unsubscribe ends the subscription. If f of the subscription is running, Unsubscribe waits for it to return,
+// and f is never called after Unsubscribe returns. Therefore Unsubscribe must not be called from within f;
+// return an error with SubscriberErrorCancelSubscription policy instead.
+func (s *Subscription) Unsubscribe() {
+	s.end(nil)
+	s.deliverMu.Lock()
+	defer s.deliverMu.Unlock()
+}
+
+// Done returns a channel that's closed when the subscription ends.
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the error that ended the subscription, or nil if the subscription is active,
+// unsubscribed or the read has finished.
+func (s *Subscription) Err() error {
+	s.errMu.Lock()
+	defer s.errMu.Unlock()
+	return s.err
+}
+
+func (s *Subscription) end(err error) {
+	s.once.Do(func() {
+		s.errMu.Lock()
+		s.err = err
+		s.errMu.Unlock()
+		close(s.done)
+	})
+}
+
+// send passes the result to the subscriber according to the slow subscriber policy.
+func (s *Subscription) send(ctx context.Context, result *ReadResult) error {
+	if s.config.SlowPolicy == SlowSubscriberDrop {
+		select {
+		case s.ch <- result:
+		case <-s.done:
+		default:
+			s.end(ErrSlowSubscriber)
+		}
+		return nil
+	}
+
+	select {
+	case s.ch <- result:
+		return nil
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run delivers the results to f until the channel is closed or the subscription ends.
+// It returns an error only if the error must cancel the read.
+func (s *Subscription) run() error {
+	for {
+		select {
+		case <-s.done:
+			return nil
+		case result, ok := <-s.ch:
+			if !ok {
+				s.end(nil)
+				return nil
+			}
+			if err := s.deliver(result); err != nil {
+				s.end(err)
+				if s.config.ErrorPolicy == SubscriberErrorCancelRead {
+					return err
+				}
+				return nil
+			}
+		}
+	}
+}
+
+func (s *Subscription) deliver(result *ReadResult) error {
+	s.deliverMu.Lock()
+	defer s.deliverMu.Unlock()
+
+	select {
+	case <-s.done:
+		// Unsubscribed while waiting for the lock.
+		return nil
+	default:
+	}
+	return s.f(result)
+}
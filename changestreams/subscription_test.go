@@ -0,0 +1,135 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestSubscription(t *testing.T) {
+	ctx := context.Background()
+	results := []*ReadResult{{PartitionToken: "a"}, {PartitionToken: "b"}, {PartitionToken: "c"}}
+
+	t.Run("every subscriber receives all results", func(t *testing.T) {
+		r := &Reader{}
+		var got1, got2 []string
+		s1, _ := r.Subscribe(func(result *ReadResult) error {
+			got1 = append(got1, result.PartitionToken)
+			return nil
+		}, SubscriptionConfig{})
+		s2, _ := r.Subscribe(func(result *ReadResult) error {
+			got2 = append(got2, result.PartitionToken)
+			return nil
+		}, SubscriptionConfig{BufferSize: 10})
+
+		errs := make(chan error, 2)
+		go func() { errs <- s1.run() }()
+		go func() { errs <- s2.run() }()
+		for _, result := range results {
+			for _, s := range []*Subscription{s1, s2} {
+				if err := s.send(ctx, result); err != nil {
+					t.Fatalf("send error: %v", err)
+				}
+			}
+		}
+		close(s1.ch)
+		close(s2.ch)
+		for i := 0; i < 2; i++ {
+			if err := <-errs; err != nil {
+				t.Errorf("run error: %v", err)
+			}
+		}
+
+		want := []string{"a", "b", "c"}
+		if diff := cmp.Diff(got1, want); diff != "" {
+			t.Errorf("subscriber 1 diff = %v", diff)
+		}
+		if diff := cmp.Diff(got2, want); diff != "" {
+			t.Errorf("subscriber 2 diff = %v", diff)
+		}
+	})
+
+	t.Run("error cancels only the subscription", func(t *testing.T) {
+		r := &Reader{}
+		subscriberErr := errors.New("subscriber error")
+		s, _ := r.Subscribe(func(result *ReadResult) error {
+			return subscriberErr
+		}, SubscriptionConfig{ErrorPolicy: SubscriberErrorCancelSubscription})
+
+		errs := make(chan error, 1)
+		go func() { errs <- s.run() }()
+		for _, result := range results {
+			if err := s.send(ctx, result); err != nil {
+				t.Fatalf("send error: %v", err)
+			}
+		}
+		if err := <-errs; err != nil {
+			t.Errorf("run error: %v", err)
+		}
+		if err := s.Err(); err != subscriberErr {
+			t.Errorf("Err() = %v, want %v", err, subscriberErr)
+		}
+	})
+
+	t.Run("error cancels the read", func(t *testing.T) {
+		r := &Reader{}
+		subscriberErr := errors.New("subscriber error")
+		s, _ := r.Subscribe(func(result *ReadResult) error {
+			return subscriberErr
+		}, SubscriptionConfig{ErrorPolicy: SubscriberErrorCancelRead})
+
+		errs := make(chan error, 1)
+		go func() { errs <- s.run() }()
+		if err := s.send(ctx, results[0]); err != nil {
+			t.Fatalf("send error: %v", err)
+		}
+		if err := <-errs; err != subscriberErr {
+			t.Errorf("run error = %v, want %v", err, subscriberErr)
+		}
+	})
+
+	t.Run("slow subscriber is dropped", func(t *testing.T) {
+		r := &Reader{}
+		s, _ := r.Subscribe(func(result *ReadResult) error {
+			return nil
+		}, SubscriptionConfig{BufferSize: 1, SlowPolicy: SlowSubscriberDrop})
+
+		// Nobody receives from the subscription, so the second result overflows the buffer.
+		for _, result := range results {
+			if err := s.send(ctx, result); err != nil {
+				t.Fatalf("send error: %v", err)
+			}
+		}
+		<-s.Done()
+		if err := s.Err(); err != ErrSlowSubscriber {
+			t.Errorf("Err() = %v, want %v", err, ErrSlowSubscriber)
+		}
+	})
+
+	t.Run("subscribe after read fails", func(t *testing.T) {
+		r := &Reader{}
+		r.group = &errgroup.Group{}
+		if _, err := r.Subscribe(func(result *ReadResult) error { return nil }, SubscriptionConfig{}); err == nil {
+			t.Error("Subscribe must fail after read")
+		}
+	})
+}
@@ -0,0 +1,38 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"cloud.google.com/go/spanner"
+	"google.golang.org/protobuf/proto"
+)
+
+// rowSize returns the number of the bytes of the row, estimated as the size of its column values encoded in
+// protobuf as Cloud Spanner sends them. The values of a GoogleSQL row are structs and those of a PostgreSQL row are
+// JSON strings, but both are measured before they are decoded, so the estimation is consistent across the dialects
+// and doesn't depend on the options of the decoding. It excludes the framing of the stream and the metadata.
+func rowSize(row *spanner.Row) int64 {
+	var size int
+	for i := 0; i < row.Size(); i++ {
+		var v spanner.GenericColumnValue
+		if err := row.Column(i, &v); err != nil {
+			continue
+		}
+		size += proto.Size(v.Value)
+	}
+	return int64(size)
+}
@@ -0,0 +1,52 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"testing"
+	"time"
+
+	"cloud.google.com/go/spanner"
+)
+
+func TestRowSize(t *testing.T) {
+	row, err := spanner.NewRow([]string{"a", "b"}, []interface{}{"abc", int64(1)})
+	if err != nil {
+		t.Fatalf("NewRow error: %v", err)
+	}
+	// "abc" is a string value of 2+3 bytes, and INT64 is encoded as the string "1" of 2+1 bytes.
+	if got := rowSize(row); got != 8 {
+		t.Errorf("rowSize = %d, want 8", got)
+	}
+}
+
+func TestStatsRecorderBytes(t *testing.T) {
+	start := mustParseTime("2023-02-24T17:00:00Z")
+	s := newStatsRecorder()
+	s.queryStarted(&partitionContext{token: "a", startTimestamp: start}, start)
+	s.bytesRead("a", 300)
+	s.queryStarted(&partitionContext{token: "b", startTimestamp: start}, start.Add(time.Second))
+	s.bytesRead("b", 100)
+
+	stats := s.snapshot(start.Add(2*time.Second), time.Minute)
+	if stats.BytesProcessed != 400 || stats.BytesPerSecond != 200 {
+		t.Errorf("BytesProcessed = %d, BytesPerSecond = %v, want 400 and 200", stats.BytesProcessed, stats.BytesPerSecond)
+	}
+	if stats.Partitions[0].Bytes != 300 || stats.Partitions[1].Bytes != 100 {
+		t.Errorf("Bytes = %d, %d, want 300 and 100", stats.Partitions[0].Bytes, stats.Partitions[1].Bytes)
+	}
+}
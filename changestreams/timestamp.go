@@ -0,0 +1,42 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"fmt"
+	"time"
+)
+
+// formatTimestampValue re-renders the TIMESTAMP value, which is an RFC 3339 string in UTC, in the location
+// with the layout. A nil location keeps the location of the value, and an empty layout means time.RFC3339Nano.
+func formatTimestampValue(v interface{}, location *time.Location, layout string) (interface{}, error) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("unexpected value of TIMESTAMP: %T", v)
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return nil, err
+	}
+	if location != nil {
+		t = t.In(location)
+	}
+	if layout == "" {
+		layout = time.RFC3339Nano
+	}
+	return t.Format(layout), nil
+}
@@ -0,0 +1,56 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+)
+
+// LevelTrace is the log level of the data change records delivered to the read function. It's below
+// slog.LevelDebug, so the records are logged only if Config.Logger is enabled for LevelTrace.
+const LevelTrace = slog.LevelDebug - 4
+
+// traceRecords logs the metadata of the data change records in the result, and their mods too if
+// Config.TraceRecordValues is true.
+func (r *Reader) traceRecords(ctx context.Context, result *ReadResult) {
+	if !r.logger.Enabled(ctx, LevelTrace) {
+		return
+	}
+	for record := range result.DataChangeRecords() {
+		attrs := []slog.Attr{
+			slog.String("event", "record_delivered"),
+			slog.String("partition_token", result.PartitionToken),
+			slog.String("table_name", record.TableName),
+			slog.String("mod_type", record.ModType),
+			slog.Time("commit_timestamp", record.CommitTimestamp),
+			slog.String("server_transaction_id", record.ServerTransactionID),
+			slog.String("record_sequence", record.RecordSequence),
+			slog.Int("mods", len(record.Mods)),
+		}
+		if r.traceRecordValues {
+			// The values may contain personal data, so they're only logged on request.
+			// They're encoded in JSON, which is readable with the text handler as well.
+			mods, err := json.Marshal(record.Mods)
+			if err == nil {
+				attrs = append(attrs, slog.String("mod_values", string(mods)))
+			}
+		}
+		r.logger.LogAttrs(ctx, LevelTrace, "record delivered", attrs...)
+	}
+}
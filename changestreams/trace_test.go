@@ -0,0 +1,94 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/spanner"
+)
+
+func TestTraceRecords(t *testing.T) {
+	ctx := context.Background()
+	result := &ReadResult{
+		PartitionToken: "token",
+		ChangeRecords: []*ChangeRecord{{
+			DataChangeRecords: []*DataChangeRecord{{
+				CommitTimestamp:     time.Date(2023, 2, 24, 17, 17, 0, 123456789, time.UTC),
+				ServerTransactionID: "txn",
+				RecordSequence:      "00000000",
+				TableName:           "Users",
+				ModType:             "INSERT",
+				Mods: []*Mod{{
+					Keys:      spanner.NullJSON{Value: map[string]interface{}{"UserId": "1"}, Valid: true},
+					NewValues: spanner.NullJSON{Value: map[string]interface{}{"Email": "user@example.com"}, Valid: true},
+				}},
+			}},
+		}},
+	}
+	trace := func(level slog.Level, traceRecordValues bool) []map[string]interface{} {
+		var buf bytes.Buffer
+		r := &Reader{
+			logger:            slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: level})),
+			traceRecordValues: traceRecordValues,
+		}
+		r.traceRecords(ctx, result)
+		var logs []map[string]interface{}
+		for dec := json.NewDecoder(&buf); dec.More(); {
+			var log map[string]interface{}
+			if err := dec.Decode(&log); err != nil {
+				t.Fatalf("invalid log: %v", err)
+			}
+			logs = append(logs, log)
+		}
+		return logs
+	}
+
+	if logs := trace(slog.LevelDebug, true); len(logs) != 0 {
+		t.Errorf("records must not be logged above LevelTrace, got %v", logs)
+	}
+
+	logs := trace(LevelTrace, false)
+	if len(logs) != 1 {
+		t.Fatalf("logs = %v, want 1 log", logs)
+	}
+	for key, want := range map[string]interface{}{
+		"event":            "record_delivered",
+		"partition_token":  "token",
+		"table_name":       "Users",
+		"mod_type":         "INSERT",
+		"commit_timestamp": "2023-02-24T17:17:00.123456789Z",
+		"mods":             float64(1),
+	} {
+		if got := logs[0][key]; got != want {
+			t.Errorf("%s = %v, want %v", key, got, want)
+		}
+	}
+	if _, ok := logs[0]["mod_values"]; ok {
+		t.Error("values must not be logged without TraceRecordValues")
+	}
+
+	logs = trace(LevelTrace, true)
+	if want := `[{"keys":{"UserId":"1"},"new_values":{"Email":"user@example.com"},"old_values":null}]`; len(logs) != 1 || logs[0]["mod_values"] != want {
+		t.Errorf("logs = %v, want mod_values %s", logs, want)
+	}
+}
@@ -0,0 +1,143 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/spanner"
+)
+
+// trackedRow is the row of the change stream query decoded without the column types and the mods of
+// the data change records.
+type trackedRow struct {
+	ChangeRecords []*trackedChangeRecord `spanner:"ChangeRecord"`
+}
+
+type trackedChangeRecord struct {
+	DataChangeRecords      []*trackedDataChangeRecord `spanner:"data_change_record" json:"data_change_record"`
+	HeartbeatRecords       []*HeartbeatRecord         `spanner:"heartbeat_record" json:"heartbeat_record"`
+	ChildPartitionsRecords []*ChildPartitionsRecord   `spanner:"child_partitions_record" json:"child_partitions_record"`
+}
+
+type trackedChangeRecordPostgres struct {
+	DataChangeRecord      *trackedDataChangeRecord `json:"data_change_record"`
+	HeartbeatRecord       *HeartbeatRecord         `json:"heartbeat_record"`
+	ChildPartitionsRecord *ChildPartitionsRecord   `json:"child_partitions_record"`
+}
+
+// trackedDataChangeRecord is DataChangeRecord without ColumnTypes and Mods.
+type trackedDataChangeRecord struct {
+	CommitTimestamp                      time.Time `spanner:"commit_timestamp" json:"commit_timestamp"`
+	RecordSequence                       string    `spanner:"record_sequence" json:"record_sequence"`
+	ServerTransactionID                  string    `spanner:"server_transaction_id" json:"server_transaction_id"`
+	IsLastRecordInTransactionInPartition bool      `spanner:"is_last_record_in_transaction_in_partition" json:"is_last_record_in_transaction_in_partition"`
+	TableName                            string    `spanner:"table_name" json:"table_name"`
+	ModType                              string    `spanner:"mod_type" json:"mod_type"`
+	ValueCaptureType                     string    `spanner:"value_capture_type" json:"value_capture_type"`
+	NumberOfRecordsInTransaction         int64     `spanner:"number_of_records_in_transaction" json:"number_of_records_in_transaction"`
+	NumberOfPartitionsInTransaction      int64     `spanner:"number_of_partitions_in_transaction" json:"number_of_partitions_in_transaction"`
+	TransactionTag                       string    `spanner:"transaction_tag" json:"transaction_tag"`
+	IsSystemTransaction                  bool      `spanner:"is_system_transaction" json:"is_system_transaction"`
+}
+
+func (t *trackedDataChangeRecord) record() *DataChangeRecord {
+	return &DataChangeRecord{
+		CommitTimestamp:                      t.CommitTimestamp,
+		RecordSequence:                       t.RecordSequence,
+		ServerTransactionID:                  t.ServerTransactionID,
+		IsLastRecordInTransactionInPartition: t.IsLastRecordInTransactionInPartition,
+		TableName:                            t.TableName,
+		ModType:                              t.ModType,
+		ValueCaptureType:                     t.ValueCaptureType,
+		NumberOfRecordsInTransaction:         t.NumberOfRecordsInTransaction,
+		NumberOfPartitionsInTransaction:      t.NumberOfPartitionsInTransaction,
+		TransactionTag:                       t.TransactionTag,
+		IsSystemTransaction:                  t.IsSystemTransaction,
+	}
+}
+
+// Track reads the change stream like Read, but delivers no result. It's meant for monitoring the lag of
+// the stream: the heartbeats drive the watermark, the data change records are counted, and Stats works
+// as usual, while the column types and the mods of the records are never decoded.
+// Read with a nil function is the same as Track.
+//
+// The results delivered to the subscriptions registered by Subscribe have no column types and mods either.
+func (r *Reader) Track(ctx context.Context) error {
+	return r.Read(ctx, nil)
+}
+
+// decodeTrackedRow decodes the row of the change stream query into the result without the column types
+// and the mods of the data change records.
+func (r *Reader) decodeTrackedRow(row *spanner.Row, result *ReadResult) error {
+	var changeRecords []*trackedChangeRecord
+	switch r.dialect {
+	case dialectGoogleSQL:
+		var tracked trackedRow
+		if err := row.ToStructLenient(&tracked); err != nil {
+			return err
+		}
+		changeRecords = tracked.ChangeRecords
+	case dialectPostgreSQL:
+		var col spanner.NullJSON
+		if err := row.Column(0, &col); err != nil {
+			return err
+		}
+		jsonBytes, err := col.MarshalJSON()
+		if err != nil {
+			return err
+		}
+		var changeRecordPG trackedChangeRecordPostgres
+		if err := json.Unmarshal(jsonBytes, &changeRecordPG); err != nil {
+			return err
+		}
+		changeRecord := &trackedChangeRecord{
+			DataChangeRecords:      []*trackedDataChangeRecord{},
+			HeartbeatRecords:       []*HeartbeatRecord{},
+			ChildPartitionsRecords: []*ChildPartitionsRecord{},
+		}
+		if changeRecordPG.DataChangeRecord != nil {
+			changeRecord.DataChangeRecords = []*trackedDataChangeRecord{changeRecordPG.DataChangeRecord}
+		}
+		if changeRecordPG.HeartbeatRecord != nil {
+			changeRecord.HeartbeatRecords = []*HeartbeatRecord{changeRecordPG.HeartbeatRecord}
+		}
+		if changeRecordPG.ChildPartitionsRecord != nil {
+			changeRecord.ChildPartitionsRecords = []*ChildPartitionsRecord{changeRecordPG.ChildPartitionsRecord}
+		}
+		changeRecords = []*trackedChangeRecord{changeRecord}
+	default:
+		return fmt.Errorf("unexpected dialect: %s", r.dialect)
+	}
+
+	result.ChangeRecords = make([]*ChangeRecord, 0, len(changeRecords))
+	for _, tracked := range changeRecords {
+		changeRecord := &ChangeRecord{
+			DataChangeRecords:      make([]*DataChangeRecord, 0, len(tracked.DataChangeRecords)),
+			HeartbeatRecords:       tracked.HeartbeatRecords,
+			ChildPartitionsRecords: tracked.ChildPartitionsRecords,
+		}
+		for _, record := range tracked.DataChangeRecords {
+			changeRecord.DataChangeRecords = append(changeRecord.DataChangeRecords, record.record())
+		}
+		result.ChangeRecords = append(result.ChangeRecords, changeRecord)
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	sppb "cloud.google.com/go/spanner/apiv1/spannerpb"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// newDataChangeRecordRow returns the row of a data change record of the GoogleSQL dialect with the mods.
+func newDataChangeRecordRow(t testing.TB, mods int) *spanner.Row {
+	t.Helper()
+	str := &sppb.Type{Code: sppb.TypeCode_STRING}
+	jsonType := &sppb.Type{Code: sppb.TypeCode_JSON}
+	boolType := &sppb.Type{Code: sppb.TypeCode_BOOL}
+	int64Type := &sppb.Type{Code: sppb.TypeCode_INT64}
+
+	var modValues []*structpb.Value
+	for i := 0; i < mods; i++ {
+		modValues = append(modValues, listValue(
+			structpb.NewStringValue(fmt.Sprintf(`{"id":"%d"}`, i)),
+			structpb.NewStringValue(fmt.Sprintf(`{"name":"name-%d","value":%d}`, i, i)),
+			structpb.NewStringValue(`{}`),
+		))
+	}
+	row, err := spanner.NewRow([]string{"ChangeRecord"}, []interface{}{spanner.GenericColumnValue{
+		Type: arrayType(structType(
+			field("data_change_record", arrayType(structType(
+				field("commit_timestamp", &sppb.Type{Code: sppb.TypeCode_TIMESTAMP}),
+				field("record_sequence", str),
+				field("server_transaction_id", str),
+				field("is_last_record_in_transaction_in_partition", boolType),
+				field("table_name", str),
+				field("column_types", arrayType(structType(
+					field("name", str),
+					field("type", jsonType),
+					field("is_primary_key", boolType),
+					field("ordinal_position", int64Type),
+				))),
+				field("mods", arrayType(structType(
+					field("keys", jsonType),
+					field("new_values", jsonType),
+					field("old_values", jsonType),
+				))),
+				field("mod_type", str),
+				field("value_capture_type", str),
+				field("number_of_records_in_transaction", int64Type),
+				field("number_of_partitions_in_transaction", int64Type),
+				field("transaction_tag", str),
+				field("is_system_transaction", boolType),
+			))),
+			field("heartbeat_record", arrayType(structType(field("timestamp", &sppb.Type{Code: sppb.TypeCode_TIMESTAMP})))),
+		)),
+		Value: listValue(listValue(
+			listValue(listValue(
+				structpb.NewStringValue("2023-02-24T17:17:00Z"),
+				structpb.NewStringValue("00000000"),
+				structpb.NewStringValue("transaction"),
+				structpb.NewBoolValue(true),
+				structpb.NewStringValue("Singers"),
+				listValue(
+					listValue(structpb.NewStringValue("id"), structpb.NewStringValue(`{"code":"STRING"}`), structpb.NewBoolValue(true), structpb.NewStringValue("1")),
+					listValue(structpb.NewStringValue("name"), structpb.NewStringValue(`{"code":"STRING"}`), structpb.NewBoolValue(false), structpb.NewStringValue("2")),
+					listValue(structpb.NewStringValue("value"), structpb.NewStringValue(`{"code":"INT64"}`), structpb.NewBoolValue(false), structpb.NewStringValue("3")),
+				),
+				listValue(modValues...),
+				structpb.NewStringValue("INSERT"),
+				structpb.NewStringValue("OLD_AND_NEW_VALUES"),
+				structpb.NewStringValue("1"),
+				structpb.NewStringValue("1"),
+				structpb.NewStringValue("tag"),
+				structpb.NewBoolValue(false),
+			)),
+			listValue(),
+		)),
+	}})
+	if err != nil {
+		t.Fatalf("NewRow error: %v", err)
+	}
+	return row
+}
+
+func TestDecodeTrackedRow(t *testing.T) {
+	row := newDataChangeRecordRow(t, 3)
+	r := &Reader{dialect: dialectGoogleSQL}
+
+	var full ReadResult
+	if err := r.decodeRow(row, &full); err != nil {
+		t.Fatalf("decodeRow error: %v", err)
+	}
+	var tracked ReadResult
+	if err := r.decodeTrackedRow(row, &tracked); err != nil {
+		t.Fatalf("decodeTrackedRow error: %v", err)
+	}
+
+	// The tracked records are the same except for the column types and the mods.
+	for _, changeRecord := range full.ChangeRecords {
+		for _, record := range changeRecord.DataChangeRecords {
+			record.ColumnTypes = nil
+			record.Mods = nil
+		}
+	}
+	if diff := cmp.Diff(full, tracked); diff != "" {
+		t.Errorf("decodeTrackedRow diff (-full +tracked):\n%s", diff)
+	}
+}
+
+func TestTrack(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2023, 2, 24, 17, 17, 0, 0, time.UTC)
+	server, opts := newFakeSpannerServer(t)
+	server.childPartitions = map[string][]*ChildPartitionsRecord{
+		"": {{StartTimestamp: start, RecordSequence: "00000001", ChildPartitions: []*ChildPartition{{Token: "a"}}}},
+	}
+	server.dataChangeRecords = map[string][]*DataChangeRecord{
+		"a": {
+			{CommitTimestamp: start.Add(time.Second), RecordSequence: "00000000", IsLastRecordInTransactionInPartition: true},
+			{CommitTimestamp: start.Add(2 * time.Second), RecordSequence: "00000001", IsLastRecordInTransactionInPartition: true},
+		},
+	}
+
+	r, err := NewReaderWithConfig(ctx, "project", "instance", "database", "stream", Config{
+		StartTimestamp:       start,
+		EndTimestamp:         start.Add(time.Minute),
+		SpannerClientOptions: opts,
+	})
+	if err != nil {
+		t.Fatalf("NewReaderWithConfig error: %v", err)
+	}
+	defer r.Close()
+	if err := r.Track(ctx); err != nil {
+		t.Fatalf("Track error: %v", err)
+	}
+
+	// The partitions are dropped from the stats when they finish, but their records are still counted.
+	stats := r.Stats()
+	if stats.FinishedPartitions != 2 || len(stats.Partitions) != 0 {
+		t.Fatalf("Stats has %d finished partitions and %+v, want 2 and none", stats.FinishedPartitions, stats.Partitions)
+	}
+	if stats.DataChangeRecords != 2 {
+		t.Errorf("DataChangeRecords = %d, want 2", stats.DataChangeRecords)
+	}
+	if want := start.Add(2 * time.Second); !r.LowWatermark().Equal(want) {
+		t.Errorf("LowWatermark = %v, want %v", r.LowWatermark(), want)
+	}
+}
+
+func BenchmarkDecodeRow(b *testing.B) {
+	row := newDataChangeRecordRow(b, 100)
+	r := &Reader{dialect: dialectGoogleSQL}
+	for _, bench := range []struct {
+		name   string
+		decode func(row *spanner.Row, result *ReadResult) error
+	}{
+		{name: "Read", decode: r.decodeRow},
+		{name: "Track", decode: r.decodeTrackedRow},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				var result ReadResult
+				if err := bench.decode(row, &result); err != nil {
+					b.Fatalf("decode error: %v", err)
+				}
+			}
+		})
+	}
+}
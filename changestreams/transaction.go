@@ -0,0 +1,189 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// IncompleteTransaction is a transaction of which only a part of the data change records have been read.
+type IncompleteTransaction struct {
+	ServerTransactionID             string
+	CommitTimestamp                 time.Time
+	FirstReadTime                   time.Time
+	RecordsRead                     int64
+	NumberOfRecordsInTransaction    int64
+	PartitionsRead                  int64
+	NumberOfPartitionsInTransaction int64
+}
+
+// TransactionSummary summarizes a transaction of which all the data change records have been read,
+// e.g. for an audit trail. See Config.OnTransactionSummary.
+type TransactionSummary struct {
+	ServerTransactionID string    `json:"server_transaction_id"`
+	CommitTimestamp     time.Time `json:"commit_timestamp"`
+	TransactionTag      string    `json:"transaction_tag"`
+	// Tables are the tables modified by the transaction, ordered by the name.
+	Tables []string `json:"tables"`
+	// TableMods is the number of the mods, i.e. the rows modified by the transaction, of each table.
+	TableMods map[string]int64 `json:"table_mods"`
+	// Mods is the total number of the mods of the transaction.
+	Mods int64 `json:"mods"`
+	// Records is the number of the data change records of the transaction.
+	Records int64 `json:"records"`
+}
+
+// maxCompletedTransactions is the number of the completed transaction IDs remembered
+// to ignore the duplicated records that arrive after the completion.
+const maxCompletedTransactions = 10000
+
+type transactionProgress struct {
+	commitTimestamp    time.Time
+	firstReadTime      time.Time
+	expectedRecords    int64
+	expectedPartitions int64
+	partitions         map[string]struct{}
+	transactionTag     string
+	// tableMods is the number of the mods read of each table.
+	tableMods map[string]int64
+	// records is the set of the records read, keyed by the partition token and the record sequence.
+	records map[string]struct{}
+}
+
+// transactionTracker counts the data change records read for each transaction, and
+// detects when all the records of a transaction have been read.
+//
+// The tracker doesn't retain the record payloads, only the identities of the records.
+type transactionTracker struct {
+	transactions map[string]*transactionProgress
+	completed    map[string]struct{}
+	// completedOrder is a ring buffer of the completed transaction IDs to evict the oldest one from completed.
+	completedOrder []string
+	completedNext  int
+	onComplete     func(summary *TransactionSummary)
+	now            func() time.Time
+	mu             sync.Mutex
+}
+
+func newTransactionTracker(onComplete func(summary *TransactionSummary)) *transactionTracker {
+	return &transactionTracker{
+		transactions:   make(map[string]*transactionProgress),
+		completed:      make(map[string]struct{}),
+		completedOrder: make([]string, maxCompletedTransactions),
+		onComplete:     onComplete,
+		now:            time.Now,
+	}
+}
+
+// observe records that the data change record has been read from the partition.
+// Records that have already been observed are ignored, so duplicated records don't complete a transaction twice.
+func (t *transactionTracker) observe(partitionToken string, record *DataChangeRecord) {
+	txnID := record.ServerTransactionID
+
+	t.mu.Lock()
+	if _, ok := t.completed[txnID]; ok {
+		t.mu.Unlock()
+		return
+	}
+	progress, ok := t.transactions[txnID]
+	if !ok {
+		progress = &transactionProgress{
+			commitTimestamp:    record.CommitTimestamp,
+			firstReadTime:      t.now(),
+			expectedRecords:    record.NumberOfRecordsInTransaction,
+			expectedPartitions: record.NumberOfPartitionsInTransaction,
+			partitions:         make(map[string]struct{}),
+			transactionTag:     record.TransactionTag,
+			tableMods:          make(map[string]int64),
+			records:            make(map[string]struct{}),
+		}
+		t.transactions[txnID] = progress
+	}
+	key := partitionToken + "/" + record.RecordSequence
+	if _, ok := progress.records[key]; !ok {
+		progress.records[key] = struct{}{}
+		progress.tableMods[record.TableName] += int64(len(record.Mods))
+	}
+	progress.partitions[partitionToken] = struct{}{}
+
+	completed := int64(len(progress.records)) >= progress.expectedRecords
+	if completed {
+		delete(t.transactions, txnID)
+		t.markCompleted(txnID)
+	}
+	t.mu.Unlock()
+
+	if completed && t.onComplete != nil {
+		summary := &TransactionSummary{
+			ServerTransactionID: txnID,
+			CommitTimestamp:     progress.commitTimestamp,
+			TransactionTag:      progress.transactionTag,
+			Tables:              make([]string, 0, len(progress.tableMods)),
+			TableMods:           progress.tableMods,
+			Records:             progress.expectedRecords,
+		}
+		for table, mods := range progress.tableMods {
+			summary.Tables = append(summary.Tables, table)
+			summary.Mods += mods
+		}
+		sort.Strings(summary.Tables)
+		t.onComplete(summary)
+	}
+}
+
+// markCompleted must be called with t.mu held.
+func (t *transactionTracker) markCompleted(txnID string) {
+	if evicted := t.completedOrder[t.completedNext]; evicted != "" {
+		delete(t.completed, evicted)
+	}
+	t.completedOrder[t.completedNext] = txnID
+	t.completedNext = (t.completedNext + 1) % len(t.completedOrder)
+	t.completed[txnID] = struct{}{}
+}
+
+// incomplete returns the transactions first read more than minAge ago that are not completed yet,
+// ordered by the commit timestamp.
+func (t *transactionTracker) incomplete(minAge time.Duration) []*IncompleteTransaction {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	var transactions []*IncompleteTransaction
+	for txnID, progress := range t.transactions {
+		if now.Sub(progress.firstReadTime) < minAge {
+			continue
+		}
+		transactions = append(transactions, &IncompleteTransaction{
+			ServerTransactionID:             txnID,
+			CommitTimestamp:                 progress.commitTimestamp,
+			FirstReadTime:                   progress.firstReadTime,
+			RecordsRead:                     int64(len(progress.records)),
+			NumberOfRecordsInTransaction:    progress.expectedRecords,
+			PartitionsRead:                  int64(len(progress.partitions)),
+			NumberOfPartitionsInTransaction: progress.expectedPartitions,
+		})
+	}
+	sort.Slice(transactions, func(i, j int) bool {
+		if transactions[i].CommitTimestamp.Equal(transactions[j].CommitTimestamp) {
+			return transactions[i].ServerTransactionID < transactions[j].ServerTransactionID
+		}
+		return transactions[i].CommitTimestamp.Before(transactions[j].CommitTimestamp)
+	})
+	return transactions
+}
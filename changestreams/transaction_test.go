@@ -0,0 +1,142 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTransactionTracker(t *testing.T) {
+	commitTimestamp := mustParseTime("2023-02-24T17:17:00.678847-08:00")
+	now := mustParseTime("2023-02-24T17:18:00Z")
+
+	var completed []string
+	var completedTables [][]string
+	tracker := newTransactionTracker(func(summary *TransactionSummary) {
+		completed = append(completed, summary.ServerTransactionID)
+		completedTables = append(completedTables, summary.Tables)
+	})
+	tracker.now = func() time.Time { return now }
+
+	txn1Record := &DataChangeRecord{
+		ServerTransactionID:             "txn1",
+		CommitTimestamp:                 commitTimestamp,
+		RecordSequence:                  "00000000",
+		TableName:                       "players",
+		NumberOfRecordsInTransaction:    2,
+		NumberOfPartitionsInTransaction: 2,
+	}
+	tracker.observe("a", txn1Record)
+	// Duplicated record must not be counted.
+	tracker.observe("a", txn1Record)
+	tracker.observe("a", &DataChangeRecord{
+		ServerTransactionID:             "txn2",
+		TableName:                       "players",
+		CommitTimestamp:                 commitTimestamp,
+		NumberOfRecordsInTransaction:    1,
+		NumberOfPartitionsInTransaction: 1,
+	})
+
+	if diff := cmp.Diff(completed, []string{"txn2"}); diff != "" {
+		t.Errorf("completed diff = %v", diff)
+	}
+	want := []*IncompleteTransaction{
+		{
+			ServerTransactionID:             "txn1",
+			CommitTimestamp:                 commitTimestamp,
+			FirstReadTime:                   now,
+			RecordsRead:                     1,
+			NumberOfRecordsInTransaction:    2,
+			PartitionsRead:                  1,
+			NumberOfPartitionsInTransaction: 2,
+		},
+	}
+	if diff := cmp.Diff(tracker.incomplete(0), want); diff != "" {
+		t.Errorf("incomplete diff = %v", diff)
+	}
+	if got := tracker.incomplete(time.Minute); len(got) != 0 {
+		t.Errorf("incomplete(time.Minute) = %v, want empty", got)
+	}
+
+	tracker.observe("b", &DataChangeRecord{
+		ServerTransactionID:             "txn1",
+		CommitTimestamp:                 commitTimestamp,
+		RecordSequence:                  "00000000",
+		TableName:                       "accounts",
+		NumberOfRecordsInTransaction:    2,
+		NumberOfPartitionsInTransaction: 2,
+	})
+	// Duplicated record after the completion must not fire the callback again.
+	tracker.observe("a", txn1Record)
+
+	if diff := cmp.Diff(completed, []string{"txn2", "txn1"}); diff != "" {
+		t.Errorf("completed diff = %v", diff)
+	}
+	if diff := cmp.Diff(completedTables, [][]string{{"players"}, {"accounts", "players"}}); diff != "" {
+		t.Errorf("completed tables diff = %v", diff)
+	}
+	if got := tracker.incomplete(0); len(got) != 0 {
+		t.Errorf("incomplete(0) = %v, want empty", got)
+	}
+}
+
+func TestTransactionSummary(t *testing.T) {
+	commitTimestamp := mustParseTime("2023-02-24T17:17:00.678847-08:00")
+
+	var summaries []*TransactionSummary
+	tracker := newTransactionTracker(func(summary *TransactionSummary) {
+		summaries = append(summaries, summary)
+	})
+	newRecord := func(sequence, table string, mods int) *DataChangeRecord {
+		record := &DataChangeRecord{
+			ServerTransactionID:             "txn1",
+			CommitTimestamp:                 commitTimestamp,
+			RecordSequence:                  sequence,
+			TableName:                       table,
+			TransactionTag:                  "audit",
+			NumberOfRecordsInTransaction:    3,
+			NumberOfPartitionsInTransaction: 2,
+		}
+		for i := 0; i < mods; i++ {
+			record.Mods = append(record.Mods, &Mod{})
+		}
+		return record
+	}
+	tracker.observe("a", newRecord("00000000", "players", 2))
+	// Duplicated record must not be counted.
+	tracker.observe("a", newRecord("00000000", "players", 2))
+	tracker.observe("a", newRecord("00000001", "accounts", 1))
+	tracker.observe("b", newRecord("00000002", "players", 3))
+
+	want := []*TransactionSummary{
+		{
+			ServerTransactionID: "txn1",
+			CommitTimestamp:     commitTimestamp,
+			TransactionTag:      "audit",
+			Tables:              []string{"accounts", "players"},
+			TableMods:           map[string]int64{"accounts": 1, "players": 5},
+			Mods:                6,
+			Records:             3,
+		},
+	}
+	if diff := cmp.Diff(summaries, want); diff != "" {
+		t.Errorf("summaries diff = %v", diff)
+	}
+}
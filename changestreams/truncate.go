@@ -0,0 +1,141 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"encoding/json"
+	"sync"
+	"unicode/utf8"
+)
+
+// valueTruncator replaces the column values larger than maxBytes with a marker, and counts the truncated values
+// of each table and column.
+type valueTruncator struct {
+	maxBytes int
+	// counts is the number of the truncated values keyed by the table and the column.
+	counts map[string]map[string]int64
+	mu     sync.Mutex
+}
+
+func newValueTruncator(maxBytes int) *valueTruncator {
+	if maxBytes <= 0 {
+		return nil
+	}
+	return &valueTruncator{maxBytes: maxBytes, counts: make(map[string]map[string]int64)}
+}
+
+// truncate truncates the new and old values of the mods of the data change records. The primary key columns
+// are never truncated. It's a no-op on a nil truncator.
+func (t *valueTruncator) truncate(changeRecord *ChangeRecord) error {
+	if t == nil {
+		return nil
+	}
+	for _, record := range changeRecord.DataChangeRecords {
+		keyColumns := make(map[string]bool)
+		for _, c := range record.ColumnTypes {
+			if c.IsPrimaryKey {
+				keyColumns[c.Name] = true
+			}
+		}
+		for _, mod := range record.Mods {
+			for _, v := range []interface{}{mod.NewValues.Value, mod.OldValues.Value} {
+				values, ok := v.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				for name, v := range values {
+					if keyColumns[name] {
+						continue
+					}
+					marker, err := t.truncateValue(v)
+					if err != nil {
+						return err
+					}
+					if marker != nil {
+						values[name] = marker
+						t.counted(record.TableName, name)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// truncateValue returns the marker of the value if it's larger than maxBytes, or nil otherwise.
+// The size of a string, e.g. a STRING, BYTES or JSON column, is the size of the string itself, and the size of
+// the other values is the size of their JSON encoding.
+func (t *valueTruncator) truncateValue(v interface{}) (map[string]interface{}, error) {
+	var s string
+	switch v := v.(type) {
+	case nil, bool, float64:
+		return nil, nil
+	case string:
+		s = v
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		s = string(b)
+	}
+	if len(s) <= t.maxBytes {
+		return nil, nil
+	}
+	// The prefix must not end in the middle of a multi-byte character.
+	end := t.maxBytes
+	for end > 0 && !utf8.RuneStart(s[end]) {
+		end--
+	}
+	prefix := s[:end]
+	return map[string]interface{}{
+		"_truncated": true,
+		"bytes":      len(s),
+		"prefix":     prefix,
+	}, nil
+}
+
+func (t *valueTruncator) counted(table, column string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	columns, ok := t.counts[table]
+	if !ok {
+		columns = make(map[string]int64)
+		t.counts[table] = columns
+	}
+	columns[column]++
+}
+
+func (t *valueTruncator) snapshot(stats *Stats) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.counts) == 0 {
+		return
+	}
+	stats.TruncatedValues = make(map[string]map[string]int64, len(t.counts))
+	for table, columns := range t.counts {
+		stats.TruncatedValues[table] = make(map[string]int64, len(columns))
+		for column, n := range columns {
+			stats.TruncatedValues[table][column] = n
+		}
+	}
+}
@@ -0,0 +1,80 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"testing"
+
+	"cloud.google.com/go/spanner"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestValueTruncator(t *testing.T) {
+	jsonValue := func(v interface{}) spanner.NullJSON {
+		return spanner.NullJSON{Value: v, Valid: true}
+	}
+	record := &DataChangeRecord{
+		TableName: "Singers",
+		ModType:   "UPDATE",
+		ColumnTypes: []*ColumnType{
+			{Name: "id", Type: jsonValue(map[string]interface{}{"code": "STRING"}), IsPrimaryKey: true},
+			{Name: "name", Type: jsonValue(map[string]interface{}{"code": "STRING"})},
+			{Name: "bio", Type: jsonValue(map[string]interface{}{"code": "STRING"})},
+			{Name: "tags", Type: jsonValue(map[string]interface{}{"code": "ARRAY", "array_element_type": map[string]interface{}{"code": "STRING"}})},
+			{Name: "score", Type: jsonValue(map[string]interface{}{"code": "FLOAT64"})},
+		},
+		Mods: []*Mod{
+			{
+				Keys:      jsonValue(map[string]interface{}{"id": "0123456789"}),
+				NewValues: jsonValue(map[string]interface{}{"id": "0123456789", "name": "abcdefgh", "bio": "日本語です", "tags": []interface{}{"a", "b"}, "score": 1234567.0}),
+				OldValues: jsonValue(map[string]interface{}{"name": "abc", "bio": nil}),
+			},
+		},
+	}
+
+	truncator := newValueTruncator(8)
+	if err := truncator.truncate(&ChangeRecord{DataChangeRecords: []*DataChangeRecord{record}}); err != nil {
+		t.Fatalf("truncate error: %v", err)
+	}
+
+	wantNewValues := map[string]interface{}{
+		"id":   "0123456789",
+		"name": "abcdefgh",
+		// The prefix ends at the boundary of the characters of 3 bytes.
+		"bio":   map[string]interface{}{"_truncated": true, "bytes": 15, "prefix": "日本"},
+		"tags":  map[string]interface{}{"_truncated": true, "bytes": 9, "prefix": `["a","b"`},
+		"score": 1234567.0,
+	}
+	if diff := cmp.Diff(record.Mods[0].NewValues.Value, wantNewValues); diff != "" {
+		t.Errorf("new values diff = %v", diff)
+	}
+	wantOldValues := map[string]interface{}{"name": "abc", "bio": nil}
+	if diff := cmp.Diff(record.Mods[0].OldValues.Value, wantOldValues); diff != "" {
+		t.Errorf("old values diff = %v", diff)
+	}
+
+	var stats Stats
+	truncator.snapshot(&stats)
+	if diff := cmp.Diff(stats.TruncatedValues, map[string]map[string]int64{"Singers": {"bio": 1, "tags": 1}}); diff != "" {
+		t.Errorf("truncated values diff = %v", diff)
+	}
+
+	// A nil truncator doesn't truncate anything.
+	if newValueTruncator(0) != nil {
+		t.Error("newValueTruncator(0) must be nil")
+	}
+}
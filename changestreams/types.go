@@ -0,0 +1,51 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Type is the Cloud Spanner type of a column, decoded from ColumnType.Type.
+type Type struct {
+	// Code is the type code such as "INT64", "STRING" or "ARRAY".
+	Code string `json:"code"`
+	// ArrayElementType is the type of the elements if Code is "ARRAY".
+	ArrayElementType *Type `json:"array_element_type,omitempty"`
+	// TypeAnnotation is set for the PostgreSQL-specific types, e.g. "PG_NUMERIC" or "PG_JSONB".
+	TypeAnnotation string `json:"type_annotation,omitempty"`
+}
+
+// DecodeType decodes the type of the column.
+func (c *ColumnType) DecodeType() (*Type, error) {
+	if !c.Type.Valid {
+		return nil, fmt.Errorf("column %s has no type", c.Name)
+	}
+	b, err := json.Marshal(c.Type.Value)
+	if err != nil {
+		return nil, err
+	}
+	var t Type
+	if err := json.Unmarshal(b, &t); err != nil {
+		return nil, fmt.Errorf("invalid type of column %s: %w", c.Name, err)
+	}
+	if t.Code == "" {
+		return nil, fmt.Errorf("invalid type of column %s: %s", c.Name, b)
+	}
+	return &t, nil
+}
@@ -0,0 +1,54 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"encoding/json"
+	"testing"
+
+	"cloud.google.com/go/spanner"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDecodeType(t *testing.T) {
+	for _, test := range []struct {
+		desc     string
+		typeJSON string
+		want     *Type
+		wantErr  bool
+	}{
+		{desc: "scalar", typeJSON: `{"code":"INT64"}`, want: &Type{Code: "INT64"}},
+		{desc: "array", typeJSON: `{"code":"ARRAY","array_element_type":{"code":"STRING"}}`, want: &Type{Code: "ARRAY", ArrayElementType: &Type{Code: "STRING"}}},
+		{desc: "PostgreSQL", typeJSON: `{"code":"NUMERIC","type_annotation":"PG_NUMERIC"}`, want: &Type{Code: "NUMERIC", TypeAnnotation: "PG_NUMERIC"}},
+		{desc: "no code", typeJSON: `{}`, wantErr: true},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			var value interface{}
+			if err := json.Unmarshal([]byte(test.typeJSON), &value); err != nil {
+				t.Fatal(err)
+			}
+			c := &ColumnType{Name: "col", Type: spanner.NullJSON{Value: value, Valid: true}}
+			got, err := c.DecodeType()
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("DecodeType error = %v, want error: %v", err, test.wantErr)
+			}
+			if diff := cmp.Diff(got, test.want); diff != "" {
+				t.Errorf("diff = %v", diff)
+			}
+		})
+	}
+}
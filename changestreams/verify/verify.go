@@ -0,0 +1,357 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package verify verifies that the net effect of the data change records of a table matches the rows of the table,
+// e.g. to make sure that a replication pipeline missed nothing.
+package verify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	spannerclient "cloud.google.com/go/spanner"
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams"
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams/sink/spanner"
+	"google.golang.org/api/iterator"
+)
+
+const defaultBatchSize = 1000
+
+// DifferenceKind is the kind of the difference between the change stream and the table.
+type DifferenceKind string
+
+const (
+	// DifferenceMissing is a row that exists according to the change stream, but not in the table.
+	DifferenceMissing DifferenceKind = "missing"
+	// DifferenceUnexpected is a row that was deleted according to the change stream, but exists in the table.
+	DifferenceUnexpected DifferenceKind = "unexpected"
+	// DifferenceValues is a row whose values differ from the last values in the change stream.
+	DifferenceValues DifferenceKind = "differ"
+)
+
+// Difference is a row that differs between the change stream and the table.
+type Difference struct {
+	// Key is the primary key of the row, in the format of changestreams.Mod.PrimaryKeyString.
+	Key  string         `json:"key"`
+	Kind DifferenceKind `json:"kind"`
+	// Columns are the names of the columns whose values differ. It's only set for DifferenceValues.
+	Columns []string `json:"columns,omitempty"`
+}
+
+// Report is the result of the comparison with a database.
+type Report struct {
+	Table string `json:"table"`
+	// Database is the name of the database compared, e.g. projects/p/instances/i/databases/d.
+	Database string `json:"database"`
+	// Timestamp is the timestamp the rows were read at.
+	Timestamp time.Time `json:"timestamp"`
+	// Keys is the number of the primary keys modified by the data change records, all of which were compared.
+	Keys        int           `json:"keys"`
+	Differences []*Difference `json:"differences"`
+}
+
+// Config is the configuration for the verifier.
+type Config struct {
+	// BatchSize is the number of the rows read at a time. If BatchSize is zero, 1000 is used.
+	BatchSize int
+}
+
+// Verifier accumulates the net effect of the data change records of a table per primary key, and compares it with
+// the rows of the table.
+//
+// To keep the memory bounded by the number of the modified rows, not by their sizes, the values are kept as hashes.
+// Only the columns in the data change records are compared, i.e. the columns tracked by the change stream and,
+// with OLD_AND_NEW_VALUES and NEW_VALUES value capture types, modified in the window.
+type Verifier struct {
+	table     string
+	batchSize int
+	mu        sync.Mutex
+	rows      map[string]*rowState
+	// keyColumns are the primary key columns of the table, set by the first data change record.
+	keyColumns []string
+}
+
+// rowsReader reads the columns of the rows of the table, and returns the values of the columns other than
+// the primary key columns keyed by the column names, keyed by the primary key, with the timestamp they were read at.
+type rowsReader func(ctx context.Context, keys []spannerclient.Key, columns []string) (map[string]map[string]interface{}, time.Time, error)
+
+// rowState is the net effect of the data change records on a row.
+type rowState struct {
+	key spannerclient.Key
+	// lastModified and deleted are of the latest mod of the row.
+	lastModified time.Time
+	deleted      bool
+	// lastDeleted is the commit timestamp of the latest delete of the row.
+	lastDeleted time.Time
+	columns     map[string]columnState
+}
+
+// columnState is the hash of the last value of a column, and the commit timestamp it was set at.
+type columnState struct {
+	hash     uint64
+	modified time.Time
+}
+
+// New returns a verifier for the table.
+func New(table string, config Config) (*Verifier, error) {
+	if table == "" {
+		return nil, errors.New("table must be specified")
+	}
+	if config.BatchSize < 0 {
+		return nil, fmt.Errorf("invalid BatchSize: %d", config.BatchSize)
+	}
+	batchSize := config.BatchSize
+	if batchSize == 0 {
+		batchSize = defaultBatchSize
+	}
+	return &Verifier{
+		table:     table,
+		batchSize: batchSize,
+		rows:      make(map[string]*rowState),
+	}, nil
+}
+
+// Add adds the data change records of the table in the result. It can be passed to changestreams.Reader.Read.
+// The records may arrive in any order, as they do from different partitions.
+func (v *Verifier) Add(result *changestreams.ReadResult) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for record := range result.DataChangeRecords() {
+		if record.TableName != v.table {
+			continue
+		}
+		if v.keyColumns == nil {
+			for _, c := range record.ColumnTypes {
+				if c.IsPrimaryKey {
+					v.keyColumns = append(v.keyColumns, c.Name)
+				}
+			}
+		}
+		for _, mod := range record.Mods {
+			if err := v.addMod(record, mod); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (v *Verifier) addMod(record *changestreams.DataChangeRecord, mod *changestreams.Mod) error {
+	keyString, err := mod.PrimaryKeyString()
+	if err != nil {
+		return err
+	}
+	row, ok := v.rows[keyString]
+	if !ok {
+		key, err := spanner.PrimaryKey(record, mod)
+		if err != nil {
+			return err
+		}
+		row = &rowState{key: key, columns: make(map[string]columnState)}
+		v.rows[keyString] = row
+	}
+	if record.CommitTimestamp.After(row.lastModified) {
+		row.lastModified = record.CommitTimestamp
+		row.deleted = record.ModType == "DELETE"
+	}
+	if record.ModType == "DELETE" {
+		if record.CommitTimestamp.After(row.lastDeleted) {
+			row.lastDeleted = record.CommitTimestamp
+		}
+		return nil
+	}
+
+	values, ok := mod.NewValues.Value.(map[string]interface{})
+	if !mod.NewValues.Valid || !ok {
+		return nil
+	}
+	for column, value := range values {
+		if c, ok := row.columns[column]; ok && !record.CommitTimestamp.After(c.modified) {
+			continue
+		}
+		hash, err := hashValue(value)
+		if err != nil {
+			return err
+		}
+		row.columns[column] = columnState{hash: hash, modified: record.CommitTimestamp}
+	}
+	return nil
+}
+
+// Keys returns the number of the primary keys modified by the data change records added so far.
+func (v *Verifier) Keys() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return len(v.rows)
+}
+
+// Compare compares the net effect of the data change records added so far with the rows of the table of
+// the database read at the timestamp, or with a strong read if the timestamp is zero. The rows are read in batches.
+// The differences are sorted by the key.
+func (v *Verifier) Compare(ctx context.Context, client *spannerclient.Client, timestamp time.Time) (*Report, error) {
+	return v.compare(ctx, client.DatabaseName(), func(ctx context.Context, keys []spannerclient.Key, columns []string) (map[string]map[string]interface{}, time.Time, error) {
+		return v.readRowsAt(ctx, client, keys, columns, timestamp)
+	})
+}
+
+func (v *Verifier) compare(ctx context.Context, database string, read rowsReader) (*Report, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	report := &Report{
+		Table:       v.table,
+		Database:    database,
+		Keys:        len(v.rows),
+		Differences: []*Difference{},
+	}
+	keyStrings := make([]string, 0, len(v.rows))
+	for keyString := range v.rows {
+		keyStrings = append(keyStrings, keyString)
+	}
+	sort.Strings(keyStrings)
+
+	for start := 0; start < len(keyStrings); start += v.batchSize {
+		batch := keyStrings[start:min(start+v.batchSize, len(keyStrings))]
+		keys := make([]spannerclient.Key, 0, len(batch))
+		columnSet := make(map[string]bool)
+		for _, keyString := range batch {
+			row := v.rows[keyString]
+			keys = append(keys, row.key)
+			for column := range row.columns {
+				columnSet[column] = true
+			}
+		}
+		columns := append([]string{}, v.keyColumns...)
+		for column := range columnSet {
+			columns = append(columns, column)
+		}
+		sort.Strings(columns[len(v.keyColumns):])
+
+		rows, readTimestamp, err := read(ctx, keys, columns)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the rows of table %s: %w", v.table, err)
+		}
+		report.Timestamp = readTimestamp
+		for _, keyString := range batch {
+			difference, err := v.rows[keyString].compare(rows[keyString])
+			if err != nil {
+				return nil, err
+			}
+			if difference != nil {
+				difference.Key = keyString
+				report.Differences = append(report.Differences, difference)
+			}
+		}
+	}
+	return report, nil
+}
+
+// compare returns the difference of the row read from the table, which is nil if the row doesn't exist.
+func (s *rowState) compare(values map[string]interface{}) (*Difference, error) {
+	switch {
+	case s.deleted && values == nil:
+		return nil, nil
+	case s.deleted:
+		return &Difference{Kind: DifferenceUnexpected}, nil
+	case values == nil:
+		return &Difference{Kind: DifferenceMissing}, nil
+	}
+
+	var columns []string
+	for column, c := range s.columns {
+		// The values before the last delete belong to the deleted row.
+		if s.lastDeleted.After(c.modified) {
+			continue
+		}
+		hash, err := hashValue(values[column])
+		if err != nil {
+			return nil, err
+		}
+		if hash != c.hash {
+			columns = append(columns, column)
+		}
+	}
+	if len(columns) == 0 {
+		return nil, nil
+	}
+	sort.Strings(columns)
+	return &Difference{Kind: DifferenceValues, Columns: columns}, nil
+}
+
+// readRowsAt reads the rows at the timestamp, or with a strong read if the timestamp is zero.
+func (v *Verifier) readRowsAt(ctx context.Context, client *spannerclient.Client, keys []spannerclient.Key, columns []string, timestamp time.Time) (map[string]map[string]interface{}, time.Time, error) {
+	tx := client.Single()
+	if !timestamp.IsZero() {
+		tx = tx.WithTimestampBound(spannerclient.ReadTimestamp(timestamp))
+	}
+	defer tx.Close()
+
+	rows := make(map[string]map[string]interface{}, len(keys))
+	iter := tx.Read(ctx, v.table, spannerclient.KeySetFromKeys(keys...), columns)
+	defer iter.Stop()
+	for {
+		row, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		values := make(map[string]interface{}, row.Size())
+		for i, name := range row.ColumnNames() {
+			var value spannerclient.GenericColumnValue
+			if err := row.Column(i, &value); err != nil {
+				return nil, time.Time{}, err
+			}
+			// The values are encoded in the same way as in the mods, e.g. INT64 as a string.
+			values[name] = value.Value.AsInterface()
+		}
+		key := make(map[string]interface{}, len(v.keyColumns))
+		for _, name := range v.keyColumns {
+			key[name] = values[name]
+			delete(values, name)
+		}
+		// encoding/json sorts the object keys, as changestreams.Mod.PrimaryKeyString does.
+		keyString, err := json.Marshal(key)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		rows[string(keyString)] = values
+	}
+	readTimestamp, err := tx.Timestamp()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return rows, readTimestamp, nil
+}
+
+// hashValue returns the hash of the JSON of the value, whose object keys are sorted by encoding/json.
+func hashValue(value interface{}) (uint64, error) {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return 0, err
+	}
+	h := fnv.New64a()
+	h.Write(b)
+	return h.Sum64(), nil
+}
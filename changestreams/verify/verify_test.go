@@ -0,0 +1,140 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package verify
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	spannerclient "cloud.google.com/go/spanner"
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestVerifier(t *testing.T) {
+	jsonValue := func(v interface{}) spannerclient.NullJSON {
+		return spannerclient.NullJSON{Value: v, Valid: true}
+	}
+	base := time.Date(2023, 2, 24, 17, 0, 0, 0, time.UTC)
+	// newResult returns the result of a mod of the singer at the second from base.
+	newResult := func(table, modType string, second int, id string, newValues map[string]interface{}) *changestreams.ReadResult {
+		mod := &changestreams.Mod{Keys: jsonValue(map[string]interface{}{"SingerId": id})}
+		if newValues != nil {
+			mod.NewValues = jsonValue(newValues)
+		}
+		return &changestreams.ReadResult{ChangeRecords: []*changestreams.ChangeRecord{{DataChangeRecords: []*changestreams.DataChangeRecord{{
+			CommitTimestamp: base.Add(time.Duration(second) * time.Second),
+			TableName:       table,
+			ModType:         modType,
+			ColumnTypes: []*changestreams.ColumnType{
+				{Name: "SingerId", Type: jsonValue(map[string]interface{}{"code": "INT64"}), IsPrimaryKey: true, OrdinalPosition: 1},
+				{Name: "Name", Type: jsonValue(map[string]interface{}{"code": "STRING"}), OrdinalPosition: 2},
+				{Name: "Age", Type: jsonValue(map[string]interface{}{"code": "INT64"}), OrdinalPosition: 3},
+			},
+			Mods: []*changestreams.Mod{mod},
+		}}}}}
+	}
+
+	v, err := New("Singers", Config{BatchSize: 2})
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	for _, result := range []*changestreams.ReadResult{
+		// 1 is inserted, and then partially updated, which arrives first.
+		newResult("Singers", "UPDATE", 2, "1", map[string]interface{}{"Age": "31"}),
+		newResult("Singers", "INSERT", 1, "1", map[string]interface{}{"Name": "a", "Age": "30"}),
+		// 2 is missing in the table.
+		newResult("Singers", "INSERT", 1, "2", map[string]interface{}{"Name": "b", "Age": "20"}),
+		// 3 is deleted, but in the table.
+		newResult("Singers", "DELETE", 1, "3", nil),
+		// 4 differs in Name.
+		newResult("Singers", "INSERT", 1, "4", map[string]interface{}{"Name": "d", "Age": nil}),
+		// 5 is deleted and inserted again with fewer columns, and the name before the delete is ignored.
+		newResult("Singers", "UPDATE", 1, "5", map[string]interface{}{"Name": "old"}),
+		newResult("Singers", "DELETE", 2, "5", nil),
+		newResult("Singers", "INSERT", 3, "5", map[string]interface{}{"Age": "50"}),
+		// 6 is deleted, and not in the table.
+		newResult("Singers", "DELETE", 1, "6", nil),
+		// Other tables are ignored.
+		newResult("Albums", "INSERT", 1, "7", map[string]interface{}{"Name": "g"}),
+	} {
+		if err := v.Add(result); err != nil {
+			t.Fatalf("Add error: %v", err)
+		}
+	}
+	if got := v.Keys(); got != 6 {
+		t.Errorf("Keys = %d, want 6", got)
+	}
+
+	table := map[string]map[string]interface{}{
+		`{"SingerId":"1"}`: {"Name": "a", "Age": "31"},
+		`{"SingerId":"3"}`: {"Name": "c", "Age": "30"},
+		`{"SingerId":"4"}`: {"Name": "x", "Age": nil},
+		`{"SingerId":"5"}`: {"Name": "new", "Age": "50"},
+	}
+	var reads [][]string
+	report, err := v.compare(context.Background(), "db", func(ctx context.Context, keys []spannerclient.Key, columns []string) (map[string]map[string]interface{}, time.Time, error) {
+		reads = append(reads, columns)
+		rows := make(map[string]map[string]interface{})
+		for _, key := range keys {
+			b, err := json.Marshal(map[string]interface{}{"SingerId": key.String()[1 : len(key.String())-1]})
+			if err != nil {
+				return nil, time.Time{}, err
+			}
+			if row, ok := table[string(b)]; ok {
+				rows[string(b)] = row
+			}
+		}
+		return rows, base.Add(time.Minute), nil
+	})
+	if err != nil {
+		t.Fatalf("compare error: %v", err)
+	}
+
+	want := &Report{
+		Table:     "Singers",
+		Database:  "db",
+		Timestamp: base.Add(time.Minute),
+		Keys:      6,
+		Differences: []*Difference{
+			{Key: `{"SingerId":"2"}`, Kind: DifferenceMissing},
+			{Key: `{"SingerId":"3"}`, Kind: DifferenceUnexpected},
+			{Key: `{"SingerId":"4"}`, Kind: DifferenceValues, Columns: []string{"Name"}},
+		},
+	}
+	if diff := cmp.Diff(report, want); diff != "" {
+		t.Errorf("report diff = %v", diff)
+	}
+	// The 6 keys are read in 3 batches, with the key columns first.
+	if len(reads) != 3 {
+		t.Fatalf("reads = %d, want 3", len(reads))
+	}
+	if diff := cmp.Diff(reads[0], []string{"SingerId", "Age", "Name"}); diff != "" {
+		t.Errorf("columns diff = %v", diff)
+	}
+}
+
+func TestNewInvalidConfig(t *testing.T) {
+	if _, err := New("", Config{}); err == nil {
+		t.Error("New without the table succeeded, want error")
+	}
+	if _, err := New("Singers", Config{BatchSize: -1}); err == nil {
+		t.Error("New with negative BatchSize succeeded, want error")
+	}
+}
@@ -0,0 +1,179 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"sync"
+)
+
+// WorkerShardKey decides which mod events are delivered in order by the same worker.
+type WorkerShardKey int
+
+const (
+	// WorkerShardByTableAndKey keeps the order of the mods of the same row.
+	WorkerShardByTableAndKey WorkerShardKey = iota
+	// WorkerShardByTable keeps the order of the mods of the same table.
+	WorkerShardByTable
+)
+
+// KeyedWorkersConfig is the configuration for the keyed workers.
+type KeyedWorkersConfig struct {
+	// Workers is the number of the worker goroutines. If Workers is zero, 1 is used.
+	Workers int
+	// QueueSize is the number of the mod events buffered for each worker.
+	QueueSize int
+	ShardKey  WorkerShardKey
+}
+
+// KeyedWorkers delivers the mod events to function f in parallel while keeping the order of the events
+// with the same shard key. Each event is routed to a worker by the hash of its shard key, so the events of
+// the same row (or table) are always delivered by the same worker in the order they were read.
+//
+// Read returns only after f has returned for all the mod events of the result, so the reader advances the
+// watermarks and the checkpoints of a partition only past the delivered events. The events of a result, and
+// the results of the partitions read in parallel, are still delivered in parallel by the workers.
+//
+// If f returns an error, the workers stop calling f, drain their queues, and the error is returned
+// from Read and Close.
+type KeyedWorkers struct {
+	f      func(event *ModEvent) error
+	config KeyedWorkersConfig
+	queues []chan keyedEvent
+	wg     sync.WaitGroup
+	failed chan struct{}
+	once   sync.Once
+	err    error
+}
+
+// keyedEvent is a mod event queued for a worker, and the wait group of its result, which is done once f has
+// returned for the event or the event is drained after a failure.
+type keyedEvent struct {
+	event     *ModEvent
+	delivered *sync.WaitGroup
+}
+
+// NewKeyedWorkers creates the keyed workers and starts the worker goroutines.
+// Pass KeyedWorkers.Read to Reader.Read, and call Close after Read returns.
+func NewKeyedWorkers(f func(event *ModEvent) error, config KeyedWorkersConfig) *KeyedWorkers {
+	if config.Workers <= 0 {
+		config.Workers = 1
+	}
+	w := &KeyedWorkers{
+		f:      f,
+		config: config,
+		queues: make([]chan keyedEvent, config.Workers),
+		failed: make(chan struct{}),
+	}
+	for i := range w.queues {
+		queue := make(chan keyedEvent, config.QueueSize)
+		w.queues[i] = queue
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			w.run(queue)
+		}()
+	}
+	return w
+}
+
+// Read routes the mod events of the result to the workers, and waits for them to be delivered.
+// It returns the error of f once any worker fails.
+func (w *KeyedWorkers) Read(result *ReadResult) error {
+	var delivered sync.WaitGroup
+	err := w.enqueue(result, &delivered)
+	// The queued events are either delivered or drained, so the wait never blocks after a failure.
+	delivered.Wait()
+	if err != nil {
+		return err
+	}
+	select {
+	case <-w.failed:
+		return w.err
+	default:
+		return nil
+	}
+}
+
+// enqueue routes the mod events of the result to the workers. It blocks while the queue of the worker is full.
+func (w *KeyedWorkers) enqueue(result *ReadResult, delivered *sync.WaitGroup) error {
+	for event := range result.ModEvents() {
+		select {
+		case <-w.failed:
+			return w.err
+		default:
+		}
+		queue := w.queues[w.shard(event)]
+		delivered.Add(1)
+		select {
+		case queue <- keyedEvent{event: event, delivered: delivered}:
+		case <-w.failed:
+			delivered.Done()
+			return w.err
+		}
+	}
+	return nil
+}
+
+// Close waits for the workers to deliver the queued events, and returns the first error of f.
+// Read must not be called after Close.
+func (w *KeyedWorkers) Close() error {
+	for _, queue := range w.queues {
+		close(queue)
+	}
+	w.wg.Wait()
+	select {
+	case <-w.failed:
+		return w.err
+	default:
+		return nil
+	}
+}
+
+func (w *KeyedWorkers) run(queue <-chan keyedEvent) {
+	for e := range queue {
+		select {
+		case <-w.failed:
+			// Drain the queue so that Read and Close never block.
+			e.delivered.Done()
+			continue
+		default:
+		}
+		if err := w.f(e.event); err != nil {
+			w.once.Do(func() {
+				w.err = err
+				close(w.failed)
+			})
+		}
+		e.delivered.Done()
+	}
+}
+
+func (w *KeyedWorkers) shard(event *ModEvent) int {
+	h := fnv.New32a()
+	h.Write([]byte(event.Record.TableName))
+	if w.config.ShardKey == WorkerShardByTableAndKey {
+		// encoding/json sorts the object keys, so the same row always has the same key.
+		keys, err := json.Marshal(event.Mod.Keys)
+		if err == nil {
+			h.Write([]byte{0})
+			h.Write(keys)
+		}
+	}
+	return int(h.Sum32() % uint32(len(w.queues)))
+}
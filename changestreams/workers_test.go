@@ -0,0 +1,127 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package changestreams
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestKeyedWorkers(t *testing.T) {
+	newResult := func(id string, seq int) *ReadResult {
+		return &ReadResult{
+			ChangeRecords: []*ChangeRecord{{
+				DataChangeRecords: []*DataChangeRecord{{
+					TableName:      "players",
+					RecordSequence: fmt.Sprintf("%08d", seq),
+					Mods: []*Mod{
+						{Keys: spanner.NullJSON{Value: map[string]interface{}{"id": id}, Valid: true}},
+					},
+				}},
+			}},
+		}
+	}
+
+	t.Run("order per key is kept", func(t *testing.T) {
+		var mu sync.Mutex
+		got := make(map[string][]string)
+		w := NewKeyedWorkers(func(event *ModEvent) error {
+			id := event.Mod.Keys.Value.(map[string]interface{})["id"].(string)
+			mu.Lock()
+			defer mu.Unlock()
+			got[id] = append(got[id], event.Record.RecordSequence)
+			return nil
+		}, KeyedWorkersConfig{Workers: 4, QueueSize: 2})
+
+		want := make(map[string][]string)
+		for seq := 0; seq < 100; seq++ {
+			id := fmt.Sprint(seq % 7)
+			want[id] = append(want[id], fmt.Sprintf("%08d", seq))
+			if err := w.Read(newResult(id, seq)); err != nil {
+				t.Fatalf("Read error: %v", err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close error: %v", err)
+		}
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("diff = %v", diff)
+		}
+	})
+
+	t.Run("checkpoint never gets ahead of the delivered events", func(t *testing.T) {
+		start := time.Date(2023, 2, 24, 17, 17, 0, 0, time.UTC)
+		var mu sync.Mutex
+		delivered := make(map[time.Time]bool)
+		w := NewKeyedWorkers(func(event *ModEvent) error {
+			// The slow worker would fall behind the reader if Read didn't wait for it.
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			defer mu.Unlock()
+			delivered[event.Record.CommitTimestamp] = true
+			return nil
+		}, KeyedWorkersConfig{Workers: 4, QueueSize: 10})
+		c := newCheckpointer(&fakeCheckpointStore{}, 0, 0, false, &Checkpoint{})
+		c.add([]*PendingPartition{{Token: "a", StartTimestamp: start}})
+
+		for seq := 0; seq < 20; seq++ {
+			result := newResult(fmt.Sprint(seq), seq)
+			result.PartitionToken = "a"
+			record := result.ChangeRecords[0].DataChangeRecords[0]
+			record.CommitTimestamp = start.Add(time.Duration(seq+1) * time.Second)
+			if err := w.Read(result); err != nil {
+				t.Fatalf("Read error: %v", err)
+			}
+			// The reader advances the checkpointer once the read function has returned.
+			c.advance("a", record.CommitTimestamp, []*DataChangeRecord{record})
+			checkpoint, _ := c.snapshot()
+			watermark := checkpoint.PendingPartitions[0].StartTimestamp
+			mu.Lock()
+			if !delivered[watermark] {
+				t.Errorf("checkpoint at %s is ahead of the delivered events", watermark)
+			}
+			mu.Unlock()
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close error: %v", err)
+		}
+	})
+
+	t.Run("error stops the workers", func(t *testing.T) {
+		workerErr := errors.New("worker error")
+		w := NewKeyedWorkers(func(event *ModEvent) error {
+			return workerErr
+		}, KeyedWorkersConfig{Workers: 2})
+
+		var err error
+		for seq := 0; seq < 100 && err == nil; seq++ {
+			err = w.Read(newResult(fmt.Sprint(seq), seq))
+		}
+		if err != workerErr {
+			t.Errorf("Read error = %v, want %v", err, workerErr)
+		}
+		if err := w.Close(); err != workerErr {
+			t.Errorf("Close error = %v, want %v", err, workerErr)
+		}
+	})
+}
@@ -0,0 +1,347 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams"
+	logging "google.golang.org/api/logging/v2"
+)
+
+const (
+	cloudLoggingLogID = "spanner-change-streams-tail"
+	// cloudLoggingQueueSize is the number of the entries waiting to be written, beyond which they are dropped.
+	cloudLoggingQueueSize = 1000
+	cloudLoggingBatchSize = 100
+	// cloudLoggingFlushInterval is the longest time an entry waits for its batch.
+	cloudLoggingFlushInterval = 5 * time.Second
+	cloudLoggingWriteTimeout  = 10 * time.Second
+	// watermarkMilestoneInterval is the interval of the low watermark logged as the watermark_milestone event.
+	watermarkMilestoneInterval = time.Minute
+	// watermarkMilestoneCheckInterval is the interval of checking the low watermark for the milestones.
+	watermarkMilestoneCheckInterval = 10 * time.Second
+)
+
+// lifecycleEvents are the events of the logs written to Cloud Logging with --cloud-logging.
+var lifecycleEvents = map[string]bool{
+	"partition_started":        true,
+	"partition_finished":       true,
+	"partition_retried":        true,
+	"partition_skipped":        true,
+	"partition_failed":         true,
+	"partition_paused":         true,
+	"partition_resumed":        true,
+	"partition_depth_exceeded": true,
+	"watermark_milestone":      true,
+}
+
+// cloudLoggingWriter writes the log entries to Cloud Logging in batches in the background. The entries are
+// queued without blocking, and dropped if the queue is full, e.g. when the API is slow, so that the logs never
+// slow down the reader.
+type cloudLoggingWriter struct {
+	logName  string
+	resource *logging.MonitoredResource
+	// labels are the labels of all the entries, i.e. the stream and the database.
+	labels map[string]string
+	write  func(ctx context.Context, request *logging.WriteLogEntriesRequest) error
+	// logger logs the failures of the writes, and must not write to Cloud Logging itself.
+	logger  *slog.Logger
+	entries chan *logging.LogEntry
+	// dropped is the number of the entries dropped because the queue was full or the write failed.
+	dropped atomic.Int64
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+// newCloudLoggingWriter returns the writer with the Application Default Credentials, the same credentials as
+// Cloud Spanner.
+func newCloudLoggingWriter(ctx context.Context, projectID string, labels map[string]string, logger *slog.Logger) (*cloudLoggingWriter, error) {
+	if projectID == "" {
+		return nil, errors.New("project must be specified for Cloud Logging")
+	}
+	service, err := logging.NewService(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newCloudLoggingWriterWithWrite(projectID, labels, logger, func(ctx context.Context, request *logging.WriteLogEntriesRequest) error {
+		_, err := service.Entries.Write(request).Context(ctx).Do()
+		return err
+	}), nil
+}
+
+func newCloudLoggingWriterWithWrite(projectID string, labels map[string]string, logger *slog.Logger, write func(ctx context.Context, request *logging.WriteLogEntriesRequest) error) *cloudLoggingWriter {
+	w := &cloudLoggingWriter{
+		logName:  fmt.Sprintf("projects/%s/logs/%s", projectID, cloudLoggingLogID),
+		resource: &logging.MonitoredResource{Type: "global", Labels: map[string]string{"project_id": projectID}},
+		labels:   labels,
+		write:    write,
+		logger:   logger,
+		entries:  make(chan *logging.LogEntry, cloudLoggingQueueSize),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// handler returns the handler of the logs, which writes only the logs of the lifecycle events at any level.
+func (w *cloudLoggingWriter) handler() slog.Handler {
+	return &cloudLoggingHandler{w: w}
+}
+
+// enqueue queues the entry, or drops it if the queue is full.
+func (w *cloudLoggingWriter) enqueue(entry *logging.LogEntry) {
+	select {
+	case w.entries <- entry:
+	default:
+		w.dropped.Add(1)
+	}
+}
+
+func (w *cloudLoggingWriter) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(cloudLoggingFlushInterval)
+	defer ticker.Stop()
+	var batch []*logging.LogEntry
+	for {
+		select {
+		case entry := <-w.entries:
+			batch = append(batch, entry)
+			if len(batch) >= cloudLoggingBatchSize {
+				w.flush(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			w.flush(batch)
+			batch = nil
+		case <-w.stop:
+			// The entries queued before Close are written as well.
+			for {
+				select {
+				case entry := <-w.entries:
+					batch = append(batch, entry)
+					if len(batch) >= cloudLoggingBatchSize {
+						w.flush(batch)
+						batch = nil
+					}
+				default:
+					w.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+func (w *cloudLoggingWriter) flush(batch []*logging.LogEntry) {
+	if len(batch) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cloudLoggingWriteTimeout)
+	defer cancel()
+	if err := w.write(ctx, &logging.WriteLogEntriesRequest{
+		LogName:  w.logName,
+		Resource: w.resource,
+		Labels:   w.labels,
+		Entries:  batch,
+	}); err != nil {
+		w.dropped.Add(int64(len(batch)))
+		w.logger.Warn("failed to write the logs to Cloud Logging", "event", "cloud_logging_failed", "entries", len(batch), "error", err)
+	}
+}
+
+// Close writes the queued entries, and reports the number of the dropped entries if any.
+func (w *cloudLoggingWriter) Close() {
+	w.once.Do(func() {
+		close(w.stop)
+		<-w.done
+		if dropped := w.dropped.Load(); dropped > 0 {
+			w.logger.Warn("some logs were not written to Cloud Logging", "event", "cloud_logging_dropped", "entries", dropped)
+		}
+	})
+}
+
+// cloudLoggingHandler converts the logs into the entries of Cloud Logging, whose JSON payload has the message and
+// the attributes of the log, and whose labels have the partition token.
+type cloudLoggingHandler struct {
+	w      *cloudLoggingWriter
+	attrs  []slog.Attr
+	prefix string
+}
+
+func (h *cloudLoggingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	// The lifecycle events are written at any level, e.g. partition_started at DEBUG.
+	return true
+}
+
+func (h *cloudLoggingHandler) Handle(ctx context.Context, record slog.Record) error {
+	attrs := append([]slog.Attr{}, h.attrs...)
+	record.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, slog.Attr{Key: h.prefix + a.Key, Value: a.Value})
+		return true
+	})
+	payload := map[string]interface{}{"message": record.Message}
+	for _, a := range attrs {
+		payload[a.Key] = cloudLoggingValue(a.Value)
+	}
+	if event, _ := payload["event"].(string); !lifecycleEvents[event] {
+		return nil
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	entry := &logging.LogEntry{
+		Severity:    cloudLoggingSeverity(record.Level),
+		Timestamp:   record.Time.UTC().Format(time.RFC3339Nano),
+		JsonPayload: b,
+	}
+	if token, ok := payload["partition_token"].(string); ok {
+		entry.Labels = map[string]string{"partition_token": token}
+	}
+	h.w.enqueue(entry)
+	return nil
+}
+
+func (h *cloudLoggingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.attrs = append([]slog.Attr{}, h.attrs...)
+	for _, a := range attrs {
+		c.attrs = append(c.attrs, slog.Attr{Key: h.prefix + a.Key, Value: a.Value})
+	}
+	return &c
+}
+
+func (h *cloudLoggingHandler) WithGroup(name string) slog.Handler {
+	c := *h
+	c.prefix = h.prefix + name + "."
+	return &c
+}
+
+// cloudLoggingValue returns the value of the attribute in the JSON payload.
+func cloudLoggingValue(v slog.Value) interface{} {
+	v = v.Resolve()
+	switch v.Kind() {
+	case slog.KindTime:
+		return v.Time().UTC().Format(time.RFC3339Nano)
+	case slog.KindDuration:
+		return v.Duration().String()
+	case slog.KindAny:
+		if err, ok := v.Any().(error); ok {
+			return err.Error()
+		}
+		return v.Any()
+	default:
+		return v.Any()
+	}
+}
+
+func cloudLoggingSeverity(level slog.Level) string {
+	switch {
+	case level >= slog.LevelError:
+		return "ERROR"
+	case level >= slog.LevelWarn:
+		return "WARNING"
+	case level >= slog.LevelInfo:
+		return "INFO"
+	default:
+		return "DEBUG"
+	}
+}
+
+// teeHandler passes the logs to all the handlers enabled for their levels.
+type teeHandler []slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range t {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeHandler) Handle(ctx context.Context, record slog.Record) error {
+	var errs []error
+	for _, h := range t {
+		if h.Enabled(ctx, record.Level) {
+			errs = append(errs, h.Handle(ctx, record.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(teeHandler, len(t))
+	for i, h := range t {
+		handlers[i] = h.WithAttrs(attrs)
+	}
+	return handlers
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	handlers := make(teeHandler, len(t))
+	for i, h := range t {
+		handlers[i] = h.WithGroup(name)
+	}
+	return handlers
+}
+
+// watermarkMilestones logs the watermark_milestone event each time the low watermark of the reader passes
+// a multiple of watermarkMilestoneInterval, so that a log-based alert can tell a stuck reader.
+type watermarkMilestones struct {
+	logger *slog.Logger
+	stats  func() changestreams.Stats
+	now    func() time.Time
+	last   time.Time
+}
+
+// run checks the low watermark every interval until ctx is done.
+func (m *watermarkMilestones) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.check()
+		}
+	}
+}
+
+func (m *watermarkMilestones) check() {
+	lag := m.stats().WatermarkLag
+	if lag <= 0 {
+		return
+	}
+	milestone := m.now().Add(-lag).Truncate(watermarkMilestoneInterval)
+	if !milestone.After(m.last) {
+		return
+	}
+	m.last = milestone
+	m.logger.Debug("low watermark passed the milestone", "event", "watermark_milestone", "milestone", milestone, "watermark_lag", lag)
+}
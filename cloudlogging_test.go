@@ -0,0 +1,146 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams"
+	logging "google.golang.org/api/logging/v2"
+)
+
+func TestCloudLoggingHandler(t *testing.T) {
+	var stderr bytes.Buffer
+	stderrLogger := slog.New(slog.NewTextHandler(&stderr, nil))
+	var requests []*logging.WriteLogEntriesRequest
+	w := newCloudLoggingWriterWithWrite("project", map[string]string{"stream": "s", "database": "d"}, stderrLogger, func(ctx context.Context, request *logging.WriteLogEntriesRequest) error {
+		requests = append(requests, request)
+		return nil
+	})
+	logger := slog.New(teeHandler{stderrLogger.Handler(), w.handler()})
+
+	partitionLogger := logger.With("partition", "p1", "partition_token", "token1")
+	partitionLogger.Debug("started", "event", "partition_started", "start_timestamp", mustParseTime(t, "2024-06-01T08:00:00Z"))
+	partitionLogger.Warn("retried", "event", "partition_retried", "attempt", 2)
+	// The other events are not written.
+	logger.Info("Reading the stream...", "event", "read_started")
+	w.Close()
+
+	if len(requests) != 1 {
+		t.Fatalf("requests = %d, want 1", len(requests))
+	}
+	request := requests[0]
+	if request.LogName != "projects/project/logs/"+cloudLoggingLogID || request.Labels["stream"] != "s" || request.Labels["database"] != "d" {
+		t.Errorf("request = %s with labels %v, want the log of the stream and the database", request.LogName, request.Labels)
+	}
+	if len(request.Entries) != 2 {
+		t.Fatalf("entries = %d, want 2", len(request.Entries))
+	}
+	started, retried := request.Entries[0], request.Entries[1]
+	if started.Severity != "DEBUG" || retried.Severity != "WARNING" {
+		t.Errorf("severities = %s, %s, want DEBUG, WARNING", started.Severity, retried.Severity)
+	}
+	if got := started.Labels["partition_token"]; got != "token1" {
+		t.Errorf("partition_token label = %q, want token1", got)
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal(started.JsonPayload, &payload); err != nil {
+		t.Fatalf("json.Unmarshal error: %v", err)
+	}
+	if payload["event"] != "partition_started" || payload["partition"] != "p1" || payload["start_timestamp"] != "2024-06-01T08:00:00Z" || payload["message"] != "started" {
+		t.Errorf("payload = %v, want the message and the attributes", payload)
+	}
+
+	// The DEBUG log is written only to Cloud Logging.
+	if got := stderr.String(); strings.Contains(got, "partition_started") || !strings.Contains(got, "partition_retried") {
+		t.Errorf("stderr = %q, want the logs at INFO or above", got)
+	}
+}
+
+func TestCloudLoggingWriterDrops(t *testing.T) {
+	var stderr bytes.Buffer
+	release := make(chan struct{})
+	var mu sync.Mutex
+	written := 0
+	w := newCloudLoggingWriterWithWrite("project", nil, slog.New(slog.NewTextHandler(&stderr, nil)), func(ctx context.Context, request *logging.WriteLogEntriesRequest) error {
+		<-release
+		mu.Lock()
+		defer mu.Unlock()
+		written += len(request.Entries)
+		return nil
+	})
+	logger := slog.New(w.handler())
+
+	// The logs never block while the write is slow.
+	total := cloudLoggingQueueSize + 2*cloudLoggingBatchSize
+	for i := 0; i < total; i++ {
+		logger.Info("finished", "event", "partition_finished")
+	}
+	close(release)
+	w.Close()
+
+	dropped := int(w.dropped.Load())
+	if dropped == 0 || written+dropped != total {
+		t.Errorf("written = %d, dropped = %d, want some dropped out of %d", written, dropped, total)
+	}
+	if got := stderr.String(); !strings.Contains(got, "cloud_logging_dropped") {
+		t.Errorf("stderr = %q, want cloud_logging_dropped", got)
+	}
+}
+
+func TestWatermarkMilestones(t *testing.T) {
+	var buf bytes.Buffer
+	now := mustParseTime(t, "2024-06-01T08:00:30Z")
+	lag := 10 * time.Second
+	m := &watermarkMilestones{
+		logger: slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})),
+		stats:  func() changestreams.Stats { return changestreams.Stats{WatermarkLag: lag} },
+		now:    func() time.Time { return now },
+	}
+
+	m.check()
+	// The low watermark in the same minute is not a milestone.
+	now = now.Add(10 * time.Second)
+	m.check()
+	now = now.Add(time.Minute)
+	m.check()
+
+	var milestones []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var log struct {
+			Event     string `json:"event"`
+			Milestone string `json:"milestone"`
+		}
+		if err := json.Unmarshal([]byte(line), &log); err != nil {
+			t.Fatalf("json.Unmarshal error: %v", err)
+		}
+		if log.Event != "watermark_milestone" {
+			t.Errorf("event = %q, want watermark_milestone", log.Event)
+		}
+		milestones = append(milestones, log.Milestone)
+	}
+	if want := []string{"2024-06-01T08:00:00Z", "2024-06-01T08:01:00Z"}; strings.Join(milestones, ",") != strings.Join(want, ",") {
+		t.Errorf("milestones = %v, want %v", milestones, want)
+	}
+}
@@ -0,0 +1,95 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Exit codes of the command. They are part of the interface for the scripts wrapping the command,
+// so an existing code must never change its meaning.
+const (
+	// exitOK is the success, including a bounded read that has read up to --end.
+	exitOK = 0
+	// exitFailure is a runtime failure not covered by the other codes.
+	exitFailure = 1
+	// exitUsage is an invalid or missing option.
+	exitUsage = 2
+	// exitPermissionDenied is an authentication or permission error.
+	exitPermissionDenied = 3
+	// exitNotFound is the stream or the database not found.
+	exitNotFound = 4
+	// exitOutsideRetention is the start timestamp outside the retention period of the stream.
+	exitOutsideRetention = 5
+	// exitLagThresholdExceeded is reserved for the lag probe, which exits with it when the lag exceeds the threshold.
+	exitLagThresholdExceeded = 6
+	// exitVerificationFailed is the differences between the change stream and the table found by verify-sync.
+	exitVerificationFailed = 7
+	// exitInterrupted is the interrupt, e.g. Ctrl-C. It's 128 + SIGINT by convention.
+	exitInterrupted = 130
+)
+
+var exitCodeMeanings = map[int]string{
+	exitOK:                   "success",
+	exitFailure:              "runtime failure",
+	exitUsage:                "usage error",
+	exitPermissionDenied:     "authentication or permission error",
+	exitNotFound:             "stream or database not found",
+	exitOutsideRetention:     "start timestamp outside the retention period",
+	exitLagThresholdExceeded: "lag threshold exceeded",
+	exitVerificationFailed:   "differences found by verification",
+	exitInterrupted:          "interrupted",
+}
+
+// exitCode maps the error of the reader to the exit code. ctx is the context of the command,
+// which is cancelled on interrupt.
+func exitCode(ctx context.Context, err error) int {
+	if err == nil {
+		return exitOK
+	}
+	if ctx.Err() != nil {
+		return exitInterrupted
+	}
+
+	// The errors of the reader wrap the errors of Cloud Spanner, which carry the gRPC status.
+	var grpcErr interface{ GRPCStatus() *status.Status }
+	if !errors.As(err, &grpcErr) {
+		return exitFailure
+	}
+	s := grpcErr.GRPCStatus()
+	switch s.Code() {
+	case codes.Unauthenticated, codes.PermissionDenied:
+		return exitPermissionDenied
+	case codes.NotFound:
+		return exitNotFound
+	case codes.OutOfRange:
+		return exitOutsideRetention
+	case codes.InvalidArgument:
+		// An unknown stream is an unknown read function, e.g. "Table-valued function not found: READ_x"
+		// for GoogleSQL or "function spanner.read_json_x(...) does not exist" for PostgreSQL.
+		message := s.Message()
+		if strings.Contains(message, "not found") || strings.Contains(message, "does not exist") {
+			return exitNotFound
+		}
+	}
+	return exitFailure
+}
@@ -0,0 +1,75 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/spanner"
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestExitCode(t *testing.T) {
+	spannerErr := func(code codes.Code, message string) error {
+		return fmt.Errorf("failed to read: %w", spanner.ToSpannerError(status.Error(code, message)))
+	}
+	ctx := context.Background()
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	for _, test := range []struct {
+		desc string
+		ctx  context.Context
+		err  error
+		want int
+	}{
+		{desc: "success", ctx: ctx, err: nil, want: exitOK},
+		{desc: "generic error", ctx: ctx, err: errors.New("error"), want: exitFailure},
+		{desc: "unauthenticated", ctx: ctx, err: spannerErr(codes.Unauthenticated, "invalid credentials"), want: exitPermissionDenied},
+		{desc: "permission denied", ctx: ctx, err: spannerErr(codes.PermissionDenied, "permission denied"), want: exitPermissionDenied},
+		{desc: "database not found", ctx: ctx, err: spannerErr(codes.NotFound, "Database not found"), want: exitNotFound},
+		{desc: "stream not found", ctx: ctx, err: spannerErr(codes.InvalidArgument, "Table-valued function not found: READ_x"), want: exitNotFound},
+		{desc: "invalid argument", ctx: ctx, err: spannerErr(codes.InvalidArgument, "invalid heartbeat"), want: exitFailure},
+		{desc: "outside retention", ctx: ctx, err: spannerErr(codes.OutOfRange, "start_timestamp is too far in the past"), want: exitOutsideRetention},
+		{desc: "partition error", ctx: ctx, err: errors.Join(&changestreams.PartitionError{PartitionToken: "a", Err: spannerErr(codes.PermissionDenied, "")}), want: exitPermissionDenied},
+		{desc: "interrupted", ctx: cancelled, err: spannerErr(codes.Canceled, "context canceled"), want: exitInterrupted},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			if got := exitCode(test.ctx, test.err); got != test.want {
+				t.Errorf("exitCode = %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRunExitCode(t *testing.T) {
+	_, stderr, code := runCommand(t, spanner.ToSpannerError(status.Error(codes.PermissionDenied, "permission denied")),
+		"-p", "project", "-i", "instance", "-d", "database", "-s", "stream")
+	if code != exitPermissionDenied {
+		t.Errorf("exit code = %d, want %d", code, exitPermissionDenied)
+	}
+	lines := strings.Split(strings.TrimSuffix(stderr, "\n"), "\n")
+	if got, want := lines[len(lines)-1], "exit status 3: authentication or permission error"; got != want {
+		t.Errorf("last line of stderr = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,215 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	monitoring "google.golang.org/api/monitoring/v3"
+)
+
+const (
+	gcmMetricTypePrefix = "custom.googleapis.com/spanner_change_streams_tail/"
+	// gcmMinWriteInterval is the shortest interval of the points of a time series that Cloud Monitoring accepts.
+	gcmMinWriteInterval = 5 * time.Second
+	// gcmMaxTimeSeriesPerRequest is the most time series that a request of Cloud Monitoring can write.
+	gcmMaxTimeSeriesPerRequest = 200
+	gcmWriteTimeout            = 10 * time.Second
+	// gcmQuotaBackoff is the time the writes are suspended for after the quota of Cloud Monitoring is exceeded.
+	gcmQuotaBackoff = time.Minute
+)
+
+// gcmEmitter writes the metrics to Cloud Monitoring as the custom metrics, e.g.
+// custom.googleapis.com/spanner_change_streams_tail/watermark_lag_seconds. The gauges and the timings are GAUGE
+// metrics of DOUBLE, the timings in seconds, and the counters are CUMULATIVE metrics of INT64 since the emitter
+// was created.
+//
+// The time series are of the generic_task resource, whose namespace and job are the database and the stream,
+// and whose task_id tells the processes apart. The other tags are the labels of the metrics.
+type gcmEmitter struct {
+	projectID string
+	resource  *monitoring.MonitoredResource
+	write     func(ctx context.Context, timeSeries []*monitoring.TimeSeries) error
+	now       func() time.Time
+	// start is the start of the intervals of the cumulative metrics.
+	start  time.Time
+	series map[string]*gcmSeries
+	// lastWrite and backoffUntil keep the writes within the rate that Cloud Monitoring accepts.
+	lastWrite    time.Time
+	backoffUntil time.Time
+}
+
+// gcmSeries is the latest value of a time series.
+type gcmSeries struct {
+	name       string
+	labels     map[string]string
+	cumulative bool
+	double     float64
+	total      int64
+}
+
+// newGCMEmitter returns the emitter with the Application Default Credentials, the same credentials as Cloud Spanner.
+func newGCMEmitter(ctx context.Context, projectID string, tags []string) (*gcmEmitter, error) {
+	if projectID == "" {
+		return nil, errors.New("project must be specified for gcm metrics")
+	}
+	service, err := monitoring.NewService(ctx)
+	if err != nil {
+		return nil, err
+	}
+	e := newGCMEmitterWithWriter(projectID, tags, func(ctx context.Context, timeSeries []*monitoring.TimeSeries) error {
+		_, err := service.Projects.TimeSeries.Create("projects/"+projectID, &monitoring.CreateTimeSeriesRequest{TimeSeries: timeSeries}).Context(ctx).Do()
+		return err
+	})
+	return e, nil
+}
+
+func newGCMEmitterWithWriter(projectID string, tags []string, write func(ctx context.Context, timeSeries []*monitoring.TimeSeries) error) *gcmEmitter {
+	hostname, _ := os.Hostname()
+	resource := &monitoring.MonitoredResource{
+		Type: "generic_task",
+		Labels: map[string]string{
+			"project_id": projectID,
+			"location":   "global",
+			"namespace":  "",
+			"job":        "",
+			"task_id":    fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+		},
+	}
+	for key, value := range gcmLabels(tags) {
+		switch key {
+		case "database":
+			resource.Labels["namespace"] = value
+		case "stream":
+			resource.Labels["job"] = value
+		}
+	}
+	return &gcmEmitter{
+		projectID: projectID,
+		resource:  resource,
+		write:     write,
+		now:       time.Now,
+		start:     time.Now(),
+		series:    make(map[string]*gcmSeries),
+	}
+}
+
+func (e *gcmEmitter) gauge(name string, value float64, tags ...string) {
+	e.get(name, tags).double = value
+}
+
+func (e *gcmEmitter) count(name string, delta int64, tags ...string) {
+	s := e.get(name, tags)
+	s.cumulative = true
+	s.total += delta
+}
+
+func (e *gcmEmitter) timing(name string, d time.Duration, tags ...string) {
+	e.get(name, tags).double = d.Seconds()
+}
+
+// get returns the time series of the metric with the tags, which is created if it doesn't exist yet.
+func (e *gcmEmitter) get(name string, tags []string) *gcmSeries {
+	key := name + "|" + strings.Join(tags, ",")
+	s := e.series[key]
+	if s == nil {
+		s = &gcmSeries{name: name, labels: gcmLabels(tags)}
+		e.series[key] = s
+	}
+	return s
+}
+
+// flush writes the latest values of all the time series in batches. The values are kept, and written by a later
+// flush instead, if the last write was too recent or the quota was exceeded.
+func (e *gcmEmitter) flush() error {
+	now := e.now()
+	if now.Before(e.backoffUntil) || now.Sub(e.lastWrite) < gcmMinWriteInterval {
+		return nil
+	}
+	keys := make([]string, 0, len(e.series))
+	for key := range e.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	timeSeries := make([]*monitoring.TimeSeries, 0, len(keys))
+	for _, key := range keys {
+		timeSeries = append(timeSeries, e.timeSeries(e.series[key], now))
+	}
+
+	e.lastWrite = now
+	for start := 0; start < len(timeSeries); start += gcmMaxTimeSeriesPerRequest {
+		ctx, cancel := context.WithTimeout(context.Background(), gcmWriteTimeout)
+		err := e.write(ctx, timeSeries[start:min(start+gcmMaxTimeSeriesPerRequest, len(timeSeries))])
+		cancel()
+		var apiErr *googleapi.Error
+		if errors.As(err, &apiErr) && apiErr.Code == http.StatusTooManyRequests {
+			e.backoffUntil = now.Add(gcmQuotaBackoff)
+			return fmt.Errorf("quota of Cloud Monitoring exceeded, suspending the metrics for %s: %w", gcmQuotaBackoff, err)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *gcmEmitter) timeSeries(s *gcmSeries, now time.Time) *monitoring.TimeSeries {
+	ts := &monitoring.TimeSeries{
+		Metric:   &monitoring.Metric{Type: gcmMetricTypePrefix + s.name, Labels: s.labels},
+		Resource: e.resource,
+	}
+	point := &monitoring.Point{Interval: &monitoring.TimeInterval{EndTime: now.UTC().Format(time.RFC3339Nano)}}
+	if s.cumulative {
+		ts.MetricKind = "CUMULATIVE"
+		ts.ValueType = "INT64"
+		point.Interval.StartTime = e.start.UTC().Format(time.RFC3339Nano)
+		total := s.total
+		point.Value = &monitoring.TypedValue{Int64Value: &total}
+	} else {
+		ts.MetricKind = "GAUGE"
+		ts.ValueType = "DOUBLE"
+		double := s.double
+		point.Value = &monitoring.TypedValue{DoubleValue: &double}
+	}
+	ts.Points = []*monitoring.Point{point}
+	return ts
+}
+
+func (e *gcmEmitter) Close() error {
+	return nil
+}
+
+// gcmLabels returns the labels of the tags in the key:value format.
+func gcmLabels(tags []string) map[string]string {
+	if len(tags) == 0 {
+		return nil
+	}
+	labels := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		key, value, _ := strings.Cut(tag, ":")
+		labels[key] = value
+	}
+	return labels
+}
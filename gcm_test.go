@@ -0,0 +1,109 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	monitoring "google.golang.org/api/monitoring/v3"
+)
+
+func TestGCMEmitter(t *testing.T) {
+	now := mustParseTime(t, "2024-06-01T08:00:00Z")
+	var writes [][]*monitoring.TimeSeries
+	var writeErr error
+	e := newGCMEmitterWithWriter("project", []string{"stream:s", "database:d"}, func(ctx context.Context, timeSeries []*monitoring.TimeSeries) error {
+		writes = append(writes, timeSeries)
+		return writeErr
+	})
+	e.start = now.Add(-time.Minute)
+	e.now = func() time.Time { return now }
+
+	e.gauge("watermark_lag_seconds", 1.5)
+	e.count("mods", 2, "mod_type:insert")
+	e.count("mods", 3, "mod_type:insert")
+	if err := e.flush(); err != nil {
+		t.Fatalf("flush error: %v", err)
+	}
+	if len(writes) != 1 || len(writes[0]) != 2 {
+		t.Fatalf("writes = %v, want a write of 2 time series", writes)
+	}
+	mods, lag := writes[0][0], writes[0][1]
+	if got := mods.Metric.Type; got != gcmMetricTypePrefix+"mods" {
+		t.Errorf("metric type = %q, want mods", got)
+	}
+	if got := mods.Metric.Labels["mod_type"]; got != "insert" {
+		t.Errorf("mod_type label = %q, want insert", got)
+	}
+	if mods.MetricKind != "CUMULATIVE" || *mods.Points[0].Value.Int64Value != 5 || mods.Points[0].Interval.StartTime != "2024-06-01T07:59:00Z" {
+		t.Errorf("mods = %s of %d from %s, want CUMULATIVE of 5 from the start", mods.MetricKind, *mods.Points[0].Value.Int64Value, mods.Points[0].Interval.StartTime)
+	}
+	if lag.MetricKind != "GAUGE" || *lag.Points[0].Value.DoubleValue != 1.5 {
+		t.Errorf("watermark_lag_seconds = %s of %v, want GAUGE of 1.5", lag.MetricKind, *lag.Points[0].Value.DoubleValue)
+	}
+	if labels := lag.Resource.Labels; lag.Resource.Type != "generic_task" || labels["namespace"] != "d" || labels["job"] != "s" || labels["project_id"] != "project" {
+		t.Errorf("resource = %s %v, want generic_task of the database and the stream", lag.Resource.Type, labels)
+	}
+
+	// The points closer than the min interval are not written.
+	now = now.Add(time.Second)
+	if err := e.flush(); err != nil || len(writes) != 1 {
+		t.Errorf("flush within the min interval wrote %d times with error %v, want no write", len(writes)-1, err)
+	}
+
+	// After the quota is exceeded, the writes are suspended, and the counters keep counting.
+	now = now.Add(gcmMinWriteInterval)
+	writeErr = &googleapi.Error{Code: http.StatusTooManyRequests}
+	if err := e.flush(); err == nil {
+		t.Error("flush succeeded, want the quota error")
+	}
+	writeErr = nil
+	e.count("mods", 1, "mod_type:insert")
+	now = now.Add(gcmQuotaBackoff / 2)
+	if err := e.flush(); err != nil || len(writes) != 2 {
+		t.Errorf("flush in the backoff wrote %d times with error %v, want no write", len(writes)-2, err)
+	}
+	now = now.Add(gcmQuotaBackoff)
+	if err := e.flush(); err != nil {
+		t.Fatalf("flush error: %v", err)
+	}
+	if got := *writes[len(writes)-1][0].Points[0].Value.Int64Value; got != 6 {
+		t.Errorf("mods after the backoff = %d, want 6", got)
+	}
+}
+
+func TestGCMEmitterBatches(t *testing.T) {
+	var sizes []int
+	e := newGCMEmitterWithWriter("project", nil, func(ctx context.Context, timeSeries []*monitoring.TimeSeries) error {
+		sizes = append(sizes, len(timeSeries))
+		return nil
+	})
+	for i := 0; i < gcmMaxTimeSeriesPerRequest+1; i++ {
+		e.gauge("partitions", 1, fmt.Sprintf("i:%d", i))
+	}
+	if err := e.flush(); err != nil {
+		t.Fatalf("flush error: %v", err)
+	}
+	if len(sizes) != 2 || sizes[0] != gcmMaxTimeSeriesPerRequest || sizes[1] != 1 {
+		t.Errorf("batches = %v, want %d and 1", sizes, gcmMaxTimeSeriesPerRequest)
+	}
+}
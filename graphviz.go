@@ -39,8 +39,11 @@ type Partition struct {
 
 type PartitionVisualizer struct {
 	partitions map[string]*Partition
-	mu         sync.Mutex
-	out        io.Writer
+	// partitionID returns the short ID of the partition token, e.g. Reader.PartitionID. If it's nil or returns
+	// the empty string, the token is drawn instead.
+	partitionID func(token string) string
+	mu          sync.Mutex
+	out         io.Writer
 }
 
 func NewPartitionVisualizer(out io.Writer) *PartitionVisualizer {
@@ -97,31 +100,47 @@ func (v *PartitionVisualizer) Read(result *changestreams.ReadResult) error {
 func (v *PartitionVisualizer) Draw() {
 	fmt.Fprintf(v.out, "digraph {\n")
 	fmt.Fprintf(v.out, "  node [shape=record];\n")
-	partitions := sortPartitions(v.partitions)
+	field := "token"
+	if v.partitionID != nil {
+		field = "partition"
+	}
+	partitions := v.sortPartitions()
 	for _, partition := range partitions {
 		var timestamp string
 		if !partition.StartTimestamp.IsZero() {
 			timestamp = partition.StartTimestamp.Format(time.RFC3339)
 		}
-		fmt.Fprintf(v.out, `  "%s" [label="{token|start_timestamp|record_sequence}|{{%s}|{%s}|{%s}}"];`, partition.Token, partition.Token, timestamp, partition.RecordSequence)
+		name := v.name(partition)
+		fmt.Fprintf(v.out, `  "%s" [label="{%s|start_timestamp|record_sequence}|{{%s}|{%s}|{%s}}"];`, name, field, name, timestamp, partition.RecordSequence)
 		fmt.Fprintln(v.out, "")
 	}
 	for _, partition := range partitions {
 		for _, parent := range partition.Parents {
-			fmt.Fprintf(v.out, `  "%s" -> "%s"`, parent.Token, partition.Token)
+			fmt.Fprintf(v.out, `  "%s" -> "%s"`, v.name(parent), v.name(partition))
 			fmt.Fprintln(v.out, "")
 		}
 	}
 	fmt.Fprintf(v.out, "}\n")
 }
 
-func sortPartitions(partitionsMap map[string]*Partition) []*Partition {
+// name returns the short ID of the partition, or its token if it has no ID.
+func (v *PartitionVisualizer) name(partition *Partition) string {
+	if v.partitionID == nil || partition.Token == rootPartitionToken {
+		return partition.Token
+	}
+	if id := v.partitionID(partition.Token); id != "" {
+		return id
+	}
+	return partition.Token
+}
+
+func (v *PartitionVisualizer) sortPartitions() []*Partition {
 	var partitions []*Partition
-	for _, p := range partitionsMap {
+	for _, p := range v.partitions {
 		partitions = append(partitions, p)
 	}
 	sort.Slice(partitions, func(i, j int) bool {
-		return partitions[i].Token < partitions[j].Token
+		return v.name(partitions[i]) < v.name(partitions[j])
 	})
 	return partitions
 }
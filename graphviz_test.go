@@ -149,6 +149,43 @@ func TestPartitionVisualizer(t *testing.T) {
 	}
 }
 
+func TestPartitionVisualizerWithPartitionIDs(t *testing.T) {
+	var out bytes.Buffer
+	visualizer := NewPartitionVisualizer(&out)
+	ids := map[string]string{"long-token-a": "P-0001", "long-token-b": "P-0002"}
+	visualizer.partitionID = func(token string) string { return ids[token] }
+	visualizer.Read(&changestreams.ReadResult{
+		ChangeRecords: []*changestreams.ChangeRecord{
+			{
+				ChildPartitionsRecords: []*changestreams.ChildPartitionsRecord{
+					{
+						StartTimestamp: mustParseTime(t, "2022-12-04T18:00:00Z"),
+						RecordSequence: "00000001",
+						ChildPartitions: []*changestreams.ChildPartition{
+							{Token: "long-token-a"},
+							{Token: "long-token-b"},
+						},
+					},
+				},
+			},
+		},
+	})
+	visualizer.Draw()
+
+	expected := `digraph {
+  node [shape=record];
+  "P-0001" [label="{partition|start_timestamp|record_sequence}|{{P-0001}|{2022-12-04T18:00:00Z}|{00000001}}"];
+  "P-0002" [label="{partition|start_timestamp|record_sequence}|{{P-0002}|{2022-12-04T18:00:00Z}|{00000001}}"];
+  "root" [label="{partition|start_timestamp|record_sequence}|{{root}|{}|{}}"];
+  "root" -> "P-0001"
+  "root" -> "P-0002"
+}
+`
+	if diff := cmp.Diff(out.String(), expected); diff != "" {
+		t.Errorf("visualizer has diff = %v", diff)
+	}
+}
+
 func mustParseTime(t *testing.T, s string) time.Time {
 	parsed, err := time.ParseInLocation(time.RFC3339, s, time.UTC)
 	if err != nil {
@@ -0,0 +1,153 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams"
+)
+
+// defaultLagWindow is the observation window of lag subcommand without --window.
+const defaultLagWindow = time.Minute
+
+// lagReport is the output of lag subcommand.
+type lagReport struct {
+	// WatermarkLag is the time between the end of the window and the low watermark of the partitions.
+	// It's zero if no partition was being read at the end of the window.
+	WatermarkLag        string  `json:"watermark_lag"`
+	WatermarkLagSeconds float64 `json:"watermark_lag_seconds"`
+	DataChangeRecords   int64   `json:"data_change_records"`
+	RecordsPerSecond    float64 `json:"records_per_second"`
+	Partitions          int     `json:"partitions"`
+	StalledPartitions   int     `json:"stalled_partitions"`
+	Window              string  `json:"window"`
+}
+
+// lag runs lag subcommand, which reads the heartbeats of the stream from --since for the observation window
+// without delivering any record, and writes the watermark lag and the throughput of the data change records
+// at the end of the window to stdout in JSON.
+func (c *command) lag(ctx context.Context, name string, args []string) int {
+	var (
+		projectID, instanceID, databaseID, streamID, role, logFormat string
+		since, window                                                time.Duration
+		verbose                                                      bool
+	)
+
+	flags := flag.NewFlagSet(name+" lag", flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	flags.StringVar(&projectID, "project", "", "")
+	flags.StringVar(&instanceID, "instance", "", "")
+	flags.StringVar(&databaseID, "database", "", "")
+	flags.StringVar(&streamID, "stream", "", "")
+	flags.StringVar(&role, "role", "", "")
+	flags.StringVar(&logFormat, "log-format", formatText, "")
+	flags.DurationVar(&since, "since", 0, "")
+	flags.DurationVar(&window, "window", defaultLagWindow, "")
+	flags.BoolVar(&verbose, "verbose", false, "")
+	flags.StringVar(&projectID, "p", "", "")
+	flags.StringVar(&instanceID, "i", "", "")
+	flags.StringVar(&databaseID, "d", "", "")
+	flags.StringVar(&streamID, "s", "", "")
+	flags.BoolVar(&verbose, "v", false, "")
+
+	flags.Usage = func() { usage(c.stderr, name) }
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if projectID == "" || instanceID == "" || databaseID == "" || streamID == "" {
+		flags.Usage()
+		return exitUsage
+	}
+	if window <= 0 {
+		return c.exitf(exitUsage, "invalid --window: %s", window)
+	}
+	// Both --since=-1h and --since=1h mean an hour ago.
+	if since < 0 {
+		since = -since
+	}
+	slogger, err := newSlogger(c.stderr, logFormat, verbose, false)
+	if err != nil {
+		return c.exitf(exitUsage, "%v", err)
+	}
+
+	reader, err := c.newReader(ctx, projectID, instanceID, databaseID, streamID, changestreams.Config{
+		StartOffset: since,
+		Logger:      slogger,
+		SpannerClientConfig: spanner.ClientConfig{
+			SessionPoolConfig: spanner.DefaultSessionPoolConfig,
+			DatabaseRole:      role,
+		},
+	})
+	if err != nil {
+		return c.exitf(exitCode(ctx, err), "failed to create a reader: %v", err)
+	}
+	defer reader.Close()
+
+	readCtx, cancelRead := context.WithCancel(ctx)
+	defer cancelRead()
+	readErr := make(chan error, 1)
+	start := time.Now()
+	go func() {
+		// A nil function reads the heartbeats and counts the records without decoding them.
+		readErr <- reader.Read(readCtx, nil)
+	}()
+
+	timer := time.NewTimer(window)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		return exitCode(ctx, ctx.Err())
+	case err := <-readErr:
+		// The stream has ended or failed before the end of the window.
+		if err != nil {
+			return c.exitf(exitCode(ctx, err), "failed to read stream: %v", err)
+		}
+	}
+	// The stats are taken before the cancellation, which finishes the partitions.
+	report := newLagReport(reader.Stats(), time.Since(start))
+	cancelRead()
+
+	if err := json.NewEncoder(c.stdout).Encode(report); err != nil {
+		return c.exitf(exitFailure, "failed to write the lag: %v", err)
+	}
+	return exitOK
+}
+
+func newLagReport(stats changestreams.Stats, elapsed time.Duration) *lagReport {
+	report := &lagReport{
+		WatermarkLag:        stats.WatermarkLag.String(),
+		WatermarkLagSeconds: stats.WatermarkLag.Seconds(),
+		Partitions:          len(stats.Partitions),
+		StalledPartitions:   stats.StalledPartitions,
+		DataChangeRecords:   stats.DataChangeRecords,
+		Window:              elapsed.Round(time.Millisecond).String(),
+	}
+	if elapsed > 0 {
+		report.RecordsPerSecond = float64(report.DataChangeRecords) / elapsed.Seconds()
+	}
+	return report
+}
@@ -20,6 +20,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"strings"
 	"sync"
 
 	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams"
@@ -46,24 +47,40 @@ func (l *Logger) Read(result *changestreams.ReadResult) error {
 	}
 
 	// Only prints the data change records.
-	for _, changeRecord := range result.ChangeRecords {
-		for _, r := range changeRecord.DataChangeRecords {
-			switch l.format {
-			case formatJSON:
-				if err := json.NewEncoder(l.out).Encode(r); err != nil {
-					return err
-				}
-			case formatText:
-				modsJSON, err := json.Marshal(r.Mods)
-				if err != nil {
-					return err
-				}
-				fmt.Fprintf(l.out, "%s | %s | %s | %s\n", r.CommitTimestamp, r.ModType, r.TableName, modsJSON)
-			default:
-				return fmt.Errorf("invalid format: %s", l.format)
+	for r := range result.DataChangeRecords() {
+		switch l.format {
+		case formatJSON:
+			var v interface{} = r
+			if result.Source != nil {
+				v = struct {
+					*changestreams.DataChangeRecord
+					Source *changestreams.Source `json:"source"`
+				}{r, result.Source}
 			}
+			if err := json.NewEncoder(l.out).Encode(v); err != nil {
+				return err
+			}
+		case formatText:
+			modsJSON, err := json.Marshal(r.Mods)
+			if err != nil {
+				return err
+			}
+			if result.Source != nil {
+				fmt.Fprintf(l.out, "%s | ", sourceName(result.Source))
+			}
+			fmt.Fprintf(l.out, "%s | %s | %s | %s\n", r.CommitTimestamp, r.ModType, r.TableName, modsJSON)
+		default:
+			return fmt.Errorf("invalid format: %s", l.format)
 		}
 	}
 
 	return nil
 }
+
+// sourceName returns the label of the source, or its IDs joined by slashes if it has no label.
+func sourceName(source *changestreams.Source) string {
+	if source.Label != "" {
+		return source.Label
+	}
+	return strings.Join([]string{source.ProjectID, source.InstanceID, source.DatabaseID, source.StreamID}, "/")
+}
@@ -19,8 +19,11 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"os/signal"
 	"strings"
@@ -28,22 +31,57 @@ import (
 
 	"cloud.google.com/go/spanner"
 	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams"
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams/rename"
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams/sink/pubsub"
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams/verify"
 )
 
-func usage() {
-	command := os.Args[0]
-	fmt.Printf(`Usage:
-  %s [OPTIONS]
+func usage(out io.Writer, command string) {
+	fmt.Fprintf(out, `Usage:
+  %[1]s [OPTIONS]
+  %[1]s list-streams -p PROJECT -i INSTANCE -d DATABASE [-f text|json] [--role=ROLE]
+  %[1]s lag -p PROJECT -i INSTANCE -d DATABASE -s STREAM [--since=-1h] [--window=1m] [--role=ROLE]
+  %[1]s verify-sync -p PROJECT -i INSTANCE -d DATABASE -s STREAM --table=TABLE --start=START --end=END
+      [--target-database=DATABASE] [--batch-size=1000] [--role=ROLE]
 
 Options:
   -p, --project=  (required)   GCP Project ID
   -i, --instance= (required)   Cloud Spanner Instance ID
   -d, --database= (required)   Cloud Spanner Database ID
+      --database-pattern=      Read all the databases whose IDs match the LIKE pattern (e.g. tenant_%%) instead of --database
+      --database-refresh-interval=
+                               Interval to list the databases again with --database-pattern (default: 5m)
   -s, --stream=   (required)   Cloud Spanner Change Stream ID
-  -f, --format=                Output format [text|json] (default: text)
+      --all-streams            Read all the change streams of the database instead of --stream
+      --stream-refresh-interval=
+                               Interval to list the change streams again with --all-streams (default: 5m)
+  -f, --format=                Output format [text|json|aggregate] (default: text)
+      --window=                Window of the commit timestamps with --format=aggregate (default: 1m)
+      --late-records=          Late records with --format=aggregate, emitted as a correction of their window or
+                               counted into the next window [amend|next] (default: amend)
+      --log-format=            Log format of the operational logs on stderr [text|json] (default: text)
+      --trace-records          Log the metadata of every record on stderr at TRACE level, without the values
       --start=                 Start timestamp with RFC3339 format (default: current timestamp)
+      --start-offset=          Start the duration before now, e.g. 1h, instead of --start
       --end=                   End timestamp with RFC3339 format (default: none)
       --role=                  Database role for fine-grained access control
+      --sink=                  Write the records to the sink URL instead of stdout (e.g. redis://localhost:6379)
+      --pubsub-ordering-key=   Ordering key of the messages of the Pub/Sub sink [table+pk|table|none] (default: none)
+      --track-transactions     Report transactions whose records were not all read on exit
+      --progress               Report the progress of catching up to the live changes to stderr periodically
+      --metrics=               Send the metrics every 10s to the URL (e.g. statsd://127.0.0.1:8125), or to Cloud Monitoring with gcm
+      --cloud-logging          Write the partition lifecycle events to Cloud Logging in addition to stderr
+      --envelope-source        Add the project, instance, database and stream to each record
+      --source-label=          Label added to each record with --envelope-source, e.g. the region
+      --max-value-bytes=       Truncate the column values larger than the bytes, except the primary keys (default: none)
+      --bytes-format=          Format of the BYTES columns, except the primary keys [base64|hex|omit] (default: base64)
+      --numeric-format=        Format of the NUMERIC columns, except the primary keys [string|number|object] (default: string)
+      --non-finite-floats=     NaN and Infinity of the FLOAT64 columns, except the primary keys [string|null|error] (default: string)
+      --parse-json-columns     Write the values of the JSON columns as structured JSON instead of strings
+      --timezone=              Time zone of the TIMESTAMP columns, e.g. Asia/Tokyo (default: UTC)
+      --timestamp-format=      Go layout of the TIMESTAMP columns, e.g. 2006-01-02 15:04:05 (default: RFC3339 with nanoseconds)
+      --rename=                Rename the column in the output, e.g. Orders.order_id=orderId (can be repeated)
+      --rename-table=          Rename the table in the output, e.g. Orders=orders_v2 (can be repeated)
       --visualize-partitions   Visualize the change stream partitions in Graphviz DOT
 
 Help Options:
@@ -51,112 +89,469 @@ Help Options:
 `, command)
 }
 
+// stringsFlag is the value of the option that can be repeated.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+// streamReader is the interface of changestreams.Reader used by the command.
+type streamReader interface {
+	Read(ctx context.Context, f func(result *changestreams.ReadResult) error) error
+	Stats() changestreams.Stats
+	IncompleteTransactions(minAge time.Duration) []*changestreams.IncompleteTransaction
+	Close()
+}
+
+func newReader(ctx context.Context, projectID, instanceID, databaseID, streamID string, config changestreams.Config) (streamReader, error) {
+	return changestreams.NewReaderWithConfig(ctx, projectID, instanceID, databaseID, streamID, config)
+}
+
+func newMultiDatabaseReader(ctx context.Context, projectID, instanceID, streamID string, config changestreams.MultiDatabaseConfig) (streamReader, error) {
+	return changestreams.NewMultiDatabaseReader(ctx, projectID, instanceID, streamID, config)
+}
+
+func newMultiStreamReader(ctx context.Context, projectID, instanceID, databaseID string, config changestreams.MultiStreamConfig) (streamReader, error) {
+	return changestreams.NewMultiStreamReader(ctx, projectID, instanceID, databaseID, config)
+}
+
+// command is the command line interface. Only the records, or the partitions with --visualize-partitions,
+// are written to stdout, and everything else, including the usage, the logs and the errors, is written to stderr,
+// so that stdout can be piped to other programs.
+type command struct {
+	stdout    io.Writer
+	stderr    io.Writer
+	newReader func(ctx context.Context, projectID, instanceID, databaseID, streamID string, config changestreams.Config) (streamReader, error)
+	// newMultiDatabaseReader is used instead of newReader with --database-pattern.
+	newMultiDatabaseReader func(ctx context.Context, projectID, instanceID, streamID string, config changestreams.MultiDatabaseConfig) (streamReader, error)
+	// newMultiStreamReader is used instead of newReader with --all-streams.
+	newMultiStreamReader func(ctx context.Context, projectID, instanceID, databaseID string, config changestreams.MultiStreamConfig) (streamReader, error)
+	// listChangeStreams is used by list-streams subcommand.
+	listChangeStreams func(ctx context.Context, dbPath, role string) ([]*changestreams.ChangeStream, error)
+	// compareRows is used by verify-sync subcommand.
+	compareRows func(ctx context.Context, v *verify.Verifier, dbPath, role string, timestamp time.Time) (*verify.Report, error)
+	handleStats func(d *statsDumper)
+}
+
 func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	go handleInterrupt(cancel)
+
+	c := &command{
+		stdout:                 os.Stdout,
+		stderr:                 os.Stderr,
+		newReader:              newReader,
+		newMultiDatabaseReader: newMultiDatabaseReader,
+		newMultiStreamReader:   newMultiStreamReader,
+		listChangeStreams:      listChangeStreams,
+		compareRows:            compareRows,
+		handleStats:            handleStatsSignals,
+	}
+	os.Exit(c.run(ctx, os.Args[0], os.Args[1:]))
+}
+
+// run runs the command with the arguments, and returns the exit code. The meaning of a non-zero code is written
+// to stderr as the last line.
+func (c *command) run(ctx context.Context, name string, args []string) int {
+	code := c.execute(ctx, name, args)
+	if code != exitOK {
+		fmt.Fprintf(c.stderr, "exit status %d: %s\n", code, exitCodeMeanings[code])
+	}
+	return code
+}
+
+func (c *command) execute(ctx context.Context, name string, args []string) int {
+	if len(args) > 0 && args[0] == "list-streams" {
+		return c.listStreams(ctx, name, args[1:])
+	}
+	if len(args) > 0 && args[0] == "lag" {
+		return c.lag(ctx, name, args[1:])
+	}
+	if len(args) > 0 && args[0] == "verify-sync" {
+		return c.verifySync(ctx, name, args[1:])
+	}
+
 	var (
-		projectID, instanceID, databaseID, streamID, format, start, end, role string
-		startTimestamp, endTimestamp                                          time.Time
-		verbose, visualizePartitions                                          bool
+		projectID, instanceID, databaseID, databasePattern, streamID, format, logFormat, start, end, role, sinkURL, sourceLabel, pubsubOrderingKey string
+		bytesFormat, numericFormat, nonFiniteFloats, timezone, timestampFormat, lateRecords, metricsURL                                            string
+		startTimestamp, endTimestamp                                                                                                               time.Time
+		startOffset, databaseRefreshInterval, streamRefreshInterval, window                                                                        time.Duration
+		columnRenames, tableRenames                                                                                                                stringsFlag
+		maxValueBytes                                                                                                                              int
+		verbose, visualizePartitions, trackTransactions, envelopeSource, traceRecords, allStreams, parseJSONColumns, progress, cloudLogging        bool
 	)
 
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+
 	// Long options.
-	flag.StringVar(&projectID, "project", "", "")
-	flag.StringVar(&instanceID, "instance", "", "")
-	flag.StringVar(&databaseID, "database", "", "")
-	flag.StringVar(&streamID, "stream", "", "")
-	flag.StringVar(&format, "format", formatText, "")
-	flag.StringVar(&start, "start", "", "")
-	flag.StringVar(&end, "end", "", "")
-	flag.StringVar(&role, "role", "", "")
-	flag.BoolVar(&verbose, "verbose", false, "")
-	flag.BoolVar(&visualizePartitions, "visualize-partitions", false, "")
+	flags.StringVar(&projectID, "project", "", "")
+	flags.StringVar(&instanceID, "instance", "", "")
+	flags.StringVar(&databaseID, "database", "", "")
+	flags.StringVar(&databasePattern, "database-pattern", "", "")
+	flags.DurationVar(&databaseRefreshInterval, "database-refresh-interval", 0, "")
+	flags.StringVar(&streamID, "stream", "", "")
+	flags.BoolVar(&allStreams, "all-streams", false, "")
+	flags.DurationVar(&streamRefreshInterval, "stream-refresh-interval", 0, "")
+	flags.StringVar(&format, "format", formatText, "")
+	flags.DurationVar(&window, "window", time.Minute, "")
+	flags.StringVar(&lateRecords, "late-records", lateRecordsAmend, "")
+	flags.StringVar(&logFormat, "log-format", formatText, "")
+	flags.BoolVar(&traceRecords, "trace-records", false, "")
+	flags.StringVar(&start, "start", "", "")
+	flags.DurationVar(&startOffset, "start-offset", 0, "")
+	flags.StringVar(&end, "end", "", "")
+	flags.StringVar(&role, "role", "", "")
+	flags.StringVar(&sinkURL, "sink", "", "")
+	flags.StringVar(&pubsubOrderingKey, "pubsub-ordering-key", "", "")
+	flags.BoolVar(&verbose, "verbose", false, "")
+	flags.BoolVar(&visualizePartitions, "visualize-partitions", false, "")
+	flags.BoolVar(&trackTransactions, "track-transactions", false, "")
+	flags.BoolVar(&progress, "progress", false, "")
+	flags.StringVar(&metricsURL, "metrics", "", "")
+	flags.BoolVar(&cloudLogging, "cloud-logging", false, "")
+	flags.BoolVar(&envelopeSource, "envelope-source", false, "")
+	flags.StringVar(&sourceLabel, "source-label", "", "")
+	flags.IntVar(&maxValueBytes, "max-value-bytes", 0, "")
+	flags.StringVar(&bytesFormat, "bytes-format", "base64", "")
+	flags.StringVar(&numericFormat, "numeric-format", "string", "")
+	flags.StringVar(&nonFiniteFloats, "non-finite-floats", "string", "")
+	flags.BoolVar(&parseJSONColumns, "parse-json-columns", false, "")
+	flags.StringVar(&timezone, "timezone", "", "")
+	flags.StringVar(&timestampFormat, "timestamp-format", "", "")
+	flags.Var(&columnRenames, "rename", "")
+	flags.Var(&tableRenames, "rename-table", "")
 
 	// Short options.
-	flag.StringVar(&projectID, "p", "", "")
-	flag.StringVar(&instanceID, "i", "", "")
-	flag.StringVar(&databaseID, "d", "", "")
-	flag.StringVar(&streamID, "s", "", "")
-	flag.StringVar(&format, "f", formatText, "")
-	flag.BoolVar(&verbose, "v", false, "")
+	flags.StringVar(&projectID, "p", "", "")
+	flags.StringVar(&instanceID, "i", "", "")
+	flags.StringVar(&databaseID, "d", "", "")
+	flags.StringVar(&streamID, "s", "", "")
+	flags.StringVar(&format, "f", formatText, "")
+	flags.BoolVar(&verbose, "v", false, "")
 
-	flag.Usage = usage
-	flag.Parse()
+	flags.Usage = func() { usage(c.stderr, name) }
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
 
 	// Validate required options.
-	if projectID == "" || instanceID == "" || databaseID == "" || streamID == "" {
-		flag.Usage()
-		os.Exit(1)
+	if projectID == "" || instanceID == "" || (databaseID == "") == (databasePattern == "") || (streamID == "") == !allStreams {
+		flags.Usage()
+		return exitUsage
 	}
 
 	// Validate optional options.
-	if format != formatText && format != formatJSON {
-		exitf("invalid format: %s", format)
+	if format != formatText && format != formatJSON && format != formatAggregate {
+		return c.exitf(exitUsage, "invalid format: %s", format)
+	}
+	if format == formatAggregate && (sinkURL != "" || databasePattern != "" || allStreams) {
+		return c.exitf(exitUsage, "--format=aggregate cannot be used with --sink, --database-pattern or --all-streams options")
 	}
+	if pubsubOrderingKey != "" {
+		switch pubsub.OrderingKey(pubsubOrderingKey) {
+		case pubsub.OrderingKeyTablePK, pubsub.OrderingKeyTable, pubsub.OrderingKeyNone:
+		default:
+			return c.exitf(exitUsage, "invalid --pubsub-ordering-key: %s", pubsubOrderingKey)
+		}
+		if !strings.HasPrefix(sinkURL, "pubsub://") {
+			return c.exitf(exitUsage, "--pubsub-ordering-key option can only be used with --sink=pubsub://")
+		}
+	}
+	if metricsURL != "" {
+		if err := validateMetrics(metricsURL); err != nil {
+			return c.exitf(exitUsage, "%v", err)
+		}
+	}
+	slogger, err := newSlogger(c.stderr, logFormat, verbose, traceRecords)
+	if err != nil {
+		return c.exitf(exitUsage, "%v", err)
+	}
+	if databasePattern != "" && visualizePartitions {
+		return c.exitf(exitUsage, "--database-pattern option cannot be used with --visualize-partitions option")
+	}
+	if allStreams && visualizePartitions {
+		return c.exitf(exitUsage, "--all-streams option cannot be used with --visualize-partitions option")
+	}
+	if allStreams && databasePattern != "" {
+		return c.exitf(exitUsage, "--all-streams and --database-pattern options cannot be specified together")
+	}
+	// The pattern and all the streams are logged in place of the IDs.
+	if databasePattern != "" {
+		databaseID = databasePattern
+	}
+	if allStreams {
+		streamID = "*"
+	}
+	dbPath := fmt.Sprintf("projects/%s/instances/%s/databases/%s", projectID, instanceID, databaseID)
 	if start != "" {
 		ts, err := time.Parse(time.RFC3339, start)
 		if err != nil {
-			exitf("invalid start timestamp: %v", err)
+			return c.exitf(exitUsage, "invalid start timestamp: %v", err)
 		}
 		startTimestamp = ts
 	}
+	if start != "" && startOffset != 0 {
+		return c.exitf(exitUsage, "--start and --start-offset options cannot be specified together")
+	}
 	if end != "" {
 		ts, err := time.Parse(time.RFC3339, end)
 		if err != nil {
-			exitf("invalid end timestamp: %v", err)
+			return c.exitf(exitUsage, "invalid end timestamp: %v", err)
 		}
 		endTimestamp = ts
 	}
+	if sourceLabel != "" && !envelopeSource {
+		return c.exitf(exitUsage, "--source-label option requires --envelope-source option")
+	}
+	if maxValueBytes < 0 {
+		return c.exitf(exitUsage, "invalid --max-value-bytes: %d", maxValueBytes)
+	}
+	parsedBytesFormat, err := changestreams.ParseBytesFormat(bytesFormat)
+	if err != nil {
+		return c.exitf(exitUsage, "%v", err)
+	}
+	parsedNumericFormat, err := changestreams.ParseNumericFormat(numericFormat)
+	if err != nil {
+		return c.exitf(exitUsage, "%v", err)
+	}
+	nonFiniteFloatPolicy, err := changestreams.ParseNonFiniteFloatPolicy(nonFiniteFloats)
+	if err != nil {
+		return c.exitf(exitUsage, "%v", err)
+	}
+	var timestampLocation *time.Location
+	if timezone != "" {
+		timestampLocation, err = time.LoadLocation(timezone)
+		if err != nil {
+			return c.exitf(exitUsage, "invalid time zone: %v", err)
+		}
+	}
+	renameConfig, err := rename.ParseConfig(columnRenames, tableRenames)
+	if err != nil {
+		return c.exitf(exitUsage, "%v", err)
+	}
+	renamer, err := rename.New(renameConfig)
+	if err != nil {
+		return c.exitf(exitUsage, "%v", err)
+	}
+	var agg *aggregator
+	if format == formatAggregate {
+		// The low watermark is set once the reader is created.
+		agg, err = newAggregator(c.stdout, window, lateRecords, nil)
+		if err != nil {
+			return c.exitf(exitUsage, "%v", err)
+		}
+	}
+	var metrics metricsEmitter
+	if metricsURL != "" {
+		metrics, err = openMetrics(ctx, metricsURL, projectID, []string{"stream:" + streamID, "database:" + databaseID})
+		if err != nil {
+			return c.exitf(exitFailure, "failed to open the metrics: %v", err)
+		}
+		defer metrics.Close()
+	}
+	if cloudLogging {
+		labels := map[string]string{"stream": streamID, "database": databaseID}
+		// The failures of Cloud Logging are logged only to stderr.
+		w, err := newCloudLoggingWriter(ctx, projectID, labels, slogger)
+		if err != nil {
+			return c.exitf(exitFailure, "failed to open Cloud Logging: %v", err)
+		}
+		defer w.Close()
+		slogger = slog.New(teeHandler{slogger.Handler(), w.handler()})
+	}
 	if visualizePartitions {
 		if start == "" || end == "" {
-			exitf("To visualize partitions, specify --start and --end options as well")
+			return c.exitf(exitUsage, "To visualize partitions, specify --start and --end options as well")
 		}
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	go handleInterrupt(cancel)
-
 	config := changestreams.Config{
-		StartTimestamp: startTimestamp,
-		EndTimestamp:   endTimestamp,
+		StartTimestamp:       startTimestamp,
+		StartOffset:          startOffset,
+		EndTimestamp:         endTimestamp,
+		TrackTransactions:    trackTransactions,
+		IncludeSource:        envelopeSource,
+		SourceLabel:          sourceLabel,
+		MaxValueBytes:        maxValueBytes,
+		BytesFormat:          parsedBytesFormat,
+		NumericFormat:        parsedNumericFormat,
+		NonFiniteFloatPolicy: nonFiniteFloatPolicy,
+		ParseJSONColumns:     parseJSONColumns,
+		TimestampLocation:    timestampLocation,
+		TimestampLayout:      timestampFormat,
+		// The tail often runs on laptops, which sleep.
+		ClockJumpThreshold: time.Minute,
+		// Verbose output prints the whole results, including the metadata.
+		IncludeReadMetadata: verbose,
+		Logger:              slogger,
 		SpannerClientConfig: spanner.ClientConfig{
 			SessionPoolConfig: spanner.DefaultSessionPoolConfig,
 			DatabaseRole:      role,
 		},
 	}
-	reader, err := changestreams.NewReaderWithConfig(ctx, projectID, instanceID, databaseID, streamID, config)
+	var reader streamReader
+	switch {
+	case allStreams:
+		reader, err = c.newMultiStreamReader(ctx, projectID, instanceID, databaseID, changestreams.MultiStreamConfig{
+			RefreshInterval: streamRefreshInterval,
+			Config:          config,
+		})
+	case databasePattern != "":
+		reader, err = c.newMultiDatabaseReader(ctx, projectID, instanceID, streamID, changestreams.MultiDatabaseConfig{
+			DatabasePattern: databasePattern,
+			RefreshInterval: databaseRefreshInterval,
+			Config:          config,
+		})
+	default:
+		reader, err = c.newReader(ctx, projectID, instanceID, databaseID, streamID, config)
+	}
 	if err != nil {
-		exitf("failed to create a reader: %v", err)
+		return c.exitf(exitCode(ctx, err), "failed to create a reader: %v", err)
 	}
 	defer reader.Close()
+	c.handleStats(&statsDumper{out: c.stderr, stats: reader.Stats})
 
 	if visualizePartitions {
-		fmt.Fprintf(os.Stderr, "Reading the stream and analyzing partitions...\n\n")
-		visualizer := NewPartitionVisualizer(os.Stdout)
+		slogger.Info("Reading the stream and analyzing partitions...", "event", "read_started", "stream", streamID, "database", dbPath)
+		visualizer := NewPartitionVisualizer(c.stdout)
+		if ids, ok := reader.(interface{ PartitionID(token string) string }); ok {
+			visualizer.partitionID = ids.PartitionID
+		}
 		if err := reader.Read(ctx, visualizer.Read); err != nil {
-			exitf("failed to read stream: %v", err)
+			return c.exitf(exitCode(ctx, err), "failed to read stream: %v", err)
 		}
 		visualizer.Draw()
-		return
+		return exitOK
 	}
 
-	fmt.Fprintf(os.Stderr, "Reading the stream...\n")
+	slogger.Info("Reading the stream...", "event", "read_started", "stream", streamID, "database", dbPath)
 
 	logger := &Logger{
-		out:     os.Stdout,
+		out:     c.stdout,
 		format:  format,
 		verbose: verbose,
 	}
-	if err := reader.Read(ctx, logger.Read); err != nil {
-		exitf("failed to read stream: %v", err)
+	read := logger.Read
+	if agg != nil {
+		// --format=aggregate is only for a single stream, read by changestreams.Reader.
+		r, ok := reader.(interface{ LowWatermark() time.Time })
+		if !ok {
+			return c.exitf(exitFailure, "the reader has no low watermark for --format=aggregate")
+		}
+		agg.lowWatermark = r.LowWatermark
+		read = agg.Read
+	}
+	var s resultSink
+	if sinkURL != "" {
+		s, err = openSink(ctx, sinkURL, slogger, pubsubOrderingKey)
+		if err != nil {
+			return c.exitf(exitFailure, "failed to open the sink: %v", err)
+		}
+		read = s.Read
+	}
+	if len(columnRenames) > 0 || len(tableRenames) > 0 {
+		read = renamer.Wrap(read)
+	}
+	if progress {
+		progressCtx, stopProgress := context.WithCancel(ctx)
+		defer stopProgress()
+		reporter := &progressReporter{out: c.stderr, stats: reader.Stats, now: time.Now}
+		go reporter.run(progressCtx, progressInterval)
+	}
+	if cloudLogging {
+		milestonesCtx, stopMilestones := context.WithCancel(ctx)
+		defer stopMilestones()
+		milestones := &watermarkMilestones{logger: slogger, stats: reader.Stats, now: time.Now}
+		go milestones.run(milestonesCtx, watermarkMilestoneCheckInterval)
+	}
+	if metrics != nil {
+		metricsCtx, stopMetrics := context.WithCancel(ctx)
+		reporter := &metricsReporter{emitter: metrics, stats: reader.Stats, logger: slogger}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			reporter.run(metricsCtx, metricsInterval)
+		}()
+		// The last metrics are sent before the emitter is closed.
+		defer func() {
+			stopMetrics()
+			<-done
+		}()
+	}
+	err = reader.Read(ctx, read)
+	// The windows are complete only when a bounded read has read up to the end.
+	if agg != nil && err == nil {
+		err = agg.Flush()
+	}
+	if s != nil {
+		if closeErr := s.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("failed to close the sink: %w", closeErr)
+		}
+	}
+	if trackTransactions {
+		reportIncompleteTransactions(c.stderr, reader.IncompleteTransactions(0))
+	}
+	if err != nil {
+		slogger.Error("failed to read stream", "event", "read_failed", "stream", streamID, "database", dbPath, "error", err)
+	}
+	return exitCode(ctx, err)
+}
+
+// newSlogger returns the logger of the operational logs, which must not be written to the output of the records.
+// With traceRecords, the records are logged at changestreams.LevelTrace, which is shown as TRACE.
+func newSlogger(out io.Writer, format string, verbose, traceRecords bool) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: slog.LevelInfo}
+	if verbose {
+		opts.Level = slog.LevelDebug
+	}
+	if traceRecords {
+		opts.Level = changestreams.LevelTrace
+		opts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.LevelKey && len(groups) == 0 && a.Value.Any() == changestreams.LevelTrace {
+				a.Value = slog.StringValue("TRACE")
+			}
+			return a
+		}
+	}
+	switch format {
+	case formatText:
+		return slog.New(slog.NewTextHandler(out, opts)), nil
+	case formatJSON:
+		return slog.New(slog.NewJSONHandler(out, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format: %s", format)
+	}
+}
+
+func reportIncompleteTransactions(out io.Writer, transactions []*changestreams.IncompleteTransaction) {
+	if len(transactions) == 0 {
+		return
+	}
+	fmt.Fprintf(out, "%d transactions were not completely read:\n", len(transactions))
+	for _, txn := range transactions {
+		fmt.Fprintf(out, "  %s | %s | records %d/%d | partitions %d/%d\n", txn.CommitTimestamp, txn.ServerTransactionID,
+			txn.RecordsRead, txn.NumberOfRecordsInTransaction, txn.PartitionsRead, txn.NumberOfPartitionsInTransaction)
 	}
 }
 
-func exitf(format string, a ...interface{}) {
+// exitf writes the error message to stderr, and returns code.
+func (c *command) exitf(code int, format string, a ...interface{}) int {
 	message := fmt.Sprintf(format, a...)
 	if !strings.HasSuffix(message, "\n") {
 		message += "\n"
 	}
-	fmt.Fprint(os.Stderr, message)
-	os.Exit(1)
+	fmt.Fprint(c.stderr, message)
+	return code
 }
 
 func handleInterrupt(cancel context.CancelFunc) {
@@ -0,0 +1,510 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams"
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams/sink/pubsub"
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams/verify"
+)
+
+// fakeReader reads the fixed results, and logs at every level like the partition reads do.
+type fakeReader struct {
+	config changestreams.Config
+	err    error
+}
+
+func (r *fakeReader) Read(ctx context.Context, f func(result *changestreams.ReadResult) error) error {
+	logger := r.config.Logger
+	logger.Debug("partition query started", "event", "partition_started", "partition_token", "a")
+	logger.Info("partition query stats", "event", "partition_query_stats", "partition_token", "a")
+	logger.Warn("partition query stalled, retrying from the watermark", "event", "partition_retried", "partition_token", "a")
+
+	commitTimestamp := time.Date(2023, 2, 24, 17, 0, 0, 0, time.UTC)
+	results := []*changestreams.ReadResult{
+		{
+			PartitionToken: "a",
+			ChangeRecords: []*changestreams.ChangeRecord{{
+				DataChangeRecords: []*changestreams.DataChangeRecord{
+					{
+						CommitTimestamp: commitTimestamp,
+						TableName:       "Players",
+						ModType:         "INSERT",
+						Mods: []*changestreams.Mod{{
+							Keys:      spanner.NullJSON{Value: map[string]interface{}{"PlayerId": "1"}, Valid: true},
+							NewValues: spanner.NullJSON{Value: map[string]interface{}{"Name": "foo | bar\nbaz"}, Valid: true},
+						}},
+					},
+					{
+						CommitTimestamp: commitTimestamp,
+						TableName:       "Players",
+						ModType:         "DELETE",
+						Mods: []*changestreams.Mod{{
+							Keys: spanner.NullJSON{Value: map[string]interface{}{"PlayerId": "2"}, Valid: true},
+						}},
+					},
+				},
+			}},
+		},
+		{
+			PartitionToken: "a",
+			ChangeRecords: []*changestreams.ChangeRecord{{
+				HeartbeatRecords: []*changestreams.HeartbeatRecord{{Timestamp: commitTimestamp}},
+			}},
+		},
+		{
+			PartitionToken: "a",
+			ChangeRecords: []*changestreams.ChangeRecord{{
+				ChildPartitionsRecords: []*changestreams.ChildPartitionsRecord{{
+					StartTimestamp: commitTimestamp,
+					RecordSequence: "00000001",
+					ChildPartitions: []*changestreams.ChildPartition{
+						{Token: "b", ParentPartitionTokens: []string{"a"}},
+					},
+				}},
+			}},
+		},
+	}
+	for _, result := range results {
+		if r.config.IncludeReadMetadata {
+			result.Metadata = &changestreams.ReadMetadata{Rows: 1, ArrivalTime: commitTimestamp}
+		}
+		if r.config.IncludeSource {
+			result.Source = &changestreams.Source{ProjectID: "project", InstanceID: "instance", DatabaseID: "database", StreamID: "stream", Label: r.config.SourceLabel}
+		}
+		if err := f(result); err != nil {
+			return err
+		}
+	}
+
+	if r.err != nil {
+		logger.Error("partition read failed", "event", "partition_failed", "partition_token", "a", "error", r.err)
+	}
+	return r.err
+}
+
+func (r *fakeReader) Stats() changestreams.Stats {
+	return changestreams.Stats{}
+}
+
+func (r *fakeReader) IncompleteTransactions(minAge time.Duration) []*changestreams.IncompleteTransaction {
+	return []*changestreams.IncompleteTransaction{{ServerTransactionID: "txn", RecordsRead: 1, NumberOfRecordsInTransaction: 2}}
+}
+
+// LowWatermark returns the zero time, so that the windows of --format=aggregate are only written by the flush at
+// the end of the read.
+func (r *fakeReader) LowWatermark() time.Time {
+	return time.Time{}
+}
+
+func (r *fakeReader) Close() {}
+
+func runCommand(t *testing.T, readErr error, args ...string) (stdout, stderr string, code int) {
+	t.Helper()
+	var outBuf, errBuf bytes.Buffer
+	c := &command{
+		stdout: &outBuf,
+		stderr: &errBuf,
+		newReader: func(ctx context.Context, projectID, instanceID, databaseID, streamID string, config changestreams.Config) (streamReader, error) {
+			return &fakeReader{config: config, err: readErr}, nil
+		},
+		newMultiDatabaseReader: func(ctx context.Context, projectID, instanceID, streamID string, config changestreams.MultiDatabaseConfig) (streamReader, error) {
+			config.Config.IncludeSource = true
+			return &fakeReader{config: config.Config, err: readErr}, nil
+		},
+		newMultiStreamReader: func(ctx context.Context, projectID, instanceID, databaseID string, config changestreams.MultiStreamConfig) (streamReader, error) {
+			config.Config.IncludeSource = true
+			return &fakeReader{config: config.Config, err: readErr}, nil
+		},
+		listChangeStreams: func(ctx context.Context, dbPath, role string) ([]*changestreams.ChangeStream, error) {
+			if readErr != nil {
+				return nil, readErr
+			}
+			return []*changestreams.ChangeStream{
+				{Name: "everything", All: true, Options: map[string]string{"value_capture_type": "NEW_ROW", "retention_period": "7d"}},
+				{Name: "players", Tables: []string{"Players", "Teams"}},
+			}, nil
+		},
+		compareRows: func(ctx context.Context, v *verify.Verifier, dbPath, role string, timestamp time.Time) (*verify.Report, error) {
+			report := &verify.Report{Table: "Albums", Database: dbPath, Timestamp: timestamp, Differences: []*verify.Difference{}}
+			if strings.HasSuffix(dbPath, "/target") {
+				report.Differences = append(report.Differences, &verify.Difference{Key: `{"AlbumId":"1"}`, Kind: verify.DifferenceMissing})
+			}
+			return report, nil
+		},
+		handleStats: func(d *statsDumper) {},
+	}
+	code = c.run(context.Background(), "spanner-change-streams-tail", args)
+	return outBuf.String(), errBuf.String(), code
+}
+
+var textRecordPattern = regexp.MustCompile(`^(\S+ \S+ \S+ \S+) \| (INSERT|UPDATE|DELETE) \| (\w+) \| (\[.*\])$`)
+
+// TestStdoutCarriesOnlyRecords asserts the invariant that stdout is parseable line by line
+// in the selected format, whatever is logged.
+func TestStdoutCarriesOnlyRecords(t *testing.T) {
+	required := []string{"-p", "project", "-i", "instance", "-d", "database", "-s", "stream", "--start", "2023-02-24T17:00:00Z"}
+
+	for _, format := range []string{formatText, formatJSON} {
+		for _, logFormat := range []string{formatText, formatJSON} {
+			for _, verbose := range []bool{false, true} {
+				for _, readErr := range []error{nil, errors.New("read error")} {
+					args := append(append([]string{}, required...), "--format", format, "--log-format", logFormat, "--track-transactions")
+					if verbose {
+						args = append(args, "--verbose")
+					}
+					t.Run(fmt.Sprintf("format=%s,log-format=%s,verbose=%v,error=%v", format, logFormat, verbose, readErr), func(t *testing.T) {
+						stdout, stderr, code := runCommand(t, readErr, args...)
+						if wantCode := map[bool]int{false: exitOK, true: exitFailure}[readErr != nil]; code != wantCode {
+							t.Errorf("exit code = %d, want %d", code, wantCode)
+						}
+						if !strings.Contains(stderr, "read_started") || !strings.Contains(stderr, "transactions were not completely read") {
+							t.Errorf("stderr must have the logs and the report, got %q", stderr)
+						}
+
+						var lines int
+						scanner := bufio.NewScanner(strings.NewReader(stdout))
+						for scanner.Scan() {
+							lines++
+							assertRecordLine(t, scanner.Text(), format, verbose)
+						}
+						// Verbose output has all the results, otherwise only the data change records.
+						if wantLines := map[bool]int{false: 2, true: 3}[verbose]; lines != wantLines {
+							t.Errorf("stdout has %d lines, want %d: %q", lines, wantLines, stdout)
+						}
+					})
+				}
+			}
+		}
+	}
+}
+
+func assertRecordLine(t *testing.T, line, format string, verbose bool) {
+	t.Helper()
+	switch {
+	case verbose:
+		var result changestreams.ReadResult
+		if err := json.Unmarshal([]byte(line), &result); err != nil || result.PartitionToken == "" {
+			t.Errorf("stdout line is not a result: %q (%v)", line, err)
+		}
+	case format == formatJSON:
+		var record changestreams.DataChangeRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil || record.TableName == "" {
+			t.Errorf("stdout line is not a data change record: %q (%v)", line, err)
+		}
+	default:
+		m := textRecordPattern.FindStringSubmatch(line)
+		if m == nil {
+			t.Errorf("stdout line is not a text record: %q", line)
+			return
+		}
+		var mods []*changestreams.Mod
+		if err := json.Unmarshal([]byte(m[4]), &mods); err != nil {
+			t.Errorf("mods of the stdout line are not JSON: %q (%v)", line, err)
+		}
+	}
+}
+
+func TestStdoutIsEmptyWithoutRecords(t *testing.T) {
+	for _, test := range []struct {
+		args []string
+		code int
+	}{
+		{args: []string{"-h"}, code: exitOK},
+		{args: []string{"--unknown"}, code: exitUsage},
+		{args: []string{"-p", "project"}, code: exitUsage},
+		{args: []string{"-p", "project", "-i", "instance", "-d", "database", "-s", "stream", "--format", "xml"}, code: exitUsage},
+		{args: []string{"-p", "project", "-i", "instance", "-d", "database", "-s", "stream", "--start", "yesterday"}, code: exitUsage},
+		{args: []string{"-p", "project", "-i", "instance", "-d", "database", "-s", "stream", "--start", "2023-02-24T17:00:00Z", "--start-offset", "1h"}, code: exitUsage},
+		{args: []string{"-p", "project", "-i", "instance", "-d", "database", "-s", "stream", "--source-label", "tokyo"}, code: exitUsage},
+		{args: []string{"-p", "project", "-i", "instance", "-d", "database", "-s", "stream", "--max-value-bytes", "-1"}, code: exitUsage},
+		{args: []string{"-p", "project", "-i", "instance", "-d", "database", "-s", "stream", "--bytes-format", "raw"}, code: exitUsage},
+		{args: []string{"-p", "project", "-i", "instance", "-d", "database", "-s", "stream", "--numeric-format", "float"}, code: exitUsage},
+		{args: []string{"-p", "project", "-i", "instance", "-d", "database", "-s", "stream", "--non-finite-floats", "zero"}, code: exitUsage},
+		{args: []string{"-p", "project", "-i", "instance", "-d", "database", "-s", "stream", "--timezone", "Mars/Olympus"}, code: exitUsage},
+		{args: []string{"-p", "project", "-i", "instance", "-d", "database", "-s", "stream", "--rename", "order_id=orderId"}, code: exitUsage},
+		{args: []string{"-p", "project", "-i", "instance", "-d", "database", "-s", "stream", "--rename-table", "Orders=orders", "--rename-table", "LegacyOrders=orders"}, code: exitUsage},
+		{args: []string{"-p", "project", "-i", "instance", "-d", "database", "-s", "stream", "--database-pattern", "tenant_%"}, code: exitUsage},
+		{args: []string{"-p", "project", "-i", "instance", "-s", "stream", "--database-pattern", "tenant_%", "--visualize-partitions"}, code: exitUsage},
+		{args: []string{"-p", "project", "-i", "instance", "-d", "database", "-s", "stream", "--all-streams"}, code: exitUsage},
+		{args: []string{"-p", "project", "-i", "instance", "--database-pattern", "tenant_%", "--all-streams"}, code: exitUsage},
+		{args: []string{"-p", "project", "-i", "instance", "-d", "database", "-s", "stream", "--format", "aggregate", "--window", "0s"}, code: exitUsage},
+		{args: []string{"-p", "project", "-i", "instance", "-d", "database", "-s", "stream", "--format", "aggregate", "--late-records", "drop"}, code: exitUsage},
+		{args: []string{"-p", "project", "-i", "instance", "-d", "database", "-s", "stream", "--format", "aggregate", "--all-streams"}, code: exitUsage},
+		{args: []string{"-p", "project", "-i", "instance", "-d", "database", "-s", "stream", "--metrics", "prometheus://localhost:9090"}, code: exitUsage},
+		{args: []string{"-p", "project", "-i", "instance", "-d", "database", "-s", "stream", "--metrics", "statsd://"}, code: exitFailure},
+		{args: []string{"-p", "project", "-i", "instance", "-d", "database", "-s", "stream", "--sink", "pubsub://project/topic", "--pubsub-ordering-key", "pk"}, code: exitUsage},
+		{args: []string{"-p", "project", "-i", "instance", "-d", "database", "-s", "stream", "--sink", "redis://localhost:6379", "--pubsub-ordering-key", "table"}, code: exitUsage},
+		{args: []string{"list-streams", "-p", "project"}, code: exitUsage},
+	} {
+		t.Run(strings.Join(test.args, " "), func(t *testing.T) {
+			stdout, stderr, code := runCommand(t, nil, test.args...)
+			if code != test.code {
+				t.Errorf("exit code = %d, want %d", code, test.code)
+			}
+			if stdout != "" {
+				t.Errorf("stdout = %q, want empty", stdout)
+			}
+			if stderr == "" {
+				t.Error("stderr must have the usage or the error")
+			}
+		})
+	}
+}
+
+func TestVisualizePartitionsStdout(t *testing.T) {
+	stdout, _, code := runCommand(t, nil, "-p", "project", "-i", "instance", "-d", "database", "-s", "stream",
+		"--start", "2023-02-24T17:00:00Z", "--end", "2023-02-24T18:00:00Z", "--visualize-partitions", "--verbose")
+	if code != 0 {
+		t.Errorf("exit code = %d, want 0", code)
+	}
+	if !strings.HasPrefix(stdout, "digraph {\n") || !strings.HasSuffix(stdout, "}\n") {
+		t.Errorf("stdout must only have the DOT graph, got %q", stdout)
+	}
+}
+
+func TestEnvelopeSource(t *testing.T) {
+	required := []string{"-p", "project", "-i", "instance", "-d", "database", "-s", "stream", "--envelope-source"}
+
+	stdout, _, _ := runCommand(t, nil, required...)
+	if line := strings.SplitN(stdout, "\n", 2)[0]; !strings.HasPrefix(line, "project/instance/database/stream | ") {
+		t.Errorf("text record = %q, want the source prefix", line)
+	}
+
+	stdout, _, _ = runCommand(t, nil, append(required, "--source-label", "tokyo", "-f", "json")...)
+	var record struct {
+		TableName string                `json:"table_name"`
+		Source    *changestreams.Source `json:"source"`
+	}
+	if err := json.Unmarshal([]byte(strings.SplitN(stdout, "\n", 2)[0]), &record); err != nil {
+		t.Fatalf("invalid JSON record: %v", err)
+	}
+	want := &changestreams.Source{ProjectID: "project", InstanceID: "instance", DatabaseID: "database", StreamID: "stream", Label: "tokyo"}
+	if record.TableName != "Players" || *record.Source != *want {
+		t.Errorf("JSON record = %+v with source %+v, want Players with source %+v", record, record.Source, want)
+	}
+}
+
+func TestDatabasePattern(t *testing.T) {
+	stdout, _, code := runCommand(t, nil, "-p", "project", "-i", "instance", "-s", "stream", "--database-pattern", "tenant_%")
+	if code != exitOK {
+		t.Errorf("exit code = %d, want %d", code, exitOK)
+	}
+	// The records of the databases are told apart by the source.
+	if line := strings.SplitN(stdout, "\n", 2)[0]; !strings.HasPrefix(line, "project/instance/database/stream | ") {
+		t.Errorf("text record = %q, want the source prefix", line)
+	}
+}
+
+func TestAllStreams(t *testing.T) {
+	stdout, _, code := runCommand(t, nil, "-p", "project", "-i", "instance", "-d", "database", "--all-streams")
+	if code != exitOK {
+		t.Errorf("exit code = %d, want %d", code, exitOK)
+	}
+	if line := strings.SplitN(stdout, "\n", 2)[0]; !strings.HasPrefix(line, "project/instance/database/stream | ") {
+		t.Errorf("text record = %q, want the source prefix", line)
+	}
+}
+
+func TestAggregateFormat(t *testing.T) {
+	stdout, _, code := runCommand(t, nil, "-p", "project", "-i", "instance", "-d", "database", "-s", "stream", "--format", "aggregate")
+	if code != exitOK {
+		t.Errorf("exit code = %d, want %d", code, exitOK)
+	}
+	// The window is written by the flush at the end of the read.
+	want := `{"window_start":"2023-02-24T17:00:00Z","window_end":"2023-02-24T17:01:00Z","tables":{"Players":{"inserts":1,"updates":0,"deletes":1}}}` + "\n"
+	if stdout != want {
+		t.Errorf("stdout = %q, want %q", stdout, want)
+	}
+}
+
+func TestListStreams(t *testing.T) {
+	required := []string{"list-streams", "-p", "project", "-i", "instance", "-d", "database"}
+
+	stdout, _, code := runCommand(t, nil, required...)
+	if code != exitOK {
+		t.Errorf("exit code = %d, want %d", code, exitOK)
+	}
+	want := "NAME        TABLES         OPTIONS\n" +
+		"everything  ALL            retention_period=7d,value_capture_type=NEW_ROW\n" +
+		"players     Players,Teams  \n"
+	if stdout != want {
+		t.Errorf("stdout = %q, want %q", stdout, want)
+	}
+
+	stdout, _, _ = runCommand(t, nil, append(required, "-f", "json")...)
+	var names []string
+	scanner := bufio.NewScanner(strings.NewReader(stdout))
+	for scanner.Scan() {
+		var stream changestreams.ChangeStream
+		if err := json.Unmarshal(scanner.Bytes(), &stream); err != nil {
+			t.Fatalf("invalid JSON line %q: %v", scanner.Text(), err)
+		}
+		names = append(names, stream.Name)
+	}
+	if got := strings.Join(names, ","); got != "everything,players" {
+		t.Errorf("streams = %q, want everything,players", got)
+	}
+
+	if _, stderr, code := runCommand(t, errors.New("list error"), required...); code != exitFailure || !strings.Contains(stderr, "list error") {
+		t.Errorf("exit code = %d with stderr %q, want %d with the error", code, stderr, exitFailure)
+	}
+}
+
+func TestNewSloggerTraceRecords(t *testing.T) {
+	for _, traceRecords := range []bool{false, true} {
+		var buf bytes.Buffer
+		logger, err := newSlogger(&buf, formatText, true, traceRecords)
+		if err != nil {
+			t.Fatalf("newSlogger error: %v", err)
+		}
+		logger.Log(context.Background(), changestreams.LevelTrace, "record delivered", "event", "record_delivered")
+		if got := strings.Contains(buf.String(), "level=TRACE"); got != traceRecords {
+			t.Errorf("traceRecords=%v: log = %q", traceRecords, buf.String())
+		}
+	}
+}
+
+// lagReader reads nothing until it's cancelled, with the stats of a partition.
+type lagReader struct {
+	fakeReader
+	read chan bool
+}
+
+func (r *lagReader) Read(ctx context.Context, f func(result *changestreams.ReadResult) error) error {
+	r.read <- f == nil
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (r *lagReader) Stats() changestreams.Stats {
+	return changestreams.Stats{
+		Partitions:        []*changestreams.PartitionStats{{PartitionToken: "a", DataChangeRecords: 10}, {PartitionToken: "b", DataChangeRecords: 5}},
+		DataChangeRecords: 15,
+		WatermarkLag:      3 * time.Second,
+	}
+}
+
+func TestLag(t *testing.T) {
+	var outBuf, errBuf bytes.Buffer
+	var startOffset time.Duration
+	reader := &lagReader{read: make(chan bool, 1)}
+	c := &command{
+		stdout: &outBuf,
+		stderr: &errBuf,
+		newReader: func(ctx context.Context, projectID, instanceID, databaseID, streamID string, config changestreams.Config) (streamReader, error) {
+			startOffset = config.StartOffset
+			return reader, nil
+		},
+	}
+	args := []string{"lag", "-p", "project", "-i", "instance", "-d", "database", "-s", "stream", "--since", "-1h", "--window", "10ms"}
+	if code := c.run(context.Background(), "spanner-change-streams-tail", args); code != exitOK {
+		t.Fatalf("exit code = %d with stderr %q, want %d", code, errBuf.String(), exitOK)
+	}
+	if startOffset != time.Hour {
+		t.Errorf("StartOffset = %s, want 1h", startOffset)
+	}
+	if trackOnly := <-reader.read; !trackOnly {
+		t.Error("Read was called with a read function, want nil")
+	}
+
+	var report lagReport
+	if err := json.Unmarshal(outBuf.Bytes(), &report); err != nil {
+		t.Fatalf("invalid JSON %q: %v", outBuf.String(), err)
+	}
+	if report.WatermarkLag != "3s" || report.WatermarkLagSeconds != 3 || report.DataChangeRecords != 15 || report.Partitions != 2 {
+		t.Errorf("report = %+v, want the lag of 3s and 15 records of 2 partitions", report)
+	}
+	if report.RecordsPerSecond <= 0 {
+		t.Errorf("RecordsPerSecond = %f, want positive", report.RecordsPerSecond)
+	}
+
+	if code := c.run(context.Background(), "spanner-change-streams-tail", []string{"lag", "-p", "project", "-i", "instance", "-d", "database", "-s", "stream", "--window", "0s"}); code != exitUsage {
+		t.Errorf("exit code with --window=0s = %d, want %d", code, exitUsage)
+	}
+}
+
+func TestVerifySync(t *testing.T) {
+	required := []string{"verify-sync", "-p", "project", "-i", "instance", "-d", "database", "-s", "stream", "--table", "Albums",
+		"--start", "2023-02-24T17:00:00Z", "--end", "2023-02-24T18:00:00Z"}
+
+	stdout, stderr, code := runCommand(t, nil, required...)
+	if code != exitOK {
+		t.Fatalf("exit code = %d with stderr %q, want %d", code, stderr, exitOK)
+	}
+	var report verify.Report
+	if err := json.Unmarshal([]byte(stdout), &report); err != nil {
+		t.Fatalf("invalid JSON %q: %v", stdout, err)
+	}
+	// The source is read at the end of the window.
+	if want := time.Date(2023, 2, 24, 18, 0, 0, 0, time.UTC); !report.Timestamp.Equal(want) {
+		t.Errorf("timestamp = %s, want %s", report.Timestamp, want)
+	}
+
+	stdout, _, code = runCommand(t, nil, append(required, "--target-database", "target")...)
+	if code != exitVerificationFailed {
+		t.Errorf("exit code with differences = %d, want %d", code, exitVerificationFailed)
+	}
+	var databases []string
+	scanner := bufio.NewScanner(strings.NewReader(stdout))
+	for scanner.Scan() {
+		var report verify.Report
+		if err := json.Unmarshal(scanner.Bytes(), &report); err != nil {
+			t.Fatalf("invalid JSON line %q: %v", scanner.Text(), err)
+		}
+		databases = append(databases, report.Database)
+	}
+	if want := []string{"projects/project/instances/instance/databases/database", "projects/project/instances/instance/databases/target"}; strings.Join(databases, ",") != strings.Join(want, ",") {
+		t.Errorf("databases = %v, want %v", databases, want)
+	}
+
+	if _, _, code := runCommand(t, nil, append(required[:len(required)-2], "--end", "2023-02-24T16:00:00Z")...); code != exitUsage {
+		t.Errorf("exit code with --end before --start = %d, want %d", code, exitUsage)
+	}
+}
+
+func TestPubSubDeadLetter(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	for _, tt := range []struct {
+		orderingKey pubsub.OrderingKey
+		deadLetter  bool
+	}{
+		{orderingKey: "", deadLetter: true},
+		{orderingKey: pubsub.OrderingKeyNone, deadLetter: true},
+		// The failed events of the ordering keys stop the read, so that the keys are never resumed past a gap.
+		{orderingKey: pubsub.OrderingKeyTable, deadLetter: false},
+		{orderingKey: pubsub.OrderingKeyTablePK, deadLetter: false},
+	} {
+		if got := pubsubDeadLetter(logger, tt.orderingKey) != nil; got != tt.deadLetter {
+			t.Errorf("pubsubDeadLetter(%q) set = %t, want %t", tt.orderingKey, got, tt.deadLetter)
+		}
+	}
+}
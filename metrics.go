@@ -0,0 +1,145 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"time"
+
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams"
+)
+
+// metricsInterval is the interval of the metrics with --metrics.
+const metricsInterval = 10 * time.Second
+
+// metricsEmitter sends the metrics to a monitoring system. The names are relative to the prefix of the emitter,
+// and the tags, in the key:value format, are added to the tags of the emitter. The metrics may be buffered until
+// flush is called.
+type metricsEmitter interface {
+	gauge(name string, value float64, tags ...string)
+	count(name string, delta int64, tags ...string)
+	timing(name string, d time.Duration, tags ...string)
+	flush() error
+	Close() error
+}
+
+// validateMetrics reports an unsupported --metrics URL, which is a usage error unlike a failure to open the metrics.
+func validateMetrics(rawURL string) error {
+	if rawURL == "gcm" {
+		return nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid metrics: %w", err)
+	}
+	if u.Scheme != "statsd" {
+		return fmt.Errorf("unsupported metrics: %q", u.Scheme)
+	}
+	return nil
+}
+
+// openMetrics opens the emitter of the URL with the tags. The scheme of the URL selects the emitter, except gcm,
+// which writes to Cloud Monitoring of the project.
+func openMetrics(ctx context.Context, rawURL, projectID string, tags []string) (metricsEmitter, error) {
+	if rawURL == "gcm" {
+		return newGCMEmitter(ctx, projectID, tags)
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metrics: %w", err)
+	}
+	switch u.Scheme {
+	case "statsd":
+		return newStatsdEmitter(u, tags)
+	default:
+		return nil, fmt.Errorf("unsupported metrics: %q", u.Scheme)
+	}
+}
+
+// metricsReporter sends the statistics of the reader to the emitter periodically. It's the only place that maps
+// the statistics to the metrics, so that all the emitters send the same set of metrics.
+type metricsReporter struct {
+	emitter metricsEmitter
+	stats   func() changestreams.Stats
+	logger  *slog.Logger
+	// last is the statistics of the last report, from which the counters are sent as the deltas.
+	last changestreams.Stats
+}
+
+// run reports the metrics every interval until ctx is done, and then reports them for the last time.
+func (m *metricsReporter) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			m.report()
+			return
+		case <-ticker.C:
+			m.report()
+		}
+	}
+}
+
+// report sends the metrics of the current statistics. The failures are logged, but never stop the read.
+func (m *metricsReporter) report() {
+	stats := m.stats()
+	var partitions int
+	for _, p := range stats.Partitions {
+		if !p.Failed {
+			partitions++
+		}
+	}
+	m.emitter.gauge("watermark_lag_seconds", stats.WatermarkLag.Seconds())
+	m.emitter.gauge("oldest_partition_age_seconds", stats.OldestPartitionAge.Seconds())
+	m.emitter.gauge("partitions", float64(partitions))
+	m.emitter.gauge("stalled_partitions", float64(stats.StalledPartitions))
+	m.emitter.gauge("paused_partitions", float64(stats.PausedPartitions))
+	m.emitter.gauge("max_partition_depth", float64(stats.MaxPartitionDepth))
+	m.emitter.gauge("buffered_records", float64(stats.BufferedRecords))
+
+	m.countDelta("rows", stats.Rows, m.last.Rows)
+	m.countDelta("data_change_records", stats.DataChangeRecords, m.last.DataChangeRecords)
+	m.countDelta("retries", stats.Retries, m.last.Retries)
+	m.countDelta("mods", stats.ModTypes.Inserts, m.last.ModTypes.Inserts, "mod_type:insert")
+	m.countDelta("mods", stats.ModTypes.Updates, m.last.ModTypes.Updates, "mod_type:update")
+	m.countDelta("mods", stats.ModTypes.Deletes, m.last.ModTypes.Deletes, "mod_type:delete")
+	m.countDelta("json_parse_failures", stats.JSONParseFailures, m.last.JSONParseFailures)
+
+	// The durations of the calls are only known in the buckets, so their mean over the interval is sent.
+	callbacks := stats.CallbackDurations.Count - m.last.CallbackDurations.Count
+	m.countDelta("callbacks", stats.CallbackDurations.Count, m.last.CallbackDurations.Count)
+	if callbacks > 0 {
+		m.emitter.timing("callback_duration", (stats.CallbackDurations.Sum-m.last.CallbackDurations.Sum)/time.Duration(callbacks))
+	}
+	m.last = stats
+
+	if err := m.emitter.flush(); err != nil {
+		m.logger.Warn("failed to send the metrics", "event", "metrics_failed", "error", err)
+	}
+}
+
+// countDelta sends the increase of the counter since the last report. A counter that decreased, e.g. because
+// a database was removed from a MultiDatabaseReader, is skipped.
+func (m *metricsReporter) countDelta(name string, current, last int64, tags ...string) {
+	if delta := current - last; delta > 0 {
+		m.emitter.count(name, delta, tags...)
+	}
+}
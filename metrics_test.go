@@ -0,0 +1,111 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams"
+	"github.com/google/go-cmp/cmp"
+)
+
+// recordingEmitter records the metrics as lines of name, value and tags.
+type recordingEmitter struct {
+	lines []string
+}
+
+func (e *recordingEmitter) gauge(name string, value float64, tags ...string) {
+	e.lines = append(e.lines, fmt.Sprintf("gauge %s %v %s", name, value, strings.Join(tags, ",")))
+}
+
+func (e *recordingEmitter) count(name string, delta int64, tags ...string) {
+	e.lines = append(e.lines, fmt.Sprintf("count %s %d %s", name, delta, strings.Join(tags, ",")))
+}
+
+func (e *recordingEmitter) timing(name string, d time.Duration, tags ...string) {
+	e.lines = append(e.lines, fmt.Sprintf("timing %s %s %s", name, d, strings.Join(tags, ",")))
+}
+
+func (e *recordingEmitter) flush() error { return nil }
+
+func (e *recordingEmitter) Close() error { return nil }
+
+func TestMetricsReporter(t *testing.T) {
+	stats := []changestreams.Stats{
+		{
+			Partitions: []*changestreams.PartitionStats{
+				{Rows: 10, DataChangeRecords: 4, Failed: true},
+				{Rows: 5, DataChangeRecords: 2, Retries: 1},
+			},
+			Rows:              15,
+			DataChangeRecords: 6,
+			Retries:           1,
+			WatermarkLag:      1500 * time.Millisecond,
+			StalledPartitions: 1,
+			MaxPartitionDepth: 2,
+			ModTypes:          changestreams.ModTypeCounts{Inserts: 5, Deletes: 1},
+			CallbackDurations: changestreams.DurationHistogram{Count: 15, Sum: 30 * time.Millisecond},
+		},
+		{
+			// The finished partition is dropped, but it's still counted in the totals.
+			Partitions: []*changestreams.PartitionStats{
+				{Rows: 9, DataChangeRecords: 3, Retries: 1},
+			},
+			FinishedPartitions: 1,
+			Rows:               19,
+			DataChangeRecords:  7,
+			Retries:            1,
+			MaxPartitionDepth:  2,
+			ModTypes:           changestreams.ModTypeCounts{Inserts: 5, Updates: 1, Deletes: 1},
+			CallbackDurations:  changestreams.DurationHistogram{Count: 19, Sum: 70 * time.Millisecond},
+		},
+	}
+	emitter := &recordingEmitter{}
+	reporter := &metricsReporter{
+		emitter: emitter,
+		stats:   func() changestreams.Stats { return stats[0] },
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	reporter.report()
+	stats = stats[1:]
+	emitter.lines = nil
+	reporter.report()
+
+	// Only the counters that increased since the first report are sent.
+	want := []string{
+		"gauge watermark_lag_seconds 0 ",
+		"gauge oldest_partition_age_seconds 0 ",
+		"gauge partitions 1 ",
+		"gauge stalled_partitions 0 ",
+		"gauge paused_partitions 0 ",
+		"gauge max_partition_depth 2 ",
+		"gauge buffered_records 0 ",
+		"count rows 4 ",
+		"count data_change_records 1 ",
+		"count mods 1 mod_type:update",
+		"count callbacks 4 ",
+		"timing callback_duration 10ms ",
+	}
+	if diff := cmp.Diff(emitter.lines, want); diff != "" {
+		t.Errorf("metrics diff = %v", diff)
+	}
+}
@@ -0,0 +1,116 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams"
+)
+
+const (
+	// progressInterval is the interval of the progress lines with --progress.
+	progressInterval = 10 * time.Second
+	// progressWindow is the sliding window over which the rate of the low watermark is estimated.
+	progressWindow = time.Minute
+	// liveLagThreshold is the lag below which the reader is considered to have caught up.
+	liveLagThreshold = 10 * time.Second
+)
+
+type progressSample struct {
+	time      time.Time
+	watermark time.Time
+}
+
+// progressReporter writes the progress of the catch-up to the live changes, estimated from the movement of
+// the low watermark of the reader.
+type progressReporter struct {
+	out     io.Writer
+	stats   func() changestreams.Stats
+	now     func() time.Time
+	samples []progressSample
+}
+
+// run reports the progress every interval until ctx is done.
+func (p *progressReporter) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.report()
+		}
+	}
+}
+
+// report writes a line of the progress, unless no partition is being read.
+func (p *progressReporter) report() {
+	lag := p.stats().WatermarkLag
+	if lag <= 0 {
+		return
+	}
+	now := p.now()
+	watermark := now.Add(-lag)
+	p.samples = append(p.samples, progressSample{time: now, watermark: watermark})
+	// The oldest sample at or before the start of the window is kept as the base of the rate.
+	for len(p.samples) > 2 && !p.samples[1].time.After(now.Add(-progressWindow)) {
+		p.samples = p.samples[1:]
+	}
+
+	if lag < liveLagThreshold {
+		fmt.Fprintf(p.out, "live, lag %s\n", formatProgressDuration(lag))
+		return
+	}
+	fmt.Fprintf(p.out, "caught up to %s (%s behind, %s)\n", watermark.UTC().Format(time.RFC3339), formatProgressDuration(lag), p.estimate(lag))
+}
+
+// estimate returns the remaining time to catch up at the rate of the watermark over the window.
+func (p *progressReporter) estimate(lag time.Duration) string {
+	if len(p.samples) < 2 {
+		return "estimating the remaining time"
+	}
+	first, last := p.samples[0], p.samples[len(p.samples)-1]
+	advanced := last.watermark.Sub(first.watermark)
+	if advanced <= 0 {
+		return "stalled"
+	}
+	// The lag shrinks by the advance of the watermark minus the elapsed time.
+	catchUp := advanced - last.time.Sub(first.time)
+	if catchUp <= 0 {
+		return "falling behind at current rate"
+	}
+	remaining := time.Duration(float64(lag) / float64(catchUp) * float64(last.time.Sub(first.time)))
+	return fmt.Sprintf("~%s remaining at current rate", formatProgressDuration(remaining))
+}
+
+// formatProgressDuration formats the duration for humans, e.g. 4h12m or 1.2s.
+func formatProgressDuration(d time.Duration) string {
+	if d < time.Minute {
+		return d.Round(100 * time.Millisecond).String()
+	}
+	s := strings.TrimSuffix(d.Round(time.Minute).String(), "0s")
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
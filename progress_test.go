@@ -0,0 +1,111 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestProgressReporter(t *testing.T) {
+	start := mustParseTime(t, "2024-06-01T08:00:00Z")
+	for _, test := range []struct {
+		desc string
+		// watermarks are the low watermarks at every 10 seconds from start. The zero time means no partition.
+		watermarks []string
+		want       []string
+	}{
+		{
+			desc:       "catching up",
+			watermarks: []string{"2024-06-01T03:00:00Z", "2024-06-01T03:10:00Z", "2024-06-01T03:21:00Z"},
+			want: []string{
+				"caught up to 2024-06-01T03:00:00Z (5h behind, estimating the remaining time)",
+				"caught up to 2024-06-01T03:10:00Z (4h50m behind, ~5m remaining at current rate)",
+				"caught up to 2024-06-01T03:21:00Z (4h39m behind, ~5m remaining at current rate)",
+			},
+		},
+		{
+			desc:       "stalled",
+			watermarks: []string{"2024-06-01T03:00:00Z", "2024-06-01T03:00:00Z"},
+			want: []string{
+				"caught up to 2024-06-01T03:00:00Z (5h behind, estimating the remaining time)",
+				"caught up to 2024-06-01T03:00:00Z (5h behind, stalled)",
+			},
+		},
+		{
+			desc:       "falling behind",
+			watermarks: []string{"2024-06-01T03:00:00Z", "2024-06-01T03:00:05Z"},
+			want: []string{
+				"caught up to 2024-06-01T03:00:00Z (5h behind, estimating the remaining time)",
+				"caught up to 2024-06-01T03:00:05Z (5h behind, falling behind at current rate)",
+			},
+		},
+		{
+			desc:       "live",
+			watermarks: []string{"", "2024-06-01T08:00:08.8Z", "2024-06-01T08:00:18Z"},
+			want:       []string{"live, lag 1.2s", "live, lag 2s"},
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			var out bytes.Buffer
+			now := start
+			var lag time.Duration
+			p := &progressReporter{
+				out:   &out,
+				stats: func() changestreams.Stats { return changestreams.Stats{WatermarkLag: lag} },
+				now:   func() time.Time { return now },
+			}
+			for _, w := range test.watermarks {
+				lag = 0
+				if w != "" {
+					watermark, err := time.Parse(time.RFC3339Nano, w)
+					if err != nil {
+						t.Fatalf("failed to parse time: %v", err)
+					}
+					lag = now.Sub(watermark)
+				}
+				p.report()
+				now = now.Add(progressInterval)
+			}
+			if diff := cmp.Diff(strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n"), test.want); diff != "" {
+				t.Errorf("progress diff = %v", diff)
+			}
+		})
+	}
+}
+
+func TestFormatProgressDuration(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		1200 * time.Millisecond:        "1.2s",
+		35 * time.Minute:               "35m",
+		4*time.Hour + 12*time.Minute:   "4h12m",
+		4 * time.Hour:                  "4h",
+		4*time.Hour + 10*time.Second:   "4h",
+		4*time.Hour + 59*time.Minute:   "4h59m",
+		24*time.Hour + 30*time.Second:  "24h1m",
+		59*time.Second + time.Second/2: "59.5s",
+	} {
+		if got := formatProgressDuration(d); got != want {
+			t.Errorf("formatProgressDuration(%s) = %q, want %q", d, got, want)
+		}
+	}
+}
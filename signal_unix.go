@@ -0,0 +1,46 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// handleStatsSignals dumps the stats on SIGUSR1, and the stats with the partitions on SIGQUIT.
+func handleStatsSignals(d *statsDumper) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR1, syscall.SIGQUIT)
+	go func() {
+		// Signals arriving while dumping are coalesced by the buffered channel.
+		for sig := range c {
+			var err error
+			if sig == syscall.SIGQUIT {
+				err = d.dumpPartitions()
+			} else {
+				err = d.dumpStats()
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to dump stats: %v\n", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,107 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams"
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams/sink"
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams/sink/amqp"
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams/sink/elasticsearch"
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams/sink/file"
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams/sink/pubsub"
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams/sink/redis"
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams/sink/sqlite"
+)
+
+// sinkMaxAttempts is the number of the attempts of the sinks with a dead-letter policy.
+const sinkMaxAttempts = 10
+
+// resultSink writes the results to an external system instead of stdout.
+type resultSink interface {
+	Read(result *changestreams.ReadResult) error
+	Close() error
+}
+
+// openSink opens the sink of the URL. The scheme of the URL selects the sink.
+// The events that the sink fails to write are logged to logger and dropped, if the sink supports it, except the
+// events of the Pub/Sub sink with an ordering key, which stop the read.
+// pubsubOrderingKey is the ordering key of the Pub/Sub sink.
+func openSink(ctx context.Context, rawURL string, logger *slog.Logger, pubsubOrderingKey string) (resultSink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sink: %w", err)
+	}
+	switch u.Scheme {
+	case "redis", "rediss":
+		return redis.NewFromURL(ctx, rawURL)
+	case "amqp", "amqps":
+		config, err := amqp.ParseURL(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		config.Retry.MaxAttempts = sinkMaxAttempts
+		config.DeadLetter = deadLetterLogger(logger)
+		return amqp.New(ctx, config)
+	case "elasticsearch+http", "elasticsearch+https", "opensearch+http", "opensearch+https":
+		config, err := elasticsearch.ParseURL(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		config.Retry.MaxAttempts = sinkMaxAttempts
+		config.DeadLetter = deadLetterLogger(logger)
+		return elasticsearch.New(ctx, config)
+	case "pubsub":
+		config, err := pubsub.ParseURL(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		config.OrderingKey = pubsub.OrderingKey(pubsubOrderingKey)
+		config.Retry.MaxAttempts = sinkMaxAttempts
+		config.DeadLetter = pubsubDeadLetter(logger, config.OrderingKey)
+		return pubsub.New(ctx, config)
+	case "sqlite":
+		return sqlite.NewFromURL(ctx, rawURL)
+	case "file":
+		return file.NewFromURL(rawURL)
+	default:
+		return nil, fmt.Errorf("unsupported sink: %q", u.Scheme)
+	}
+}
+
+// pubsubDeadLetter returns the dead-letter policy of the Pub/Sub sink. Without an ordering key, the failed events are
+// logged and dropped. With an ordering key, there is none, so the failed events stop the read and their keys stay
+// paused: the sink resumes the keys once the events are dead-lettered, and only logging them would let the later
+// events of the keys be published past the gap.
+func pubsubDeadLetter(logger *slog.Logger, orderingKey pubsub.OrderingKey) sink.DeadLetterFunc {
+	if orderingKey != "" && orderingKey != pubsub.OrderingKeyNone {
+		return nil
+	}
+	return deadLetterLogger(logger)
+}
+
+func deadLetterLogger(logger *slog.Logger) sink.DeadLetterFunc {
+	return func(event *sink.Event, err error) error {
+		logger.Error("failed to write the event to the sink, dropped", "event", "dead_letter", "partition_token", event.PartitionToken,
+			"idempotency_key", event.IdempotencyKey, "table_name", event.TableName, "commit_timestamp", event.CommitTimestamp, "error", err)
+		return nil
+	}
+}
@@ -0,0 +1,90 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams"
+)
+
+// statsDumper writes the stats of the reader on demand, e.g. on a signal.
+type statsDumper struct {
+	out   io.Writer
+	stats func() changestreams.Stats
+	mu    sync.Mutex
+}
+
+// dumpStats writes the stats snapshot in JSON.
+func (d *statsDumper) dumpStats() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return json.NewEncoder(d.out).Encode(d.stats())
+}
+
+// dumpPartitions writes the stats snapshot in JSON, followed by the table of the partitions.
+func (d *statsDumper) dumpPartitions() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	stats := d.stats()
+	if err := json.NewEncoder(d.out).Encode(stats); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(d.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PARTITION\tSTATE\tWATERMARK\tROWS\tLAST HEARTBEAT\tRETRIES")
+	writePartitions(w, "", stats.Partitions)
+	for _, db := range stats.Databases {
+		// The partitions read with --database-pattern or --all-streams are prefixed with the database and stream IDs.
+		prefix := db.DatabaseID + "/" + db.StreamID + "/"
+		fmt.Fprintf(w, "%s\t%s\t-\t-\t-\t-\n", prefix, db.State)
+		writePartitions(w, prefix, db.Stats.Partitions)
+	}
+	return w.Flush()
+}
+
+func writePartitions(w io.Writer, prefix string, partitions []*changestreams.PartitionStats) {
+	for _, p := range partitions {
+		state := "reading"
+		if p.Failed {
+			state = "failed"
+		}
+		// The tokens are in the JSON above, so the table shows the short IDs.
+		id := p.PartitionID
+		if id == "" {
+			id = p.PartitionToken
+		}
+		if id == "" {
+			id = "root"
+		}
+		fmt.Fprintf(w, "%s%s\t%s\t%s\t%d\t%s\t%d\n", prefix, id, state, formatTime(p.Watermark), p.Rows, formatTime(p.LastHeartbeatTime), p.Retries)
+	}
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339Nano)
+}
@@ -0,0 +1,66 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams"
+)
+
+func TestStatsDumper(t *testing.T) {
+	var out bytes.Buffer
+	d := &statsDumper{
+		out: &out,
+		stats: func() changestreams.Stats {
+			return changestreams.Stats{
+				Partitions: []*changestreams.PartitionStats{
+					{PartitionToken: "", PartitionID: "root", Rows: 2, Watermark: mustParseTime(t, "2022-12-04T18:00:00Z"), Failed: true},
+					{PartitionToken: "a", PartitionID: "P-0001", Rows: 5, LastHeartbeatTime: mustParseTime(t, "2022-12-04T18:01:00Z"), Retries: 1},
+				},
+			}
+		},
+	}
+
+	if err := d.dumpStats(); err != nil {
+		t.Fatalf("dumpStats error: %v", err)
+	}
+	if got := out.String(); !strings.HasPrefix(got, `{"partitions":[`) || strings.Count(got, "\n") != 1 {
+		t.Errorf("dumpStats must write a single JSON line, got %q", got)
+	}
+
+	out.Reset()
+	if err := d.dumpPartitions(); err != nil {
+		t.Fatalf("dumpPartitions error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	want := []string{
+		"PARTITION  STATE    WATERMARK             ROWS  LAST HEARTBEAT        RETRIES",
+		"root       failed   2022-12-04T18:00:00Z  2     -                     0",
+		"P-0001     reading  -                     5     2022-12-04T18:01:00Z  1",
+	}
+	if len(lines) != 4 {
+		t.Fatalf("dumpPartitions must write the JSON line and the table, got %q", out.String())
+	}
+	for i, w := range want {
+		if got := strings.TrimRight(lines[i+1], " "); got != w {
+			t.Errorf("line %d = %q, want %q", i+1, got, w)
+		}
+	}
+}
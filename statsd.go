@@ -0,0 +1,145 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultStatsdPort   = "8125"
+	defaultStatsdPrefix = "spanner_change_streams_tail."
+	// statsdMaxPacketBytes keeps a packet within the MTU of the most networks, as DogStatsD clients do.
+	statsdMaxPacketBytes = 1432
+)
+
+// statsdTagReplacer replaces the characters that delimit the tags in the DogStatsD format.
+var statsdTagReplacer = strings.NewReplacer("|", "_", ",", "_", "#", "_", "\n", "_")
+
+// statsdEmitter sends the metrics over UDP in the StatsD format, with the tags in the DogStatsD extension, e.g.
+// spanner_change_streams_tail.watermark_lag_seconds:1.5|g|#stream:mystream. The lines are packed into as few
+// packets as possible when flushed.
+//
+// The URL is statsd://HOST[:PORT][?prefix=PREFIX&tag=KEY:VALUE...], where the port defaults to 8125, the prefix
+// of the names defaults to spanner_change_streams_tail., and tag can be repeated.
+type statsdEmitter struct {
+	conn   net.Conn
+	prefix string
+	// tags is the suffix of the tags added to every line, e.g. "stream:s,database:d".
+	tags    string
+	packet  []byte
+	packets [][]byte
+}
+
+func newStatsdEmitter(u *url.URL, tags []string) (*statsdEmitter, error) {
+	if u.Hostname() == "" {
+		return nil, errors.New("statsd host must be specified")
+	}
+	port := u.Port()
+	if port == "" {
+		port = defaultStatsdPort
+	}
+	query := u.Query()
+	prefix := defaultStatsdPrefix
+	if query.Has("prefix") {
+		prefix = query.Get("prefix")
+	}
+	if strings.ContainsAny(prefix, ":|@#\n") {
+		return nil, fmt.Errorf("invalid statsd prefix: %q", prefix)
+	}
+	conn, err := net.Dial("udp", net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return nil, err
+	}
+	return &statsdEmitter{
+		conn:   conn,
+		prefix: prefix,
+		tags:   statsdTags(append(append([]string{}, tags...), query["tag"]...)),
+	}, nil
+}
+
+func (e *statsdEmitter) gauge(name string, value float64, tags ...string) {
+	e.add(name, strconv.FormatFloat(value, 'f', -1, 64), "g", tags)
+}
+
+func (e *statsdEmitter) count(name string, delta int64, tags ...string) {
+	e.add(name, strconv.FormatInt(delta, 10), "c", tags)
+}
+
+func (e *statsdEmitter) timing(name string, d time.Duration, tags ...string) {
+	e.add(name, strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64), "ms", tags)
+}
+
+// add appends the line of the metric to the packet, which is queued once the line doesn't fit in it.
+func (e *statsdEmitter) add(name, value, metricType string, tags []string) {
+	line := e.prefix + name + ":" + value + "|" + metricType
+	allTags := e.tags
+	if extra := statsdTags(tags); extra != "" {
+		if allTags != "" {
+			allTags += ","
+		}
+		allTags += extra
+	}
+	if allTags != "" {
+		line += "|#" + allTags
+	}
+	if len(e.packet) > 0 && len(e.packet)+1+len(line) > statsdMaxPacketBytes {
+		e.packets = append(e.packets, e.packet)
+		e.packet = nil
+	}
+	if len(e.packet) > 0 {
+		e.packet = append(e.packet, '\n')
+	}
+	e.packet = append(e.packet, line...)
+}
+
+// flush sends the queued packets. All the packets are sent even if some fail, and the first error is returned.
+func (e *statsdEmitter) flush() error {
+	if len(e.packet) > 0 {
+		e.packets = append(e.packets, e.packet)
+		e.packet = nil
+	}
+	var firstErr error
+	for _, packet := range e.packets {
+		if _, err := e.conn.Write(packet); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	e.packets = nil
+	return firstErr
+}
+
+func (e *statsdEmitter) Close() error {
+	return e.conn.Close()
+}
+
+// statsdTags joins the tags, replacing the delimiters in them.
+func statsdTags(tags []string) string {
+	replaced := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if tag != "" {
+			replaced = append(replaced, statsdTagReplacer.Replace(tag))
+		}
+	}
+	return strings.Join(replaced, ",")
+}
@@ -0,0 +1,115 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// listenStatsd returns the UDP connection of a fake StatsD server, and the function that reads a packet from it.
+func listenStatsd(t *testing.T) (*net.UDPConn, func() string) {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, func() string {
+		t.Helper()
+		buf := make([]byte, 65536)
+		if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("failed to read a packet: %v", err)
+		}
+		return string(buf[:n])
+	}
+}
+
+func TestStatsdEmitter(t *testing.T) {
+	conn, readPacket := listenStatsd(t)
+	emitter, err := openMetrics(context.Background(), "statsd://"+conn.LocalAddr().String()+"?prefix=cs.&tag=env:prod", "project", []string{"stream:s", "database:d|x"})
+	if err != nil {
+		t.Fatalf("openMetrics error: %v", err)
+	}
+	defer emitter.Close()
+
+	emitter.gauge("watermark_lag_seconds", 1.5)
+	emitter.count("mods", 3, "mod_type:insert")
+	emitter.timing("callback_duration", 1500*time.Microsecond)
+	if err := emitter.flush(); err != nil {
+		t.Fatalf("flush error: %v", err)
+	}
+	want := []string{
+		"cs.watermark_lag_seconds:1.5|g|#stream:s,database:d_x,env:prod",
+		"cs.mods:3|c|#stream:s,database:d_x,env:prod,mod_type:insert",
+		"cs.callback_duration:1.5|ms|#stream:s,database:d_x,env:prod",
+	}
+	if diff := cmp.Diff(strings.Split(readPacket(), "\n"), want); diff != "" {
+		t.Errorf("packet diff = %v", diff)
+	}
+}
+
+func TestStatsdEmitterSplitsPackets(t *testing.T) {
+	conn, readPacket := listenStatsd(t)
+	emitter, err := openMetrics(context.Background(), "statsd://"+conn.LocalAddr().String(), "project", nil)
+	if err != nil {
+		t.Fatalf("openMetrics error: %v", err)
+	}
+	defer emitter.Close()
+
+	const metrics = 100
+	for i := 0; i < metrics; i++ {
+		emitter.gauge("partitions", float64(i))
+	}
+	if err := emitter.flush(); err != nil {
+		t.Fatalf("flush error: %v", err)
+	}
+	var lines int
+	for lines < metrics {
+		packet := readPacket()
+		if len(packet) > statsdMaxPacketBytes {
+			t.Errorf("packet of %d bytes, want at most %d", len(packet), statsdMaxPacketBytes)
+		}
+		for _, line := range strings.Split(packet, "\n") {
+			if !strings.HasPrefix(line, defaultStatsdPrefix+"partitions:") {
+				t.Errorf("line = %q, want the default prefix", line)
+			}
+			lines++
+		}
+	}
+}
+
+func TestOpenMetricsInvalid(t *testing.T) {
+	for _, rawURL := range []string{
+		"prometheus://localhost:9090",
+		"statsd://",
+		"statsd://localhost:8125?prefix=a:b",
+	} {
+		if _, err := openMetrics(context.Background(), rawURL, "project", nil); err == nil {
+			t.Errorf("openMetrics(%q) succeeded, want error", rawURL)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"cloud.google.com/go/spanner"
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams"
+)
+
+func listChangeStreams(ctx context.Context, dbPath, role string) ([]*changestreams.ChangeStream, error) {
+	client, err := spanner.NewClientWithConfig(ctx, dbPath, spanner.ClientConfig{
+		SessionPoolConfig: spanner.DefaultSessionPoolConfig,
+		DatabaseRole:      role,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	return changestreams.ListChangeStreams(ctx, client)
+}
+
+// listStreams runs list-streams subcommand, which writes the change streams of the database with their options
+// to stdout.
+func (c *command) listStreams(ctx context.Context, name string, args []string) int {
+	var projectID, instanceID, databaseID, format, role string
+
+	flags := flag.NewFlagSet(name+" list-streams", flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	flags.StringVar(&projectID, "project", "", "")
+	flags.StringVar(&instanceID, "instance", "", "")
+	flags.StringVar(&databaseID, "database", "", "")
+	flags.StringVar(&format, "format", formatText, "")
+	flags.StringVar(&role, "role", "", "")
+	flags.StringVar(&projectID, "p", "", "")
+	flags.StringVar(&instanceID, "i", "", "")
+	flags.StringVar(&databaseID, "d", "", "")
+	flags.StringVar(&format, "f", formatText, "")
+
+	flags.Usage = func() { usage(c.stderr, name) }
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if projectID == "" || instanceID == "" || databaseID == "" {
+		flags.Usage()
+		return exitUsage
+	}
+	if format != formatText && format != formatJSON {
+		return c.exitf(exitUsage, "invalid format: %s", format)
+	}
+
+	dbPath := fmt.Sprintf("projects/%s/instances/%s/databases/%s", projectID, instanceID, databaseID)
+	streams, err := c.listChangeStreams(ctx, dbPath, role)
+	if err != nil {
+		return c.exitf(exitCode(ctx, err), "failed to list the change streams: %v", err)
+	}
+
+	if format == formatJSON {
+		encoder := json.NewEncoder(c.stdout)
+		for _, s := range streams {
+			if err := encoder.Encode(s); err != nil {
+				return c.exitf(exitFailure, "failed to write the change streams: %v", err)
+			}
+		}
+		return exitOK
+	}
+	w := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tTABLES\tOPTIONS")
+	for _, s := range streams {
+		tables := strings.Join(s.Tables, ",")
+		if s.All {
+			tables = "ALL"
+		}
+		var options []string
+		for name, value := range s.Options {
+			options = append(options, name+"="+value)
+		}
+		sort.Strings(options)
+		fmt.Fprintf(w, "%s\t%s\t%s\n", s.Name, tables, strings.Join(options, ","))
+	}
+	if err := w.Flush(); err != nil {
+		return c.exitf(exitFailure, "failed to write the change streams: %v", err)
+	}
+	return exitOK
+}
@@ -0,0 +1,162 @@
+//
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams"
+	"github.com/cloudspannerecosystem/spanner-change-streams-tail/changestreams/verify"
+)
+
+// verifyTarget is a database to compare the rows with, read at the timestamp, or with a strong read if it's zero.
+type verifyTarget struct {
+	dbPath    string
+	timestamp time.Time
+}
+
+func compareRows(ctx context.Context, v *verify.Verifier, dbPath, role string, timestamp time.Time) (*verify.Report, error) {
+	client, err := spanner.NewClientWithConfig(ctx, dbPath, spanner.ClientConfig{
+		SessionPoolConfig: spanner.DefaultSessionPoolConfig,
+		DatabaseRole:      role,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	return v.Compare(ctx, client, timestamp)
+}
+
+// verifySync runs verify-sync subcommand, which reads the change stream from --start to --end, and compares the net
+// effect of the data change records of the table with the rows of the table read at --end, and with the current rows
+// of the table of --target-database if specified. A report of each database is written to stdout in JSON.
+func (c *command) verifySync(ctx context.Context, name string, args []string) int {
+	var (
+		projectID, instanceID, databaseID, streamID, table, start, end, targetDatabase, role, logFormat string
+		batchSize                                                                                       int
+		verbose                                                                                         bool
+	)
+
+	flags := flag.NewFlagSet(name+" verify-sync", flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	flags.StringVar(&projectID, "project", "", "")
+	flags.StringVar(&instanceID, "instance", "", "")
+	flags.StringVar(&databaseID, "database", "", "")
+	flags.StringVar(&streamID, "stream", "", "")
+	flags.StringVar(&table, "table", "", "")
+	flags.StringVar(&start, "start", "", "")
+	flags.StringVar(&end, "end", "", "")
+	flags.StringVar(&targetDatabase, "target-database", "", "")
+	flags.IntVar(&batchSize, "batch-size", 0, "")
+	flags.StringVar(&role, "role", "", "")
+	flags.StringVar(&logFormat, "log-format", formatText, "")
+	flags.BoolVar(&verbose, "verbose", false, "")
+	flags.StringVar(&projectID, "p", "", "")
+	flags.StringVar(&instanceID, "i", "", "")
+	flags.StringVar(&databaseID, "d", "", "")
+	flags.StringVar(&streamID, "s", "", "")
+	flags.BoolVar(&verbose, "v", false, "")
+
+	flags.Usage = func() { usage(c.stderr, name) }
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if projectID == "" || instanceID == "" || databaseID == "" || streamID == "" || table == "" || start == "" || end == "" {
+		flags.Usage()
+		return exitUsage
+	}
+	startTimestamp, err := time.Parse(time.RFC3339, start)
+	if err != nil {
+		return c.exitf(exitUsage, "invalid start timestamp: %v", err)
+	}
+	endTimestamp, err := time.Parse(time.RFC3339, end)
+	if err != nil {
+		return c.exitf(exitUsage, "invalid end timestamp: %v", err)
+	}
+	if !endTimestamp.After(startTimestamp) {
+		return c.exitf(exitUsage, "--end must be after --start")
+	}
+	if batchSize < 0 {
+		return c.exitf(exitUsage, "invalid --batch-size: %d", batchSize)
+	}
+	slogger, err := newSlogger(c.stderr, logFormat, verbose, false)
+	if err != nil {
+		return c.exitf(exitUsage, "%v", err)
+	}
+	verifier, err := verify.New(table, verify.Config{BatchSize: batchSize})
+	if err != nil {
+		return c.exitf(exitUsage, "%v", err)
+	}
+
+	dbPath := fmt.Sprintf("projects/%s/instances/%s/databases/%s", projectID, instanceID, databaseID)
+	reader, err := c.newReader(ctx, projectID, instanceID, databaseID, streamID, changestreams.Config{
+		StartTimestamp: startTimestamp,
+		EndTimestamp:   endTimestamp,
+		Logger:         slogger,
+		SpannerClientConfig: spanner.ClientConfig{
+			SessionPoolConfig: spanner.DefaultSessionPoolConfig,
+			DatabaseRole:      role,
+		},
+	})
+	if err != nil {
+		return c.exitf(exitCode(ctx, err), "failed to create a reader: %v", err)
+	}
+	defer reader.Close()
+
+	slogger.Info("Reading the stream...", "event", "read_started", "stream", streamID, "database", dbPath)
+	if err := reader.Read(ctx, verifier.Add); err != nil {
+		return c.exitf(exitCode(ctx, err), "failed to read stream: %v", err)
+	}
+	slogger.Info("Comparing the rows...", "event", "verify_started", "table", table, "keys", verifier.Keys())
+
+	// The rows of the source are read at the end of the window, and the rows of the target are the current ones,
+	// whose commit timestamps differ from the source.
+	targets := []verifyTarget{{dbPath: dbPath, timestamp: endTimestamp}}
+	if targetDatabase != "" {
+		targetPath := targetDatabase
+		if !strings.Contains(targetPath, "/") {
+			targetPath = fmt.Sprintf("projects/%s/instances/%s/databases/%s", projectID, instanceID, targetDatabase)
+		}
+		targets = append(targets, verifyTarget{dbPath: targetPath})
+	}
+
+	code := exitOK
+	encoder := json.NewEncoder(c.stdout)
+	for _, target := range targets {
+		report, err := c.compareRows(ctx, verifier, target.dbPath, role, target.timestamp)
+		if err != nil {
+			return c.exitf(exitCode(ctx, err), "failed to compare the rows of %s: %v", target.dbPath, err)
+		}
+		if err := encoder.Encode(report); err != nil {
+			return c.exitf(exitFailure, "failed to write the report: %v", err)
+		}
+		if len(report.Differences) > 0 {
+			code = exitVerificationFailed
+		}
+	}
+	return code
+}